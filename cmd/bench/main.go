@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tcp.to.http/internal/client"
+)
+
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("want Name: value, got %q", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// result is what one worker saw; they are merged at the end.
+type result struct {
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    map[string]int
+	bytes     int64
+}
+
+func main() {
+	workers := flag.Int("c", 10, "concurrent workers")
+	duration := flag.Duration("d", 10*time.Second, "how long to run, unless -n is set")
+	total := flag.Int("n", 0, "total requests to send; overrides -d")
+	method := flag.String("m", "GET", "request method")
+	body := flag.String("body", "", "request body")
+	var headers headerFlags
+	flag.Var(&headers, "H", "request header, Name: value; may be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: bench [flags] url\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *workers < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	url := flag.Arg(0)
+	if _, err := client.NewRequest(context.Background(), *method, url, nil); err != nil {
+		log.Fatal("Error", "Error", err)
+	}
+
+	c := &client.Client{MaxIdlePerHost: *workers}
+	ctx := context.Background()
+	if *total == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	// Workers take numbers until they run out; with -d there is no end.
+	var issued atomic.Int64
+	next := func() bool {
+		return ctx.Err() == nil && (*total == 0 || issued.Add(1) <= int64(*total))
+	}
+
+	results := make([]*result, *workers)
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	for i := range results {
+		r := &result{statuses: map[int]int{}, errors: map[string]int{}}
+		results[i] = r
+		wg.Go(func() {
+			for next() {
+				req, _ := client.NewRequest(ctx, *method, url, []byte(*body))
+				for _, h := range headers {
+					name, value, _ := strings.Cut(h, ":")
+					req.Headers.Set(name, strings.TrimSpace(value))
+				}
+				began := time.Now()
+				res, err := c.Do(req)
+				if err == nil {
+					var n int64
+					n, err = io.Copy(io.Discard, res.Body)
+					res.Body.Close()
+					r.bytes += n
+					r.statuses[int(res.StatusCode)]++
+				}
+				if err != nil {
+					// The deadline cutting off the last requests isn't an error.
+					if ctx.Err() != nil {
+						return
+					}
+					r.errors[err.Error()]++
+					continue
+				}
+				r.latencies = append(r.latencies, time.Since(began))
+			}
+		})
+	}
+	wg.Wait()
+	report(merge(results), time.Since(start))
+}
+
+func merge(results []*result) *result {
+	all := &result{statuses: map[int]int{}, errors: map[string]int{}}
+	for _, r := range results {
+		all.latencies = append(all.latencies, r.latencies...)
+		all.bytes += r.bytes
+		for k, v := range r.statuses {
+			all.statuses[k] += v
+		}
+		for k, v := range r.errors {
+			all.errors[k] += v
+		}
+	}
+	slices.Sort(all.latencies)
+	return all
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(len(sorted)-1, int(float64(len(sorted))*p))]
+}
+
+func report(r *result, elapsed time.Duration) {
+	errors := 0
+	for _, n := range r.errors {
+		errors += n
+	}
+	done := len(r.latencies)
+	fmt.Printf("Requests:    %d completed, %d failed in %s\n", done, errors, elapsed.Round(time.Millisecond))
+	fmt.Printf("Throughput:  %.1f req/s, %.1f KiB/s\n", float64(done)/elapsed.Seconds(), float64(r.bytes)/1024/elapsed.Seconds())
+	if errors > 0 {
+		fmt.Println("Errors:")
+		for msg, n := range r.errors {
+			fmt.Printf("  %d× %s\n", n, msg)
+		}
+	}
+	if done == 0 {
+		return
+	}
+	fmt.Printf("Latency:     p50 %s  p95 %s  p99 %s  max %s\n",
+		percentile(r.latencies, 0.50), percentile(r.latencies, 0.95), percentile(r.latencies, 0.99), r.latencies[done-1])
+
+	fmt.Println("Histogram:")
+	histogram(r.latencies)
+
+	fmt.Println("Status codes:")
+	codes := []int{}
+	for code := range r.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Printf("  %d: %d\n", code, r.statuses[code])
+	}
+}
+
+// histogram prints ten equal-width buckets between the fastest and slowest
+// request.
+func histogram(sorted []time.Duration) {
+	const buckets = 10
+	low, high := sorted[0], sorted[len(sorted)-1]
+	width := max((high-low)/buckets, 1)
+	counts := make([]int, buckets)
+	for _, d := range sorted {
+		counts[min(buckets-1, int((d-low)/width))]++
+	}
+	most := slices.Max(counts)
+	for i, n := range counts {
+		bar := strings.Repeat("■", n*40/most)
+		fmt.Printf("  %10s [%6d] %s\n", low+time.Duration(i+1)*width, n, bar)
+	}
+}
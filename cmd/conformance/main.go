@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"tcp.to.http/internal/conformance"
+)
+
+// conformance runs the HTTP/1.1 corpus against a server, any server, and
+// prints which cases it handles as the RFCs require.
+func main() {
+	addr := flag.String("addr", "localhost:42069", "host:port of the server to test")
+	parser := flag.Bool("parser", false, "run the corpus through this module's parser instead of a server")
+	timeout := flag.Duration("timeout", 2*time.Second, "how long to wait for each response")
+	diff := flag.Bool("diff", false, "compare this module's server with net/http's instead")
+	flag.Parse()
+
+	if *diff {
+		diffs, err := conformance.Differential(conformance.Corpus, *timeout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		conformance.Report(os.Stdout, diffs)
+		return
+	}
+
+	var results []conformance.Result
+	if *parser {
+		results = conformance.Parse(conformance.Corpus)
+	} else {
+		results = conformance.Run(*addr, conformance.Corpus, *timeout)
+	}
+
+	failed := 0
+	for _, r := range results {
+		mark := "PASS"
+		if !r.Pass {
+			mark = "FAIL"
+			failed++
+		}
+		got := fmt.Sprintf("status %d", r.Status)
+		if *parser {
+			got = "accepted"
+		}
+		if r.Err != nil {
+			got = r.Err.Error()
+		}
+		want := r.Case.Want.String()
+		if r.Case.Status != 0 {
+			want = fmt.Sprintf("%s (%d)", want, r.Case.Status)
+		}
+		fmt.Printf("%s  %-42s want %-14s got %s\n", mark, r.Case.Name, want, got)
+	}
+	fmt.Printf("\n%d of %d cases passed\n", len(results)-failed, len(results))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
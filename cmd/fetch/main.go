@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"tcp.to.http/internal/client"
+	"tcp.to.http/internal/headers"
+	"tcp.to.http/internal/response"
+)
+
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("want Name: value, got %q", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+func main() {
+	method := flag.String("X", "", "request method; GET, or POST when there is a body")
+	data := flag.String("d", "", "request body; @file reads a file and @- reads stdin")
+	include := flag.Bool("i", false, "print the response status line and headers before the body")
+	verbose := flag.Bool("v", false, "print the request and response heads to stderr")
+	follow := flag.Bool("L", false, "follow redirects")
+	output := flag.String("o", "", "write the body to this file instead of stdout")
+	var hs headerFlags
+	flag.Var(&hs, "H", "request header, Name: value; may be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fetch [flags] url\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := fetch(ctx, flag.Arg(0), *method, *data, hs, *include, *verbose, *follow, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "fetch:", err)
+		os.Exit(1)
+	}
+}
+
+func fetch(ctx context.Context, url, method, data string, hs headerFlags, include, verbose, follow bool, output string) error {
+	if method == "" {
+		method = "GET"
+		if data != "" {
+			method = "POST"
+		}
+	}
+	req, err := client.NewRequest(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	for _, h := range hs {
+		name, value, _ := strings.Cut(h, ":")
+		req.Headers.Set(name, strings.TrimSpace(value))
+	}
+	if err := setBody(req, data); err != nil {
+		return err
+	}
+
+	c := &client.Client{MaxIdlePerHost: -1}
+	if !follow {
+		c.Redirects.MaxHops = -1
+	}
+	c.Redirects.Check = func(next *client.Request, via []*client.Request) error {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "* following redirect to %s\n", next.URL)
+			printRequest(next)
+		}
+		return nil
+	}
+	if verbose {
+		printRequest(req)
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if verbose {
+		printHead(os.Stderr, "< ", res)
+	}
+
+	out := io.Writer(os.Stdout)
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if include {
+		printHead(out, "", res)
+	}
+	if _, err := io.Copy(out, res.Body); err != nil {
+		return err
+	}
+	if verbose && len(sorted(res.Trailers)) > 0 {
+		for _, line := range sorted(res.Trailers) {
+			fmt.Fprintf(os.Stderr, "< %s\n", line)
+		}
+	}
+	return nil
+}
+
+// setBody streams files and stdin rather than reading them into memory.
+func setBody(req *client.Request, data string) error {
+	name, ok := strings.CutPrefix(data, "@")
+	if !ok {
+		if data != "" {
+			req.Body = []byte(data)
+		}
+		return nil
+	}
+	if name == "-" {
+		req.BodyReader, req.ContentLength = os.Stdin, -1
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req.BodyReader, req.ContentLength = f, info.Size()
+	return nil
+}
+
+// printRequest shows the request head as the caller built it; the client
+// adds Host, User-Agent and framing headers on the way out.
+func printRequest(req *client.Request) {
+	fmt.Fprintf(os.Stderr, "> %s %s HTTP/1.1\n", req.Method, req.URL.RequestURI())
+	fmt.Fprintf(os.Stderr, "> host: %s\n", req.URL.Host)
+	for _, line := range sorted(req.Headers) {
+		fmt.Fprintf(os.Stderr, "> %s\n", line)
+	}
+	fmt.Fprintln(os.Stderr, ">")
+}
+
+func printHead(w io.Writer, prefix string, res *client.Response) {
+	fmt.Fprintf(w, "%sHTTP/1.1 %d %s\r\n", prefix, res.StatusCode, response.StatusText(res.StatusCode))
+	for _, line := range sorted(res.Headers) {
+		fmt.Fprintf(w, "%s%s\r\n", prefix, line)
+	}
+	fmt.Fprintf(w, "%s\r\n", prefix)
+}
+
+func sorted(h *headers.Headers) []string {
+	lines := []string{}
+	h.ForEach(func(n, v string) {
+		lines = append(lines, n+": "+v)
+	})
+	sort.Strings(lines)
+	return lines
+}
@@ -1,31 +1,32 @@
 package main
 
 import (
-	"crypto/sha256"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
-	"tcp.to.http/internal/headers"
+	"tcp.to.http/internal/client"
+	"tcp.to.http/internal/config"
+	"tcp.to.http/internal/digest"
+	"tcp.to.http/internal/logfile"
+	"tcp.to.http/internal/proxy"
 	request "tcp.to.http/internal/requests"
 	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/router"
 	"tcp.to.http/internal/server"
 )
 
 const port = 42069
 
-func toStr(bytes []byte) string {
-	out := ""
-	for _, b := range bytes {
-		out += fmt.Sprintf("%02x", b)
-	}
-	return out
-}
-
 func response400() []byte {
 	return []byte(`
 	<html>
@@ -52,6 +53,19 @@ func response500() []byte {
 	</html>
 	`)
 }
+func response404() []byte {
+	return []byte(`
+	<html>
+	<head>
+		<title>404 Not Found</title>
+	</head>
+	<body>
+		<h1>Not Found</h1>
+		<p>Nothing here, and nothing was ever going to be.</p>
+	</body>
+	</html>
+	`)
+}
 func response200() []byte {
 	return []byte(`
 	<html>
@@ -67,83 +81,153 @@ func response200() []byte {
 }
 
 func main() {
-	server, err := server.Serve(port, func(w *response.Writer, req *request.Request) {
-		h := response.GetDefaultHeaders(0)
-		body := response200()
-		status := response.StatusOK
-		if req.RequestLine.RequestTarget == "/yourproblem" {
-			body = response400()
-			status = response.StatusBadRequest
-		} else if req.RequestLine.RequestTarget == "/myproblem" {
-			body = response500()
-			status = response.StatusInternalServeError
-		} else if req.RequestLine.RequestTarget == "/video" {
-			f, _ := os.ReadFile("assets/vim.mp4")
-			h.Replace("content-type", "video/mp4")
-			h.Replace("content-length", fmt.Sprintf("%d", len(f)))
+	s, err := loadSettings(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Error reading settings: %v", err)
+	}
+	if s.LogFile != "" {
+		f, err := logfile.Open(s.LogFile)
+		if err != nil {
+			log.Fatalf("Error opening log: %v", err)
+		}
+		log.SetOutput(f)
+		defer logfile.ReopenOnSignal(f)()
+	}
+	host, port, _ := s.hostPort()
+	options := []server.Option{server.WithHost(host)}
+	if s.ReadTimeout > 0 {
+		options = append(options, server.WithReadTimeout(time.Duration(s.ReadTimeout)))
+	}
+	if s.WriteTimeout > 0 {
+		options = append(options, server.WithWriteTimeout(time.Duration(s.WriteTimeout)))
+	}
+	if s.IdleTimeout > 0 {
+		options = append(options, server.WithIdleTimeout(time.Duration(s.IdleTimeout)))
+	}
+	if s.HealthPath != "" {
+		options = append(options, server.WithHealthCheck(s.HealthPath))
+	}
+	if s.DrainDelay > 0 {
+		options = append(options, server.WithDrainDelay(time.Duration(s.DrainDelay)))
+	}
+	if s.LogLevel == "debug" {
+		options = append(options, server.WithConnLog(log.Default()))
+	}
+	infof := func(format string, v ...any) {
+		if s.LogLevel != "error" {
+			log.Printf(format, v...)
+		}
+	}
 
-			w.WriteStatusLine(response.StatusOK)
+	page := func(status response.StatusCode, body []byte) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			h := response.GetDefaultHeaders(len(body))
+			h.Replace("Content-type", "text/html")
+			w.WriteStatusLine(status)
 			w.WriteHeaders(*h)
-			w.WriteBody(f)
-
+			w.WriteBody(body)
+		}
+	}
+	routes := router.New()
+	routes.Handle("GET", "/", page(response.StatusOK, response200()))
+	routes.Handle("GET", "/yourproblem", page(response.StatusBadRequest, response400()))
+	routes.Handle("GET", "/myproblem", page(response.StatusInternalServeError, response500()))
+	routes.Handle("GET", "/video", func(w *response.Writer, req *request.Request) {
+		f, err := os.Open(filepath.Join(s.Assets, "vim.mp4"))
+		if err != nil {
+			server.Error(w, req, response.StatusNotFound, "")
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			server.Error(w, req, response.StatusInternalServeError, "")
 			return
-		} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-			target := req.RequestLine.RequestTarget
-			res, err := http.Get("https://httpbin.org/" + target[len("/httpbin/"):])
-
-			// res, err := http.Get("https://httpbin.org/stream/2")
-			if err != nil {
-				body = response500()
-				status = response.StatusInternalServeError
-			} else {
-				w.WriteStatusLine(response.StatusOK)
-
-				h.Delete("Content-length")
-				h.Set("transfer-encoding", "chunked")
-				h.Replace("Content-Type", "text/plain")
-				h.Set("Trailer", "X-Content-SHA256")
-				h.Set("Trailer", "X-Content-Length ")
-				w.WriteHeaders(*h)
-
-				fullBody := []byte{}
-
-				for {
-					data := make([]byte, 32)
-					n, err := res.Body.Read(data)
-					if err != nil {
-						break
-					}
-
-					fullBody = append(fullBody, data[:n]...)
-					w.WriteBody([]byte(fmt.Sprintf("%x\r\n", n)))
-					w.WriteBody(data[:n])
-					w.WriteBody([]byte("\r\n"))
-				}
-				w.WriteBody([]byte("0\r\n"))
-				tailers := headers.NewHeaders()
-				out := sha256.Sum256(fullBody)
-				tailers.Set("X-Content-SHA256", toStr(out[:]))
-				tailers.Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
-				w.WriteHeaders(*tailers)
-				return
-			}
 		}
+		h := response.GetDefaultHeaders(0)
+		h.Replace("content-type", "video/mp4")
+		h.Replace("content-length", fmt.Sprintf("%d", info.Size()))
 
-		h.Replace("Content-length", fmt.Sprintf("%d", len(body)))
-		h.Replace("Content-type", "text/html")
-		w.WriteStatusLine(status)
+		w.WriteStatusLine(response.StatusOK)
 		w.WriteHeaders(*h)
-		w.WriteBody(body)
+		// Copied with sendfile, straight from the page cache.
+		io.Copy(w, f)
+	})
+	routes.Handle("GET", "/httpbin/*", func(w *response.Writer, req *request.Request) {
+		target := req.RequestLine.RequestTarget
+		// The request context is canceled if the client hangs up, which
+		// abandons the upstream fetch as well.
+		res, err := client.Get(req.Context(), strings.TrimSuffix(s.HTTPBin, "/")+"/"+target[len("/httpbin/"):])
+		if err != nil {
+			page(response.StatusInternalServeError, response500())(w, req)
+			return
+		}
+		defer res.Body.Close()
+
+		h := response.GetDefaultHeaders(0)
+		h.Replace("Content-Type", "text/plain")
+		body := digest.NewWriter(w, digest.Trailers{
+			Digest: []string{"SHA-256"},
+			SHA256: "X-Content-SHA256",
+			Length: "X-Content-Length",
+		})
+		body.WriteHead(response.StatusOK, h)
+		// Small chunks to show off the chunked encoding.
+		if _, err := io.CopyBuffer(body, res.Body, make([]byte, 32)); err != nil {
+			// Left unterminated, so the client sees the body is incomplete.
+			return
+		}
+		// Pass on what httpbin sent after its own body as well.
+		body.Close(res.Trailers)
 	})
+	routes.NotFound(page(response.StatusNotFound, response404()))
+
+	// Static directories and upstreams from the reloadable config take
+	// precedence over the routes above; SIGHUP swaps in the file's new
+	// contents without dropping connections.
+	handler := routes.Serve
+	var reloader *config.Reloader
+	if s.Reloadable != "" {
+		reloader, err = config.NewReloader(s.Reloadable)
+		if err != nil {
+			log.Fatalf("Error reading config: %v", err)
+		}
+		defer reloader.ReloadOnSignal()()
+		handler = reloader.Static(reloader.Upstreams(proxy.Config{}, handler))
+	}
+
+	server, err := server.Serve(port, handler, options...)
 
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 	defer server.Close()
-	log.Println("Server started on port", port)
+	if reloader != nil {
+		reloader.OnReload(func(c *config.Config) {
+			if c.ReadTimeout > 0 || c.WriteTimeout > 0 {
+				server.SetTimeouts(time.Duration(c.ReadTimeout), time.Duration(c.WriteTimeout))
+			}
+		})
+	}
+	infof("Server started on %s", server.Addr())
+
+	// SIGUSR2 hands the listening socket to a fresh copy of the binary and
+	// drains this one.
+	restarted := server.RestartOnSignal(30 * time.Second)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-	log.Println("Server gracefully stopped")
+	select {
+	case <-sigChan:
+		// Fails the health check for the drain delay, then lets busy
+		// connections finish.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.DrainDelay)+30*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	case <-restarted:
+	}
+	infof("Server gracefully stopped")
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tcp.to.http/internal/config"
+)
+
+// settings configure the demo server. Each comes, in increasing priority,
+// from its default, the --config file, an HTTPSERVER_* variable or a flag.
+type settings struct {
+	Listen       string          `json:"listen"`
+	Assets       string          `json:"assets"`
+	ReadTimeout  config.Duration `json:"read_timeout"`
+	WriteTimeout config.Duration `json:"write_timeout"`
+	IdleTimeout  config.Duration `json:"idle_timeout"`
+	LogLevel     string          `json:"log_level"`
+	LogFile      string          `json:"log_file"`
+	HealthPath   string          `json:"health_path"`
+	DrainDelay   config.Duration `json:"drain_delay"`
+	HTTPBin      string          `json:"httpbin_url"`
+	Reloadable   string          `json:"reloadable_config"`
+}
+
+func loadSettings(args []string) (*settings, error) {
+	s := &settings{
+		Listen:   fmt.Sprintf(":%d", port),
+		Assets:   "assets",
+		LogLevel: "info",
+		HTTPBin:  "https://httpbin.org",
+	}
+	path := ""
+	fs := flag.NewFlagSet("httpServer", flag.ContinueOnError)
+	fs.StringVar(&path, "config", "", "JSON file with any of the settings below, keyed in snake_case")
+	fs.StringVar(&s.Listen, "listen", s.Listen, "address to listen on, host:port or :port")
+	fs.StringVar(&s.Assets, "assets", s.Assets, "directory holding vim.mp4 for /video")
+	fs.DurationVar((*time.Duration)(&s.ReadTimeout), "read-timeout", 0, "how long a client may take to send a request; 0 for no limit")
+	fs.DurationVar((*time.Duration)(&s.WriteTimeout), "write-timeout", 0, "how long writing a response may take; 0 for no limit")
+	fs.DurationVar((*time.Duration)(&s.IdleTimeout), "idle-timeout", 0, "how long a keep-alive connection may sit idle; 0 for the server default")
+	fs.StringVar(&s.LogLevel, "log-level", s.LogLevel, "debug (adds a line per connection), info or error")
+	fs.StringVar(&s.LogFile, "log-file", s.LogFile, "file to append the log to, reopened on SIGUSR1; stderr by default")
+	fs.StringVar(&s.HealthPath, "health-path", s.HealthPath, "path answered with 200, or 503 once shutting down; none by default")
+	fs.DurationVar((*time.Duration)(&s.DrainDelay), "drain-delay", 0, "how long to fail health checks on shutdown before closing the listener")
+	fs.StringVar(&s.HTTPBin, "httpbin-url", s.HTTPBin, "upstream that /httpbin/ proxies to")
+	fs.StringVar(&s.Reloadable, "reloadable-config", s.Reloadable, "JSON file of timeouts, static directories and upstreams, reloaded on SIGHUP")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Flags have been read once to find the file; read the file, then the
+	// environment, then the flags again so each overrides the one before.
+	if path == "" {
+		path = os.Getenv("HTTPSERVER_CONFIG")
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := "HTTPSERVER_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok && f.Name != "config" && envErr == nil {
+			if err := f.Value.Set(value); err != nil {
+				envErr = fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+	fs.Parse(args)
+
+	switch s.LogLevel {
+	case "debug", "info", "error":
+	default:
+		return nil, fmt.Errorf("unknown log level %q", s.LogLevel)
+	}
+	if _, _, err := s.hostPort(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *settings) hostPort() (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(s.Listen)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("listen %s: bad port", s.Listen)
+	}
+	return host, uint16(port), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"tcp.to.http/internal/middleware"
+	"tcp.to.http/internal/proxy"
+	"tcp.to.http/internal/server"
+)
+
+// fileConfig is the YAML config file; flags override or add to it.
+type fileConfig struct {
+	Listen  string `yaml:"listen"`
+	Connect bool   `yaml:"connect"`
+	TLS     struct {
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+		// Certificates are picked by SNI name over cert_file, which
+		// remains the default for other names.
+		Certificates []struct {
+			CertFile string `yaml:"cert_file"`
+			KeyFile  string `yaml:"key_file"`
+		} `yaml:"certificates"`
+	} `yaml:"tls"`
+	Routes []struct {
+		Prefix      string   `yaml:"prefix"`
+		Upstreams   []string `yaml:"upstreams"`
+		StripPrefix string   `yaml:"strip_prefix"`
+		AddPrefix   string   `yaml:"add_prefix"`
+		Rewrites    []struct {
+			Pattern     string `yaml:"pattern"`
+			Replacement string `yaml:"replacement"`
+		} `yaml:"rewrites"`
+		SetRequestHeaders     map[string]string `yaml:"set_request_headers"`
+		RemoveRequestHeaders  []string          `yaml:"remove_request_headers"`
+		SetResponseHeaders    map[string]string `yaml:"set_response_headers"`
+		RemoveResponseHeaders []string          `yaml:"remove_response_headers"`
+		Replace               []struct {
+			Type string `yaml:"type"`
+			Old  string `yaml:"old"`
+			New  string `yaml:"new"`
+		} `yaml:"replace"`
+		Gzip          []string      `yaml:"gzip"`
+		Retries       int           `yaml:"retries"`
+		MaxFailures   int           `yaml:"max_failures"`
+		SlowThreshold time.Duration `yaml:"slow_threshold"`
+		FailTimeout   time.Duration `yaml:"fail_timeout"`
+		Buffer        bool          `yaml:"buffer"`
+		BufferMemory  int64         `yaml:"buffer_memory"`
+	} `yaml:"routes"`
+}
+
+type routeFlags []proxy.Route
+
+func (r *routeFlags) String() string { return fmt.Sprint(*r) }
+
+func (r *routeFlags) Set(v string) error {
+	prefix, upstreams, ok := strings.Cut(v, "=")
+	if !ok || upstreams == "" {
+		return fmt.Errorf("want /prefix=http://upstream[,http://upstream], got %q", v)
+	}
+	*r = append(*r, proxy.Route{Prefix: prefix, Upstreams: strings.Split(upstreams, ",")})
+	return nil
+}
+
+func main() {
+	configPath := flag.String("config", "", "YAML config file")
+	listen := flag.String("listen", "", "address to listen on (default :8080)")
+	connect := flag.Bool("connect", false, "also act as a forward proxy for CONNECT tunnels")
+	certFile := flag.String("cert", "", "TLS certificate file; serves HTTPS with -key")
+	keyFile := flag.String("key", "", "TLS key file")
+	var routes routeFlags
+	flag.Var(&routes, "route", "route as /prefix=http://upstream[,http://upstream]; may be repeated")
+	flag.Parse()
+
+	fc := fileConfig{Listen: ":8080"}
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			log.Fatalf("Error reading config: %v", err)
+		}
+		if err := yaml.Unmarshal(data, &fc); err != nil {
+			log.Fatalf("Error reading config %s: %v", *configPath, err)
+		}
+	}
+	config := proxy.Config{Connect: fc.Connect || *connect}
+	config.Circuit = func(upstream string, open bool) {
+		if open {
+			log.Printf("Circuit opened for %s", upstream)
+		} else {
+			log.Printf("Circuit closed for %s", upstream)
+		}
+	}
+	for _, r := range fc.Routes {
+		route := proxy.Route{
+			Prefix:                r.Prefix,
+			Upstreams:             r.Upstreams,
+			StripPrefix:           r.StripPrefix,
+			AddPrefix:             r.AddPrefix,
+			SetRequestHeaders:     r.SetRequestHeaders,
+			RemoveRequestHeaders:  r.RemoveRequestHeaders,
+			SetResponseHeaders:    r.SetResponseHeaders,
+			RemoveResponseHeaders: r.RemoveResponseHeaders,
+			Retries:               r.Retries,
+			MaxFailures:           r.MaxFailures,
+			SlowThreshold:         r.SlowThreshold,
+			FailTimeout:           r.FailTimeout,
+			Buffer:                r.Buffer,
+			BufferMemory:          r.BufferMemory,
+		}
+		for _, rw := range r.Rewrites {
+			route.Rewrites = append(route.Rewrites, proxy.Rewrite(rw))
+		}
+		for _, rep := range r.Replace {
+			route.Transforms = append(route.Transforms, proxy.Replace(rep.Type, rep.Old, rep.New))
+		}
+		for _, mediaType := range r.Gzip {
+			route.Transforms = append(route.Transforms, proxy.Gzip(mediaType))
+		}
+		config.Routes = append(config.Routes, route)
+	}
+	config.Routes = append(config.Routes, routes...)
+	if *listen != "" {
+		fc.Listen = *listen
+	}
+	if *certFile != "" {
+		fc.TLS.CertFile, fc.TLS.KeyFile = *certFile, *keyFile
+	}
+	if len(config.Routes) == 0 && !config.Connect {
+		log.Fatal("Nothing to proxy: add routes or -connect")
+	}
+
+	handler, err := proxy.Handler(config)
+	if err != nil {
+		log.Fatalf("Error in config: %v", err)
+	}
+	handler = middleware.RequestID(middleware.RequestIDConfig{})(handler)
+	host, portStr, err := net.SplitHostPort(fc.Listen)
+	if err != nil {
+		log.Fatalf("Error in listen address: %v", err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		log.Fatalf("Error in listen address: bad port %q", portStr)
+	}
+	options := []server.Option{server.WithHost(host), server.WithIdleTimeout(time.Minute)}
+	if fc.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(fc.TLS.CertFile, fc.TLS.KeyFile)
+		if err != nil {
+			log.Fatalf("Error loading certificate: %v", err)
+		}
+		options = append(options, server.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+		if len(fc.TLS.Certificates) > 0 {
+			certs := server.NewCertificates()
+			certs.SetDefault(&cert)
+			for _, c := range fc.TLS.Certificates {
+				cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+				if err == nil {
+					err = certs.Add(&cert)
+				}
+				if err != nil {
+					log.Fatalf("Error loading certificate %s: %v", c.CertFile, err)
+				}
+			}
+			options = append(options, server.WithGetCertificate(certs.GetCertificate))
+		}
+	}
+
+	s, err := server.Serve(uint16(port), handler, options...)
+	if err != nil {
+		log.Fatalf("Error starting proxy: %v", err)
+	}
+	log.Printf("Proxy listening on %s", s.Addr())
+	for _, r := range config.Routes {
+		log.Printf("  %s -> %s", r.Prefix, strings.Join(r.Upstreams, ", "))
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp.to.http/internal/capture"
+	"tcp.to.http/internal/client"
+)
+
+// replay sends the requests in a capture file written by
+// `tcplistener --json --out` to a target server, and prints how each went.
+func main() {
+	in := flag.String("in", "capture.jsonl", "capture file to replay")
+	target := flag.String("target", "http://localhost:42069", "base URL to send the requests to")
+	speed := flag.Float64("speed", 1, "timing multiplier: 1 keeps the original gaps, 2 halves them, 0 sends back to back")
+	flag.Parse()
+
+	f, err := os.Open(*in)
+	if err != nil {
+		log.Fatal("Error", "Error", err)
+	}
+	records, err := capture.Read(f)
+	f.Close()
+	if err != nil {
+		log.Fatal("Error", "Error", err)
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	base := strings.TrimSuffix(*target, "/")
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	out := sync.Mutex{}
+	for i, rec := range records {
+		if *speed > 0 {
+			// Keep the gaps between requests, not their durations, so a slow
+			// response doesn't push the rest back.
+			at := time.Duration(float64(rec.Time.Sub(records[0].Time)) / *speed)
+			time.Sleep(time.Until(start.Add(at)))
+		}
+		send := func() {
+			line := replay(base, rec)
+			out.Lock()
+			fmt.Printf("%d %s %s %s\n", i, rec.Method, rec.Target, line)
+			out.Unlock()
+		}
+		if *speed > 0 {
+			wg.Go(send)
+		} else {
+			send()
+		}
+	}
+	wg.Wait()
+}
+
+func replay(base string, rec capture.Record) string {
+	req, err := client.NewRequest(context.Background(), rec.Method, base+rec.Target, []byte(rec.Body))
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	for name, value := range rec.Headers {
+		switch name {
+		// The client frames the body and names the host itself.
+		case "host", "content-length", "transfer-encoding", "connection":
+			continue
+		}
+		req.Headers.Replace(name, value)
+	}
+
+	start := time.Now()
+	res, err := client.DefaultClient.Do(req)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	n, err := io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if err != nil {
+		return fmt.Sprintf("-> %d, body error after %d bytes: %v", res.StatusCode, n, err)
+	}
+	return fmt.Sprintf("-> %d, %d bytes in %s", res.StatusCode, n, time.Since(start).Round(time.Millisecond))
+}
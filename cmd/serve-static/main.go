@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"tcp.to.http/internal/fileserver"
+	"tcp.to.http/internal/headers"
+	"tcp.to.http/internal/logfile"
+	"tcp.to.http/internal/middleware"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// serve-static serves a directory, like python -m http.server:
+//
+//	go run ./cmd/serve-static -port 8000 ./public
+func main() {
+	port := flag.Uint("port", 8000, "port to listen on")
+	bind := flag.String("bind", "", "address to listen on; all of them by default")
+	index := flag.String("index", "index.html", "file served for directory requests")
+	listing := flag.Bool("listing", true, "list directories that have no index file")
+	maxAge := flag.Duration("max-age", 0, "Cache-Control max-age for successful responses; 0 sends no-cache")
+	quiet := flag.Bool("quiet", false, "don't log each request")
+	errorsOnly := flag.Bool("log-errors", false, "log only requests answered with a 4xx or 5xx")
+	sample := flag.Int("log-sample", 1, "log one in this many successful requests")
+	accessPath := flag.String("access-log", "", "file to append request lines to, reopened on SIGUSR1; stderr by default")
+	errorPath := flag.String("error-log", "", "file to append other messages to, reopened on SIGUSR1; stderr by default")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: serve-static [flags] [directory]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 || *port > 65535 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	root := "."
+	if flag.NArg() == 1 {
+		root = flag.Arg(0)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		log.Fatalf("Error: %s is not a directory", root)
+	}
+	root, _ = filepath.Abs(root)
+
+	logs := []*logfile.File{}
+	openLog := func(path string) io.Writer {
+		if path == "" {
+			return os.Stderr
+		}
+		f, err := logfile.Open(path)
+		if err != nil {
+			log.Fatalf("Error opening log: %v", err)
+		}
+		logs = append(logs, f)
+		return f
+	}
+	log.SetOutput(openLog(*errorPath))
+	accessLog := middleware.AccessLogConfig{Output: openLog(*accessPath), SampleSuccess: *sample}
+	defer logfile.ReopenOnSignal(logs...)()
+
+	files := fileserver.Handler(fileserver.Config{Root: root, Index: *index, Listing: *listing})
+	cacheControl := "no-cache"
+	if *maxAge > 0 {
+		cacheControl = fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+	}
+	handler := func(w *response.Writer, req *request.Request) {
+		w.OnHeaders(func(status response.StatusCode, h *headers.Headers) {
+			if status < 300 {
+				h.Replace("Cache-Control", cacheControl)
+			}
+		})
+		files(w, req)
+	}
+	if !*quiet {
+		if *errorsOnly {
+			accessLog.MinStatus = response.StatusBadRequest
+		}
+		handler = middleware.AccessLog(accessLog)(handler)
+	}
+	handler = middleware.RequestID(middleware.RequestIDConfig{})(handler)
+
+	s, err := server.Serve(uint16(*port), handler, server.WithHost(*bind), server.WithIdleTimeout(30*time.Second))
+	if err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+	log.Printf("Serving %s on http://%s/", root, s.Addr())
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
+}
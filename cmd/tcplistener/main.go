@@ -1,18 +1,46 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"tcp.to.http/internal/capture"
 	request "tcp.to.http/internal/requests"
 )
 
+// out keeps the dumps of concurrent connections from interleaving.
+var out sync.Mutex
+
+var (
+	hexDump = flag.Bool("hex", false, "print the raw bytes received as a hex dump")
+	parse   = flag.Bool("parse", true, "print each request as parsed; turn off with --hex to see only the bytes")
+	asJSON  = flag.Bool("json", false, "print each request as one JSON record per line")
+	outPath = flag.String("out", "", "append output to this file instead of stdout")
+)
+
+// output is where everything goes, guarded by out.
+var output io.Writer = os.Stdout
+
 func main() {
-	// f, err := os.Open("message.txt")
-	// if err != nil {
-	// 	log.Fatal("crash", "crash", err)
-	// }
+	flag.Parse()
+	if *outPath != "" {
+		f, err := os.OpenFile(*outPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatal("Error", "Error", err)
+		}
+		defer f.Close()
+		output = f
+	}
 
 	listener, err := net.Listen("tcp", ":42068")
 
@@ -23,24 +51,89 @@ func main() {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Fatal("Error", "Error", err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println("accept:", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
 		}
+		go handle(conn)
+	}
+}
 
-		r, err := request.RequestFromReader(conn)
+// handle prints every request on conn until the client hangs up. A request
+// that fails to parse leaves the stream unusable, so the connection is
+// dropped and the listener carries on.
+func handle(conn net.Conn) {
+	defer conn.Close()
+	var reader io.Reader = conn
+	if *hexDump {
+		reader = &hexReader{r: conn, addr: conn.RemoteAddr()}
+	}
+	if !*parse {
+		io.Copy(io.Discard, reader)
+		return
+	}
+
+	parser := request.NewParser(reader, request.Options{})
+	for {
+		r, err := parser.Next(context.Background())
+		if errors.Is(err, io.EOF) {
+			return
+		}
 		if err != nil {
-			log.Fatal("Error", "Error", err)
+			log.Printf("%s: %v", conn.RemoteAddr(), err)
+			return
 		}
+		dump(conn.RemoteAddr(), r)
+	}
+}
 
-		fmt.Printf("Request line: \n")
-		fmt.Printf("- Method: %s\n", r.RequestLine.Method)
-		fmt.Printf("- Target: %s\n", r.RequestLine.RequestTarget)
-		fmt.Printf("- Version: %s\n", r.RequestLine.HttpVersion)
-		fmt.Printf("Headers: \n")
-		r.Headers.ForEach(func(n, v string) {
-			fmt.Printf("- %s: %s\n", n, v)
-		})
-		fmt.Printf("Body: \n")
-		fmt.Printf("%s \n", r.Body)
+func dump(addr net.Addr, r *request.Request) {
+	out.Lock()
+	defer out.Unlock()
+	if *asJSON {
+		capture.Write(output, capture.FromRequest(r, addr.String(), time.Now()))
+		return
 	}
 
+	fmt.Fprintf(output, "Connection: %s\n", addr)
+	fmt.Fprintf(output, "Request line: \n")
+	fmt.Fprintf(output, "- Method: %s\n", r.RequestLine.Method)
+	fmt.Fprintf(output, "- Target: %s\n", r.RequestLine.RequestTarget)
+	fmt.Fprintf(output, "- Version: %s\n", r.RequestLine.HttpVersion)
+	fmt.Fprintf(output, "Headers: \n")
+	r.Headers.ForEach(func(n, v string) {
+		fmt.Fprintf(output, "- %s: %s\n", n, v)
+	})
+	fmt.Fprintf(output, "Body: \n")
+	fmt.Fprintf(output, "%s \n", r.Body)
+}
+
+// hexReader prints each chunk it reads as offset, hex and ASCII columns,
+// counting offsets from the start of the connection. The parser may read
+// ahead, so bytes can show up before the request they belong to is printed.
+type hexReader struct {
+	r      io.Reader
+	addr   net.Addr
+	offset int
+}
+
+func (hr *hexReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	if n > 0 {
+		lines := strings.SplitAfter(hex.Dump(p[:n]), "\n")
+		out.Lock()
+		fmt.Fprintf(output, "Bytes from %s:\n", hr.addr)
+		for i, line := range lines {
+			if line != "" {
+				// hex.Dump counts from zero; the first column is the offset.
+				fmt.Fprintf(output, "%08x%s", hr.offset+i*16, line[8:])
+			}
+		}
+		out.Unlock()
+		hr.offset += n
+	}
+	return n, err
 }
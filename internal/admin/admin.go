@@ -0,0 +1,213 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp.to.http/internal/headers"
+	"tcp.to.http/internal/metrics"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/router"
+	"tcp.to.http/internal/server"
+)
+
+type Config struct {
+	// Token must be presented as "Authorization: Bearer <token>". With no
+	// token configured every request is refused.
+	Token string
+	// Metrics is the registry of the server being observed.
+	Metrics *metrics.Registry
+	// ConfigDump returns the running configuration for /config; secrets
+	// should be redacted by the caller.
+	ConfigDump func() any
+	// Reload re-reads the configuration for POST /reload.
+	Reload func() error
+	// Maintenance switches maintenance mode for POST /maintenance/on and
+	// /maintenance/off, e.g. a server's SetMaintenance.
+	Maintenance func(on bool)
+	// DisableProfiling leaves /debug/pprof/ unmounted.
+	DisableProfiling bool
+	// BlockProfileRate and MutexProfileFraction turn on the block and mutex
+	// profiles when positive; see the runtime functions of the same names.
+	// Both cost enough to be off by default.
+	BlockProfileRate     int
+	MutexProfileFraction int
+}
+
+type routeStats struct {
+	count int64
+	total time.Duration
+	max   time.Duration
+}
+
+// Admin serves runtime stats on its own port, away from public traffic:
+//
+//	/stats          connections, goroutines, memory and per-route latency
+//	/metrics        the metrics registry in Prometheus text format
+//	/config         the running configuration
+//	/reload         (POST) reload the configuration
+//	/maintenance/on (POST) and /maintenance/off switch maintenance mode
+//	/debug/pprof/   the standard pprof handlers: profile (CPU), heap,
+//	                goroutine, block, mutex and the rest, unless disabled
+type Admin struct {
+	config  Config
+	started time.Time
+
+	mu     sync.Mutex
+	routes map[string]*routeStats
+}
+
+func New(config Config) *Admin {
+	if config.Metrics == nil {
+		config.Metrics = metrics.NewRegistry()
+	}
+	if config.BlockProfileRate > 0 {
+		runtime.SetBlockProfileRate(config.BlockProfileRate)
+	}
+	if config.MutexProfileFraction > 0 {
+		runtime.SetMutexProfileFraction(config.MutexProfileFraction)
+	}
+	return &Admin{
+		config:  config,
+		started: time.Now(),
+		routes:  map[string]*routeStats{},
+	}
+}
+
+// ObserveRoute records a handled request; pass it to router.Observe.
+func (a *Admin) ObserveRoute(req *request.Request, pattern string, _ response.StatusCode, d time.Duration) {
+	key := req.RequestLine.Method + " " + pattern
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.routes[key]
+	if !ok {
+		s = &routeStats{}
+		a.routes[key] = s
+	}
+	s.count++
+	s.total += d
+	s.max = max(s.max, d)
+}
+
+func (a *Admin) Handler() server.Handler {
+	r := router.New()
+	r.Handle("GET", "/stats", a.stats)
+	r.Handle("GET", "/metrics", a.metrics)
+	r.Handle("GET", "/config", a.configDump)
+	r.Handle("POST", "/reload", a.reload)
+	r.Handle("POST", "/maintenance/{state}", a.maintenance)
+	if !a.config.DisableProfiling {
+		r.Handle("GET", "/debug/pprof/*", pprof)
+		r.Handle("POST", "/debug/pprof/*", pprof)
+	}
+	return a.authorize(r.Serve)
+}
+
+func (a *Admin) Serve(port uint16, options ...server.Option) (*server.Server, error) {
+	return server.Serve(port, a.Handler(), options...)
+}
+
+func (a *Admin) authorize(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		authorization, _ := req.Headers.Get("authorization")
+		token, ok := strings.CutPrefix(authorization, "Bearer ")
+		if !ok || a.config.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.config.Token)) != 1 {
+			w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+				h.Replace("WWW-Authenticate", `Bearer realm="admin"`)
+			})
+			server.Error(w, req, response.StatusUnauthorized, "")
+			return
+		}
+		next(w, req)
+	}
+}
+
+type routeJSON struct {
+	Route     string  `json:"route"`
+	Count     int64   `json:"count"`
+	AvgMillis float64 `json:"avg_ms"`
+	MaxMillis float64 `json:"max_ms"`
+}
+
+func (a *Admin) stats(w *response.Writer, req *request.Request) {
+	mem := runtime.MemStats{}
+	runtime.ReadMemStats(&mem)
+	active, _ := a.config.Metrics.Value("connections_active")
+
+	a.mu.Lock()
+	routes := make([]routeJSON, 0, len(a.routes))
+	for key, s := range a.routes {
+		routes = append(routes, routeJSON{
+			Route:     key,
+			Count:     s.count,
+			AvgMillis: millis(s.total / time.Duration(s.count)),
+			MaxMillis: millis(s.max),
+		})
+	}
+	a.mu.Unlock()
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Route < routes[j].Route })
+
+	w.WriteJSON(response.StatusOK, map[string]any{
+		"uptime_seconds":     int64(time.Since(a.started).Seconds()),
+		"active_connections": active,
+		"goroutines":         runtime.NumGoroutine(),
+		"memory": map[string]uint64{
+			"heap_alloc_bytes": mem.HeapAlloc,
+			"heap_sys_bytes":   mem.HeapSys,
+			"sys_bytes":        mem.Sys,
+			"num_gc":           uint64(mem.NumGC),
+		},
+		"routes": routes,
+	})
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (a *Admin) metrics(w *response.Writer, req *request.Request) {
+	b := strings.Builder{}
+	a.config.Metrics.WriteText(&b)
+	h := response.GetDefaultHeaders(b.Len())
+	h.Replace("Content-Type", "text/plain; version=0.0.4")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	w.WriteBody([]byte(b.String()))
+}
+
+func (a *Admin) configDump(w *response.Writer, req *request.Request) {
+	if a.config.ConfigDump == nil {
+		server.Error(w, req, response.StatusNotFound, "")
+		return
+	}
+	w.WriteJSON(response.StatusOK, a.config.ConfigDump())
+}
+
+func (a *Admin) reload(w *response.Writer, req *request.Request) {
+	if a.config.Reload == nil {
+		server.Error(w, req, response.StatusNotFound, "")
+		return
+	}
+	if err := a.config.Reload(); err != nil {
+		server.Error(w, req, response.StatusInternalServeError, err.Error())
+		return
+	}
+	w.WriteJSON(response.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+func (a *Admin) maintenance(w *response.Writer, req *request.Request) {
+	state := router.Param(req, "state")
+	if a.config.Maintenance == nil || state != "on" && state != "off" {
+		server.Error(w, req, response.StatusNotFound, "")
+		return
+	}
+	a.config.Maintenance(state == "on")
+	w.WriteJSON(response.StatusOK, map[string]bool{"maintenance": state == "on"})
+}
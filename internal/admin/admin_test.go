@@ -0,0 +1,159 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/metrics"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func run(t *testing.T, a *Admin, target, token string) string {
+	raw := "GET " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+	if token != "" {
+		raw += "Authorization: Bearer " + token + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	out := bytes.Buffer{}
+	a.Handler()(response.NewWriter(&out), req)
+	return out.String()
+}
+
+func body(out string) string {
+	_, b, _ := strings.Cut(out, "\r\n\r\n")
+	return b
+}
+
+func TestAdmin(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.Gauge("connections_active", "").Set(3)
+	a := New(Config{
+		Token:      "s3cret",
+		Metrics:    registry,
+		ConfigDump: func() any { return map[string]string{"static": "/srv/www"} },
+	})
+
+	// Test: Requests without the token are refused
+	out := run(t, a, "/stats", "")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	out = run(t, a, "/stats", "wrong")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+
+	// Test: Stats include connections and per-route latency
+	req, err := request.RequestFromReader(strings.NewReader("GET /users/1 HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	a.ObserveRoute(req, "/users/{id}", response.StatusOK, 10*time.Millisecond)
+	a.ObserveRoute(req, "/users/{id}", response.StatusOK, 30*time.Millisecond)
+
+	out = run(t, a, "/stats", "s3cret")
+	require.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	stats := struct {
+		ActiveConnections int64       `json:"active_connections"`
+		Goroutines        int         `json:"goroutines"`
+		Routes            []routeJSON `json:"routes"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body(out)), &stats))
+	assert.Equal(t, int64(3), stats.ActiveConnections)
+	assert.Positive(t, stats.Goroutines)
+	assert.Equal(t, []routeJSON{{Route: "GET /users/{id}", Count: 2, AvgMillis: 20, MaxMillis: 30}}, stats.Routes)
+
+	// Test: Metrics and config are exposed
+	assert.Contains(t, body(run(t, a, "/metrics", "s3cret")), "connections_active 3\n")
+	assert.JSONEq(t, `{"static":"/srv/www"}`, body(run(t, a, "/config", "s3cret")))
+
+	// Test: pprof is passed through
+	out = run(t, a, "/debug/pprof/goroutine?debug=1", "s3cret")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, body(out), "goroutine profile:")
+}
+
+func TestAdminReload(t *testing.T) {
+	reloads := 0
+	a := New(Config{Token: "s3cret", Reload: func() error {
+		reloads++
+		return nil
+	}})
+
+	req, err := request.RequestFromReader(strings.NewReader("POST /reload HTTP/1.1\r\nAuthorization: Bearer s3cret\r\n\r\n"))
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	a.Handler()(response.NewWriter(&out), req)
+
+	// Test: POST /reload calls Reload
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, 1, reloads)
+}
+
+func TestAdminProfiling(t *testing.T) {
+	// Test: Named profiles such as heap are served
+	a := New(Config{Token: "s3cret"})
+	out := run(t, a, "/debug/pprof/heap?debug=1", "s3cret")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, body(out), "heap profile:")
+
+	// Test: Profiling can be left unmounted
+	a = New(Config{Token: "s3cret", DisableProfiling: true})
+	out = run(t, a, "/debug/pprof/goroutine?debug=1", "s3cret")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), out)
+}
+
+func TestWatchStalls(t *testing.T) {
+	registry := metrics.NewRegistry()
+	inFlight := registry.Gauge("requests_in_flight", "")
+	a := New(Config{Metrics: registry})
+	dir := t.TempDir()
+	stop := a.WatchStalls(StallConfig{Threshold: 2, For: 50 * time.Millisecond, Dir: dir})
+	defer stop()
+	dumps := func() []string {
+		files, _ := filepath.Glob(filepath.Join(dir, "goroutines-*.txt"))
+		return files
+	}
+
+	// Test: A brief spike is not a stall
+	inFlight.Set(5)
+	time.Sleep(20 * time.Millisecond)
+	inFlight.Set(1)
+	time.Sleep(80 * time.Millisecond)
+	assert.Empty(t, dumps())
+
+	// Test: Staying over the threshold dumps goroutines once
+	inFlight.Set(3)
+	require.Eventually(t, func() bool { return len(dumps()) == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, dumps(), 1)
+	data, err := os.ReadFile(dumps()[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "goroutine ")
+}
+
+func TestAdminMaintenance(t *testing.T) {
+	states := []bool{}
+	a := New(Config{Token: "s3cret", Maintenance: func(on bool) { states = append(states, on) }})
+	post := func(target string) string {
+		req, err := request.RequestFromReader(strings.NewReader("POST " + target + " HTTP/1.1\r\nAuthorization: Bearer s3cret\r\n\r\n"))
+		require.NoError(t, err)
+		out := bytes.Buffer{}
+		a.Handler()(response.NewWriter(&out), req)
+		return out.String()
+	}
+
+	// Test: Maintenance is switched on and off
+	out := post("/maintenance/on")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.JSONEq(t, `{"maintenance":true}`, body(out))
+	post("/maintenance/off")
+	assert.Equal(t, []bool{true, false}, states)
+
+	// Test: Unknown states are not found
+	assert.True(t, strings.HasPrefix(post("/maintenance/soon"), "HTTP/1.1 404 Not Found\r\n"))
+}
@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"bytes"
+	"net/http"
+	httppprof "net/http/pprof"
+	"strings"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// pprof passes /debug/pprof requests through to net/http/pprof, which only
+// speaks http.Handler, by buffering its output into a response.
+func pprof(w *response.Writer, req *request.Request) {
+	target := req.RequestLine.RequestTarget
+	httpReq, err := http.NewRequestWithContext(req.Context(), req.RequestLine.Method, target, req.BodyReader())
+	if err != nil {
+		server.Error(w, req, response.StatusBadRequest, "")
+		return
+	}
+	req.Headers.ForEach(func(n, v string) {
+		httpReq.Header.Set(n, v)
+	})
+
+	var handler http.HandlerFunc
+	switch strings.TrimPrefix(httpReq.URL.Path, "/debug/pprof/") {
+	case "cmdline":
+		handler = httppprof.Cmdline
+	case "profile":
+		handler = httppprof.Profile
+	case "symbol":
+		handler = httppprof.Symbol
+	case "trace":
+		handler = httppprof.Trace
+	default:
+		handler = httppprof.Index
+	}
+
+	rec := &recorder{header: http.Header{}, status: http.StatusOK}
+	handler(rec, httpReq)
+
+	h := response.GetDefaultHeaders(rec.body.Len())
+	for name, values := range rec.header {
+		h.Replace(name, strings.Join(values, ", "))
+	}
+	w.WriteStatusLine(response.StatusCode(rec.status))
+	w.WriteHeaders(*h)
+	w.WriteBody(rec.body.Bytes())
+}
+
+type recorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.wroteHeader = true
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	runtimepprof "runtime/pprof"
+	"time"
+)
+
+type StallConfig struct {
+	// Threshold is the in-flight request count that counts as a stall once
+	// it has been exceeded for For.
+	Threshold int64
+	For       time.Duration
+	// Dir receives the goroutine dumps, os.TempDir() when empty.
+	Dir string
+}
+
+// WatchStalls dumps every goroutine's stack to a file when the server's
+// requests_in_flight gauge stays above config.Threshold for config.For, to
+// show what piled-up requests are stuck on. One dump is taken per stall.
+// The returned func stops watching.
+func (a *Admin) WatchStalls(config StallConfig) (stop func()) {
+	if config.Dir == "" {
+		config.Dir = os.TempDir()
+	}
+	interval := min(max(config.For/10, 10*time.Millisecond), time.Second)
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		defer ticker.Stop()
+		var since time.Time
+		dumped := false
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				inFlight, _ := a.config.Metrics.Value("requests_in_flight")
+				if inFlight <= config.Threshold {
+					since, dumped = time.Time{}, false
+					continue
+				}
+				if since.IsZero() {
+					since = now
+				}
+				if !dumped && now.Sub(since) >= config.For {
+					dumped = true
+					path, err := dumpGoroutines(config.Dir, now)
+					if err != nil {
+						log.Printf("admin: goroutine dump failed: %v", err)
+						continue
+					}
+					log.Printf("admin: %d requests in flight for %s, goroutines dumped to %s", inFlight, now.Sub(since).Round(time.Millisecond), path)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+func dumpGoroutines(dir string, now time.Time) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("goroutines-%d.txt", now.UnixNano()))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := runtimepprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+type DigestConfig struct {
+	Realm string
+	// Password looks up the plain password for a user.
+	Password func(username string) (string, bool)
+	// Algorithm is "SHA-256" (default) or "MD5" for legacy clients.
+	Algorithm string
+	NonceTTL  time.Duration
+	// MaxNonces bounds how many nonces are tracked for replays, 10000 by
+	// default. Past it the oldest are dropped and answered as stale, so
+	// their clients ask for a fresh one.
+	MaxNonces int
+	// Secret keys nonce generation; a random one is used when empty.
+	Secret []byte
+}
+
+type DigestAuth struct {
+	config DigestConfig
+	opaque string
+	now    func() time.Time
+
+	// seen holds the nonces in use, ordered by when they were issued, so
+	// expired ones are dropped from the front. Nonces issued up to floor
+	// were dropped to stay under MaxNonces and are no longer accepted.
+	mu    sync.Mutex
+	seen  map[string]*list.Element
+	order *list.List
+	floor time.Time
+}
+
+type seenNonce struct {
+	nonce  string
+	issued time.Time
+	nc     uint64
+}
+
+func NewDigestAuth(config DigestConfig) *DigestAuth {
+	if config.Algorithm == "" {
+		config.Algorithm = "SHA-256"
+	}
+	if config.NonceTTL == 0 {
+		config.NonceTTL = 5 * time.Minute
+	}
+	if config.MaxNonces <= 0 {
+		config.MaxNonces = 10000
+	}
+	if len(config.Secret) == 0 {
+		config.Secret = make([]byte, 32)
+		rand.Read(config.Secret)
+	}
+
+	opaque := make([]byte, 16)
+	rand.Read(opaque)
+	return &DigestAuth{
+		config: config,
+		opaque: hex.EncodeToString(opaque),
+		now:    time.Now,
+		seen:   map[string]*list.Element{},
+		order:  list.New(),
+	}
+}
+
+type userKey struct{}
+
+func UserFromRequest(req *request.Request) (string, bool) {
+	u, ok := req.Context().Value(userKey{}).(string)
+	return u, ok
+}
+
+func (d *DigestAuth) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		authorization, _ := req.Headers.Get("authorization")
+		params, ok := strings.CutPrefix(authorization, "Digest ")
+		if !ok {
+			unauthorized(w, req, d.challenge(false))
+			return
+		}
+
+		username, stale, ok := d.verify(req, parseAuthParams(params))
+		if !ok {
+			unauthorized(w, req, d.challenge(stale))
+			return
+		}
+		next(w, req.WithContext(context.WithValue(req.Context(), userKey{}, username)))
+	}
+}
+
+func (d *DigestAuth) challenge(stale bool) string {
+	c := fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=%s, nonce=%q, opaque=%q`, d.config.Realm, d.config.Algorithm, d.nonce(), d.opaque)
+	if stale {
+		c += ", stale=true"
+	}
+	return c
+}
+
+func (d *DigestAuth) verify(req *request.Request, p map[string]string) (string, bool, bool) {
+	if p["realm"] != d.config.Realm || p["opaque"] != d.opaque || p["qop"] != "auth" {
+		return "", false, false
+	}
+	if alg := p["algorithm"]; alg != "" && !strings.EqualFold(alg, d.config.Algorithm) {
+		return "", false, false
+	}
+	if p["uri"] != req.RequestLine.RequestTarget {
+		return "", false, false
+	}
+
+	issued, ok := d.checkNonce(p["nonce"])
+	if !ok {
+		return "", false, false
+	}
+	if d.now().Sub(issued) > d.config.NonceTTL {
+		return "", true, false
+	}
+
+	password, ok := d.config.Password(p["username"])
+	if !ok {
+		return "", false, false
+	}
+
+	ha1 := d.hash(p["username"] + ":" + d.config.Realm + ":" + password)
+	ha2 := d.hash(req.RequestLine.Method + ":" + p["uri"])
+	expected := d.hash(strings.Join([]string{ha1, p["nonce"], p["nc"], p["cnonce"], "auth", ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(p["response"])) != 1 {
+		return "", false, false
+	}
+
+	nc, err := strconv.ParseUint(p["nc"], 16, 64)
+	if err != nil {
+		return "", false, false
+	}
+	if ok, stale := d.countNonce(p["nonce"], issued, nc); !ok {
+		return "", stale, false
+	}
+	return p["username"], false, true
+}
+
+// countNonce rejects replays by requiring the nonce count to increase. A
+// nonce no longer tracked is stale.
+func (d *DigestAuth) countNonce(nonce string, issued time.Time, nc uint64) (ok, stale bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	expired := d.now().Add(-d.config.NonceTTL)
+	for e := d.order.Front(); e != nil && e.Value.(*seenNonce).issued.Before(expired); e = d.order.Front() {
+		d.forget(e)
+	}
+
+	if e, ok := d.seen[nonce]; ok {
+		entry := e.Value.(*seenNonce)
+		if nc <= entry.nc {
+			return false, false
+		}
+		entry.nc = nc
+		return true, false
+	}
+	if !issued.After(d.floor) {
+		return false, true
+	}
+
+	// Nonces mostly arrive in the order they were issued, so the walk from
+	// the back is short.
+	entry := &seenNonce{nonce: nonce, issued: issued, nc: nc}
+	at := d.order.Back()
+	for at != nil && at.Value.(*seenNonce).issued.After(issued) {
+		at = at.Prev()
+	}
+	if at == nil {
+		d.seen[nonce] = d.order.PushFront(entry)
+	} else {
+		d.seen[nonce] = d.order.InsertAfter(entry, at)
+	}
+	for d.order.Len() > d.config.MaxNonces {
+		oldest := d.order.Front()
+		d.floor = oldest.Value.(*seenNonce).issued
+		d.forget(oldest)
+	}
+	_, ok = d.seen[nonce]
+	return ok, !ok
+}
+
+func (d *DigestAuth) forget(e *list.Element) {
+	d.order.Remove(e)
+	delete(d.seen, e.Value.(*seenNonce).nonce)
+}
+
+func (d *DigestAuth) nonce() string {
+	b := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(b, uint64(d.now().UnixNano()))
+	mac := hmac.New(sha256.New, d.config.Secret)
+	mac.Write(b)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(b))
+}
+
+func (d *DigestAuth) checkNonce(nonce string) (time.Time, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 8+sha256.Size {
+		return time.Time{}, false
+	}
+	mac := hmac.New(sha256.New, d.config.Secret)
+	mac.Write(b[:8])
+	if !hmac.Equal(b[8:], mac.Sum(nil)) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b[:8]))), true
+}
+
+func (d *DigestAuth) hash(s string) string {
+	var h hash.Hash
+	if strings.EqualFold(d.config.Algorithm, "MD5") {
+		h = md5.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			b := strings.Builder{}
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			value = b.String()
+			rest = rest[min(i+1, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[name] = value
+		s = rest
+	}
+	return params
+}
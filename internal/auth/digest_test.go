@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestDigestAuth(t *testing.T) {
+	d := NewDigestAuth(DigestConfig{
+		Realm: "tcp.to.http",
+		Password: func(username string) (string, bool) {
+			return "hunter2", username == "prime"
+		},
+	})
+	handler := d.Middleware(func(w *response.Writer, req *request.Request) {
+		user, _ := UserFromRequest(req)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(user)))
+		w.WriteBody([]byte(user))
+	})
+	run := func(authorization string) string {
+		raw := "GET /secret HTTP/1.1\r\nHost: localhost\r\n"
+		if authorization != "" {
+			raw += "Authorization: " + authorization + "\r\n"
+		}
+		req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+		require.NoError(t, err)
+		out := bytes.Buffer{}
+		handler(response.NewWriter(&out), req)
+		return out.String()
+	}
+	authorize := func(user, password, nonce, nc string) string {
+		ha1 := d.hash(user + ":tcp.to.http:" + password)
+		ha2 := d.hash("GET:/secret")
+		res := d.hash(strings.Join([]string{ha1, nonce, nc, "abc", "auth", ha2}, ":"))
+		return fmt.Sprintf(`Digest username=%q, realm="tcp.to.http", nonce=%q, uri="/secret", qop=auth, nc=%s, cnonce="abc", response=%q, opaque=%q, algorithm=SHA-256`, user, nonce, nc, res, d.opaque)
+	}
+
+	// Test: Missing credentials get a challenge
+	out := run("")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.Contains(t, out, `www-authenticate: Digest realm="tcp.to.http", qop="auth", algorithm=SHA-256, nonce=`)
+
+	// Test: Valid response
+	nonce := d.nonce()
+	out = run(authorize("prime", "hunter2", nonce, "00000001"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "prime"))
+
+	// Test: Replayed nonce count and wrong password
+	out = run(authorize("prime", "hunter2", nonce, "00000001"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	out = run(authorize("prime", "wrong", nonce, "00000002"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+
+	// Test: Expired nonce is reported as stale
+	d.now = func() time.Time { return time.Now().Add(time.Hour) }
+	out = run(authorize("prime", "hunter2", nonce, "00000003"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.Contains(t, out, "stale=true")
+
+	// Test: Expired nonces stop being tracked
+	fresh := d.nonce()
+	out = run(authorize("prime", "hunter2", fresh, "00000001"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Len(t, d.seen, 1)
+	assert.NotContains(t, d.seen, nonce)
+
+	// Test: Past MaxNonces the oldest are dropped and answered as stale,
+	// even with a count never used
+	d.config.MaxNonces = 2
+	start := time.Now()
+	nonces := []string{}
+	for i := range 3 {
+		d.now = func() time.Time { return start.Add(time.Duration(i) * time.Second) }
+		nonces = append(nonces, d.nonce())
+		out = run(authorize("prime", "hunter2", nonces[i], "00000001"))
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	}
+	assert.Len(t, d.seen, 2)
+	for _, dropped := range nonces[:2] {
+		out = run(authorize("prime", "hunter2", dropped, "00000009"))
+		assert.Contains(t, out, "stale=true")
+	}
+	out = run(authorize("prime", "hunter2", nonces[2], "00000002"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+}
+
+func TestParseAuthParams(t *testing.T) {
+	p := parseAuthParams(`username="pri\"me", realm="a, b", qop=auth, nc=00000001`)
+	assert.Equal(t, `pri"me`, p["username"])
+	assert.Equal(t, "a, b", p["realm"])
+	assert.Equal(t, "auth", p["qop"])
+	assert.Equal(t, "00000001", p["nc"])
+}
@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp.to.http/internal/client"
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+	"tcp.to.http/internal/singleflight"
+)
+
+var ERROR_MALFORMED_TOKEN = fmt.Errorf("malformed token")
+var ERROR_UNSUPPORTED_ALGORITHM = fmt.Errorf("unsupported token algorithm")
+var ERROR_UNKNOWN_KEY = fmt.Errorf("no key available to verify token")
+var ERROR_INVALID_SIGNATURE = fmt.Errorf("invalid token signature")
+var ERROR_TOKEN_EXPIRED = fmt.Errorf("token expired")
+var ERROR_MISSING_EXPIRY = fmt.Errorf("token has no expiry")
+var ERROR_TOKEN_NOT_YET_VALID = fmt.Errorf("token not yet valid")
+var ERROR_INVALID_ISSUER = fmt.Errorf("invalid token issuer")
+var ERROR_INVALID_AUDIENCE = fmt.Errorf("invalid token audience")
+
+type Claims map[string]any
+
+func (c Claims) String(name string) (string, bool) {
+	v, ok := c[name].(string)
+	return v, ok
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+type JWTConfig struct {
+	// HMACKey verifies HS256 tokens.
+	HMACKey []byte
+	// PublicKeys verifies RS256/ES256 tokens by "kid"; the "" entry is used
+	// for tokens without one.
+	PublicKeys map[string]crypto.PublicKey
+	// JWKSURL is fetched for keys missing from PublicKeys and cached for
+	// JWKSRefresh. A fetch taking longer than JWKSTimeout (10s by default)
+	// is given up on, and the keys already cached stay in use.
+	JWKSURL     string
+	JWKSRefresh time.Duration
+	JWKSTimeout time.Duration
+	Issuer      string
+	Audience    string
+	Leeway      time.Duration
+	// AllowNoExpiry accepts tokens without an "exp" claim, which are then
+	// valid forever; they are refused otherwise.
+	AllowNoExpiry bool
+}
+
+type JWTValidator struct {
+	config JWTConfig
+	now    func() time.Time
+	flight singleflight.Group[struct{}]
+
+	mu        sync.Mutex
+	jwks      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// triedAt is the last fetch, successful or not.
+	triedAt time.Time
+}
+
+func NewJWTValidator(config JWTConfig) *JWTValidator {
+	if config.JWKSRefresh == 0 {
+		config.JWKSRefresh = time.Hour
+	}
+	if config.JWKSTimeout == 0 {
+		config.JWKSTimeout = 10 * time.Second
+	}
+	return &JWTValidator{
+		config: config,
+		now:    time.Now,
+		jwks:   map[string]crypto.PublicKey{},
+	}
+}
+
+type claimsKey struct{}
+
+func ClaimsFromRequest(req *request.Request) (Claims, bool) {
+	c, ok := req.Context().Value(claimsKey{}).(Claims)
+	return c, ok
+}
+
+func (v *JWTValidator) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		authorization, _ := req.Headers.Get("authorization")
+		scheme, token, ok := strings.Cut(authorization, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			unauthorized(w, req, `Bearer`)
+			return
+		}
+
+		claims, err := v.Validate(strings.TrimSpace(token))
+		if err != nil {
+			unauthorized(w, req, fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, err.Error()))
+			return
+		}
+		next(w, req.WithContext(context.WithValue(req.Context(), claimsKey{}, claims)))
+	}
+}
+
+func (v *JWTValidator) Validate(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ERROR_MALFORMED_TOKEN
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ERROR_MALFORMED_TOKEN
+	}
+	if err := v.verify(header.Alg, header.Kid, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, v.checkClaims(claims)
+}
+
+func (v *JWTValidator) verify(alg, kid, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "HS256":
+		if len(v.config.HMACKey) == 0 {
+			return ERROR_UNKNOWN_KEY
+		}
+		mac := hmac.New(sha256.New, v.config.HMACKey)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return ERROR_INVALID_SIGNATURE
+		}
+		return nil
+
+	case "RS256":
+		key, ok := v.key(kid).(*rsa.PublicKey)
+		if !ok {
+			return ERROR_UNKNOWN_KEY
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) != nil {
+			return ERROR_INVALID_SIGNATURE
+		}
+		return nil
+
+	case "ES256":
+		key, ok := v.key(kid).(*ecdsa.PublicKey)
+		if !ok || key.Curve != elliptic.P256() {
+			return ERROR_UNKNOWN_KEY
+		}
+		if len(sig) != 64 {
+			return ERROR_INVALID_SIGNATURE
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return ERROR_INVALID_SIGNATURE
+		}
+		return nil
+	}
+	return ERROR_UNSUPPORTED_ALGORITHM
+}
+
+func (v *JWTValidator) checkClaims(claims Claims) error {
+	now := v.now()
+
+	exp, ok := claims.time("exp")
+	if !ok && !v.config.AllowNoExpiry {
+		return ERROR_MISSING_EXPIRY
+	}
+	if ok && now.After(exp.Add(v.config.Leeway)) {
+		return ERROR_TOKEN_EXPIRED
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(v.config.Leeway).Before(nbf) {
+		return ERROR_TOKEN_NOT_YET_VALID
+	}
+	if v.config.Issuer != "" {
+		if iss, _ := claims.String("iss"); iss != v.config.Issuer {
+			return ERROR_INVALID_ISSUER
+		}
+	}
+	if v.config.Audience != "" && !hasAudience(claims["aud"], v.config.Audience) {
+		return ERROR_INVALID_AUDIENCE
+	}
+	return nil
+}
+
+func hasAudience(aud any, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []any:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *JWTValidator) key(kid string) crypto.PublicKey {
+	if key, ok := v.config.PublicKeys[kid]; ok {
+		return key
+	}
+	if v.config.JWKSURL == "" {
+		return nil
+	}
+
+	v.mu.Lock()
+	key, ok := v.jwks[kid]
+	stale := v.now().Sub(v.fetchedAt) > v.config.JWKSRefresh
+	// Unknown kids trigger a refetch (keys may have rotated), and failed
+	// fetches are retried, but at most once a minute so bogus tokens or a
+	// down endpoint can't hammer it.
+	due := v.now().Sub(v.triedAt) > min(time.Minute, v.config.JWKSRefresh)
+	v.mu.Unlock()
+	if !(stale || !ok) || !due {
+		return key
+	}
+
+	// The fetch runs without the lock, so requests whose keys are cached
+	// don't wait on it, and concurrent requests share a single fetch.
+	v.flight.Do("", func() struct{} {
+		ctx, cancel := context.WithTimeout(context.Background(), v.config.JWKSTimeout)
+		defer cancel()
+		keys, err := fetchJWKS(ctx, v.config.JWKSURL)
+
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.triedAt = v.now()
+		if err == nil {
+			v.jwks = keys
+			v.fetchedAt = v.triedAt
+		}
+		return struct{}{}
+	})
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.jwks[kid]
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func fetchJWKS(ctx context.Context, url string) (map[string]crypto.PublicKey, error) {
+	res, err := client.Get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != response.StatusOK {
+		return nil, fmt.Errorf("jwks fetch failed with status %d", res.StatusCode)
+	}
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, ERROR_UNSUPPORTED_ALGORITHM
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, ERROR_UNSUPPORTED_ALGORITHM
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ERROR_MALFORMED_TOKEN
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ERROR_MALFORMED_TOKEN
+	}
+	return nil
+}
+
+func unauthorized(w *response.Writer, req *request.Request, challenge string) {
+	w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+		h.Replace("WWW-Authenticate", challenge)
+	})
+	server.Error(w, req, response.StatusUnauthorized, "")
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func sign(t *testing.T, alg, kid string, key any, claims Claims) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTValidate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	v := NewJWTValidator(JWTConfig{
+		HMACKey:    []byte("secret"),
+		PublicKeys: map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey},
+		Issuer:     "tcp.to.http",
+		Audience:   "api",
+	})
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	claims := Claims{"sub": "prime", "iss": "tcp.to.http", "aud": "api", "exp": exp}
+
+	// Test: Each supported algorithm
+	for _, tc := range []struct {
+		alg, kid string
+		key      any
+	}{{"HS256", "", []byte("secret")}, {"RS256", "rsa", rsaKey}, {"ES256", "ec", ecKey}} {
+		got, err := v.Validate(sign(t, tc.alg, tc.kid, tc.key, claims))
+		require.NoError(t, err, tc.alg)
+		sub, _ := got.String("sub")
+		assert.Equal(t, "prime", sub)
+	}
+
+	// Test: Wrong key and algorithm confusion
+	_, err = v.Validate(sign(t, "HS256", "", []byte("nope"), claims))
+	assert.ErrorIs(t, err, ERROR_INVALID_SIGNATURE)
+	_, err = v.Validate(sign(t, "RS256", "ec", rsaKey, claims))
+	assert.ErrorIs(t, err, ERROR_UNKNOWN_KEY)
+	_, err = v.Validate(sign(t, "none", "", []byte("secret"), claims))
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_ALGORITHM)
+
+	// Test: Claim checks
+	_, err = v.Validate(sign(t, "HS256", "", []byte("secret"), Claims{"iss": "tcp.to.http", "aud": "api", "exp": float64(time.Now().Add(-time.Hour).Unix())}))
+	assert.ErrorIs(t, err, ERROR_TOKEN_EXPIRED)
+	_, err = v.Validate(sign(t, "HS256", "", []byte("secret"), Claims{"iss": "evil", "aud": "api", "exp": exp}))
+	assert.ErrorIs(t, err, ERROR_INVALID_ISSUER)
+	_, err = v.Validate(sign(t, "HS256", "", []byte("secret"), Claims{"iss": "tcp.to.http", "aud": []any{"web", "api"}, "exp": exp}))
+	assert.NoError(t, err)
+	_, err = v.Validate(sign(t, "HS256", "", []byte("secret"), Claims{"iss": "tcp.to.http", "aud": "web", "exp": exp}))
+	assert.ErrorIs(t, err, ERROR_INVALID_AUDIENCE)
+
+	// Test: Tokens without an expiry are refused unless allowed
+	forever := Claims{"iss": "tcp.to.http", "aud": "api"}
+	_, err = v.Validate(sign(t, "HS256", "", []byte("secret"), forever))
+	assert.ErrorIs(t, err, ERROR_MISSING_EXPIRY)
+	v.config.AllowNoExpiry = true
+	_, err = v.Validate(sign(t, "HS256", "", []byte("secret"), forever))
+	assert.NoError(t, err)
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprintf(w, `{"keys":[{"kty":"RSA","kid":"k1","n":%q,"e":%q}]}`,
+			base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()))
+	}))
+	defer ts.Close()
+
+	// Test: Keys are fetched once and cached
+	v := NewJWTValidator(JWTConfig{JWKSURL: ts.URL})
+	for i := 0; i < 3; i++ {
+		_, err = v.Validate(sign(t, "RS256", "k1", rsaKey, Claims{"sub": "prime", "exp": float64(time.Now().Add(time.Hour).Unix())}))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, fetches)
+}
+
+func TestJWKSSlowEndpoint(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	// Test: Concurrent requests share one fetch, given up on after the timeout
+	v := NewJWTValidator(JWTConfig{JWKSURL: ts.URL, JWKSTimeout: 100 * time.Millisecond})
+	token := sign(t, "RS256", "k1", rsaKey, Claims{"sub": "prime"})
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := v.Validate(token)
+			assert.ErrorIs(t, err, ERROR_UNKNOWN_KEY)
+		}()
+	}
+	wg.Wait()
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// Test: A failed fetch isn't retried right away
+	_, err = v.Validate(token)
+	assert.ErrorIs(t, err, ERROR_UNKNOWN_KEY)
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	v := NewJWTValidator(JWTConfig{HMACKey: []byte("secret")})
+	handler := v.Middleware(func(w *response.Writer, req *request.Request) {
+		claims, ok := ClaimsFromRequest(req)
+		require.True(t, ok)
+		sub, _ := claims.String("sub")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(sub)))
+		w.WriteBody([]byte(sub))
+	})
+
+	run := func(authorization string) string {
+		raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+		if authorization != "" {
+			raw += "Authorization: " + authorization + "\r\n"
+		}
+		req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+		require.NoError(t, err)
+		out := bytes.Buffer{}
+		handler(response.NewWriter(&out), req)
+		return out.String()
+	}
+
+	// Test: Missing and valid tokens
+	assert.True(t, strings.HasPrefix(run(""), "HTTP/1.1 401 Unauthorized\r\n"))
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	out := run("Bearer " + sign(t, "HS256", "", []byte("secret"), Claims{"sub": "prime", "exp": exp}))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "prime"))
+
+	// Test: The scheme is case-insensitive
+	out = run("bearer " + sign(t, "HS256", "", []byte("secret"), Claims{"sub": "prime", "exp": exp}))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasPrefix(run("Basic cHJpbWU6"), "HTTP/1.1 401 Unauthorized\r\n"))
+}
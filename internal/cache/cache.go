@@ -0,0 +1,355 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+	"tcp.to.http/internal/singleflight"
+	"tcp.to.http/internal/spill"
+)
+
+var ERROR_MALFORMED_RESPONSE = fmt.Errorf("malformed response from handler")
+
+type result struct {
+	entry  *Entry
+	cached bool
+	// stored is set once the response went into the store, which makes it
+	// fit to hand to every waiting request.
+	stored  bool
+	raw     []byte
+	spilled *spill.Buffer
+}
+
+type Cache struct {
+	// SpillThreshold buffers responses over this many bytes in a temporary
+	// file instead of memory. Those are passed on uncached. Zero keeps every
+	// response in memory.
+	SpillThreshold int64
+
+	store  Store
+	now    func() time.Time
+	flight singleflight.Group[result]
+}
+
+func New(store Store) *Cache {
+	return &Cache{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+func (c *Cache) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "GET" {
+			next(w, req)
+			return
+		}
+
+		reqDirectives := parseCacheControl(req.Headers)
+		if _, ok := reqDirectives["no-store"]; ok {
+			next(w, req)
+			return
+		}
+
+		// The host is part of the key so virtual hosts and proxied sites
+		// sharing a path keep their own responses.
+		host, _ := req.Headers.Get("host")
+		base := req.RequestLine.Method + " " + strings.ToLower(host) + " " + req.RequestLine.RequestTarget
+		key := c.key(base, req)
+		now := c.now()
+
+		// A credentialed request may be answered for that user alone, so it
+		// is never answered from the store.
+		var entry *Entry
+		hit := false
+		if !credentialed(req) {
+			entry, hit = c.store.Get(key)
+		}
+		if hit {
+			_, noCache := reqDirectives["no-cache"]
+			if !noCache && now.Before(entry.Expires) {
+				serve(w, result{entry: entry, cached: true}, now)
+				return
+			}
+		}
+
+		fetch := func() result {
+			return c.fetch(next, req, base, key, entry, now)
+		}
+		_, inm := req.Headers.Get("if-none-match")
+		_, ims := req.Headers.Get("if-modified-since")
+		if inm || ims || credentialed(req) {
+			// Nor does it share another request's response.
+			serve(w, fetch(), now)
+			return
+		}
+
+		ran := false
+		res, _ := c.flight.Do(key, func() result {
+			ran = true
+			return fetch()
+		})
+		if !ran && !res.stored {
+			// A response the store refused, e.g. one setting a cookie or
+			// marked private, may be meant for the leader alone; a spilled
+			// response's file belongs to the leader too.
+			res = fetch()
+		}
+		serve(w, res, now)
+	}
+}
+
+func (c *Cache) fetch(next server.Handler, req *request.Request, base, key string, entry *Entry, now time.Time) result {
+	revalidating := entry != nil && addValidators(req, entry)
+
+	raw := spill.New(c.SpillThreshold)
+	next(response.NewWriter(raw), req)
+	if raw.Spilled() {
+		return result{spilled: raw}
+	}
+
+	res, err := parseResponse(raw.Bytes())
+	if err != nil {
+		return result{raw: raw.Bytes()}
+	}
+
+	if revalidating && res.Status == response.StatusNotModified {
+		refreshed := &Entry{
+			Status:  entry.Status,
+			Headers: entry.Headers.Clone(),
+			Body:    entry.Body,
+			Stored:  now,
+		}
+		res.Headers.ForEach(func(n, v string) {
+			if n != "content-length" {
+				refreshed.Headers.Replace(n, v)
+			}
+		})
+		refreshed.Expires = expiry(refreshed.Headers, now)
+		c.store.Set(key, refreshed)
+		return result{entry: refreshed, cached: true, stored: true}
+	}
+
+	if storable(res) && (!credentialed(req) || shared(res)) {
+		res.Stored = now
+		res.Expires = expiry(res.Headers, now)
+		if key, ok := c.varyKeyFor(base, req, res); ok {
+			c.store.Set(key, res)
+			return result{entry: res, stored: true}
+		}
+	}
+	return result{entry: res}
+}
+
+// credentialed reports whether req carries credentials that could make its
+// response personal.
+func credentialed(req *request.Request) bool {
+	_, auth := req.Headers.Get("authorization")
+	_, cookie := req.Headers.Get("cookie")
+	return auth || cookie
+}
+
+// shared reports whether res, answering a credentialed request, says it may
+// be served to others too (RFC 9111 §3.5).
+func shared(res *Entry) bool {
+	directives := parseCacheControl(res.Headers)
+	for _, name := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := directives[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// The header names a response for base varies on are kept in the store
+// too, under varyMarker+base, so they are evicted along with the responses.
+const varyMarker = "\x00vary "
+
+func (c *Cache) key(base string, req *request.Request) string {
+	names := []string{}
+	if marker, ok := c.store.Get(varyMarker + base); ok {
+		v, _ := marker.Headers.Get("vary")
+		names = strings.Split(v, ",")
+	}
+	return varyKey(base, names, req)
+}
+
+func (c *Cache) varyKeyFor(base string, req *request.Request, res *Entry) (string, bool) {
+	names := []string{}
+	if v, ok := res.Headers.Get("vary"); ok {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "*" {
+				return "", false
+			}
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		c.store.Delete(varyMarker + base)
+	} else {
+		h := headers.NewHeaders()
+		h.Set("vary", strings.Join(names, ","))
+		c.store.Set(varyMarker+base, &Entry{Headers: h})
+	}
+	return varyKey(base, names, req), true
+}
+
+func varyKey(base string, names []string, req *request.Request) string {
+	key := base
+	for _, name := range names {
+		v, _ := req.Headers.Get(name)
+		key += "\x00" + name + "=" + v
+	}
+	return key
+}
+
+func addValidators(req *request.Request, entry *Entry) bool {
+	_, inm := req.Headers.Get("if-none-match")
+	_, ims := req.Headers.Get("if-modified-since")
+	if inm || ims || !entry.hasValidators() {
+		return false
+	}
+
+	if etag, ok := entry.Headers.Get("etag"); ok {
+		req.Headers.Replace("if-none-match", etag)
+	}
+	if lastModified, ok := entry.Headers.Get("last-modified"); ok {
+		req.Headers.Replace("if-modified-since", lastModified)
+	}
+	return true
+}
+
+func serve(w *response.Writer, res result, now time.Time) {
+	if res.spilled != nil {
+		defer res.spilled.Close()
+		io.Copy(w, res.spilled.Reader())
+		return
+	}
+	if res.entry == nil {
+		w.WriteBody(res.raw)
+		return
+	}
+
+	h := res.entry.Headers.Clone()
+	if res.cached {
+		h.Replace("age", fmt.Sprintf("%d", int(now.Sub(res.entry.Stored).Seconds())))
+	}
+	w.WriteStatusLine(res.entry.Status)
+	w.WriteHeaders(*h)
+	w.WriteBody(res.entry.Body)
+}
+
+func storable(res *Entry) bool {
+	if res.Status != response.StatusOK {
+		return false
+	}
+	if _, ok := res.Headers.Get("transfer-encoding"); ok {
+		return false
+	}
+	if _, ok := res.Headers.Get("set-cookie"); ok {
+		return false
+	}
+
+	directives := parseCacheControl(res.Headers)
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+	if _, ok := directives["private"]; ok {
+		return false
+	}
+
+	_, hasMaxAge := directives["max-age"]
+	_, hasSMaxAge := directives["s-maxage"]
+	_, hasExpires := res.Headers.Get("expires")
+	return hasMaxAge || hasSMaxAge || hasExpires || res.hasValidators()
+}
+
+func expiry(h *headers.Headers, now time.Time) time.Time {
+	directives := parseCacheControl(h)
+	if _, ok := directives["no-cache"]; ok {
+		return now
+	}
+
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[name]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil {
+				return now
+			}
+			return now.Add(time.Duration(seconds) * time.Second)
+		}
+	}
+
+	if v, ok := h.Get("expires"); ok {
+		expires, err := time.Parse(response.TimeFormat, v)
+		if err != nil {
+			return now
+		}
+		if v, ok := h.Get("date"); ok {
+			if date, err := time.Parse(response.TimeFormat, v); err == nil {
+				return now.Add(expires.Sub(date))
+			}
+		}
+		return expires
+	}
+	return now
+}
+
+func parseCacheControl(h *headers.Headers) map[string]string {
+	directives := map[string]string{}
+	v, ok := h.Get("cache-control")
+	if !ok {
+		return directives
+	}
+
+	for _, part := range strings.Split(v, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, "\"")
+	}
+	return directives
+}
+
+func parseResponse(raw []byte) (*Entry, error) {
+	idx := bytes.Index(raw, []byte("\r\n"))
+	if idx == -1 {
+		return nil, ERROR_MALFORMED_RESPONSE
+	}
+
+	parts := bytes.SplitN(raw[:idx], []byte(" "), 3)
+	if len(parts) < 2 || string(parts[0]) != "HTTP/1.1" {
+		return nil, ERROR_MALFORMED_RESPONSE
+	}
+	code, err := strconv.Atoi(string(parts[1]))
+	if err != nil {
+		return nil, ERROR_MALFORMED_RESPONSE
+	}
+
+	h := headers.NewHeaders()
+	n, done, err := h.Parse(raw[idx+2:])
+	if err != nil || !done {
+		return nil, ERROR_MALFORMED_RESPONSE
+	}
+
+	return &Entry{
+		Status:  response.StatusCode(code),
+		Headers: h,
+		Body:    bytes.Clone(raw[idx+2+n:]),
+	}, nil
+}
@@ -0,0 +1,266 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func newRequest(t *testing.T, raw string) *request.Request {
+	r, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return r
+}
+
+func do(t *testing.T, c *Cache, handler func(w *response.Writer, req *request.Request), raw string) *Entry {
+	out := bytes.Buffer{}
+	c.Middleware(handler)(response.NewWriter(&out), newRequest(t, raw))
+	res, err := parseResponse(out.Bytes())
+	require.NoError(t, err)
+	return res
+}
+
+func TestCacheMiddleware(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	handler := func(w *response.Writer, req *request.Request) {
+		calls++
+		body := fmt.Sprintf("call %d", calls)
+		if inm, ok := req.Headers.Get("if-none-match"); ok && inm == `"v1"` {
+			h := response.GetDefaultHeaders(0)
+			h.Replace("cache-control", "max-age=60")
+			w.WriteStatusLine(response.StatusNotModified)
+			w.WriteHeaders(*h)
+			return
+		}
+		h := response.GetDefaultHeaders(len(body))
+		switch req.RequestLine.RequestTarget {
+		case "/fresh":
+			h.Replace("cache-control", "max-age=60")
+		case "/nostore":
+			h.Replace("cache-control", "no-store")
+		case "/etag":
+			h.Replace("cache-control", "no-cache")
+			h.Replace("etag", `"v1"`)
+		case "/vary":
+			h.Replace("cache-control", "max-age=60")
+			h.Replace("vary", "Accept-Language")
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte(body))
+	}
+
+	// Test: Fresh response served from cache
+	c := New(NewMemoryStore(0))
+	c.now = func() time.Time { return now }
+	res := do(t, c, handler, "GET /fresh HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "call 1", string(res.Body))
+	c.now = func() time.Time { return now.Add(10 * time.Second) }
+	res = do(t, c, handler, "GET /fresh HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "call 1", string(res.Body))
+	age, _ := res.Headers.Get("age")
+	assert.Equal(t, "10", age)
+
+	// Test: Stale response is fetched again
+	c.now = func() time.Time { return now.Add(2 * time.Minute) }
+	res = do(t, c, handler, "GET /fresh HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "call 2", string(res.Body))
+
+	// Test: no-store responses are never cached
+	calls = 0
+	do(t, c, handler, "GET /nostore HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	res = do(t, c, handler, "GET /nostore HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "call 2", string(res.Body))
+
+	// Test: Revalidation with a 304 serves the cached body
+	calls = 0
+	do(t, c, handler, "GET /etag HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	res = do(t, c, handler, "GET /etag HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, response.StatusOK, res.Status)
+	assert.Equal(t, "call 1", string(res.Body))
+	res = do(t, c, handler, "GET /etag HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "call 1", string(res.Body))
+
+	// Test: Vary keeps one entry per header value
+	calls = 0
+	do(t, c, handler, "GET /vary HTTP/1.1\r\nAccept-Language: en\r\n\r\n")
+	do(t, c, handler, "GET /vary HTTP/1.1\r\nAccept-Language: fr\r\n\r\n")
+	res = do(t, c, handler, "GET /vary HTTP/1.1\r\nAccept-Language: en\r\n\r\n")
+	assert.Equal(t, "call 1", string(res.Body))
+	res = do(t, c, handler, "GET /vary HTTP/1.1\r\nAccept-Language: fr\r\n\r\n")
+	assert.Equal(t, "call 2", string(res.Body))
+
+	// Test: Each Host keeps its own entry, whatever its case
+	calls = 0
+	do(t, c, handler, "GET /fresh HTTP/1.1\r\nHost: a.example\r\n\r\n")
+	res = do(t, c, handler, "GET /fresh HTTP/1.1\r\nHost: b.example\r\n\r\n")
+	assert.Equal(t, "call 2", string(res.Body))
+	res = do(t, c, handler, "GET /fresh HTTP/1.1\r\nHost: A.Example\r\n\r\n")
+	assert.Equal(t, "call 1", string(res.Body))
+}
+
+func TestCacheCredentials(t *testing.T) {
+	calls := 0
+	handler := func(w *response.Writer, req *request.Request) {
+		calls++
+		user, _ := req.Headers.Get("authorization")
+		body := fmt.Sprintf("call %d for %q", calls, user)
+		h := response.GetDefaultHeaders(len(body))
+		h.Replace("cache-control", "max-age=60")
+		if req.RequestLine.RequestTarget == "/public" {
+			h.Replace("cache-control", "public, max-age=60")
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte(body))
+	}
+	c := New(NewMemoryStore(0))
+
+	// Test: A response to an authorized request is not served to others
+	do(t, c, handler, "GET /page HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer a\r\n\r\n")
+	res := do(t, c, handler, "GET /page HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, `call 2 for ""`, string(res.Body))
+
+	// Test: Nor is a stored response served to an authorized request
+	res = do(t, c, handler, "GET /page HTTP/1.1\r\nHost: localhost\r\nCookie: id=b\r\n\r\n")
+	assert.Equal(t, `call 3 for ""`, string(res.Body))
+	res = do(t, c, handler, "GET /page HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, `call 2 for ""`, string(res.Body))
+
+	// Test: Unless the response is marked public
+	do(t, c, handler, "GET /public HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer a\r\n\r\n")
+	res = do(t, c, handler, "GET /public HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, `call 4 for "Bearer a"`, string(res.Body))
+}
+
+func TestCacheVaryEviction(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(2)
+		h.Replace("cache-control", "max-age=60")
+		h.Replace("vary", "Accept-Language")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte("ok"))
+	}
+
+	// Test: What responses vary on is evicted with them
+	store := NewMemoryStore(4)
+	c := New(store)
+	for i := range 20 {
+		do(t, c, handler, fmt.Sprintf("GET /%d HTTP/1.1\r\nAccept-Language: en\r\n\r\n", i))
+	}
+	assert.Equal(t, 4, store.order.Len())
+}
+
+func TestCacheSpill(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	calls := 0
+	body := strings.Repeat("x", 4096)
+	handler := func(w *response.Writer, req *request.Request) {
+		calls++
+		h := response.GetDefaultHeaders(len(body))
+		h.Replace("cache-control", "max-age=60")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte(body))
+	}
+	c := New(NewMemoryStore(0))
+	c.SpillThreshold = 1024
+
+	// Test: A response over the threshold is passed on whole but not cached
+	res := do(t, c, handler, "GET /big HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, body, string(res.Body))
+	do(t, c, handler, "GET /big HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 2, calls)
+
+	// Test: Its temporary file is gone once it was served
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	entered := make(chan string, 10)
+	release := make(chan struct{})
+	var calls atomic.Int32
+	handler := func(w *response.Writer, req *request.Request) {
+		n := calls.Add(1)
+		if n == 1 {
+			entered <- req.RequestLine.RequestTarget
+			<-release
+		}
+		body := fmt.Sprintf("call %d", n)
+		h := response.GetDefaultHeaders(len(body))
+		h.Replace("cache-control", "max-age=60")
+		if req.RequestLine.RequestTarget == "/private" {
+			h.Replace("cache-control", "private, max-age=60")
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte(body))
+	}
+	concurrent := func(c *Cache, target string, n int) []string {
+		calls.Store(0)
+		bodies := make([]string, n+1)
+		wg := sync.WaitGroup{}
+		wg.Add(n + 1)
+		go func() {
+			defer wg.Done()
+			bodies[0] = string(do(t, c, handler, "GET "+target+" HTTP/1.1\r\nHost: localhost\r\n\r\n").Body)
+		}()
+		<-entered
+		for i := 1; i <= n; i++ {
+			go func() {
+				defer wg.Done()
+				bodies[i] = string(do(t, c, handler, "GET "+target+" HTTP/1.1\r\nHost: localhost\r\n\r\n").Body)
+			}()
+		}
+		// Give the others time to join the leader's flight.
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		release = make(chan struct{})
+		return bodies
+	}
+
+	// Test: Concurrent misses share one stored response
+	c := New(NewMemoryStore(0))
+	bodies := concurrent(c, "/shared", 3)
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, []string{"call 1", "call 1", "call 1", "call 1"}, bodies)
+
+	// Test: A response the store refused is fetched again for each waiter
+	bodies = concurrent(c, "/private", 3)
+	assert.Equal(t, int32(4), calls.Load())
+	assert.Equal(t, "call 1", bodies[0])
+	assert.NotContains(t, bodies[1:], "call 1")
+
+	// Test: Requests with credentials don't wait for another request's response
+	calls.Store(0)
+	done := make(chan struct{})
+	go func() {
+		do(t, c, handler, "GET /creds HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		close(done)
+	}()
+	<-entered
+	for _, field := range []string{"Cookie: id=1", "Authorization: Bearer x"} {
+		res := do(t, c, handler, "GET /creds HTTP/1.1\r\nHost: localhost\r\n"+field+"\r\n\r\n")
+		assert.NotEqual(t, "call 1", string(res.Body))
+	}
+	close(release)
+	<-done
+}
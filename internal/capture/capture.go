@@ -0,0 +1,60 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+)
+
+// Record is one captured request, stored as a line of JSON.
+type Record struct {
+	Time       time.Time         `json:"time"`
+	RemoteAddr string            `json:"remote_addr"`
+	Method     string            `json:"method"`
+	Target     string            `json:"target"`
+	Version    string            `json:"version"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+}
+
+func FromRequest(r *request.Request, remoteAddr string, t time.Time) Record {
+	rec := Record{
+		Time:       t,
+		RemoteAddr: remoteAddr,
+		Method:     r.RequestLine.Method,
+		Target:     r.RequestLine.RequestTarget,
+		Version:    r.RequestLine.HttpVersion,
+		Headers:    map[string]string{},
+	}
+	body, _ := io.ReadAll(r.BodyReader())
+	rec.Body = string(body)
+	r.Headers.ForEach(func(n, v string) {
+		rec.Headers[n] = v
+	})
+	return rec
+}
+
+func Write(w io.Writer, rec Record) error {
+	return json.NewEncoder(w).Encode(rec)
+}
+
+// Read returns every record in r, in order. Blank lines are skipped.
+func Read(r io.Reader) ([]Record, error) {
+	records := []Record{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
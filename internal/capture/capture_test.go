@@ -0,0 +1,37 @@
+package capture
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+)
+
+func TestRoundTrip(t *testing.T) {
+	r, err := request.RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Test: Records survive being written and read back
+	b := bytes.Buffer{}
+	require.NoError(t, Write(&b, FromRequest(r, "127.0.0.1:1234", at)))
+	b.WriteString("\n")
+	require.NoError(t, Write(&b, FromRequest(r, "127.0.0.1:1234", at.Add(time.Second))))
+	records, err := Read(&b)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "POST", records[0].Method)
+	assert.Equal(t, "/submit", records[0].Target)
+	assert.Equal(t, "localhost", records[0].Headers["host"])
+	assert.Equal(t, "hello", records[0].Body)
+	assert.True(t, at.Equal(records[0].Time))
+	assert.Equal(t, time.Second, records[1].Time.Sub(records[0].Time))
+
+	// Test: A broken line is an error
+	_, err = Read(strings.NewReader("{not json\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,223 @@
+package cgi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+const maxHeaderBytes = 64 << 10
+
+var ERROR_MALFORMED_HEADERS = fmt.Errorf("malformed cgi response headers")
+
+type Config struct {
+	Path string
+	Args []string
+	Dir  string
+	// Prefix is the path the script is mounted at; it becomes SCRIPT_NAME
+	// and the rest of the request path becomes PATH_INFO.
+	Prefix string
+	// Env holds extra variables; InheritEnv names variables copied from the
+	// server's own environment (PATH is always copied).
+	Env        map[string]string
+	InheritEnv []string
+}
+
+func Handler(config Config) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+		env := Env(req)
+		env["SCRIPT_NAME"] = config.Prefix
+		env["PATH_INFO"] = strings.TrimPrefix(path, config.Prefix)
+		for _, name := range append([]string{"PATH"}, config.InheritEnv...) {
+			if v, ok := os.LookupEnv(name); ok {
+				env[name] = v
+			}
+		}
+		for name, value := range config.Env {
+			env[name] = value
+		}
+
+		cmd := exec.CommandContext(req.Context(), config.Path, config.Args...)
+		cmd.Dir = config.Dir
+		cmd.Stdin = req.BodyReader()
+		cmd.Stderr = stderrLogger{config.Path}
+		for name, value := range env {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			server.Error(w, req, response.StatusInternalServeError, "")
+			return
+		}
+		if err := cmd.Start(); err != nil {
+			log.Printf("cgi: starting %s: %v", config.Path, err)
+			server.Error(w, req, response.StatusInternalServeError, "")
+			return
+		}
+
+		if err := Relay(w, req, stdout); err != nil {
+			log.Printf("cgi: %s: %v", config.Path, err)
+		}
+		if err := cmd.Wait(); err != nil {
+			log.Printf("cgi: %s exited: %v", config.Path, err)
+		}
+	}
+}
+
+type stderrLogger struct {
+	name string
+}
+
+func (l stderrLogger) Write(p []byte) (int, error) {
+	log.Printf("cgi stderr (%s): %s", l.name, bytes.TrimSpace(p))
+	return len(p), nil
+}
+
+// Env builds the request meta-variables shared by CGI and FastCGI.
+func Env(req *request.Request) map[string]string {
+	target := req.RequestLine.RequestTarget
+	_, query, _ := strings.Cut(target, "?")
+
+	env := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "tcp.to.http",
+		"SERVER_PROTOCOL":   "HTTP/" + req.RequestLine.HttpVersion,
+		"REQUEST_METHOD":    req.RequestLine.Method,
+		"REQUEST_URI":       target,
+		"QUERY_STRING":      query,
+		"CONTENT_LENGTH":    strconv.FormatInt(req.BodyLen(), 10),
+	}
+	if host, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		env["REMOTE_ADDR"] = host
+		env["REMOTE_PORT"] = port
+	}
+	if host, ok := req.Headers.Get("host"); ok {
+		name, port, err := net.SplitHostPort(host)
+		if err != nil {
+			name, port = host, "80"
+		}
+		env["SERVER_NAME"] = name
+		env["SERVER_PORT"] = port
+	}
+	req.Headers.ForEach(func(n, v string) {
+		switch n {
+		case "content-type":
+			env["CONTENT_TYPE"] = v
+		case "content-length", "proxy":
+			// CONTENT_LENGTH is derived from the body; HTTP_PROXY is the
+			// httpoxy vector and must never be forwarded.
+		default:
+			env["HTTP_"+strings.ToUpper(strings.ReplaceAll(n, "-", "_"))] = v
+		}
+	})
+	return env
+}
+
+// Relay streams CGI script output to the client: the header block becomes
+// the status line and headers and the rest is sent chunked.
+func Relay(w *response.Writer, req *request.Request, r io.Reader) error {
+	pending := []byte{}
+	buf := make([]byte, 32<<10)
+	headersSent := false
+
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if headersSent {
+				if _, err := w.WriteChunkedBody(buf[:n]); err != nil {
+					return err
+				}
+			} else {
+				pending = append(pending, buf[:n]...)
+				status, h, body, ok, err := ParseHeaders(pending)
+				if err == nil && !ok && len(pending) > maxHeaderBytes {
+					err = ERROR_MALFORMED_HEADERS
+				}
+				if err != nil {
+					server.Error(w, req, response.StatusBadGateway, "")
+					return err
+				}
+				if ok {
+					headersSent = true
+					h.Delete("Content-Length")
+					h.Replace("Transfer-Encoding", "chunked")
+					w.WriteStatusLine(status)
+					w.WriteHeaders(*h)
+					if _, err := w.WriteChunkedBody(body); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if err == io.EOF {
+			if !headersSent {
+				server.Error(w, req, response.StatusBadGateway, "")
+				return ERROR_MALFORMED_HEADERS
+			}
+			_, err := w.WriteChunkedBodyDone()
+			return err
+		}
+		if err != nil {
+			if !headersSent {
+				server.Error(w, req, response.StatusBadGateway, "")
+			}
+			return err
+		}
+	}
+}
+
+// ParseHeaders splits CGI script output into status, headers and the start
+// of the body. ok is false until the full header block has arrived.
+func ParseHeaders(data []byte) (response.StatusCode, *headers.Headers, []byte, bool, error) {
+	end, sep := bytes.Index(data, []byte("\r\n\r\n")), 4
+	if lf := bytes.Index(data, []byte("\n\n")); lf != -1 && (end == -1 || lf < end) {
+		end, sep = lf, 2
+	}
+	if end == -1 {
+		return 0, nil, nil, false, nil
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Connection", "close")
+	status := response.StatusOK
+	explicitStatus := false
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		name, value, ok := strings.Cut(strings.TrimSuffix(line, "\r"), ":")
+		if !ok {
+			return 0, nil, nil, false, ERROR_MALFORMED_HEADERS
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(name) {
+		case "status":
+			code, err := strconv.Atoi(strings.SplitN(value, " ", 2)[0])
+			if err != nil {
+				return 0, nil, nil, false, ERROR_MALFORMED_HEADERS
+			}
+			status = response.StatusCode(code)
+			explicitStatus = true
+		case "location":
+			if !explicitStatus {
+				status = response.StatusFound
+			}
+			h.Replace(name, value)
+		default:
+			h.Set(name, value)
+		}
+	}
+	return status, h, data[end+sep:], true, nil
+}
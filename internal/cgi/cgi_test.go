@@ -0,0 +1,67 @@
+package cgi
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func script(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "script.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755))
+	return path
+}
+
+func run(t *testing.T, config Config, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	Handler(config)(response.NewWriter(&out), req)
+	return out.String()
+}
+
+func TestHandler(t *testing.T) {
+	// Test: Environment, stdin and headers
+	path := script(t, `printf 'Status: 201 Created\r\nContent-Type: text/plain\r\n\r\n'
+printf '%s %s %s %s ' "$REQUEST_METHOD" "$PATH_INFO" "$QUERY_STRING" "$HTTP_X_COFFEE"
+cat
+`)
+	out := run(t, Config{Path: path, Prefix: "/cgi-bin/brew"}, "POST /cgi-bin/brew/dark?size=big HTTP/1.1\r\nX-Coffee: yes\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 201 Created\r\n"), out)
+	assert.Contains(t, out, "content-type: text/plain\r\n")
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, out, "POST /dark size=big yes ")
+	assert.True(t, strings.HasSuffix(out, "hello\r\n0\r\n\r\n"), out)
+
+	// Test: Script without a header block
+	path = script(t, "echo oops\n")
+	out = run(t, Config{Path: path}, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 502 Bad Gateway\r\n"), out)
+}
+
+func TestParseHeaders(t *testing.T) {
+	// Test: Incomplete header block
+	_, _, _, ok, err := ParseHeaders([]byte("Content-Type: text/plain\n"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Test: Location implies a redirect
+	status, h, body, ok, err := ParseHeaders([]byte("Location: /elsewhere\n\nbody"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, response.StatusFound, status)
+	location, _ := h.Get("location")
+	assert.Equal(t, "/elsewhere", location)
+	assert.Equal(t, "body", string(body))
+
+	// Test: Malformed line
+	_, _, _, _, err = ParseHeaders([]byte("oops\r\n\r\n"))
+	assert.ErrorIs(t, err, ERROR_MALFORMED_HEADERS)
+}
@@ -0,0 +1,41 @@
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func BenchmarkWriter(b *testing.B) {
+	chunk := bytes.Repeat([]byte("x"), 4096)
+	b.ReportAllocs()
+	b.SetBytes(int64(16 * len(chunk)))
+	for b.Loop() {
+		w := NewWriter(io.Discard)
+		for range 16 {
+			w.Write(chunk)
+		}
+		w.Close()
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	encoded := &bytes.Buffer{}
+	w := NewWriter(encoded)
+	for range 16 {
+		w.Write(bytes.Repeat([]byte("x"), 4096))
+	}
+	w.Close()
+	data := encoded.Bytes()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	br := bufio.NewReader(nil)
+	for b.Loop() {
+		br.Reset(bytes.NewReader(data))
+		if _, err := io.Copy(io.Discard, NewReader(br, nil)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
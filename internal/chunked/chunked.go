@@ -0,0 +1,293 @@
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"tcp.to.http/internal/headers"
+)
+
+var ERROR_MALFORMED_CHUNK = fmt.Errorf("malformed chunked encoding")
+var ERROR_TRAILERS_TOO_LARGE = fmt.Errorf("chunked trailers too large")
+var ERROR_TOO_MANY_TRAILERS = fmt.Errorf("too many chunked trailer fields")
+var ERROR_EXTENSIONS_TOO_LARGE = fmt.Errorf("chunk extensions too large")
+
+const (
+	maxLineLength = 4 << 10
+
+	DefaultMaxTrailerBytes  = 16 << 10
+	DefaultMaxTrailerFields = 32
+
+	// MaxSizeLineLength bounds a chunk-size line, extensions included.
+	MaxSizeLineLength = 1024
+	// MaxExtensionLength bounds the extensions of a single chunk.
+	MaxExtensionLength = 256
+	// MaxBodyExtensionBytes bounds the extensions of a whole body, so a
+	// stream of tiny chunks cannot make them most of what is read.
+	MaxBodyExtensionBytes = 16 << 10
+)
+
+// ParseSizeLine reads a chunk-size line without its CRLF, returning the
+// chunk size and the length of the extensions after it. Extensions are
+// checked against RFC 9112's grammar and otherwise ignored.
+func ParseSizeLine(line []byte) (int64, int, error) {
+	if len(line) > MaxSizeLineLength {
+		return 0, 0, ERROR_MALFORMED_CHUNK
+	}
+	digits := 0
+	for digits < len(line) && isHex(line[digits]) {
+		digits++
+	}
+	if digits == 0 {
+		return 0, 0, ERROR_MALFORMED_CHUNK
+	}
+	n, err := strconv.ParseInt(string(line[:digits]), 16, 64)
+	if err != nil {
+		return 0, 0, ERROR_MALFORMED_CHUNK
+	}
+
+	ext := skipWhitespace(line[digits:])
+	if len(ext) == 0 {
+		return n, 0, nil
+	}
+	if len(ext) > MaxExtensionLength {
+		return 0, 0, ERROR_EXTENSIONS_TOO_LARGE
+	}
+	if !validExtensions(ext) {
+		return 0, 0, ERROR_MALFORMED_CHUNK
+	}
+	return n, len(ext), nil
+}
+
+// validExtensions checks *( BWS ";" BWS name [ BWS "=" BWS value ] ), where
+// a value is a token or a quoted string.
+func validExtensions(ext []byte) bool {
+	for len(ext) > 0 {
+		if ext[0] != ';' {
+			return false
+		}
+		ext = skipWhitespace(ext[1:])
+		name := tokenLength(ext)
+		if name == 0 {
+			return false
+		}
+		ext = skipWhitespace(ext[name:])
+		if len(ext) > 0 && ext[0] == '=' {
+			ext = skipWhitespace(ext[1:])
+			value := tokenLength(ext)
+			if value == 0 {
+				value = quotedLength(ext)
+			}
+			if value == 0 {
+				return false
+			}
+			ext = skipWhitespace(ext[value:])
+		}
+	}
+	return true
+}
+
+func tokenLength(b []byte) int {
+	n := 0
+	for n < len(b) && headers.IsToken(string(b[n])) {
+		n++
+	}
+	return n
+}
+
+// quotedLength is the length of the quoted string b starts with, or 0.
+func quotedLength(b []byte) int {
+	if len(b) == 0 || b[0] != '"' {
+		return 0
+	}
+	for i := 1; i < len(b); i++ {
+		switch {
+		case b[i] == '"':
+			return i + 1
+		case b[i] == '\\':
+			i++
+		case b[i] < ' ' && b[i] != '\t', b[i] == 0x7f:
+			return 0
+		}
+	}
+	return 0
+}
+
+func skipWhitespace(b []byte) []byte {
+	return bytes.TrimLeft(b, " \t")
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// Reader decodes a chunked body. It reads no further than the end of the
+// body, so whatever r has buffered past it belongs to the next message.
+type Reader struct {
+	// MaxTrailerBytes and MaxTrailerFields bound the trailer section; zero
+	// means the defaults.
+	MaxTrailerBytes  int
+	MaxTrailerFields int
+
+	r         *bufio.Reader
+	trailers  *headers.Headers
+	remaining int64
+	started   bool
+	// extensions counts the extension bytes of the body so far.
+	extensions int
+	err        error
+}
+
+// NewReader decodes the chunked body read from r, adding any trailer fields
+// to trailers, which may be nil to drop them.
+func NewReader(r *bufio.Reader, trailers *headers.Headers) *Reader {
+	if trailers == nil {
+		trailers = headers.NewHeaders()
+	}
+	return &Reader{r: r, trailers: trailers}
+}
+
+func (cr *Reader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.remaining == 0 {
+		if cr.err = cr.nextChunk(); cr.err != nil {
+			return 0, cr.err
+		}
+	}
+
+	n, err := cr.r.Read(p[:min(int64(len(p)), cr.remaining)])
+	cr.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	cr.err = err
+	return n, err
+}
+
+// nextChunk finishes the current chunk and reads the next size line, or the
+// trailers after the last chunk, in which case it returns io.EOF.
+func (cr *Reader) nextChunk() error {
+	if cr.started {
+		line, err := cr.line()
+		if err != nil {
+			return err
+		}
+		if len(line) != 0 {
+			return ERROR_MALFORMED_CHUNK
+		}
+	}
+	cr.started = true
+
+	line, err := cr.line()
+	if err != nil {
+		return err
+	}
+	n, ext, err := ParseSizeLine(line)
+	if err != nil {
+		return err
+	}
+	if cr.extensions += ext; cr.extensions > MaxBodyExtensionBytes {
+		return ERROR_EXTENSIONS_TOO_LARGE
+	}
+	if n > 0 {
+		cr.remaining = n
+		return nil
+	}
+	return cr.readTrailers()
+}
+
+func (cr *Reader) readTrailers() error {
+	maxBytes, maxFields := cr.MaxTrailerBytes, cr.MaxTrailerFields
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxTrailerBytes
+	}
+	if maxFields <= 0 {
+		maxFields = DefaultMaxTrailerFields
+	}
+
+	block := []byte{}
+	for fields := 0; ; fields++ {
+		line, err := cr.line()
+		if err != nil {
+			return err
+		}
+		block = append(block, line...)
+		block = append(block, "\r\n"...)
+		if len(block) > maxBytes {
+			return ERROR_TRAILERS_TOO_LARGE
+		}
+		if len(line) == 0 {
+			break
+		}
+		if fields == maxFields {
+			return ERROR_TOO_MANY_TRAILERS
+		}
+	}
+	if _, _, err := cr.trailers.Parse(block); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// line reads one CRLF-terminated line without its terminator.
+func (cr *Reader) line() ([]byte, error) {
+	line, err := cr.r.ReadSlice('\n')
+	switch {
+	case err == bufio.ErrBufferFull || len(line) > maxLineLength:
+		return nil, ERROR_MALFORMED_CHUNK
+	case err == io.EOF:
+		return nil, io.ErrUnexpectedEOF
+	case err != nil:
+		return nil, err
+	}
+	line, ok := bytes.CutSuffix(line, []byte("\r\n"))
+	if !ok {
+		return nil, ERROR_MALFORMED_CHUNK
+	}
+	return line, nil
+}
+
+// Writer encodes everything written to it as chunks. Close writes the last
+// chunk and any trailers; it does not close the underlying writer.
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (cw *Writer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	chunk := fmt.Appendf(nil, "%x\r\n", len(p))
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+	if _, err := cw.w.Write(chunk); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// CloseWithTrailers ends the body, sending trailers after the last chunk.
+func (cw *Writer) CloseWithTrailers(trailers *headers.Headers) error {
+	b := []byte("0\r\n")
+	if trailers != nil {
+		trailers.ForEach(func(n, v string) {
+			b = fmt.Appendf(b, "%s: %s\r\n", n, v)
+		})
+	}
+	b = append(b, "\r\n"...)
+	_, err := cw.w.Write(b)
+	return err
+}
+
+func (cw *Writer) Close() error {
+	return cw.CloseWithTrailers(nil)
+}
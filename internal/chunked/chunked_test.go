@@ -0,0 +1,90 @@
+package chunked
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/headers"
+)
+
+func TestReader(t *testing.T) {
+	// Test: Chunks are joined and trailers collected
+	br := bufio.NewReader(strings.NewReader("5;ext=1\r\nhello\r\n7\r\n, world\r\n0\r\nX-Sum: abc\r\n\r\nNEXT"))
+	trailers := headers.NewHeaders()
+	body, err := io.ReadAll(NewReader(br, trailers))
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(body))
+	sum, _ := trailers.Get("x-sum")
+	assert.Equal(t, "abc", sum)
+
+	// Test: Nothing past the body is consumed
+	rest, _ := io.ReadAll(br)
+	assert.Equal(t, "NEXT", string(rest))
+
+	// Test: Malformed and truncated bodies
+	for raw, want := range map[string]error{
+		"zz\r\n":             ERROR_MALFORMED_CHUNK,
+		"3\r\nabcX\r\n0\r\n": ERROR_MALFORMED_CHUNK,
+		"5\nhello\r\n":       ERROR_MALFORMED_CHUNK,
+		"5\r\nhel":           io.ErrUnexpectedEOF,
+		"0\r\nX-A: b\r\n":    io.ErrUnexpectedEOF,
+	} {
+		_, err := io.ReadAll(NewReader(bufio.NewReader(strings.NewReader(raw)), nil))
+		assert.ErrorIs(t, err, want, raw)
+	}
+}
+
+func TestParseSizeLine(t *testing.T) {
+	// Test: Sizes with and without extensions
+	for line, want := range map[string]int64{
+		"1a":                      26,
+		"5 ":                      5,
+		"5;ext":                   5,
+		`5 ; a=1 ;b = "x;\"y" ;c`: 5,
+	} {
+		n, _, err := ParseSizeLine([]byte(line))
+		assert.NoError(t, err, line)
+		assert.Equal(t, want, n, line)
+	}
+
+	// Test: Malformed sizes and extensions
+	for _, line := range []string{"", " 5", "+5", "-1", "0x5", "5;", "5;=1", "5;a=", `5;a="open`, "5 x", "5;a\x00", "11111111111111111"} {
+		_, _, err := ParseSizeLine([]byte(line))
+		assert.ErrorIs(t, err, ERROR_MALFORMED_CHUNK, line)
+	}
+
+	// Test: Extensions and lines over their limits
+	_, _, err := ParseSizeLine([]byte("5;a=" + strings.Repeat("x", MaxExtensionLength)))
+	assert.ErrorIs(t, err, ERROR_EXTENSIONS_TOO_LARGE)
+	_, _, err = ParseSizeLine([]byte(strings.Repeat("0", MaxSizeLineLength) + "5"))
+	assert.ErrorIs(t, err, ERROR_MALFORMED_CHUNK)
+
+	// Test: A body's extensions are bounded as a whole
+	body := strings.Repeat("1;a="+strings.Repeat("x", 200)+"\r\nx\r\n", MaxBodyExtensionBytes/200) + "0\r\n\r\n"
+	_, err = io.ReadAll(NewReader(bufio.NewReader(strings.NewReader(body)), nil))
+	assert.ErrorIs(t, err, ERROR_EXTENSIONS_TOO_LARGE)
+}
+
+func TestWriter(t *testing.T) {
+	// Test: What Writer encodes, Reader decodes
+	buf := &strings.Builder{}
+	w := NewWriter(buf)
+	w.Write([]byte("hello, "))
+	w.Write(nil)
+	w.Write([]byte("world"))
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Sum", "abc")
+	require.NoError(t, w.CloseWithTrailers(trailers))
+	assert.Equal(t, "7\r\nhello, \r\n5\r\nworld\r\n0\r\nx-sum: abc\r\n\r\n", buf.String())
+
+	decoded := headers.NewHeaders()
+	body, err := io.ReadAll(NewReader(bufio.NewReader(strings.NewReader(buf.String())), decoded))
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(body))
+	sum, _ := decoded.Get("x-sum")
+	assert.Equal(t, "abc", sum)
+}
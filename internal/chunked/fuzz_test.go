@@ -0,0 +1,37 @@
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/headers"
+)
+
+func FuzzChunkedDecode(f *testing.F) {
+	f.Add([]byte("5\r\nhello\r\n0\r\n\r\n"))
+	f.Add([]byte("3;ext=1\r\nabc\r\n0\r\nX-Sum: 1\r\n\r\n"))
+	f.Add([]byte("ffffffffffffffff\r\n"))
+	f.Add([]byte("-1\r\n"))
+	f.Add([]byte("5\r\nhelloXX0\r\n\r\n"))
+	f.Add([]byte("0\r\nBad Trailer\r\n\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		body, err := io.ReadAll(NewReader(bufio.NewReader(bytes.NewReader(data)), headers.NewHeaders()))
+		if err != nil {
+			return
+		}
+
+		// Whatever decodes cleanly survives being encoded and decoded again.
+		encoded := bytes.Buffer{}
+		w := NewWriter(&encoded)
+		w.Write(body)
+		require.NoError(t, w.Close())
+		again, err := io.ReadAll(NewReader(bufio.NewReader(&encoded), nil))
+		require.NoError(t, err)
+		assert.Equal(t, body, again)
+	})
+}
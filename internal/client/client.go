@@ -0,0 +1,425 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"tcp.to.http/internal/chunked"
+	"tcp.to.http/internal/headers"
+	"tcp.to.http/internal/response"
+)
+
+var ERROR_UNSUPPORTED_SCHEME = fmt.Errorf("unsupported url scheme")
+var ERROR_PIN_MISMATCH = fmt.Errorf("no pinned public key in the server's certificate chain")
+var ERROR_BODY_CLOSED = fmt.Errorf("read on closed response body")
+
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers *headers.Headers
+	Body    []byte
+	// BodyReader, when set, is streamed instead of Body: with a
+	// Content-Length of ContentLength, or chunked when that is negative.
+	// Such a request cannot be replayed for retries or 307/308 redirects.
+	BodyReader    io.Reader
+	ContentLength int64
+	ctx           context.Context
+}
+
+func NewRequest(ctx context.Context, method, rawURL string, body []byte) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, ERROR_UNSUPPORTED_SCHEME
+	}
+	return &Request{
+		Method:  method,
+		URL:     u,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+		ctx:     ctx,
+	}, nil
+}
+
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+type Response struct {
+	StatusCode response.StatusCode
+	// Request is the request this response answers, the last one when
+	// redirects were followed.
+	Request *Request
+	Headers *headers.Headers
+	// Trailers is filled in once Body has been read to EOF.
+	Trailers *headers.Headers
+	// Body must be closed; reading it to EOF first lets the connection be
+	// reused.
+	Body io.ReadCloser
+}
+
+type Client struct {
+	TLSConfig *tls.Config
+	// Pins, when set, are base64 SHA-256 hashes of public keys (see Pin);
+	// one of them must appear in the server's chain, on top of the usual
+	// verification.
+	Pins []string
+	// Proxy tunnels every connection through an http:// (CONNECT) or
+	// socks5:// proxy. Credentials in the URL are sent to the proxy.
+	Proxy       *url.URL
+	DialTimeout time.Duration
+	// Dial, when set, opens connections in place of a TCP dial; Proxy
+	// still takes precedence.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// MaxIdlePerHost caps the keep-alive connections kept open per host;
+	// negative disables keep-alive. IdleTimeout is how long one may sit
+	// unused before it is closed.
+	MaxIdlePerHost int
+	IdleTimeout    time.Duration
+	Redirects      RedirectPolicy
+	Retries        RetryPolicy
+	// ContinueThreshold holds back bodies over this many bytes, or of
+	// unknown length, until the server answers Expect: 100-continue, or
+	// ContinueTimeout passes without an answer. Zero never asks.
+	ContinueThreshold int64
+	ContinueTimeout   time.Duration
+	// Transport, when set, sends each request in place of the client's own
+	// connections (see vcr); redirects and retries still apply on top.
+	Transport func(req *Request) (*Response, error)
+
+	pool pool
+}
+
+var DefaultClient = &Client{}
+
+func Get(ctx context.Context, rawURL string) (*Response, error) {
+	req, err := NewRequest(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return DefaultClient.Do(req)
+}
+
+// send sends req, over a pooled keep-alive connection when one is idle, and
+// reads the response with the same parser the rest of the module uses. The
+// body streams from the connection, which goes back to the pool once the
+// body has been read to EOF and closed.
+func (c *Client) send(req *Request) (*Response, error) {
+	if c.Transport != nil {
+		return c.Transport(req)
+	}
+	key := req.URL.Scheme + "://" + address(req.URL)
+	pc := c.pool.get(key)
+	reused := pc != nil
+	if pc == nil {
+		conn, err := c.dial(req)
+		if err != nil {
+			return nil, err
+		}
+		pc = &persistConn{Conn: conn, key: key}
+		pc.parser = response.NewParser(pc, response.ParseOptions{})
+	}
+	// Until the body is done, a canceled context aborts the exchange.
+	stop := context.AfterFunc(req.Context(), func() { pc.Close() })
+
+	res, reusable, err := c.roundTrip(pc, req)
+	if err != nil {
+		stop()
+		pc.Close()
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		// The server may have closed an idle connection just as it was
+		// reused; nothing was processed, so an idempotent request is retried.
+		if reused && retryable(req, err) {
+			return c.send(req)
+		}
+		return nil, err
+	}
+
+	b := res.Body.(*body)
+	b.done = func(eof bool) {
+		if stop() && eof && reusable {
+			c.pool.put(pc, c.maxIdlePerHost(), c.idleTimeout())
+		} else {
+			pc.Close()
+		}
+	}
+	return res, nil
+}
+
+// body is a response body streaming from its connection. done runs once,
+// when the body hits EOF, fails or is closed, with whether it was read to
+// the end.
+type body struct {
+	r    io.Reader
+	done func(eof bool)
+	err  error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.r.Read(p)
+	if err != nil {
+		b.err = err
+		b.done(err == io.EOF)
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	if b.err == nil {
+		b.err = ERROR_BODY_CLOSED
+		b.done(false)
+	}
+	return nil
+}
+
+func retryable(req *Request, err error) bool {
+	if !idempotent(req.Method) || req.BodyReader != nil {
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE", "TRACE":
+		return true
+	}
+	return false
+}
+
+func address(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// Connect opens a connection to req.URL's host the way Do would, through
+// any proxy and with TLS for https, for a caller that speaks on it itself,
+// such as after an Upgrade.
+func (c *Client) Connect(req *Request) (net.Conn, error) {
+	return c.dial(req)
+}
+
+func (c *Client) dial(req *Request) (net.Conn, error) {
+	timeout := c.DialTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	addr := address(req.URL)
+
+	var conn net.Conn
+	var err error
+	if c.Proxy != nil {
+		conn, err = c.dialProxy(req.Context(), &dialer, addr)
+	} else if c.Dial != nil {
+		conn, err = c.Dial(req.Context(), "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(req.Context(), "tcp", addr)
+	}
+	if err != nil || req.URL.Scheme == "http" {
+		return conn, err
+	}
+
+	tlsConn := tls.Client(conn, c.tlsConfig(req.URL.Hostname()))
+	if err := tlsConn.HandshakeContext(req.Context()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (c *Client) tlsConfig(host string) *tls.Config {
+	config := &tls.Config{}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if len(c.Pins) > 0 {
+		verify := config.VerifyConnection
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if err := checkPins(state.PeerCertificates, c.Pins); err != nil {
+				return err
+			}
+			if verify != nil {
+				return verify(state)
+			}
+			return nil
+		}
+	}
+	return config
+}
+
+// checkPins passes if any certificate in the chain has a pinned key.
+func checkPins(certs []*x509.Certificate, pins []string) error {
+	for _, cert := range certs {
+		if slices.Contains(pins, Pin(cert)) {
+			return nil
+		}
+	}
+	return ERROR_PIN_MISMATCH
+}
+
+// Pin returns the pin for cert's public key, for Client.Pins.
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (c *Client) roundTrip(pc *persistConn, req *Request) (*Response, bool, error) {
+	keepAlive := c.maxIdlePerHost() >= 0
+	expect := c.expectContinue(req)
+	if err := writeHead(pc, req, keepAlive, expect); err != nil {
+		return nil, false, err
+	}
+
+	bodySent := false
+	if expect {
+		// Anything arriving in time is the server's answer; otherwise the
+		// body goes out anyway.
+		answered, err := pc.await(c.continueTimeout())
+		if err != nil {
+			return nil, false, err
+		}
+		expect = answered
+	}
+	if !expect {
+		if err := writeBody(pc, req); err != nil {
+			return nil, false, err
+		}
+		bodySent = true
+	}
+
+	pc.parser.SetMethod(req.Method)
+	for {
+		res, r, err := pc.parser.NextStream()
+		if err != nil {
+			return nil, false, err
+		}
+		code := res.StatusLine.StatusCode
+		if code == 100 && !bodySent {
+			if err := writeBody(pc, req); err != nil {
+				return nil, false, err
+			}
+			bodySent = true
+			continue
+		}
+		// Other interim responses precede the real one.
+		if code >= 200 {
+			return &Response{
+				StatusCode: code,
+				Request:    req,
+				Headers:    res.Headers,
+				Trailers:   res.Trailers,
+				Body:       &body{r: r},
+				// A body the server turned down was never sent, which leaves
+				// the connection out of step.
+			}, keepAlive && bodySent && res.Reusable(), nil
+		}
+	}
+}
+
+func (c *Client) continueTimeout() time.Duration {
+	if c.ContinueTimeout == 0 {
+		return time.Second
+	}
+	return c.ContinueTimeout
+}
+
+func (c *Client) expectContinue(req *Request) bool {
+	if value, ok := req.Headers.Get("expect"); ok {
+		return strings.EqualFold(value, "100-continue")
+	}
+	if c.ContinueThreshold <= 0 {
+		return false
+	}
+	if req.BodyReader != nil {
+		return req.ContentLength < 0 || req.ContentLength > c.ContinueThreshold
+	}
+	return int64(len(req.Body)) > c.ContinueThreshold
+}
+
+func writeHead(w io.Writer, req *Request, keepAlive, expect bool) error {
+	target := req.URL.RequestURI()
+	h := req.Headers.Clone()
+	if _, ok := h.Get("host"); !ok {
+		h.Replace("Host", req.URL.Host)
+	}
+	if _, ok := h.Get("user-agent"); !ok {
+		h.Replace("User-Agent", "tcp.to.http")
+	}
+	switch {
+	case req.BodyReader != nil && req.ContentLength < 0:
+		h.Delete("Content-Length")
+		h.Replace("Transfer-Encoding", "chunked")
+	case req.BodyReader != nil:
+		h.Replace("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	case len(req.Body) > 0 || req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH":
+		h.Replace("Content-Length", strconv.Itoa(len(req.Body)))
+	}
+	if expect {
+		h.Replace("Expect", "100-continue")
+	}
+	if !keepAlive {
+		h.Replace("Connection", "close")
+	}
+	if _, ok := h.Get("te"); !ok {
+		// Response.Trailers collects them, so the client can take trailers.
+		h.Replace("TE", "trailers")
+		h.Set("Connection", "TE")
+	}
+
+	b := fmt.Appendf(nil, "%s %s HTTP/1.1\r\n", req.Method, target)
+	h.ForEach(func(n, v string) {
+		b = fmt.Appendf(b, "%s: %s\r\n", n, v)
+	})
+	b = append(b, "\r\n"...)
+	_, err := w.Write(b)
+	return err
+}
+
+func writeBody(w io.Writer, req *Request) error {
+	if req.BodyReader == nil {
+		_, err := w.Write(req.Body)
+		return err
+	}
+	if req.ContentLength >= 0 {
+		n, err := io.Copy(w, io.LimitReader(req.BodyReader, req.ContentLength))
+		if err == nil && n < req.ContentLength {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	cw := chunked.NewWriter(w)
+	if _, err := io.Copy(cw, req.BodyReader); err != nil {
+		return err
+	}
+	return cw.Close()
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+func testServer(t *testing.T, handler server.Handler, options ...server.Option) string {
+	_, base := startServer(t, handler, options...)
+	return base
+}
+
+func startServer(t *testing.T, handler server.Handler, options ...server.Option) (*server.Server, string) {
+	s, err := server.Serve(0, handler, options...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, fmt.Sprintf("http://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+}
+
+func ok(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*response.GetDefaultHeaders(2))
+	w.WriteBody([]byte("ok"))
+}
+
+func accepted(s *server.Server) int64 {
+	n, _ := s.Metrics().Value("connections_accepted_total")
+	return n
+}
+
+func get(t *testing.T, c *Client, url string) {
+	res := mustDo(t, c, url)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "ok", string(body))
+}
+
+func TestStreamingBody(t *testing.T) {
+	release := make(chan struct{})
+	s, base := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Replace("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteChunkedBody([]byte("first"))
+		<-release
+		w.WriteChunkedBody([]byte("second"))
+		w.WriteBody([]byte("0\r\nX-Sum: 42\r\n\r\n"))
+	}, server.WithIdleTimeout(time.Second))
+	c := &Client{}
+
+	// Test: The body can be read before the server has finished sending it
+	res, err := c.Do(mustRequest(t, base+"/"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(res.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(buf))
+	_, ok := res.Trailers.Get("x-sum")
+	assert.False(t, ok)
+
+	// Test: Trailers are there once the body hits EOF
+	close(release)
+	rest, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(rest))
+	sum, _ := res.Trailers.Get("x-sum")
+	assert.Equal(t, "42", sum)
+	res.Body.Close()
+
+	// Test: A fully read chunked response frees the connection for reuse
+	res, err = c.Do(mustRequest(t, base+"/"))
+	require.NoError(t, err)
+	io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, int64(1), accepted(s))
+
+	// Test: Closing a body early gives up the connection
+	res, err = c.Do(mustRequest(t, base+"/"))
+	require.NoError(t, err)
+	res.Body.Close()
+	_, err = res.Body.Read(buf)
+	assert.ErrorIs(t, err, ERROR_BODY_CLOSED)
+	io.ReadAll(mustDo(t, c, base+"/").Body)
+	assert.Equal(t, int64(2), accepted(s))
+}
+
+func mustRequest(t *testing.T, url string) *Request {
+	req, err := NewRequest(context.Background(), "GET", url, nil)
+	require.NoError(t, err)
+	return req
+}
+
+func mustDo(t *testing.T, c *Client, url string) *Response {
+	res, err := c.Do(mustRequest(t, url))
+	require.NoError(t, err)
+	return res
+}
+
+func TestConnectionPool(t *testing.T) {
+	// Test: Keep-alive connections are reused
+	s, base := startServer(t, ok, server.WithIdleTimeout(time.Second))
+	c := &Client{}
+	for range 3 {
+		get(t, c, base+"/")
+	}
+	assert.Equal(t, int64(1), accepted(s))
+
+	// Test: Idle connections are dropped after the client's idle timeout
+	c = &Client{IdleTimeout: 10 * time.Millisecond}
+	get(t, c, base+"/")
+	time.Sleep(50 * time.Millisecond)
+	get(t, c, base+"/")
+	assert.Equal(t, int64(3), accepted(s))
+
+	// Test: Keep-alive can be turned off
+	c = &Client{MaxIdlePerHost: -1}
+	get(t, c, base+"/")
+	get(t, c, base+"/")
+	assert.Equal(t, int64(5), accepted(s))
+
+	// Test: A server that does not keep connections open gets a new one each time
+	s, base = startServer(t, ok)
+	c = &Client{}
+	get(t, c, base+"/")
+	get(t, c, base+"/")
+	assert.Equal(t, int64(2), accepted(s))
+
+	// Test: A connection the server reaped while idle is retried transparently
+	s, base = startServer(t, ok, server.WithIdleTimeout(10*time.Millisecond))
+	get(t, c, base+"/")
+	time.Sleep(50 * time.Millisecond)
+	get(t, c, base+"/")
+	assert.Equal(t, int64(2), accepted(s))
+	c.CloseIdleConnections()
+}
+
+func TestDo(t *testing.T) {
+	base := testServer(t, func(w *response.Writer, req *request.Request) {
+		body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + req.Body)
+		h := response.GetDefaultHeaders(len(body))
+		host, _ := req.Headers.Get("host")
+		h.Replace("X-Host", host)
+		w.WriteStatusLine(response.StatusCreated)
+		w.WriteHeaders(*h)
+		w.WriteBody(body)
+	})
+
+	// Test: A request is written and the response parsed by this module
+	req, err := NewRequest(context.Background(), "POST", base+"/echo?x=1", []byte("hello"))
+	require.NoError(t, err)
+	res, err := DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, response.StatusCreated, res.StatusCode)
+	host, _ := res.Headers.Get("x-host")
+	assert.Equal(t, req.URL.Host, host)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /echo?x=1 hello", string(body))
+
+	// Test: Chunked responses are decoded along with their trailers
+	chunked := testServer(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Replace("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteChunkedBody([]byte("hello, "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteBody([]byte("0\r\nX-Sum: 42\r\n\r\n"))
+	})
+	res, err = Get(context.Background(), chunked)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	assert.Equal(t, "hello, world", string(body))
+	sum, _ := res.Trailers.Get("x-sum")
+	assert.Equal(t, "42", sum)
+
+	// Test: Unsupported schemes are rejected
+	_, err = Get(context.Background(), "ftp://example.com/")
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_SCHEME)
+
+	// Test: A canceled context aborts the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Get(ctx, base+"/")
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"tcp.to.http/internal/response"
+)
+
+const (
+	DefaultMaxIdlePerHost = 2
+	DefaultIdleTimeout    = 90 * time.Second
+)
+
+// persistConn is a connection that can carry several requests in turn.
+type persistConn struct {
+	net.Conn
+	key    string
+	parser *response.Parser
+	timer  *time.Timer
+	// peeked holds a byte read by await, returned ahead of the connection.
+	peeked []byte
+}
+
+func (pc *persistConn) Read(p []byte) (int, error) {
+	if len(pc.peeked) > 0 {
+		n := copy(p, pc.peeked)
+		pc.peeked = pc.peeked[n:]
+		return n, nil
+	}
+	return pc.Conn.Read(p)
+}
+
+// await reports whether the server starts answering within timeout.
+func (pc *persistConn) await(timeout time.Duration) (bool, error) {
+	pc.Conn.SetReadDeadline(time.Now().Add(timeout))
+	defer pc.Conn.SetReadDeadline(time.Time{})
+	b := make([]byte, 1)
+	n, err := pc.Conn.Read(b)
+	if n > 0 {
+		pc.peeked = b[:n]
+		return true, nil
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false, nil
+	}
+	return false, err
+}
+
+// pool keeps idle keep-alive connections per scheme and host, newest last.
+type pool struct {
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+func (c *Client) maxIdlePerHost() int {
+	if c.MaxIdlePerHost == 0 {
+		return DefaultMaxIdlePerHost
+	}
+	return c.MaxIdlePerHost
+}
+
+func (c *Client) idleTimeout() time.Duration {
+	if c.IdleTimeout == 0 {
+		return DefaultIdleTimeout
+	}
+	return c.IdleTimeout
+}
+
+// get hands out the most recently used idle connection for key, if any.
+func (p *pool) get(key string) *persistConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conns := p.idle[key]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		p.idle[key] = conns
+		// A stopped timer means the connection is still ours; otherwise it
+		// is already being closed for idling too long.
+		if pc.timer.Stop() {
+			return pc
+		}
+	}
+	return nil
+}
+
+// put returns pc to the pool, closing it instead when the host already has
+// max idle connections.
+func (p *pool) put(pc *persistConn, max int, timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if max < 0 || len(p.idle[pc.key]) >= max {
+		pc.Close()
+		return
+	}
+	if p.idle == nil {
+		p.idle = map[string][]*persistConn{}
+	}
+	p.idle[pc.key] = append(p.idle[pc.key], pc)
+	pc.timer = time.AfterFunc(timeout, func() { p.remove(pc) })
+}
+
+func (p *pool) remove(pc *persistConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conns := p.idle[pc.key]
+	for i, c := range conns {
+		if c == pc {
+			p.idle[pc.key] = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	pc.Close()
+}
+
+// CloseIdleConnections closes every pooled connection not in use.
+func (c *Client) CloseIdleConnections() {
+	c.pool.mu.Lock()
+	idle := c.pool.idle
+	c.pool.idle = nil
+	c.pool.mu.Unlock()
+	for _, conns := range idle {
+		for _, pc := range conns {
+			pc.timer.Stop()
+			pc.Close()
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"tcp.to.http/internal/response"
+)
+
+var ERROR_UNSUPPORTED_PROXY = fmt.Errorf("unsupported proxy scheme")
+var ERROR_PROXY_REFUSED = fmt.Errorf("proxy refused the connection")
+
+// dialProxy opens a tunnel to addr through c.Proxy: an HTTP proxy via
+// CONNECT, or a SOCKS5 proxy, which resolves the host name itself.
+func (c *Client) dialProxy(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	proxyAddr := c.Proxy.Host
+	if c.Proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(c.Proxy.Hostname(), map[string]string{"http": "80", "socks5": "1080", "socks5h": "1080"}[c.Proxy.Scheme])
+	}
+	var handshake func(conn net.Conn, addr string) error
+	switch c.Proxy.Scheme {
+	case "http":
+		handshake = c.connect
+	case "socks5", "socks5h":
+		handshake = c.socks5
+	default:
+		return nil, ERROR_UNSUPPORTED_PROXY
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	err = handshake(conn, addr)
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *Client) connect(conn net.Conn, addr string) error {
+	b := fmt.Appendf(nil, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if user := c.Proxy.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		b = fmt.Appendf(b, "Proxy-Authorization: Basic %s\r\n", credentials)
+	}
+	b = append(b, "\r\n"...)
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+
+	parser := response.NewParser(conn, response.ParseOptions{Method: "CONNECT"})
+	res, err := parser.Next()
+	if err != nil {
+		return err
+	}
+	if code := res.StatusLine.StatusCode; code < 200 || code > 299 {
+		return fmt.Errorf("%w: %d %s", ERROR_PROXY_REFUSED, code, res.StatusLine.ReasonPhrase)
+	}
+	if parser.Buffered() > 0 {
+		// The tunnel's first bytes must come from the client side.
+		return fmt.Errorf("%w: data after CONNECT response", ERROR_PROXY_REFUSED)
+	}
+	return nil
+}
+
+// socks5 runs the RFC 1928 handshake, with RFC 1929 username and password
+// authentication when the proxy URL carries credentials.
+func (c *Client) socks5(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	method := byte(0x00)
+	if c.Proxy.User != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != method {
+		return fmt.Errorf("%w: no acceptable socks5 authentication", ERROR_PROXY_REFUSED)
+	}
+
+	if method == 0x02 {
+		user := c.Proxy.User.Username()
+		password, _ := c.Proxy.User.Password()
+		b := append([]byte{1, byte(len(user))}, user...)
+		b = append(append(b, byte(len(password))), password...)
+		if _, err := conn.Write(b); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return fmt.Errorf("%w: socks5 authentication failed", ERROR_PROXY_REFUSED)
+		}
+	}
+
+	b := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		b = append(append(b, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(append(b, 1), ip4...)
+	} else {
+		b = append(append(b, 4), ip...)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(port))
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("%w: socks5 reply %d", ERROR_PROXY_REFUSED, head[1])
+	}
+	// Skip the bound address and port.
+	skip := map[byte]int{1: 4, 4: 16}[head[3]]
+	if head[3] == 3 {
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
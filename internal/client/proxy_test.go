@@ -0,0 +1,171 @@
+package client
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/server"
+)
+
+// tunnelProxy accepts connections and, once handshake has returned the
+// target address, splices the client onto it. It records each target.
+func tunnelProxy(t *testing.T, handshake func(c net.Conn, r *bufio.Reader) (string, error)) (*url.URL, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	targets := make(chan string, 10)
+
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				addr, err := handshake(c, r)
+				if err != nil {
+					return
+				}
+				targets <- addr
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go io.Copy(upstream, r)
+				io.Copy(c, upstream)
+			}()
+		}
+	}()
+	return &url.URL{Host: listener.Addr().String()}, targets
+}
+
+func connectProxy(c net.Conn, r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	auth := false
+	for {
+		h, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		auth = auth || strings.HasPrefix(h, "Proxy-Authorization: Basic dXNlcjpwYXNz")
+		if h == "\r\n" {
+			break
+		}
+	}
+	if !auth {
+		fmt.Fprint(c, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+		return "", fmt.Errorf("no credentials")
+	}
+	fmt.Fprint(c, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	return strings.Fields(line)[1], nil
+}
+
+func socks5Proxy(c net.Conn, r *bufio.Reader) (string, error) {
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(r, greeting); err != nil {
+		return "", err
+	}
+	c.Write([]byte{5, 0})
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(r, head); err != nil || head[3] != 3 {
+		return "", fmt.Errorf("unexpected request")
+	}
+	rest := make([]byte, int(head[4])+2)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return "", err
+	}
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	port := binary.BigEndian.Uint16(rest[len(rest)-2:])
+	return fmt.Sprintf("%s:%d", rest[:len(rest)-2], port), nil
+}
+
+func TestProxies(t *testing.T) {
+	_, base := startServer(t, ok)
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(base, "http://"))
+	target := "http://localhost:" + port + "/"
+
+	// Test: CONNECT tunnels carry the request, with proxy credentials
+	proxy, targets := tunnelProxy(t, connectProxy)
+	proxy.Scheme, proxy.User = "http", url.UserPassword("user", "pass")
+	get(t, &Client{Proxy: proxy}, target)
+	assert.Equal(t, "localhost:"+port, <-targets)
+
+	// Test: A refusing proxy is reported
+	proxy.User = nil
+	_, err := (&Client{Proxy: proxy}).Do(mustRequest(t, target))
+	assert.ErrorIs(t, err, ERROR_PROXY_REFUSED)
+
+	// Test: SOCKS5 proxies get the host name to resolve
+	proxy, targets = tunnelProxy(t, socks5Proxy)
+	proxy.Scheme = "socks5"
+	get(t, &Client{Proxy: proxy}, target)
+	assert.Equal(t, "localhost:"+port, <-targets)
+
+	// Test: Unknown proxy schemes are rejected
+	_, err = (&Client{Proxy: &url.URL{Scheme: "ftp", Host: "x:1"}}).Do(mustRequest(t, target))
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_PROXY)
+}
+
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	_, base := startServer(t, ok, server.WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	target := strings.Replace(base, "http://127.0.0.1", "https://localhost", 1) + "/"
+
+	// Test: HTTPS with a custom root
+	get(t, &Client{TLSConfig: &tls.Config{RootCAs: pool}}, target)
+
+	// Test: A matching pin passes
+	get(t, &Client{TLSConfig: &tls.Config{RootCAs: pool}, Pins: []string{Pin(cert.Leaf)}}, target)
+
+	// Test: A chain without a pinned key is refused
+	other, _ := selfSigned(t)
+	_, err := (&Client{TLSConfig: &tls.Config{RootCAs: pool}, Pins: []string{Pin(other.Leaf)}}).Do(mustRequest(t, target))
+	assert.ErrorIs(t, err, ERROR_PIN_MISMATCH)
+
+	// Test: HTTPS through a CONNECT proxy
+	proxy, _ := tunnelProxy(t, connectProxy)
+	proxy.Scheme, proxy.User = "http", url.UserPassword("user", "pass")
+	get(t, &Client{TLSConfig: &tls.Config{RootCAs: pool}, Proxy: proxy}, target)
+}
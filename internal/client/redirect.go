@@ -0,0 +1,130 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"tcp.to.http/internal/response"
+)
+
+const DefaultMaxRedirects = 10
+
+var ERROR_TOO_MANY_REDIRECTS = fmt.Errorf("too many redirects")
+
+// ERROR_USE_LAST_RESPONSE, returned from RedirectPolicy.Check, stops
+// following redirects and hands back the redirect response itself.
+var ERROR_USE_LAST_RESPONSE = fmt.Errorf("use last response")
+
+// sensitiveHeaders are only forwarded to the original host and its
+// subdomains unless RedirectPolicy.ForwardSensitive is set.
+var sensitiveHeaders = []string{"authorization", "proxy-authorization", "cookie", "cookie2", "www-authenticate"}
+
+type RedirectPolicy struct {
+	// MaxHops is how many redirects are followed; zero means
+	// DefaultMaxRedirects and a negative value returns redirects as is.
+	MaxHops int
+	// ForwardSensitive sends credentials and cookies on to other hosts.
+	ForwardSensitive bool
+	// Check, when set, vets every hop before it is followed; via holds the
+	// requests made so far, oldest first.
+	Check func(req *Request, via []*Request) error
+}
+
+// Do sends req and follows redirects as the client's RedirectPolicy allows.
+func (c *Client) Do(req *Request) (*Response, error) {
+	via := []*Request{}
+	for {
+		res, err := c.sendRetrying(req)
+		if err != nil {
+			return nil, err
+		}
+		next := c.redirect(req, res)
+		if next == nil {
+			return res, nil
+		}
+
+		via = append(via, req)
+		max := c.Redirects.MaxHops
+		if max == 0 {
+			max = DefaultMaxRedirects
+		}
+		if max < 0 {
+			return res, nil
+		}
+		if len(via) > max {
+			res.Body.Close()
+			return nil, ERROR_TOO_MANY_REDIRECTS
+		}
+		if c.Redirects.Check != nil {
+			if err := c.Redirects.Check(next, via); err == ERROR_USE_LAST_RESPONSE {
+				return res, nil
+			} else if err != nil {
+				res.Body.Close()
+				return nil, err
+			}
+		}
+
+		// Draining a short body lets the connection be reused for the hop.
+		io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+		res.Body.Close()
+		req = next
+	}
+}
+
+// redirect builds the request that follows res, or returns nil when res is
+// not a redirect that can be followed.
+func (c *Client) redirect(req *Request, res *Response) *Request {
+	method, body, bodyReader := req.Method, req.Body, req.BodyReader
+	switch res.StatusCode {
+	case response.StatusMovedPermanently, response.StatusFound:
+		// What browsers do, although the RFCs only allow it for POST.
+		if method == "POST" {
+			method, body, bodyReader = "GET", nil, nil
+		}
+	case response.StatusSeeOther:
+		if method != "HEAD" {
+			method, body, bodyReader = "GET", nil, nil
+		}
+	case response.StatusTemporaryRedirect, response.StatusPermanentRedirect:
+		// A streamed body has been consumed and cannot be sent again.
+		if bodyReader != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	location, ok := res.Headers.Get("location")
+	if !ok {
+		return nil
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return nil
+	}
+	u := req.URL.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+
+	h := req.Headers.Clone()
+	h.Delete("host")
+	if body == nil && bodyReader == nil {
+		h.Delete("content-length")
+		h.Delete("content-type")
+		h.Delete("transfer-encoding")
+	}
+	if !c.Redirects.ForwardSensitive && !sameOrSubdomain(u.Hostname(), req.URL.Hostname()) {
+		for _, name := range sensitiveHeaders {
+			h.Delete(name)
+		}
+	}
+	return &Request{Method: method, URL: u, Headers: h, Body: body, BodyReader: bodyReader, ContentLength: req.ContentLength, ctx: req.ctx}
+}
+
+func sameOrSubdomain(host, original string) bool {
+	host, original = strings.ToLower(host), strings.ToLower(original)
+	return host == original || strings.HasSuffix(host, "."+original)
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+// redirectServer redirects /<code>/<rest> to /<rest> with the given status
+// and echoes the method, body and cookie of anything else.
+func redirectServer(t *testing.T) string {
+	return testServer(t, func(w *response.Writer, req *request.Request) {
+		target := req.RequestLine.RequestTarget
+		var code int
+		if n, _ := fmt.Sscanf(target, "/%d/", &code); n == 1 {
+			h := response.GetDefaultHeaders(0)
+			location := target[len("/000/"):]
+			if !strings.HasPrefix(location, "http") {
+				location = "/" + location
+			}
+			h.Replace("Location", location)
+			w.WriteStatusLine(response.StatusCode(code))
+			w.WriteHeaders(*h)
+			return
+		}
+		cookie, _ := req.Headers.Get("cookie")
+		body := []byte(req.RequestLine.Method + " " + target + " " + req.Body + " " + cookie)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+}
+
+func post(t *testing.T, c *Client, url string) (*Response, string) {
+	req, err := NewRequest(context.Background(), "POST", url, []byte("data"))
+	require.NoError(t, err)
+	req.Headers.Set("Cookie", "a=b")
+	res, err := c.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	return res, string(body)
+}
+
+func TestRedirects(t *testing.T) {
+	base := redirectServer(t)
+	c := &Client{}
+
+	// Test: 301, 302 and 303 turn a POST into a GET without a body
+	for _, code := range []string{"301", "302", "303"} {
+		res, body := post(t, c, base+"/"+code+"/done")
+		assert.Equal(t, "GET /done  a=b", body, code)
+		assert.Equal(t, "/done", res.Request.URL.Path)
+	}
+
+	// Test: 307 and 308 keep the method and body
+	for _, code := range []string{"307", "308"} {
+		_, body := post(t, c, base+"/"+code+"/done")
+		assert.Equal(t, "POST /done data a=b", body, code)
+	}
+
+	// Test: Hops are capped
+	c = &Client{Redirects: RedirectPolicy{MaxHops: 2}}
+	_, body := post(t, c, base+"/307/307/done")
+	assert.Equal(t, "POST /done data a=b", body)
+	req, _ := NewRequest(context.Background(), "GET", base+"/302/302/302/done", nil)
+	_, err := c.Do(req)
+	assert.ErrorIs(t, err, ERROR_TOO_MANY_REDIRECTS)
+
+	// Test: Following can be turned off
+	c = &Client{Redirects: RedirectPolicy{MaxHops: -1}}
+	res, _ := post(t, c, base+"/302/done")
+	assert.Equal(t, response.StatusFound, res.StatusCode)
+
+	// Test: Check can stop at a redirect or fail it
+	c = &Client{Redirects: RedirectPolicy{Check: func(req *Request, via []*Request) error {
+		if strings.HasPrefix(req.URL.Path, "/stop") {
+			return ERROR_USE_LAST_RESPONSE
+		}
+		if len(via) > 1 {
+			return fmt.Errorf("no second hop")
+		}
+		return nil
+	}}}
+	res, _ = post(t, c, base+"/307/stop")
+	assert.Equal(t, response.StatusTemporaryRedirect, res.StatusCode)
+	req, _ = NewRequest(context.Background(), "GET", base+"/302/302/done", nil)
+	_, err = c.Do(req)
+	assert.EqualError(t, err, "no second hop")
+}
+
+func TestRedirectHeaders(t *testing.T) {
+	base := redirectServer(t)
+	other := strings.Replace(base, "127.0.0.1", "localhost", 1)
+
+	// Test: Cookies are dropped on the way to another host
+	_, body := post(t, &Client{}, base+"/307/"+other+"/done")
+	assert.Equal(t, "POST /done data ", body)
+
+	// Test: Unless forwarding them is allowed
+	_, body = post(t, &Client{Redirects: RedirectPolicy{ForwardSensitive: true}}, base+"/307/"+other+"/done")
+	assert.Equal(t, "POST /done data a=b", body)
+
+	// Test: Subdomains count as the same host
+	assert.True(t, sameOrSubdomain("api.example.com", "example.com"))
+	assert.False(t, sameOrSubdomain("badexample.com", "example.com"))
+}
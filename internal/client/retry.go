@@ -0,0 +1,86 @@
+package client
+
+import (
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"tcp.to.http/internal/response"
+)
+
+const (
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	DefaultRetryMaxDelay  = 10 * time.Second
+)
+
+// RetryPolicy retries idempotent requests that fail to connect or get a
+// 502, 503 or 504. Off unless MaxRetries is set.
+type RetryPolicy struct {
+	MaxRetries int
+	// BaseDelay doubles with every attempt up to MaxDelay, with jitter. A
+	// Retry-After from the server takes precedence, still capped at MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func (p RetryPolicy) delay(attempt int, res *Response) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = DefaultRetryMaxDelay
+	}
+	if res != nil {
+		if d, ok := retryAfter(res); ok {
+			return min(d, max)
+		}
+	}
+
+	d := min(base<<attempt, max)
+	// Half fixed, half random, so clients that failed together spread out.
+	return d/2 + rand.N(d/2+1)
+}
+
+func retryAfter(res *Response) (time.Duration, bool) {
+	value, ok := res.Headers.Get("retry-after")
+	if !ok {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := time.Parse(response.TimeFormat, value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func retryStatus(code response.StatusCode) bool {
+	return code == response.StatusBadGateway || code == response.StatusServiceUnavailable || code == response.StatusGatewayTimeout
+}
+
+// sendRetrying is send with the client's RetryPolicy applied.
+func (c *Client) sendRetrying(req *Request) (*Response, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(req)
+		if attempt >= c.Retries.MaxRetries || !idempotent(req.Method) || req.BodyReader != nil || req.Context().Err() != nil {
+			return res, err
+		}
+		if err == nil && !retryStatus(res.StatusCode) {
+			return res, nil
+		}
+
+		wait := c.Retries.delay(attempt, res)
+		if res != nil {
+			io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+			res.Body.Close()
+		}
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
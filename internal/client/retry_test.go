@@ -0,0 +1,79 @@
+package client
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestRetries(t *testing.T) {
+	hits := atomic.Int32{}
+	base := testServer(t, func(w *response.Writer, req *request.Request) {
+		if hits.Add(1)%3 != 0 {
+			h := response.GetDefaultHeaders(0)
+			h.Replace("Retry-After", "0")
+			w.WriteStatusLine(response.StatusServiceUnavailable)
+			w.WriteHeaders(*h)
+			return
+		}
+		ok(w, req)
+	})
+
+	// Test: Retries are off by default
+	res := mustDo(t, &Client{}, base+"/")
+	res.Body.Close()
+	assert.Equal(t, response.StatusServiceUnavailable, res.StatusCode)
+
+	// Test: 503s are retried until the request succeeds
+	hits.Store(0)
+	get(t, &Client{Retries: RetryPolicy{MaxRetries: 3}}, base+"/")
+	assert.Equal(t, int32(3), hits.Load())
+
+	// Test: The last response is returned once retries run out
+	hits.Store(0)
+	res = mustDo(t, &Client{Retries: RetryPolicy{MaxRetries: 1}}, base+"/")
+	res.Body.Close()
+	assert.Equal(t, response.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, int32(2), hits.Load())
+
+	// Test: Non-idempotent requests are never retried
+	hits.Store(0)
+	req, err := NewRequest(t.Context(), "POST", base+"/", []byte("x"))
+	require.NoError(t, err)
+	res, err = (&Client{Retries: RetryPolicy{MaxRetries: 3}}).Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, int32(1), hits.Load())
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	// Test: Delays double with jitter and are capped
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		d := p.delay(attempt, nil)
+		assert.GreaterOrEqual(t, d, want/2)
+		assert.LessOrEqual(t, d, want)
+	}
+
+	// Test: Retry-After wins, in seconds or as a date, still capped
+	res := &Response{Headers: newHeaders("Retry-After", "0")}
+	assert.Equal(t, time.Duration(0), p.delay(3, res))
+	res = &Response{Headers: newHeaders("Retry-After", "120")}
+	assert.Equal(t, time.Second, p.delay(0, res))
+	res = &Response{Headers: newHeaders("Retry-After", time.Now().Add(-time.Minute).UTC().Format(response.TimeFormat))}
+	assert.Equal(t, time.Duration(0), p.delay(0, res))
+}
+
+func newHeaders(name, value string) *headers.Headers {
+	h := headers.NewHeaders()
+	h.Set(name, value)
+	return h
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/chunked"
+	"tcp.to.http/internal/headers"
+)
+
+// uploadServer answers one request per connection. When the request expects
+// 100-continue, answer decides what goes back first: "100", "417" or
+// nothing. It echoes the decoded body, and reports the request head.
+func uploadServer(t *testing.T, answer string) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	heads := make(chan string, 10)
+
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				h := headers.NewHeaders()
+				head := ""
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					head += line
+					if line == "\r\n" {
+						break
+					}
+				}
+				_, rest, _ := strings.Cut(head, "\r\n")
+				h.Parse([]byte(rest))
+				heads <- head
+
+				if _, ok := h.Get("expect"); ok {
+					switch answer {
+					case "100":
+						fmt.Fprint(c, "HTTP/1.1 100 Continue\r\n\r\n")
+					case "417":
+						fmt.Fprint(c, "HTTP/1.1 417 Expectation Failed\r\nContent-Length: 0\r\n\r\n")
+						return
+					}
+				}
+
+				var body []byte
+				if _, ok := h.Get("transfer-encoding"); ok {
+					body, err = io.ReadAll(chunked.NewReader(r, nil))
+				} else {
+					n := 0
+					if cl, ok := h.Get("content-length"); ok {
+						fmt.Sscan(cl, &n)
+					}
+					body = make([]byte, n)
+					_, err = io.ReadFull(r, body)
+				}
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String() + "/upload", heads
+}
+
+func upload(t *testing.T, c *Client, url string, length int64) (*Response, string) {
+	req, err := NewRequest(context.Background(), "POST", url, nil)
+	require.NoError(t, err)
+	req.BodyReader = strings.NewReader("streamed body")
+	req.ContentLength = length
+	res, err := c.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(b)
+}
+
+func TestStreamingUpload(t *testing.T) {
+	// Test: A body of unknown length goes out chunked
+	url, heads := uploadServer(t, "100")
+	res, body := upload(t, &Client{}, url, -1)
+	assert.Equal(t, 200, int(res.StatusCode))
+	assert.Equal(t, "streamed body", body)
+	head := <-heads
+	assert.Contains(t, head, "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, head, "content-length")
+	assert.NotContains(t, head, "expect")
+
+	// Test: A known length is sent as Content-Length
+	res, body = upload(t, &Client{}, url, 13)
+	assert.Equal(t, "streamed body", body)
+	assert.Contains(t, <-heads, "content-length: 13\r\n")
+
+	// Test: Large bodies wait for 100 Continue
+	c := &Client{ContinueThreshold: 4}
+	res, body = upload(t, c, url, 13)
+	assert.Equal(t, 200, int(res.StatusCode))
+	assert.Equal(t, "streamed body", body)
+	assert.Contains(t, <-heads, "expect: 100-continue\r\n")
+
+	// Test: Unknown lengths always ask first
+	res, body = upload(t, c, url, -1)
+	assert.Equal(t, "streamed body", body)
+	assert.Contains(t, <-heads, "expect: 100-continue\r\n")
+}
+
+func TestExpectContinue(t *testing.T) {
+	// Test: A final response in place of 100 Continue keeps the body back
+	url, _ := uploadServer(t, "417")
+	c := &Client{ContinueThreshold: 1}
+	res, _ := upload(t, c, url, -1)
+	assert.Equal(t, 417, int(res.StatusCode))
+	c.pool.mu.Lock()
+	assert.Empty(t, c.pool.idle)
+	c.pool.mu.Unlock()
+
+	// Test: A server that never answers gets the body after the timeout
+	url, _ = uploadServer(t, "")
+	c = &Client{ContinueThreshold: 1, ContinueTimeout: 50 * time.Millisecond}
+	start := time.Now()
+	res, body := upload(t, c, url, 13)
+	assert.Equal(t, 200, int(res.StatusCode))
+	assert.Equal(t, "streamed body", body)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// Test: Small bodies are sent straight away
+	url, heads := uploadServer(t, "")
+	c = &Client{ContinueThreshold: 100}
+	_, body = upload(t, c, url, 13)
+	assert.Equal(t, "streamed body", body)
+	assert.NotContains(t, <-heads, "expect")
+}
+
+func TestStreamingBodyNotReplayed(t *testing.T) {
+	// Test: A 307 with a streamed body is returned rather than followed
+	req, err := NewRequest(context.Background(), "POST", redirectServer(t)+"/307/done", nil)
+	require.NoError(t, err)
+	req.BodyReader = strings.NewReader("once")
+	req.ContentLength = 4
+	res, err := (&Client{}).Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 307, int(res.StatusCode))
+}
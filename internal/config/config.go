@@ -0,0 +1,92 @@
+package config
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"tcp.to.http/internal/proxy"
+)
+
+var ERROR_INVALID_DURATION = fmt.Errorf("invalid duration")
+
+// Duration reads from JSON as a string such as "5s" or "1m30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	s := ""
+	if err := json.Unmarshal(data, &s); err != nil {
+		return ERROR_INVALID_DURATION
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return ERROR_INVALID_DURATION
+	}
+	*d = Duration(v)
+	return nil
+}
+
+type Cert struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// Config is the reloadable part of a server's setup, read from a JSON file.
+type Config struct {
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	// Static maps URL prefixes to directories served from them.
+	Static map[string]string `json:"static"`
+	// Upstreams maps URL prefixes to the base URLs of the backends proxied
+	// from them, such as "http://10.0.0.1:8080", tried in turn.
+	Upstreams map[string][]string `json:"upstreams"`
+	TLS       []Cert              `json:"tls"`
+
+	certificates []tls.Certificate
+}
+
+// Load reads and validates the file at path. TLS key pairs are loaded here
+// too, so a reload with a broken certificate fails instead of half-applying.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for prefix, dir := range c.Static {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("static %s: %w", prefix, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("static %s: %s is not a directory", prefix, dir)
+		}
+	}
+	for prefix, upstreams := range c.Upstreams {
+		if _, err := proxy.Handler(proxy.Config{Routes: []proxy.Route{{Prefix: prefix, Upstreams: upstreams}}}); err != nil {
+			return nil, fmt.Errorf("upstreams: %w", err)
+		}
+	}
+	for _, cert := range c.TLS {
+		pair, err := tls.LoadX509KeyPair(cert.CertFile, cert.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls %s: %w", cert.CertFile, err)
+		}
+		c.certificates = append(c.certificates, pair)
+	}
+	return c, nil
+}
+
+func (c *Config) Certificates() []tls.Certificate {
+	return c.certificates
+}
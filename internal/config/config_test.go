@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/proxy"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+func write(t *testing.T, path, data string) {
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	// Test: Durations, static dirs and upstreams are read
+	write(t, path, `{"read_timeout": "5s", "static": {"/assets": "`+dir+`"}, "upstreams": {"/api": ["http://10.0.0.1:80"]}}`)
+	c, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, Duration(5*time.Second), c.ReadTimeout)
+	assert.Equal(t, map[string]string{"/assets": dir}, c.Static)
+	assert.Equal(t, []string{"http://10.0.0.1:80"}, c.Upstreams["/api"])
+
+	// Test: Bad values are rejected
+	write(t, path, `{"read_timeout": "soon"}`)
+	_, err = Load(path)
+	assert.ErrorIs(t, err, ERROR_INVALID_DURATION)
+
+	write(t, path, `{"static": {"/assets": "`+filepath.Join(dir, "missing")+`"}}`)
+	_, err = Load(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	write(t, path, `{"upstreams": {"/api": ["10.0.0.1:80"]}}`)
+	_, err = Load(path)
+	assert.Error(t, err)
+}
+
+func get(t *testing.T, r *Reloader, target string) string {
+	req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	out := bytes.Buffer{}
+	r.Static(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusNotFound)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	})(response.NewWriter(&out), req)
+	return out.String()
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	v1, v2 := filepath.Join(dir, "v1"), filepath.Join(dir, "v2")
+	require.NoError(t, os.Mkdir(v1, 0o755))
+	require.NoError(t, os.Mkdir(v2, 0o755))
+	write(t, filepath.Join(v1, "app.js"), "one")
+	write(t, filepath.Join(v2, "app.js"), "two")
+
+	path := filepath.Join(dir, "config.json")
+	write(t, path, `{"read_timeout": "1s", "static": {"/assets/": "`+v1+`"}}`)
+	r, err := NewReloader(path)
+	require.NoError(t, err)
+
+	timeouts := []Duration{}
+	r.OnReload(func(c *Config) { timeouts = append(timeouts, c.ReadTimeout) })
+
+	// Test: Static prefixes are served from the current config
+	assert.True(t, strings.HasSuffix(get(t, r, "/assets/app.js"), "one"))
+	assert.True(t, strings.HasPrefix(get(t, r, "/assetsx/app.js"), "HTTP/1.1 404"))
+
+	// Test: Reload swaps the config and notifies listeners
+	write(t, path, `{"read_timeout": "2s", "static": {"/assets/": "`+v2+`"}}`)
+	require.NoError(t, r.Reload())
+	assert.True(t, strings.HasSuffix(get(t, r, "/assets/app.js"), "two"))
+	assert.Equal(t, []Duration{Duration(time.Second), Duration(2 * time.Second)}, timeouts)
+
+	// Test: A broken file leaves the current config in place
+	write(t, path, `{`)
+	require.Error(t, r.Reload())
+	assert.Equal(t, Duration(2*time.Second), r.Current().ReadTimeout)
+
+	// Test: SIGHUP triggers a reload
+	stop := r.ReloadOnSignal()
+	defer stop()
+	write(t, path, `{"read_timeout": "3s"}`)
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.Eventually(t, func() bool {
+		return r.Current().ReadTimeout == Duration(3*time.Second)
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestReloaderUpstreams(t *testing.T) {
+	backend := func(name string) string {
+		s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+			body := name + " " + req.RequestLine.RequestTarget
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+			w.WriteBody([]byte(body))
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return fmt.Sprintf("http://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+	}
+	one, two := backend("one"), backend("two")
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	write(t, path, `{"upstreams": {"/api/": ["`+one+`"]}}`)
+	r, err := NewReloader(path)
+	require.NoError(t, err)
+	handler := r.Upstreams(proxy.Config{}, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusNotFound)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	})
+	get := func(target string) string {
+		req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		out := bytes.Buffer{}
+		handler(response.NewWriter(&out), req)
+		return out.String()
+	}
+
+	// Test: Upstream prefixes are proxied, other paths fall through
+	assert.True(t, strings.HasSuffix(get("/api/users"), "one /api/users"))
+	assert.True(t, strings.HasPrefix(get("/apix"), "HTTP/1.1 404"))
+
+	// Test: A reload switches backends
+	write(t, path, `{"upstreams": {"/api/": ["`+two+`"]}}`)
+	require.NoError(t, r.Reload())
+	assert.True(t, strings.HasSuffix(get("/api/users"), "two /api/users"))
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"tcp.to.http/internal/fileserver"
+	"tcp.to.http/internal/proxy"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// Reloader holds the current Config and swaps in a new one on Reload.
+// Readers always see a complete config; requests already in flight finish
+// with the one they started with.
+type Reloader struct {
+	path    string
+	current atomic.Pointer[Config]
+
+	mu       sync.Mutex
+	onReload []func(c *Config)
+}
+
+func NewReloader(path string) (*Reloader, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reloader{path: path}
+	r.current.Store(c)
+	return r, nil
+}
+
+func (r *Reloader) Current() *Config {
+	return r.current.Load()
+}
+
+// OnReload registers fn to run with each newly loaded config, e.g. to
+// apply timeouts with server.SetTimeouts. It also runs once right away.
+func (r *Reloader) OnReload(fn func(c *Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onReload = append(r.onReload, fn)
+	fn(r.Current())
+}
+
+// Reload reads the file again. On error the current config stays in place.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+	r.current.Store(c)
+	for _, fn := range r.onReload {
+		fn(c)
+	}
+	return nil
+}
+
+// ReloadOnSignal reloads whenever the process receives SIGHUP, until stop
+// is called.
+func (r *Reloader) ReloadOnSignal() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if err := r.Reload(); err != nil {
+					log.Printf("config: reload of %s failed, keeping current config: %v", r.path, err)
+				} else {
+					log.Printf("config: reloaded %s", r.path)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// GetCertificate picks a certificate from the current config by SNI, for
+// use as tls.Config.GetCertificate so new handshakes pick up reloaded certs.
+func (r *Reloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := r.Current().Certificates()
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	if len(certs) > 0 {
+		return &certs[0], nil
+	}
+	return nil, nil
+}
+
+// Static serves the current config's static directories, falling through
+// to next for paths outside all of them. The longest matching prefix wins.
+func (r *Reloader) Static(next server.Handler) server.Handler {
+	return r.prefixed(next, func(c *Config) map[string]server.Handler {
+		prefixes := map[string]server.Handler{}
+		for prefix, dir := range c.Static {
+			prefixes[prefix] = fileserver.Handler(fileserver.Config{
+				Root:   dir,
+				Prefix: strings.TrimSuffix(prefix, "/"),
+			})
+		}
+		return prefixes
+	})
+}
+
+// Upstreams proxies the current config's upstream prefixes with base, whose
+// Routes are ignored, falling through to next for paths outside all of them.
+// The longest matching prefix wins. A reload that changes the upstreams
+// starts their circuit breakers afresh.
+func (r *Reloader) Upstreams(base proxy.Config, next server.Handler) server.Handler {
+	return r.prefixed(next, func(c *Config) map[string]server.Handler {
+		prefixes := map[string]server.Handler{}
+		for prefix, upstreams := range c.Upstreams {
+			config := base
+			config.Routes = []proxy.Route{{Prefix: strings.TrimSuffix(prefix, "/"), Upstreams: upstreams}}
+			h, err := proxy.Handler(config)
+			if err != nil {
+				// Load checked the upstreams already.
+				log.Printf("config: upstreams %s: %v", prefix, err)
+				continue
+			}
+			prefixes[prefix] = h
+		}
+		return prefixes
+	})
+}
+
+// prefixed routes requests to the handlers build makes from the current
+// config, rebuilding them when the config is reloaded.
+func (r *Reloader) prefixed(next server.Handler, build func(c *Config) map[string]server.Handler) server.Handler {
+	type handlers struct {
+		config   *Config
+		prefixes map[string]server.Handler
+	}
+	cached := atomic.Pointer[handlers]{}
+
+	return func(w *response.Writer, req *request.Request) {
+		c := r.Current()
+		hs := cached.Load()
+		if hs == nil || hs.config != c {
+			hs = &handlers{config: c, prefixes: build(c)}
+			cached.Store(hs)
+		}
+
+		path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+		best := ""
+		for prefix := range hs.prefixes {
+			if underPrefix(path, prefix) && len(prefix) > len(best) {
+				best = prefix
+			}
+		}
+		if best == "" {
+			next(w, req)
+			return
+		}
+		hs.prefixes[best](w, req)
+	}
+}
+
+func underPrefix(path, prefix string) bool {
+	rest, ok := strings.CutPrefix(path, strings.TrimSuffix(prefix, "/"))
+	return ok && (rest == "" || rest[0] == '/')
+}
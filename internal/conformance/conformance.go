@@ -0,0 +1,83 @@
+package conformance
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+)
+
+type Result struct {
+	Case Case
+	// Status is the server's response status, or 0 when it closed the
+	// connection or timed out without answering.
+	Status int
+	Err    error
+	Pass   bool
+}
+
+// rejected reports whether status refuses a request as unparsable or
+// unsupported, as opposed to a handler's answer such as 404 or 405.
+func rejected(status int) bool {
+	switch status {
+	case 0, 400, 411, 413, 414, 431, 501, 505:
+		return true
+	}
+	return false
+}
+
+func (c Case) passes(status int) bool {
+	if c.Want == Accept {
+		return !rejected(status)
+	}
+	return rejected(status) && (c.Status == 0 || status == 0 || status == c.Status)
+}
+
+// Run sends each case over its own connection to addr, a host:port of any
+// HTTP/1.1 server, and checks the status line that comes back.
+func Run(addr string, cases []Case, timeout time.Duration) []Result {
+	results := []Result{}
+	for _, c := range cases {
+		status, err := send(addr, c.Raw, timeout)
+		results = append(results, Result{Case: c, Status: status, Err: err, Pass: c.passes(status)})
+	}
+	return results
+}
+
+func send(addr, raw string, timeout time.Duration) (int, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	// A server may answer and close before reading everything, so the
+	// write error is not the interesting one.
+	go conn.Write([]byte(raw))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	_, rest, _ := strings.Cut(line, " ")
+	code, _, _ := strings.Cut(rest, " ")
+	return strconv.Atoi(strings.TrimSpace(code))
+}
+
+// Parse runs each case through this module's request parser alone: a
+// parse error counts as a rejection.
+func Parse(cases []Case) []Result {
+	results := []Result{}
+	for _, c := range cases {
+		r, err := request.RequestFromReader(strings.NewReader(c.Raw))
+		pass := (err == nil) == (c.Want == Accept)
+		if err == nil && c.Body != "" && r.Body != c.Body {
+			pass = false
+		}
+		results = append(results, Result{Case: c, Err: err, Pass: pass})
+	}
+	return results
+}
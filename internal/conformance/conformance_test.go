@@ -0,0 +1,105 @@
+package conformance
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// Where this module still departs from the RFCs. Fixing one makes its test
+// fail until it is taken off the list.
+var knownParserFailures = map[string]string{
+	"HTTP/1.0 request": "only HTTP/1.1 is parsed",
+	"missing host":     "Host is not required",
+	"two host fields":  "repeated fields are always combined",
+}
+
+var knownServerFailures = map[string]string{
+	"unsupported major version": "answered as a malformed request line",
+}
+
+func checkResults(t *testing.T, results []Result, known ...map[string]string) {
+	require.Len(t, results, len(Corpus))
+	for _, r := range results {
+		expected := true
+		for _, k := range known {
+			if _, ok := k[r.Case.Name]; ok {
+				expected = false
+			}
+		}
+		assert.Equal(t, expected, r.Pass, "%s: status %d, err %v", r.Case.Name, r.Status, r.Err)
+	}
+}
+
+func TestParser(t *testing.T) {
+	// Test: The parser accepts and rejects the corpus as the RFCs require
+	checkResults(t, Parse(Corpus), knownParserFailures)
+}
+
+func TestServer(t *testing.T) {
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The server answers the corpus with the status codes the RFCs
+	// call for. Whatever the parser wrongly accepts is answered with 200.
+	results := Run(fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port), Corpus, 2*time.Second)
+	checkResults(t, results, knownServerFailures, withoutBodyChecks(knownParserFailures))
+}
+
+// withoutBodyChecks drops the parser failures only visible in the body,
+// which a status line cannot show.
+func withoutBodyChecks(known map[string]string) map[string]string {
+	filtered := map[string]string{}
+	for name, reason := range known {
+		for _, c := range Corpus {
+			if c.Name == name && !(c.Want == Accept && c.Body != "") {
+				filtered[name] = reason
+			}
+		}
+	}
+	return filtered
+}
+
+// Where this module and net/http part ways, whichever of the two is right.
+var knownDifferences = map[string]string{
+	"asterisk-form OPTIONS":                 "net/http answers OPTIONS * itself",
+	"HTTP/1.0 request":                      "only HTTP/1.1 is parsed",
+	"unsupported major version":             "answered as a malformed request line",
+	"request line too long":                 "net/http allows a longer request line",
+	"missing host":                          "Host is not required",
+	"two host fields":                       "repeated fields are always combined",
+	"obsolete line folding":                 "net/http unfolds the line",
+	"bare LF in value":                      "net/http takes a bare LF for the end of the line",
+	"header section too large":              "net/http allows a larger header section",
+	"content-length and transfer-encoding":  "net/http drops Content-Length",
+	"transfer coding not ending in chunked": "net/http answers 501",
+	"malformed chunk size":                  "net/http fails reading the body, after the handler ran",
+	"whitespace before chunk size":          "net/http fails reading the body, after the handler ran",
+	"chunk extensions with quoted value":    "net/http refuses whitespace before the extensions",
+	"malformed chunk extension":             "net/http ignores extension syntax",
+	"oversized chunk extension":             "net/http only bounds the whole line",
+}
+
+func TestDifferential(t *testing.T) {
+	diffs, err := Differential(Corpus, 2*time.Second)
+	require.NoError(t, err)
+	require.Len(t, diffs, len(Corpus))
+
+	// Test: This module and net/http make the same of every request, apart
+	// from the known differences
+	for _, d := range diffs {
+		_, known := knownDifferences[d.Case.Name]
+		assert.Equal(t, known, len(d.Differences) > 0, "%s: %v", d.Case.Name, d.Differences)
+	}
+}
@@ -0,0 +1,81 @@
+package conformance
+
+import "strings"
+
+type Expect int
+
+const (
+	// Accept means the request is valid and must be processed.
+	Accept Expect = iota
+	// Reject means a server must refuse the request, with a 4xx or 5xx
+	// status or by closing the connection.
+	Reject
+)
+
+func (e Expect) String() string {
+	if e == Accept {
+		return "accept"
+	}
+	return "reject"
+}
+
+type Case struct {
+	Name string
+	Raw  string
+	Want Expect
+	// Status, when set, is the exact status the server should answer with.
+	Status int
+	// Body, when set, is the body a parser should find, after removing any
+	// transfer coding.
+	Body string
+}
+
+// Corpus is what RFC 9110 and RFC 9112 require of a server, not what this
+// one does; see the tests for where the two differ.
+var Corpus = []Case{
+	// Valid requests.
+	{Name: "simple GET", Raw: "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", Want: Accept},
+	{Name: "POST with content-length", Raw: "POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello", Want: Accept, Body: "hello"},
+	{Name: "optional whitespace around value", Raw: "GET / HTTP/1.1\r\nHost:   localhost \t\r\n\r\n", Want: Accept},
+	{Name: "empty header value", Raw: "GET / HTTP/1.1\r\nHost: localhost\r\nX-Empty:\r\n\r\n", Want: Accept},
+	{Name: "repeated header field", Raw: "GET / HTTP/1.1\r\nHost: localhost\r\nAccept: text/html\r\nAccept: text/plain\r\n\r\n", Want: Accept},
+	{Name: "absolute-form target", Raw: "GET http://localhost/ HTTP/1.1\r\nHost: localhost\r\n\r\n", Want: Accept},
+	{Name: "asterisk-form OPTIONS", Raw: "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n", Want: Accept},
+	{Name: "extension method", Raw: "PROPFIND / HTTP/1.1\r\nHost: localhost\r\n\r\n", Want: Accept},
+	{Name: "unregistered method with body", Raw: "X-FROB~1 / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi", Want: Accept, Body: "hi"},
+	{Name: "HTTP/1.0 request", Raw: "GET / HTTP/1.0\r\n\r\n", Want: Accept},
+	{Name: "chunked body", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n", Want: Accept, Body: "hello"},
+	{Name: "chunked body with extension and trailer", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n0\r\nX-Sum: 1\r\n\r\n", Want: Accept, Body: "hello"},
+
+	// Malformed request lines.
+	{Name: "missing version", Raw: "GET /\r\nHost: localhost\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "lowercase version", Raw: "GET / http/1.1\r\nHost: localhost\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "invalid character in method", Raw: "G(ET / HTTP/1.1\r\nHost: localhost\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "double space in request line", Raw: "GET  / HTTP/1.1\r\nHost: localhost\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "unsupported major version", Raw: "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n", Want: Reject, Status: 505},
+	{Name: "request line too long", Raw: "GET /" + strings.Repeat("a", 16<<10) + " HTTP/1.1\r\nHost: localhost\r\n\r\n", Want: Reject, Status: 414},
+
+	// Malformed header sections.
+	{Name: "missing host", Raw: "GET / HTTP/1.1\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "two host fields", Raw: "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "space before colon", Raw: "GET / HTTP/1.1\r\nHost : localhost\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "obsolete line folding", Raw: "GET / HTTP/1.1\r\nHost: localhost\r\nX-Folded: a\r\n b\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "whitespace before first field", Raw: "GET / HTTP/1.1\r\n Host: localhost\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "invalid character in name", Raw: "GET / HTTP/1.1\r\nHost: localhost\r\nX@Y: a\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "NUL in value", Raw: "GET / HTTP/1.1\r\nHost: localhost\r\nX-Nul: a\x00b\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "bare LF in value", Raw: "GET / HTTP/1.1\r\nHost: localhost\r\nX-A: a\nInjected: yes\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "header section too large", Raw: "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 128<<10) + "\r\n\r\n", Want: Reject, Status: 431},
+
+	// Framing ambiguities that enable request smuggling.
+	{Name: "content-length and transfer-encoding", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "conflicting content-lengths", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!", Want: Reject, Status: 400},
+	{Name: "non-numeric content-length", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: abc\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "negative content-length", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: -1\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "transfer coding not ending in chunked", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked, gzip\r\n\r\n0\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "unknown transfer coding", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: foo, chunked\r\n\r\n0\r\n\r\n", Want: Reject, Status: 501},
+	{Name: "chunk extensions with quoted value", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5 ; a=\"x;y\" ; b\r\nhello\r\n0\r\n\r\n", Want: Accept, Body: "hello"},
+	{Name: "malformed chunk extension", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5;=x\r\nhello\r\n0\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "oversized chunk extension", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5;a=" + strings.Repeat("x", 300) + "\r\nhello\r\n0\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "whitespace before chunk size", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n 5\r\nhello\r\n0\r\n\r\n", Want: Reject, Status: 400},
+	{Name: "malformed chunk size", Raw: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n", Want: Reject, Status: 400},
+}
@@ -0,0 +1,162 @@
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// Parsed is what a server made of a request: the status it answered with
+// and, when it reached the handler, the request as the handler saw it.
+type Parsed struct {
+	Status  int               `json:"-"`
+	Err     string            `json:"-"`
+	Method  string            `json:"method"`
+	Target  string            `json:"target"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+type Diff struct {
+	Case        Case
+	Ours        Parsed
+	Theirs      Parsed
+	Differences []string
+}
+
+// Differential sends every case to this module's server and to net/http's,
+// both echoing what they parsed, and lists where the two disagree.
+func Differential(cases []Case, timeout time.Duration) ([]Diff, error) {
+	ours, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		p := Parsed{Method: req.RequestLine.Method, Target: req.RequestLine.RequestTarget, Headers: map[string]string{}, Body: req.Body}
+		req.Headers.ForEach(func(n, v string) {
+			p.Headers[n] = v
+		})
+		w.WriteJSON(response.StatusOK, p)
+	}, server.WithHost("127.0.0.1"))
+	if err != nil {
+		return nil, err
+	}
+	defer ours.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	theirs := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := Parsed{Method: r.Method, Target: r.RequestURI, Headers: map[string]string{}}
+		for name, values := range r.Header {
+			p.Headers[strings.ToLower(name)] = strings.Join(values, ",")
+		}
+		// net/http moves Host and the framing headers out of Header.
+		if r.Host != "" {
+			p.Headers["host"] = r.Host
+		}
+		if len(r.TransferEncoding) > 0 {
+			p.Headers["transfer-encoding"] = strings.Join(r.TransferEncoding, ",")
+		}
+		body, _ := io.ReadAll(r.Body)
+		p.Body = string(body)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	})}
+	go theirs.Serve(listener)
+	defer theirs.Close()
+
+	diffs := []Diff{}
+	for _, c := range cases {
+		d := Diff{
+			Case:   c,
+			Ours:   exchange(ours.Addr().String(), c.Raw, timeout),
+			Theirs: exchange(listener.Addr().String(), c.Raw, timeout),
+		}
+		d.Differences = compare(d.Ours, d.Theirs)
+		diffs = append(diffs, d)
+	}
+	return diffs, nil
+}
+
+func exchange(addr, raw string, timeout time.Duration) Parsed {
+	p := Parsed{}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		p.Err = err.Error()
+		return p
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	go conn.Write([]byte(raw))
+
+	res, err := response.ResponseFromReader(conn)
+	if err != nil {
+		p.Err = err.Error()
+		return p
+	}
+	p.Status = int(res.StatusLine.StatusCode)
+	if p.Status == 200 {
+		json.Unmarshal([]byte(res.Body), &p)
+	}
+	return p
+}
+
+func compare(a, b Parsed) []string {
+	differences := []string{}
+	if a.Status != b.Status {
+		differences = append(differences, fmt.Sprintf("status %d vs %d", a.Status, b.Status))
+	}
+	if a.Status != 200 || b.Status != 200 {
+		return differences
+	}
+	if a.Method != b.Method {
+		differences = append(differences, fmt.Sprintf("method %q vs %q", a.Method, b.Method))
+	}
+	if a.Target != b.Target {
+		differences = append(differences, fmt.Sprintf("target %q vs %q", a.Target, b.Target))
+	}
+	if a.Body != b.Body {
+		differences = append(differences, fmt.Sprintf("body %q vs %q", a.Body, b.Body))
+	}
+	names := map[string]bool{}
+	for n := range a.Headers {
+		names[n] = true
+	}
+	for n := range b.Headers {
+		names[n] = true
+	}
+	sorted := []string{}
+	for n := range names {
+		sorted = append(sorted, n)
+	}
+	sort.Strings(sorted)
+	for _, n := range sorted {
+		if a.Headers[n] != b.Headers[n] {
+			differences = append(differences, fmt.Sprintf("header %s %q vs %q", n, a.Headers[n], b.Headers[n]))
+		}
+	}
+	return differences
+}
+
+// Report writes the cases where the two disagree, ours first.
+func Report(w io.Writer, diffs []Diff) {
+	differing := 0
+	for _, d := range diffs {
+		if len(d.Differences) == 0 {
+			continue
+		}
+		differing++
+		fmt.Fprintf(w, "%s:\n", d.Case.Name)
+		for _, line := range d.Differences {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	fmt.Fprintf(w, "\n%d of %d cases agree with net/http\n", len(diffs)-differing, len(diffs))
+}
@@ -0,0 +1,129 @@
+package digest
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"tcp.to.http/internal/headers"
+)
+
+var ERROR_DIGEST_MISMATCH = fmt.Errorf("body does not match its digest")
+
+// Algorithms are the RFC 3230 digest algorithms understood, by the names
+// used in Digest headers.
+var Algorithms = map[string]func() hash.Hash{
+	"MD5":     md5.New,
+	"SHA":     sha1.New,
+	"SHA-256": sha256.New,
+	"SHA-512": sha512.New,
+}
+
+// Parse reads a Digest header into base64 values by upper-cased algorithm.
+func Parse(value string) map[string]string {
+	digests := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		alg, v, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok {
+			digests[strings.ToUpper(alg)] = v
+		}
+	}
+	return digests
+}
+
+// Hasher hashes what is written to it with several algorithms at once.
+type Hasher struct {
+	algs   []string
+	hashes []hash.Hash
+	w      io.Writer
+}
+
+// NewHasher hashes with algs, which must be keys of Algorithms.
+func NewHasher(algs ...string) *Hasher {
+	h := &Hasher{algs: algs}
+	writers := []io.Writer{}
+	for _, alg := range algs {
+		fn := Algorithms[strings.ToUpper(alg)]
+		if fn == nil {
+			panic("digest: unknown algorithm " + alg)
+		}
+		h.hashes = append(h.hashes, fn())
+		writers = append(writers, h.hashes[len(h.hashes)-1])
+	}
+	h.w = io.MultiWriter(writers...)
+	return h
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.w.Write(p)
+}
+
+// Value is the Digest header value for what was written so far.
+func (h *Hasher) Value() string {
+	values := make([]string, len(h.algs))
+	for i, alg := range h.algs {
+		values[i] = strings.ToUpper(alg) + "=" + base64.StdEncoding.EncodeToString(h.hashes[i].Sum(nil))
+	}
+	return strings.Join(values, ",")
+}
+
+// Header is the Digest header value for body.
+func Header(body []byte, algs ...string) string {
+	h := NewHasher(algs...)
+	h.Write(body)
+	return h.Value()
+}
+
+// Verifier checks a body, written to it as it streams, against the Digest
+// and Content-MD5 fields of h.
+type Verifier struct {
+	want   map[string]string
+	hasher *Hasher
+}
+
+// NewVerifier checks every digest in h with a known algorithm. It returns
+// nil when there are none, so there is nothing to hash.
+func NewVerifier(h *headers.Headers) *Verifier {
+	want := map[string]string{}
+	if value, ok := h.Get("digest"); ok {
+		for alg, v := range Parse(value) {
+			if Algorithms[alg] != nil {
+				want[alg] = v
+			}
+		}
+	}
+	if value, ok := h.Get("content-md5"); ok {
+		want["MD5"] = strings.TrimSpace(value)
+	}
+	if len(want) == 0 {
+		return nil
+	}
+	algs := []string{}
+	for alg := range want {
+		algs = append(algs, alg)
+	}
+	return &Verifier{want: want, hasher: NewHasher(algs...)}
+}
+
+func (v *Verifier) Write(p []byte) (int, error) {
+	return v.hasher.Write(p)
+}
+
+// Verify reports ERROR_DIGEST_MISMATCH if what was written does not match
+// every digest.
+func (v *Verifier) Verify() error {
+	for i, alg := range v.hasher.algs {
+		sum, err := base64.StdEncoding.DecodeString(v.want[alg])
+		if err != nil || subtle.ConstantTimeCompare(sum, v.hasher.hashes[i].Sum(nil)) != 1 {
+			return fmt.Errorf("%w: %s", ERROR_DIGEST_MISMATCH, alg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package digest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/headers"
+	"tcp.to.http/internal/response"
+)
+
+func TestDigest(t *testing.T) {
+	// Test: Header values carry each algorithm's base64 sum
+	assert.Equal(t, "SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=,MD5=XUFAKrxLKna5cZ2REBfFkg==",
+		Header([]byte("hello"), "SHA-256", "md5"))
+
+	// Test: A body verifies against every digest it came with
+	h := headers.NewHeaders()
+	h.Set("Digest", "sha-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=, unixsum=30")
+	h.Set("Content-MD5", "XUFAKrxLKna5cZ2REBfFkg==")
+	v := NewVerifier(h)
+	v.Write([]byte("hel"))
+	v.Write([]byte("lo"))
+	assert.NoError(t, v.Verify())
+
+	// Test: Any mismatch fails
+	v = NewVerifier(h)
+	v.Write([]byte("hello!"))
+	assert.ErrorIs(t, v.Verify(), ERROR_DIGEST_MISMATCH)
+	h.Replace("Content-MD5", "bm9wZQ==")
+	v = NewVerifier(h)
+	v.Write([]byte("hello"))
+	assert.ErrorIs(t, v.Verify(), ERROR_DIGEST_MISMATCH)
+
+	// Test: Nothing to verify without a known algorithm
+	h = headers.NewHeaders()
+	h.Set("Digest", "unixsum=30")
+	assert.Nil(t, NewVerifier(h))
+}
+
+func TestWriter(t *testing.T) {
+	out := bytes.Buffer{}
+	extra := headers.NewHeaders()
+	extra.Set("X-Upstream", "1")
+	w := NewWriter(response.NewWriter(&out), Trailers{Digest: []string{"SHA-256"}, SHA256: "X-Content-SHA256", Length: "X-Content-Length"})
+	require.NoError(t, w.WriteHead(response.StatusOK, response.GetDefaultHeaders(0)))
+	w.Write([]byte("hel"))
+	w.Write([]byte("lo"))
+	require.NoError(t, w.Close(extra))
+
+	// Test: The body streams chunked and its trailers are announced
+	res, err := response.ResponseFromReader(&out)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", res.Body)
+	announced, _ := res.Headers.Get("trailer")
+	assert.Equal(t, "Digest, X-Content-SHA256, X-Content-Length", announced)
+
+	// Test: The integrity trailers match the body, next to the extra ones
+	for name, want := range map[string]string{
+		"digest":           Header([]byte("hello"), "SHA-256"),
+		"x-content-sha256": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		"x-content-length": "5",
+		"x-upstream":       "1",
+	} {
+		got, _ := res.Trailers.Get(name)
+		assert.Equal(t, want, got, name)
+	}
+}
@@ -0,0 +1,93 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+
+	"tcp.to.http/internal/headers"
+	"tcp.to.http/internal/response"
+)
+
+// Trailers picks the integrity trailers a Writer ends the body with; empty
+// names and no algorithms leave a trailer out.
+type Trailers struct {
+	// Digest lists the algorithms of a Digest trailer, such as "SHA-256".
+	Digest []string
+	// SHA256 names a trailer with the hex SHA-256 of the body.
+	SHA256 string
+	// Length names a trailer with the length of the body in bytes.
+	Length string
+}
+
+func (t Trailers) names() []string {
+	names := []string{}
+	if len(t.Digest) > 0 {
+		names = append(names, "Digest")
+	}
+	for _, name := range []string{t.SHA256, t.Length} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Writer streams a chunked response body, hashing it on the way out, so
+// the integrity trailers are ready when it ends without the body ever being
+// held in memory.
+type Writer struct {
+	w        *response.Writer
+	trailers Trailers
+	digest   *Hasher
+	sha256   hash.Hash
+	n        int64
+}
+
+func NewWriter(w *response.Writer, trailers Trailers) *Writer {
+	return &Writer{w: w, trailers: trailers, digest: NewHasher(trailers.Digest...), sha256: sha256.New()}
+}
+
+// WriteHead sends the status line and h, made chunked and announcing the
+// trailers.
+func (iw *Writer) WriteHead(status response.StatusCode, h *headers.Headers) error {
+	h = h.Clone()
+	h.Delete("Content-Length")
+	h.Replace("Transfer-Encoding", "chunked")
+	if names := iw.trailers.names(); len(names) > 0 {
+		h.Set("Trailer", strings.Join(names, ", "))
+	}
+	if err := iw.w.WriteStatusLine(status); err != nil {
+		return err
+	}
+	return iw.w.WriteHeaders(*h)
+}
+
+// Write sends p as one chunk.
+func (iw *Writer) Write(p []byte) (int, error) {
+	iw.digest.Write(p)
+	iw.sha256.Write(p)
+	iw.n += int64(len(p))
+	return iw.w.WriteChunkedBody(p)
+}
+
+// Close ends the body with the integrity trailers, and extra ones when
+// extra is not nil.
+func (iw *Writer) Close(extra *headers.Headers) error {
+	trailers := headers.NewHeaders()
+	if extra != nil {
+		trailers = extra.Clone()
+	}
+	if len(iw.trailers.Digest) > 0 {
+		trailers.Replace("Digest", iw.digest.Value())
+	}
+	if iw.trailers.SHA256 != "" {
+		trailers.Replace(iw.trailers.SHA256, hex.EncodeToString(iw.sha256.Sum(nil)))
+	}
+	if iw.trailers.Length != "" {
+		trailers.Replace(iw.trailers.Length, strconv.FormatInt(iw.n, 10))
+	}
+	return iw.w.WriteTrailers(*trailers)
+}
@@ -0,0 +1,91 @@
+package dumputil
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// DumpRequest renders req in wire format. Headers are sorted by name so
+// dumps are stable enough to compare in tests.
+func DumpRequest(req *request.Request, body bool) []byte {
+	b := bytes.Buffer{}
+	fmt.Fprintf(&b, "%s %s HTTP/%s\r\n",
+		req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion)
+	writeHeaders(&b, req.Headers)
+	if body {
+		b.ReadFrom(req.BodyReader())
+	}
+	return b.Bytes()
+}
+
+func writeHeaders(b *bytes.Buffer, h *headers.Headers) {
+	lines := []string{}
+	h.ForEach(func(n, v string) {
+		lines = append(lines, n+": "+v+"\r\n")
+	})
+	sort.Strings(lines)
+	for _, line := range lines {
+		b.WriteString(line)
+	}
+	b.WriteString("\r\n")
+}
+
+// ResponseDump collects what a handler writes to the client.
+type ResponseDump struct {
+	body bool
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+	// done is set once the header block is complete and body is false.
+	done bool
+}
+
+// DumpResponseWriter copies everything written through w from now on into
+// the returned dump. Without body only the status line and headers are kept.
+func DumpResponseWriter(w *response.Writer, body bool) *ResponseDump {
+	d := &ResponseDump{body: body}
+	w.Tee(d)
+	return d
+}
+
+func (d *ResponseDump) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.done {
+		return len(p), nil
+	}
+	d.buf.Write(p)
+	if !d.body {
+		if i := bytes.Index(d.buf.Bytes(), []byte("\r\n\r\n")); i != -1 {
+			d.buf.Truncate(i + 4)
+			d.done = true
+		}
+	}
+	return len(p), nil
+}
+
+func (d *ResponseDump) Bytes() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return bytes.Clone(d.buf.Bytes())
+}
+
+// Middleware logs a dump of every request and its response.
+func Middleware(l *log.Logger, body bool) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			dump := DumpResponseWriter(w, body)
+			next(w, req)
+			l.Printf("request:\n%s\nresponse:\n%s", DumpRequest(req, body), dump.Bytes())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package dumputil
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+const raw = "POST /items HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nAccept: */*\r\n\r\nhello"
+
+func TestDumpRequest(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	// Test: Dumps are wire format with sorted headers
+	assert.Equal(t, "POST /items HTTP/1.1\r\naccept: */*\r\ncontent-length: 5\r\nhost: localhost\r\n\r\nhello",
+		string(DumpRequest(req, true)))
+
+	// Test: The body can be left out
+	assert.True(t, strings.HasSuffix(string(DumpRequest(req, false)), "host: localhost\r\n\r\n"))
+
+	// Test: A body spilled to disk is dumped too
+	req, err = request.RequestFromReaderWithOptions(strings.NewReader(raw), request.Options{SpillThreshold: 2, SpillDir: t.TempDir()})
+	require.NoError(t, err)
+	defer req.Close()
+	assert.True(t, strings.HasSuffix(string(DumpRequest(req, true)), "\r\n\r\nhello"))
+}
+
+func TestDumpResponseWriter(t *testing.T) {
+	out := bytes.Buffer{}
+	w := response.NewWriter(&out)
+	headersOnly := DumpResponseWriter(w, false)
+	full := DumpResponseWriter(w, true)
+
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*response.GetDefaultHeaders(2))
+	w.WriteBody([]byte("ok"))
+
+	// Test: The dump matches what reached the client
+	assert.Equal(t, out.String(), string(full.Bytes()))
+
+	// Test: Without body only the head is kept
+	assert.Equal(t, strings.TrimSuffix(out.String(), "ok"), string(headersOnly.Bytes()))
+}
+
+func TestMiddleware(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	logs := bytes.Buffer{}
+	handler := Middleware(log.New(&logs, "", 0), true)(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCreated)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	})
+	handler(response.NewWriter(&bytes.Buffer{}), req)
+
+	// Test: Both sides of the exchange are logged
+	assert.Contains(t, logs.String(), "request:\nPOST /items HTTP/1.1\r\n")
+	assert.Contains(t, logs.String(), "response:\nHTTP/1.1 201 Created\r\n")
+}
@@ -0,0 +1,196 @@
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"tcp.to.http/internal/cgi"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+const (
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+
+	roleResponder = 1
+	requestID     = 1
+	maxContent    = 65535
+)
+
+var ERROR_MALFORMED_RECORD = fmt.Errorf("malformed fastcgi record")
+
+type Config struct {
+	// Network is "tcp" or "unix".
+	Network string
+	Address string
+	// Root is the DOCUMENT_ROOT; SCRIPT_FILENAME is Root joined with the
+	// request path, or with Index when the path ends in a slash.
+	Root        string
+	Index       string
+	Params      map[string]string
+	DialTimeout time.Duration
+}
+
+func Handler(config Config) server.Handler {
+	if config.Network == "" {
+		config.Network = "tcp"
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		dialer := net.Dialer{Timeout: config.DialTimeout}
+		conn, err := dialer.DialContext(req.Context(), config.Network, config.Address)
+		if err != nil {
+			server.Error(w, req, response.StatusBadGateway, "")
+			return
+		}
+		defer conn.Close()
+		// A client that hangs up aborts the application's request too.
+		defer context.AfterFunc(req.Context(), func() { conn.Close() })()
+
+		if err := writeRequest(conn, config, req); err != nil {
+			server.Error(w, req, response.StatusBadGateway, "")
+			return
+		}
+		stdout, pw := io.Pipe()
+		defer stdout.Close()
+		go func() {
+			pw.CloseWithError(readStdout(bufio.NewReader(conn), pw))
+		}()
+		if err := cgi.Relay(w, req, stdout); err != nil {
+			log.Printf("fastcgi: %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		}
+	}
+}
+
+func writeRequest(conn io.Writer, config Config, req *request.Request) error {
+	begin := []byte{0, roleResponder, 0, 0, 0, 0, 0, 0}
+	if err := writeRecord(conn, typeBeginRequest, begin); err != nil {
+		return err
+	}
+
+	encoded := []byte{}
+	for name, value := range params(config, req) {
+		encoded = appendPair(encoded, name, value)
+	}
+	if err := writeStream(conn, typeParams, bytes.NewReader(encoded)); err != nil {
+		return err
+	}
+	// The body may have spilled to disk, so it's streamed rather than taken
+	// from req.Body.
+	return writeStream(conn, typeStdin, req.BodyReader())
+}
+
+func params(config Config, req *request.Request) map[string]string {
+	scriptName, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	if strings.HasSuffix(scriptName, "/") && config.Index != "" {
+		scriptName += config.Index
+	}
+
+	p := cgi.Env(req)
+	p["SCRIPT_NAME"] = scriptName
+	p["SCRIPT_FILENAME"] = path.Join(config.Root, scriptName)
+	p["DOCUMENT_ROOT"] = config.Root
+	for name, value := range config.Params {
+		p[name] = value
+	}
+	return p
+}
+
+func appendPair(b []byte, name, value string) []byte {
+	for _, l := range []int{len(name), len(value)} {
+		if l < 128 {
+			b = append(b, byte(l))
+		} else {
+			b = binary.BigEndian.AppendUint32(b, uint32(l)|1<<31)
+		}
+	}
+	b = append(b, name...)
+	return append(b, value...)
+}
+
+// writeStream sends r as a stream of records terminated by an empty one.
+func writeStream(w io.Writer, recordType byte, r io.Reader) error {
+	buf := make([]byte, maxContent)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := writeRecord(w, recordType, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return writeRecord(w, recordType, nil)
+}
+
+func writeRecord(w io.Writer, recordType byte, content []byte) error {
+	padding := -len(content) & 7
+	b := []byte{1, recordType, 0, requestID, 0, 0, byte(padding), 0}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(content)))
+	b = append(b, content...)
+	b = append(b, make([]byte, padding)...)
+	_, err := w.Write(b)
+	return err
+}
+
+func readRecord(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	if header[0] != 1 {
+		return 0, nil, ERROR_MALFORMED_RECORD
+	}
+
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	content := make([]byte, length+int(header[6]))
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return header[1], content[:length], nil
+}
+
+// readStdout copies the application's stdout records to w until the
+// request ends, logging anything sent on stderr.
+func readStdout(r *bufio.Reader, w io.Writer) error {
+	for {
+		recordType, content, err := readRecord(r)
+		if err != nil {
+			return err
+		}
+
+		switch recordType {
+		case typeStderr:
+			log.Printf("fastcgi stderr: %s", bytes.TrimSpace(content))
+		case typeStdout:
+			if _, err := w.Write(content); err != nil {
+				return err
+			}
+		case typeEndRequest:
+			return nil
+		}
+	}
+}
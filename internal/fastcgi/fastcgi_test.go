@@ -0,0 +1,80 @@
+package fastcgi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestHandler(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		env := fcgi.ProcessEnv(r)
+		w.Header().Set("X-Script", env["SCRIPT_FILENAME"])
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.URL.RawQuery, r.Header.Get("X-Coffee"), body)
+	}))
+
+	handler := Handler(Config{Address: listener.Addr().String(), Root: "/srv/www", Index: "index.php"})
+	req, err := request.RequestFromReader(strings.NewReader("POST /app/?cup=big HTTP/1.1\r\nHost: localhost\r\nX-Coffee: dark\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+
+	out := bytes.Buffer{}
+	handler(response.NewWriter(&out), req)
+
+	// Test: Status, headers and body are relayed
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 201 Created\r\n"), out.String())
+	assert.Contains(t, out.String(), "x-script: /srv/www/app/index.php\r\n")
+	assert.Contains(t, out.String(), "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "POST cup=big dark hello\r\n0\r\n\r\n"))
+}
+
+func TestHandlerSpilledBody(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d %d", r.ContentLength, len(body))
+	}))
+
+	// Test: A body spilled to disk is sent whole, across several records
+	body := strings.Repeat("x", 100_000)
+	raw := fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	req, err := request.RequestFromReaderWithOptions(strings.NewReader(raw), request.Options{SpillThreshold: 1024, SpillDir: t.TempDir()})
+	require.NoError(t, err)
+	defer req.Close()
+	require.Empty(t, req.Body)
+
+	out := bytes.Buffer{}
+	Handler(Config{Address: listener.Addr().String()})(response.NewWriter(&out), req)
+	assert.Contains(t, out.String(), "100000 100000\r\n0\r\n\r\n")
+}
+
+func TestHandlerBackendDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	Handler(Config{Address: addr})(response.NewWriter(&out), req)
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 502 Bad Gateway\r\n"))
+}
@@ -0,0 +1,203 @@
+package fileserver
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+type Config struct {
+	Root string
+	// Prefix is stripped from the request path before it is mapped onto
+	// Root, so the server can be mounted below "/".
+	Prefix string
+	// Index is served for directory requests; defaults to index.html.
+	Index string
+	// Listing renders an HTML listing for directories without an index.
+	Listing bool
+}
+
+// Handler serves files below config.Root. A ?download query on a file sends
+// it as an attachment instead of inline.
+func Handler(config Config) server.Handler {
+	if config.Index == "" {
+		config.Index = "index.html"
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		method := req.RequestLine.Method
+		if method != "GET" && method != "HEAD" {
+			w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+				h.Replace("Allow", "GET, HEAD")
+			})
+			server.Error(w, req, response.StatusMethodNotAllowed, "")
+			return
+		}
+
+		target, query, hasQuery := strings.Cut(req.RequestLine.RequestTarget, "?")
+		rel, ok := strings.CutPrefix(target, config.Prefix)
+		if !ok {
+			server.Error(w, req, response.StatusNotFound, "")
+			return
+		}
+		rel, err := url.PathUnescape(rel)
+		if err != nil {
+			server.Error(w, req, response.StatusBadRequest, "")
+			return
+		}
+		rel = path.Clean("/" + rel)
+		name := filepath.Join(config.Root, filepath.FromSlash(rel))
+
+		info, err := os.Stat(name)
+		if err != nil {
+			notFoundOrError(w, req, err)
+			return
+		}
+
+		if info.IsDir() {
+			if !strings.HasSuffix(target, "/") {
+				// Built from the cleaned path, not the target, which could
+				// make a protocol-relative Location such as //host/../.
+				location := strings.TrimSuffix(config.Prefix, "/") + (&url.URL{Path: rel}).EscapedPath()
+				if !strings.HasSuffix(location, "/") {
+					location += "/"
+				}
+				if hasQuery {
+					location += "?" + query
+				}
+				redirect(w, location)
+				return
+			}
+
+			index := filepath.Join(name, config.Index)
+			if indexInfo, err := os.Stat(index); err == nil && !indexInfo.IsDir() {
+				serveFile(w, req, index, indexInfo)
+				return
+			}
+			if !config.Listing {
+				server.Error(w, req, response.StatusNotFound, "")
+				return
+			}
+			serveListing(w, req, name, target, query)
+			return
+		}
+
+		if values, _ := url.ParseQuery(query); values.Has("download") && method == "GET" {
+			serveDownload(w, req, name, info)
+			return
+		}
+		serveFile(w, req, name, info)
+	}
+}
+
+func notFoundOrError(w *response.Writer, req *request.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		server.Error(w, req, response.StatusNotFound, "")
+	case errors.Is(err, fs.ErrPermission):
+		server.Error(w, req, response.StatusForbidden, "")
+	default:
+		server.Error(w, req, response.StatusInternalServeError, "")
+	}
+}
+
+func redirect(w *response.Writer, location string) {
+	h := response.GetDefaultHeaders(0)
+	h.Replace("Location", location)
+	w.WriteStatusLine(response.StatusMovedPermanently)
+	w.WriteHeaders(*h)
+}
+
+func ContentType(name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func serveFile(w *response.Writer, req *request.Request, name string, info fs.FileInfo) {
+	f, err := os.Open(name)
+	if err != nil {
+		notFoundOrError(w, req, err)
+		return
+	}
+	defer f.Close()
+
+	modified := info.ModTime().UTC().Truncate(time.Second)
+	if since, ok := req.Headers.Get("if-modified-since"); ok {
+		if t, err := time.Parse(response.TimeFormat, since); err == nil && !modified.After(t) {
+			h := response.GetDefaultHeaders(0)
+			h.Delete("Content-Length")
+			h.Delete("Content-Type")
+			h.Replace("Last-Modified", modified.Format(response.TimeFormat))
+			w.WriteStatusLine(response.StatusNotModified)
+			w.WriteHeaders(*h)
+			return
+		}
+	}
+
+	h := response.GetDefaultHeaders(int(info.Size()))
+	h.Replace("Content-Type", ContentType(name))
+	h.Replace("Last-Modified", modified.Format(response.TimeFormat))
+	h.Replace("Accept-Ranges", "bytes")
+
+	if value, ok := req.Headers.Get("range"); ok && req.RequestLine.Method == "GET" && rangeCurrent(req, modified) {
+		ranges, err := response.ParseRange(value, info.Size())
+		if err != nil {
+			w.WriteRangeNotSatisfiable(info.Size())
+			return
+		}
+		if ranges != nil {
+			if err := w.ServeRanges(h, f, info.Size(), ranges); err != nil {
+				log.Printf("fileserver: copying %s: %v", name, err)
+			}
+			return
+		}
+	}
+
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	if req.RequestLine.Method == "HEAD" {
+		return
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("fileserver: copying %s: %v", name, err)
+	}
+}
+
+// rangeCurrent reports whether a Range may be honored: with If-Range, only
+// while the file is exactly as old as the date the client has parts of.
+func rangeCurrent(req *request.Request, modified time.Time) bool {
+	value, ok := req.Headers.Get("if-range")
+	if !ok {
+		return true
+	}
+	t, err := time.Parse(response.TimeFormat, value)
+	return err == nil && t.Equal(modified)
+}
+
+func serveDownload(w *response.Writer, req *request.Request, name string, info fs.FileInfo) {
+	f, err := os.Open(name)
+	if err != nil {
+		notFoundOrError(w, req, err)
+		return
+	}
+	defer f.Close()
+
+	if err := w.ServeDownload(info.Name(), f, info.Size()); err != nil {
+		log.Printf("fileserver: copying %s: %v", name, err)
+	}
+}
@@ -0,0 +1,180 @@
+package fileserver
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+func run(t *testing.T, handler server.Handler, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	out := bytes.Buffer{}
+	handler(response.NewWriter(&out), req)
+	return out.String()
+}
+
+func get(target string) string {
+	return "GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"
+}
+
+func TestFileServer(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.txt"), []byte("hello"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "site"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<p>home</p>"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "files"), 0o755))
+
+	handler := Handler(Config{Root: root})
+
+	// Test: Files are served with a type and length
+	out := run(t, handler, get("/hello.txt"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+
+	// Test: Traversal cannot escape the root
+	out = run(t, handler, get("/../../etc/passwd"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Directories without a slash are redirected
+	out = run(t, handler, get("/site"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, out, "location: /site/\r\n")
+	out = run(t, handler, get("/site?page=2"))
+	assert.Contains(t, out, "location: /site/?page=2\r\n")
+
+	// Test: The redirect goes to the cleaned path, never to another host
+	out = run(t, handler, get("//evil.com/.."))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, out, "location: /\r\n")
+
+	// Test: The index file is served for directories
+	out = run(t, handler, get("/site/"))
+	assert.True(t, strings.HasSuffix(out, "<p>home</p>"))
+
+	// Test: Listings are off by default
+	out = run(t, handler, get("/files/"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Only GET and HEAD are allowed
+	out = run(t, handler, "DELETE /hello.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD\r\n")
+}
+
+func TestDownload(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "report.pdf"), []byte("%PDF"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "résumé \"final\".txt"), []byte("cv"), 0o644))
+
+	handler := Handler(Config{Root: root})
+
+	// Test: ?download sends the file as an attachment
+	out := run(t, handler, get("/report.pdf?download"))
+	assert.Contains(t, out, "content-disposition: attachment; filename=\"report.pdf\"\r\n")
+	assert.Contains(t, out, "content-type: application/pdf\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n%PDF"))
+
+	// Test: Non-ASCII names get an RFC 5987 filename* and an ASCII fallback
+	out = run(t, handler, get("/r%C3%A9sum%C3%A9%20%22final%22.txt?download"))
+	assert.Contains(t, out, `content-disposition: attachment; filename="r_sum_ \"final\".txt"; filename*=UTF-8''r%C3%A9sum%C3%A9%20%22final%22.txt`)
+}
+
+func TestDownloadChunked(t *testing.T) {
+	out := bytes.Buffer{}
+	err := response.NewWriter(&out).ServeDownload("data.bin", strings.NewReader("abc"), -1)
+	require.NoError(t, err)
+
+	// Test: Unknown sizes are streamed chunked
+	assert.Contains(t, out.String(), "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, out.String(), "content-length")
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n3\r\nabc\r\n0\r\n\r\n"))
+}
+
+func TestDirectoryListing(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "b.txt"), []byte("b"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("aaaa"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "<script>.txt"), []byte("xx"), 0o644))
+
+	handler := Handler(Config{Root: root, Listing: true})
+
+	// Test: Entries are listed by name and escaped
+	out := run(t, handler, get("/"))
+	assert.Contains(t, out, "content-type: text/html; charset=utf-8\r\n")
+	assert.NotContains(t, out, "<script>")
+	assert.Contains(t, out, `<a href="%3Cscript%3E.txt">&lt;script&gt;.txt</a>`)
+	assert.Less(t, strings.Index(out, ">a.txt<"), strings.Index(out, ">b.txt<"))
+
+	// Test: Listings can be sorted by size, descending
+	out = run(t, handler, get("/?sort=size&order=desc"))
+	assert.Less(t, strings.Index(out, ">a.txt<"), strings.Index(out, "&lt;script&gt;.txt<"))
+	assert.Less(t, strings.Index(out, "&lt;script&gt;.txt<"), strings.Index(out, ">b.txt<"))
+}
+
+func TestRanges(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "digits.txt"), []byte("0123456789"), 0o644))
+	handler := Handler(Config{Root: root})
+	ranged := func(header string) *response.Response {
+		out := run(t, handler, "GET /digits.txt HTTP/1.1\r\nHost: localhost\r\n"+header+"\r\n\r\n")
+		res, err := response.ResponseFromReader(strings.NewReader(out))
+		require.NoError(t, err)
+		return res
+	}
+
+	// Test: A single range is sent as is with its Content-Range
+	res := ranged("Range: bytes=2-4")
+	assert.Equal(t, response.StatusPartialContent, res.StatusLine.StatusCode)
+	contentRange, _ := res.Headers.Get("content-range")
+	assert.Equal(t, "bytes 2-4/10", contentRange)
+	assert.Equal(t, "234", res.Body)
+
+	// Test: Several ranges become multipart/byteranges with a part each
+	res = ranged("Range: bytes=0-1, -3")
+	assert.Equal(t, response.StatusPartialContent, res.StatusLine.StatusCode)
+	contentType, _ := res.Headers.Get("content-type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	parts := multipart.NewReader(strings.NewReader(res.Body), params["boundary"])
+	for _, want := range []struct{ contentRange, body string }{{"bytes 0-1/10", "01"}, {"bytes 7-9/10", "789"}} {
+		part, err := parts.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, want.contentRange, part.Header.Get("Content-Range"))
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(part)
+		assert.Equal(t, want.body, string(body))
+	}
+	_, err = parts.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Ranges past the end are a 416
+	res = ranged("Range: bytes=10-")
+	assert.Equal(t, response.StatusRangeNotSatisfied, res.StatusLine.StatusCode)
+	contentRange, _ = res.Headers.Get("content-range")
+	assert.Equal(t, "bytes */10", contentRange)
+
+	// Test: Malformed ranges and stale If-Range get the whole file
+	res = ranged("Range: bytes=4-2")
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	res = ranged("Range: bytes=0-1\r\nIf-Range: Mon, 02 Jan 2006 15:04:05 GMT")
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	assert.Equal(t, "0123456789", res.Body)
+	acceptRanges, _ := res.Headers.Get("accept-ranges")
+	assert.Equal(t, "bytes", acceptRanges)
+}
@@ -0,0 +1,105 @@
+package fileserver
+
+import (
+	"cmp"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+type listingEntry struct {
+	name  string
+	dir   bool
+	size  int64
+	mtime int64
+}
+
+// serveListing renders dir as an HTML table. The query selects the order:
+// sort=name|size|mtime and order=asc|desc, defaulting to name ascending.
+func serveListing(w *response.Writer, req *request.Request, dir, target, query string) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		notFoundOrError(w, req, err)
+		return
+	}
+
+	entries := make([]listingEntry, 0, len(dirEntries))
+	for _, d := range dirEntries {
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, listingEntry{
+			name:  d.Name(),
+			dir:   d.IsDir(),
+			size:  info.Size(),
+			mtime: info.ModTime().Unix(),
+		})
+	}
+
+	values, _ := url.ParseQuery(query)
+	by, order := values.Get("sort"), values.Get("order")
+	slices.SortStableFunc(entries, func(a, b listingEntry) int {
+		c := 0
+		switch by {
+		case "size":
+			c = cmp.Compare(a.size, b.size)
+		case "mtime":
+			c = cmp.Compare(a.mtime, b.mtime)
+		}
+		if c == 0 {
+			c = strings.Compare(a.name, b.name)
+		}
+		if order == "desc" {
+			return -c
+		}
+		return c
+	})
+
+	body := renderListing(html.EscapeString(target), by, order, entries)
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", "text/html; charset=utf-8")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody([]byte(body))
+	}
+}
+
+func renderListing(title, by, order string, entries []listingEntry) string {
+	column := func(name, label string) string {
+		next := "asc"
+		if (by == name || (by == "" && name == "name")) && order != "desc" {
+			next = "desc"
+		}
+		return fmt.Sprintf(`<th><a href="?sort=%s&amp;order=%s">%s</a></th>`, name, next, label)
+	}
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>Index of %s</title></head>\n<body>\n", title)
+	fmt.Fprintf(&b, "<h1>Index of %s</h1>\n<table>\n<tr>%s%s%s</tr>\n", title,
+		column("name", "Name"), column("size", "Size"), column("mtime", "Modified"))
+	for _, e := range entries {
+		name, size := e.name, fmt.Sprintf("%d", e.size)
+		if e.dir {
+			name, size = name+"/", "-"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		if strings.Contains(strings.SplitN(name, "/", 2)[0], ":") {
+			// A colon in the first segment would otherwise read as a scheme.
+			href = "./" + href
+		}
+		fmt.Fprintf(&b, "<tr><td><a href=\"%s\">%s</a></td><td>%s</td><td>%s</td></tr>\n",
+			html.EscapeString(href), html.EscapeString(name), size,
+			time.Unix(e.mtime, 0).UTC().Format(response.TimeFormat))
+	}
+	b.WriteString("</table>\n</body>\n</html>\n")
+	return b.String()
+}
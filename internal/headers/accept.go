@@ -0,0 +1,75 @@
+package headers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MediaRange is one entry of an Accept header, such as "text/*;q=0.5".
+type MediaRange struct {
+	Type    string
+	Subtype string
+	Q       float64
+}
+
+// ParseAccept reads the media ranges of an Accept header in the order they
+// were sent. Malformed entries are skipped; a missing q means 1.
+func ParseAccept(value string) []MediaRange {
+	ranges := []MediaRange{}
+	for _, entry := range strings.Split(value, ",") {
+		params := strings.Split(entry, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !ok || !IsToken(typ) || !IsToken(subtype) || typ == "*" && subtype != "*" {
+			continue
+		}
+		r := MediaRange{Type: typ, Subtype: subtype, Q: 1}
+		for _, p := range params[1:] {
+			name, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(name, "q") {
+				if q, err := strconv.ParseFloat(v, 64); err == nil && q >= 0 && q <= 1 {
+					r.Q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// Negotiate picks the offered media type the Accept header value prefers.
+// Each offer takes the q of the most specific range matching it; ties go to
+// the earlier offer. An empty value accepts anything, so the first offer
+// wins, and false means nothing offered is acceptable.
+func Negotiate(accept string, offers []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		if len(offers) == 0 {
+			return "", false
+		}
+		return offers[0], true
+	}
+
+	ranges := ParseAccept(accept)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		typ, subtype, _ := strings.Cut(strings.ToLower(offer), "/")
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			s := -1
+			switch {
+			case r.Type == typ && r.Subtype == subtype:
+				s = 2
+			case r.Type == typ && r.Subtype == "*":
+				s = 1
+			case r.Type == "*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = r.Q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
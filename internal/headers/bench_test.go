@@ -0,0 +1,44 @@
+package headers
+
+import (
+	"testing"
+)
+
+var benchHeaders = []byte("Host: localhost:42069\r\n" +
+	"User-Agent: bench/1.0\r\n" +
+	"Accept: application/json\r\n" +
+	"Accept-Encoding: gzip, deflate\r\n" +
+	"Cookie: a=1\r\n" +
+	"Cookie: b=2\r\n" +
+	"Connection: keep-alive\r\n\r\n")
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchHeaders)))
+	for b.Loop() {
+		if _, _, err := NewHeaders().Parse(benchHeaders); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	h := NewHeaders()
+	h.Parse(benchHeaders)
+	b.ReportAllocs()
+	for b.Loop() {
+		h.Get("Accept-Encoding")
+	}
+}
+
+func BenchmarkParseLazy(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchHeaders)))
+	for b.Loop() {
+		h := NewLazyHeaders()
+		if _, _, err := h.Parse(benchHeaders); err != nil {
+			b.Fatal(err)
+		}
+		h.Get("Accept-Encoding")
+	}
+}
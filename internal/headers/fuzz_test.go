@@ -0,0 +1,34 @@
+package headers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func FuzzHeadersParse(f *testing.F) {
+	f.Add([]byte("Host: localhost:42069\r\n\r\n"))
+	f.Add([]byte("A: 1\r\nA: 2\r\nB:\r\n\r\n"))
+	f.Add([]byte("       Host : localhost:42069       \r\n\r\n"))
+	f.Add([]byte("H©st: localhost\r\n\r\n"))
+	f.Add([]byte("X: a\r\n b\r\n\r\n"))
+	f.Add([]byte("partial: line"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h := NewHeaders()
+		n, done, err := h.Parse(data)
+		if err != nil {
+			return
+		}
+		assert.LessOrEqual(t, n, len(data))
+		if done {
+			assert.Equal(t, "\r\n", string(data[n-2:n]))
+		}
+		h.ForEach(func(name, value string) {
+			assert.Equal(t, strings.ToLower(name), name)
+			assert.True(t, isToken([]byte(name)), name)
+			assert.Equal(t, strings.TrimSpace(value), value)
+		})
+	})
+}
@@ -6,6 +6,12 @@ import (
 	"strings"
 )
 
+// IsToken reports whether s is a token as RFC 9110 defines it, the syntax of
+// field names and methods.
+func IsToken(s string) bool {
+	return s != "" && isToken([]byte(s))
+}
+
 func isToken(str []byte) bool {
 	for _, char := range str {
 		switch {
@@ -36,25 +42,48 @@ func isToken(str []byte) bool {
 
 var rn = []byte("\r\n")
 
-func parseHeader(fieldLine []byte) (string, string, error) {
-	parts := bytes.SplitN(fieldLine, []byte(":"), 2)
-
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("malformed header line!🤨")
+func parseHeader(fieldLine []byte) ([]byte, []byte, error) {
+	colon := bytes.IndexByte(fieldLine, ':')
+	if colon == -1 {
+		return nil, nil, fmt.Errorf("malformed header line!🤨")
 	}
 
-	fieldName := parts[0]
-	fieldValue := bytes.TrimSpace(parts[1])
+	fieldName := fieldLine[:colon]
+	fieldValue := bytes.TrimSpace(fieldLine[colon+1:])
 
 	if bytes.HasSuffix(fieldName, []byte(" ")) {
-		return "", "", fmt.Errorf("malformed header field name!🤨")
+		return nil, nil, fmt.Errorf("malformed header field name!🤨")
+	}
+	// A bare CR or LF would split the value into lines of its own wherever
+	// it is written out again, such as by a proxy.
+	if bytes.ContainsAny(fieldValue, "\r\n\x00") {
+		return nil, nil, fmt.Errorf("malformed header field value!🤨")
 	}
 
-	return string(fieldName), string(fieldValue), nil
+	return fieldName, fieldValue, nil
 }
 
 type Headers struct {
 	headers map[string]string
+	// lines keeps each value of a field that can't be joined into one
+	// line; see separate.
+	lines map[string][]string
+
+	// In lazy mode parsed fields are kept as offsets into arena, with the
+	// names lowercased in place, until something needs the map.
+	lazy   bool
+	arena  []byte
+	fields []field
+}
+
+type field struct {
+	name, value [2]int
+}
+
+// separate lists the fields whose values can't be joined with commas
+// (RFC 9110 section 5.3), so each value set goes out on a line of its own.
+var separate = map[string]bool{
+	"set-cookie": true,
 }
 
 func NewHeaders() *Headers {
@@ -63,37 +92,160 @@ func NewHeaders() *Headers {
 	}
 }
 
+// NewLazyHeaders returns headers whose Parse copies each field into one
+// buffer instead of allocating strings for it. Get only allocates for the
+// value it returns; anything else turns the fields into the map first.
+func NewLazyHeaders() *Headers {
+	return &Headers{lazy: true, arena: make([]byte, 0, 512), fields: make([]field, 0, 16)}
+}
+
+func (h *Headers) materialize() {
+	// Nothing is written when there is nothing to do, so headers shared
+	// between goroutines, such as a cached response's, can be read at once.
+	if h.headers != nil && len(h.fields) == 0 {
+		return
+	}
+	if h.headers == nil {
+		h.headers = map[string]string{}
+	}
+	fields := h.fields
+	h.fields = nil
+	for _, f := range fields {
+		h.Set(string(h.arena[f.name[0]:f.name[1]]), string(h.arena[f.value[0]:f.value[1]]))
+	}
+	h.arena = h.arena[:0]
+}
+
 func (h *Headers) Get(name string) (string, bool) {
-	str, ok := h.headers[strings.ToLower(name)]
+	name = strings.ToLower(name)
+	str, ok := h.headers[name]
+	for _, f := range h.fields {
+		if string(h.arena[f.name[0]:f.name[1]]) != name {
+			continue
+		}
+		value := string(h.arena[f.value[0]:f.value[1]])
+		if ok {
+			value = str + "," + value
+		}
+		str, ok = value, true
+	}
 	return str, ok
 }
 
+// Values returns every value of name, one per field line for fields such
+// as Set-Cookie that can't be joined.
+func (h *Headers) Values(name string) []string {
+	h.materialize()
+	name = strings.ToLower(name)
+	if lines, ok := h.lines[name]; ok {
+		return append([]string{}, lines...)
+	}
+	if v, ok := h.headers[name]; ok {
+		return []string{v}
+	}
+	return nil
+}
+
 func (h *Headers) Replace(name, value string) {
+	h.materialize()
 	name = strings.ToLower(name)
 	h.headers[name] = value
+	delete(h.lines, name)
 }
 
 func (h *Headers) Delete(name string) {
+	h.materialize()
 	name = strings.ToLower(name)
 	delete(h.headers, name)
+	delete(h.lines, name)
 }
 
 func (h *Headers) Set(name, value string) {
+	if len(h.fields) > 0 || h.headers == nil {
+		h.materialize()
+	}
 	name = strings.ToLower(name)
-	if v, ok := h.headers[name]; ok {
+	v, ok := h.headers[name]
+	if ok {
 		h.headers[name] = fmt.Sprintf("%s,%s", v, value)
 	} else {
 		h.headers[name] = value
 	}
+	if separate[name] {
+		if h.lines == nil {
+			h.lines = map[string][]string{}
+		}
+		if _, split := h.lines[name]; ok && !split {
+			h.lines[name] = []string{v}
+		}
+		h.lines[name] = append(h.lines[name], value)
+	}
+}
+
+// AddVary adds names to the Vary header, skipping those already listed. A
+// Vary of "*" already covers every name.
+func (h *Headers) AddVary(names ...string) {
+	vary, _ := h.Get("vary")
+	listed := map[string]bool{}
+	for _, n := range strings.Split(vary, ",") {
+		if n = strings.ToLower(strings.TrimSpace(n)); n != "" {
+			listed[n] = true
+		}
+	}
+	if listed["*"] {
+		return
+	}
+	for _, n := range names {
+		if !listed[strings.ToLower(n)] {
+			listed[strings.ToLower(n)] = true
+			if vary != "" {
+				vary += ", "
+			}
+			vary += n
+		}
+	}
+	if vary != "" {
+		h.Replace("Vary", vary)
+	}
 }
 
+func (h *Headers) Clone() *Headers {
+	h.materialize()
+	clone := NewHeaders()
+	for n, v := range h.headers {
+		clone.headers[n] = v
+	}
+	for n, lines := range h.lines {
+		if clone.lines == nil {
+			clone.lines = map[string][]string{}
+		}
+		clone.lines[n] = append([]string{}, lines...)
+	}
+	return clone
+}
+
+// ForEach calls cb for every field line: once per field, or once per value
+// of a field such as Set-Cookie that can't be joined.
 func (h *Headers) ForEach(cb func(n, v string)) {
+	h.materialize()
 	for n, v := range h.headers {
+		if lines, ok := h.lines[n]; ok {
+			for _, line := range lines {
+				cb(n, line)
+			}
+			continue
+		}
 		cb(n, v)
 	}
 }
 
 func (h *Headers) Parse(data []byte) (int, bool, error) {
+	return h.ParseEach(data, nil)
+}
+
+// ParseEach is Parse, calling each (when non-nil) for every field line as it
+// is added.
+func (h *Headers) ParseEach(data []byte, each func(name, value string)) (int, bool, error) {
 	read := 0
 	done := false
 	for {
@@ -114,12 +266,35 @@ func (h *Headers) Parse(data []byte) (int, bool, error) {
 			return 0, false, err
 		}
 
-		if !isToken([]byte(fieldName)) {
+		if !isToken(fieldName) {
 			return 0, false, fmt.Errorf("malformed header name")
 		}
 		read += (idx + len(rn))
-		h.Set(fieldName, fieldValue)
+		if h.lazy {
+			h.add(fieldName, fieldValue)
+		} else {
+			h.Set(string(fieldName), string(fieldValue))
+		}
+		if each != nil {
+			each(string(fieldName), string(fieldValue))
+		}
 	}
 
 	return read, done, nil
 }
+
+func (h *Headers) add(name, value []byte) {
+	f := field{}
+	f.name[0] = len(h.arena)
+	for _, c := range name {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		h.arena = append(h.arena, c)
+	}
+	f.name[1] = len(h.arena)
+	f.value[0] = len(h.arena)
+	h.arena = append(h.arena, value...)
+	f.value[1] = len(h.arena)
+	h.fields = append(h.fields, f)
+}
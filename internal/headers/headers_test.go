@@ -42,4 +42,125 @@ func TestHeaderParse(t *testing.T) {
 	require.NotNil(t, headers)
 	// assert.Equal(t, "localhost:42069,localhost:42069", headers.Get("Host"))
 	assert.False(t, done)
+	// Test: CR, LF and NUL are refused in values, so none can start a
+	// field of its own when the value is written out again
+	for _, value := range []string{"a\nInjected: yes", "a\rb", "a\x00b"} {
+		headers = NewHeaders()
+		_, _, err = headers.Parse([]byte("X-A: " + value + "\r\n\r\n"))
+		assert.Error(t, err, value)
+	}
+}
+
+func TestLazyHeaders(t *testing.T) {
+	h := NewLazyHeaders()
+	n, done, err := h.Parse([]byte("Host: localhost\r\nX-Tag: a\r\nx-tag: b\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 39, n)
+	assert.True(t, done)
+
+	// Test: Fields are looked up case-insensitively, repeats combined
+	v, ok := h.Get("X-TAG")
+	assert.True(t, ok)
+	assert.Equal(t, "a,b", v)
+	_, ok = h.Get("missing")
+	assert.False(t, ok)
+
+	// Test: Changing a field keeps the ones parsed earlier
+	h.Set("X-Tag", "c")
+	h.Replace("Host", "example.com")
+	v, _ = h.Get("x-tag")
+	assert.Equal(t, "a,b,c", v)
+	v, _ = h.Get("host")
+	assert.Equal(t, "example.com", v)
+
+	// Test: Fields parsed after a change still combine with it
+	_, _, err = h.Parse([]byte("X-Tag: d\r\n"))
+	require.NoError(t, err)
+	v, _ = h.Get("x-tag")
+	assert.Equal(t, "a,b,c,d", v)
+	count := 0
+	h.Clone().ForEach(func(n, v string) { count++ })
+	assert.Equal(t, 2, count)
+
+	// Test: Looking up a field allocates only its value
+	h = NewLazyHeaders()
+	h.Parse([]byte("Host: localhost\r\nAccept: */*\r\n\r\n"))
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() { h.Get("missing") }))
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"application/json", "application/xml", "text/html"}
+
+	// Test: Ranges keep their order and q values; malformed ones are dropped
+	ranges := ParseAccept("text/html, application/*;q=0.5, */xml, bogus, */*;q=0.1")
+	assert.Equal(t, []MediaRange{
+		{Type: "text", Subtype: "html", Q: 1},
+		{Type: "application", Subtype: "*", Q: 0.5},
+		{Type: "*", Subtype: "*", Q: 0.1},
+	}, ranges)
+
+	// Test: Highest q wins, and the most specific range sets an offer's q
+	best, ok := Negotiate("application/*;q=0.5, application/xml;q=0.9, text/html;q=0.2", offers)
+	assert.True(t, ok)
+	assert.Equal(t, "application/xml", best)
+	best, _ = Negotiate("*/*;q=0.8, application/json;q=0", offers)
+	assert.Equal(t, "application/xml", best)
+
+	// Test: Ties and an empty header go to the first offer
+	best, _ = Negotiate("*/*", offers)
+	assert.Equal(t, "application/json", best)
+	best, ok = Negotiate("", offers)
+	assert.True(t, ok)
+	assert.Equal(t, "application/json", best)
+
+	// Test: Nothing acceptable
+	_, ok = Negotiate("image/png, text/*;q=0", offers)
+	assert.False(t, ok)
+}
+
+func TestAddVary(t *testing.T) {
+	h := NewHeaders()
+
+	// Test: Names are appended once, whatever their case
+	h.AddVary("Accept")
+	h.AddVary("accept-encoding", "ACCEPT")
+	vary, _ := h.Get("vary")
+	assert.Equal(t, "Accept, accept-encoding", vary)
+
+	// Test: Nothing is added to a Vary of *
+	h.Replace("Vary", "*")
+	h.AddVary("Origin")
+	vary, _ = h.Get("vary")
+	assert.Equal(t, "*", vary)
+}
+
+func TestSeparateLines(t *testing.T) {
+	h := NewHeaders()
+
+	// Test: Set-Cookie values stay on lines of their own
+	h.Set("Set-Cookie", "a=1; Path=/")
+	h.Set("set-cookie", "b=2")
+	h.Set("Accept", "text/html")
+	h.Set("Accept", "text/plain")
+	assert.Equal(t, []string{"a=1; Path=/", "b=2"}, h.Values("Set-Cookie"))
+	assert.Equal(t, []string{"text/html,text/plain"}, h.Values("accept"))
+	lines := []string{}
+	h.Clone().ForEach(func(n, v string) {
+		lines = append(lines, n+": "+v)
+	})
+	assert.ElementsMatch(t, []string{"set-cookie: a=1; Path=/", "set-cookie: b=2", "accept: text/html,text/plain"}, lines)
+
+	// Test: Replace leaves a single value, which Set adds to
+	h.Replace("Set-Cookie", "c=3")
+	h.Set("Set-Cookie", "d=4")
+	assert.Equal(t, []string{"c=3", "d=4"}, h.Values("set-cookie"))
+	h.Delete("Set-Cookie")
+	assert.Nil(t, h.Values("set-cookie"))
+
+	// Test: Parsed Set-Cookie lines stay apart, lazily parsed or not
+	for _, h := range []*Headers{NewHeaders(), NewLazyHeaders()} {
+		_, _, err := h.Parse([]byte("Set-Cookie: a=1\r\nSet-Cookie: b=2\r\n\r\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a=1", "b=2"}, h.Values("set-cookie"))
+	}
 }
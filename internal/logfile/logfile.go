@@ -0,0 +1,100 @@
+package logfile
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Writer passes writes on to an io.Writer that can be swapped while it is in
+// use, so loggers handed a Writer never need to be rebuilt.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// Swap sends later writes to next and returns the writer it replaces. A
+// write in progress finishes on the old one first.
+func (w *Writer) Swap(next io.Writer) io.Writer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prev := w.w
+	w.w = next
+	return prev
+}
+
+// File is a log file that is appended to and can be reopened by path, so
+// logrotate can move it aside and have a fresh one take its place.
+type File struct {
+	Writer
+	path string
+}
+
+// Open appends to the file at path, creating it if need be.
+func Open(path string) (*File, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Writer: Writer{w: f}, path: path}, nil
+}
+
+func openFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// Reopen opens path again and closes the file written to until now. If path
+// cannot be opened the old file is kept.
+func (f *File) Reopen() error {
+	next, err := openFile(f.path)
+	if err != nil {
+		return err
+	}
+	return f.Swap(next).(*os.File).Close()
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.w.(*os.File).Close()
+}
+
+// ReopenOnSignal reopens every file on SIGUSR1, the signal logrotate's
+// postrotate scripts conventionally send. The returned func stops it.
+func ReopenOnSignal(files ...*File) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				for _, f := range files {
+					if err := f.Reopen(); err != nil {
+						log.Printf("logfile: reopen %s: %v", f.path, err)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,76 @@
+package logfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterSwap(t *testing.T) {
+	// Test: Writes go to whichever writer was swapped in last
+	first, second := bytes.Buffer{}, bytes.Buffer{}
+	w := NewWriter(&first)
+	fmt.Fprintln(w, "one")
+	assert.Equal(t, &first, w.Swap(&second))
+	fmt.Fprintln(w, "two")
+	assert.Equal(t, "one\n", first.String())
+	assert.Equal(t, "two\n", second.String())
+}
+
+func TestFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	fmt.Fprintln(f, "before")
+
+	// Test: After the file is moved aside, Reopen starts a fresh one
+	require.NoError(t, os.Rename(path, path+".1"))
+	fmt.Fprintln(f, "still old")
+	require.NoError(t, f.Reopen())
+	fmt.Fprintln(f, "after")
+
+	old, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "before\nstill old\n", string(old))
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "after\n", string(current))
+
+	// Test: A file that cannot be reopened keeps the old one
+	require.NoError(t, os.Rename(path, path+".2"))
+	require.NoError(t, os.Mkdir(path, 0o755))
+	assert.Error(t, f.Reopen())
+	fmt.Fprintln(f, "kept")
+	current, err = os.ReadFile(path + ".2")
+	require.NoError(t, err)
+	assert.Equal(t, "after\nkept\n", string(current))
+}
+
+func TestReopenOnSignal(t *testing.T) {
+	// Test: SIGUSR1 reopens the file
+	path := filepath.Join(t.TempDir(), "error.log")
+	f, err := Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	stop := ReopenOnSignal(f)
+	defer stop()
+
+	require.NoError(t, os.Rename(path, path+".1"))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	fmt.Fprintln(f, "fresh")
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "fresh\n", string(current))
+}
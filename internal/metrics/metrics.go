@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Counter struct {
+	v atomic.Int64
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+type Gauge struct {
+	v atomic.Int64
+}
+
+func (g *Gauge) Add(n int64) {
+	g.v.Add(n)
+}
+
+func (g *Gauge) Set(n int64) {
+	g.v.Store(n)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.v.Load()
+}
+
+// DefaultBuckets suit request durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into buckets by upper bound, as Prometheus
+// histograms do.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Count is how many values have been observed.
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) Sum() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum
+}
+
+// write renders the _bucket, _sum and _count series of the histogram name.
+func (h *Histogram) write(b *strings.Builder, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cumulative := int64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(b, "%s %d\n", series(name, "_bucket", `le="`+formatFloat(bound)+`"`), cumulative)
+	}
+	fmt.Fprintf(b, "%s %d\n", series(name, "_bucket", `le="+Inf"`), h.count)
+	fmt.Fprintf(b, "%s %s\n", series(name, "_sum", ""), formatFloat(h.sum))
+	fmt.Fprintf(b, "%s %d\n", series(name, "_count", ""), h.count)
+}
+
+type metric struct {
+	kind  string
+	value func() int64
+	// write renders metrics that take more than one line, in place of value.
+	write func(b *strings.Builder, name string)
+}
+
+// Registry hands out named metrics and renders them in the Prometheus text
+// format. Names may carry labels, e.g. `requests_total{route="/"}`; HELP and
+// TYPE are emitted once per base name.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+	help    map[string]string
+	created map[string]any
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: map[string]metric{},
+		help:    map[string]string{},
+		created: map[string]any{},
+	}
+}
+
+// Counter returns the counter called name, creating it on first use.
+func (r *Registry) Counter(name, help string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.created[name].(*Counter); ok {
+		return c
+	}
+	c := &Counter{}
+	r.register(name, help, metric{kind: "counter", value: c.Value}, c)
+	return c
+}
+
+// Gauge returns the gauge called name, creating it on first use.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if g, ok := r.created[name].(*Gauge); ok {
+		return g
+	}
+	g := &Gauge{}
+	r.register(name, help, metric{kind: "gauge", value: g.Value}, g)
+	return g
+}
+
+// Histogram returns the histogram called name, creating it on first use
+// with buckets, or DefaultBuckets when buckets is nil.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if h, ok := r.created[name].(*Histogram); ok {
+		return h
+	}
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	bounds := append([]float64{}, buckets...)
+	sort.Float64s(bounds)
+	h := &Histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+	r.register(name, help, metric{kind: "histogram", value: h.Count, write: h.write}, h)
+	return h
+}
+
+func (r *Registry) register(name, help string, m metric, v any) {
+	r.metrics[name] = m
+	r.created[name] = v
+	if help != "" {
+		r.help[baseName(name)] = help
+	}
+}
+
+// Value reports the current value of name, for tests and admin endpoints.
+func (r *Registry) Value(name string) (int64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.metrics[name]
+	if !ok {
+		return 0, false
+	}
+	return m.value(), true
+}
+
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := strings.Builder{}
+	last := ""
+	for _, name := range names {
+		m := r.metrics[name]
+		if base := baseName(name); base != last {
+			last = base
+			if help := r.help[base]; help != "" {
+				fmt.Fprintf(&b, "# HELP %s %s\n", base, help)
+			}
+			fmt.Fprintf(&b, "# TYPE %s %s\n", base, m.kind)
+		}
+		if m.write != nil {
+			m.write(&b, name)
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d\n", name, m.value())
+	}
+	r.mu.Unlock()
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Name builds a metric name with labels from key, value pairs, e.g.
+// Name("requests_total", "route", "/") is `requests_total{route="/"}`.
+func Name(base string, labels ...string) string {
+	if len(labels) < 2 {
+		return base
+	}
+	pairs := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return base + "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// series is name with suffix added to its base name and extra to its labels.
+func series(name, suffix, extra string) string {
+	base, labels, _ := strings.Cut(name, "{")
+	labels = strings.TrimSuffix(labels, "}")
+	if extra != "" && labels != "" {
+		labels += ","
+	}
+	labels += extra
+	if labels == "" {
+		return base + suffix
+	}
+	return base + suffix + "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func baseName(name string) string {
+	base, _, _ := strings.Cut(name, "{")
+	return base
+}
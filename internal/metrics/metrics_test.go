@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	// Test: Metrics are created once and shared by name
+	r.Counter("requests_total", "Requests handled.").Inc()
+	r.Counter("requests_total", "").Add(2)
+	r.Gauge("in_flight", "").Set(4)
+	r.Counter(`route_requests_total{route="/b"}`, "Requests per route.").Inc()
+	r.Counter(`route_requests_total{route="/a"}`, "").Inc()
+
+	v, ok := r.Value("requests_total")
+	require.True(t, ok)
+	assert.Equal(t, int64(3), v)
+
+	// Test: The text format groups labelled series under one TYPE line
+	out := strings.Builder{}
+	require.NoError(t, r.WriteText(&out))
+	assert.Equal(t, "# TYPE in_flight gauge\n"+
+		"in_flight 4\n"+
+		"# HELP requests_total Requests handled.\n"+
+		"# TYPE requests_total counter\n"+
+		"requests_total 3\n"+
+		"# HELP route_requests_total Requests per route.\n"+
+		"# TYPE route_requests_total counter\n"+
+		"route_requests_total{route=\"/a\"} 1\n"+
+		"route_requests_total{route=\"/b\"} 1\n", out.String())
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+
+	// Test: Observations land in cumulative buckets with a sum and count
+	h := r.Histogram(Name("duration_seconds", "route", "/a"), "Request durations.", []float64{1, 0.1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(3)
+	assert.Same(t, h, r.Histogram(`duration_seconds{route="/a"}`, "", nil))
+	v, ok := r.Value(`duration_seconds{route="/a"}`)
+	require.True(t, ok)
+	assert.Equal(t, int64(3), v)
+
+	out := strings.Builder{}
+	require.NoError(t, r.WriteText(&out))
+	assert.Equal(t, "# HELP duration_seconds Request durations.\n"+
+		"# TYPE duration_seconds histogram\n"+
+		"duration_seconds_bucket{route=\"/a\",le=\"0.1\"} 1\n"+
+		"duration_seconds_bucket{route=\"/a\",le=\"1\"} 2\n"+
+		"duration_seconds_bucket{route=\"/a\",le=\"+Inf\"} 3\n"+
+		"duration_seconds_sum{route=\"/a\"} 3.55\n"+
+		"duration_seconds_count{route=\"/a\"} 3\n", out.String())
+
+	// Test: Label values are escaped
+	assert.Equal(t, `x{path="a\"b\\c",n="1"}`, Name("x", "path", `a"b\c`, "n", "1"))
+	assert.Equal(t, "x", Name("x"))
+}
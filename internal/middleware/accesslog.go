@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// AccessLogConfig picks which requests get a line; a request is logged when
+// every condition set holds.
+type AccessLogConfig struct {
+	// Output receives the lines, os.Stderr when nil.
+	Output io.Writer
+	// Skip leaves out requests it reports true for, such as health checks.
+	Skip func(req *request.Request) bool
+	// MinStatus logs only responses with at least this status, such as 400
+	// to log errors alone.
+	MinStatus response.StatusCode
+	// SampleSuccess logs one in that many 2xx responses; 0 or 1 logs all.
+	SampleSuccess int
+}
+
+// AccessLog writes a line per request once it has been answered: client
+// address, time, method, target as the client sent it, status, duration and
+// request ID.
+func AccessLog(config AccessLogConfig) server.Middleware {
+	if config.Output == nil {
+		config.Output = os.Stderr
+	}
+	var mu sync.Mutex
+	var successes atomic.Int64
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			if config.Skip != nil && config.Skip(req) {
+				return
+			}
+			status := w.Status()
+			if status < config.MinStatus {
+				return
+			}
+			if status >= 200 && status < 300 && config.SampleSuccess > 1 &&
+				(successes.Add(1)-1)%int64(config.SampleSuccess) != 0 {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(config.Output, "%s [%s] %s %s %d %s %s\n", req.RemoteAddr, response.LogTime(),
+				req.RequestLine.Method, req.RawTarget(), status, time.Since(start).Round(time.Microsecond), req.ID())
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func notFound(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusNotFound)
+	w.WriteHeaders(*response.GetDefaultHeaders(0))
+}
+
+func TestAccessLog(t *testing.T) {
+	// Test: Every request is logged by default
+	out := bytes.Buffer{}
+	handler := AccessLog(AccessLogConfig{Output: &out})(ok)
+	run(t, handler, "GET /a HTTP/1.1\r\n\r\n")
+	run(t, handler, "POST /b HTTP/1.1\r\nContent-Length: 0\r\n\r\n")
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], " GET /a 200 ")
+	assert.Contains(t, lines[1], " POST /b 200 ")
+
+	// Test: Skip leaves out health checks
+	out.Reset()
+	handler = AccessLog(AccessLogConfig{Output: &out, Skip: func(req *request.Request) bool {
+		return req.RequestLine.RequestTarget == "/healthz"
+	}})(ok)
+	run(t, handler, "GET /healthz HTTP/1.1\r\n\r\n")
+	assert.Empty(t, out.String())
+	run(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out.String(), " GET / 200 ")
+
+	// Test: MinStatus logs only errors
+	out.Reset()
+	config := AccessLogConfig{Output: &out, MinStatus: response.StatusBadRequest}
+	run(t, AccessLog(config)(ok), "GET / HTTP/1.1\r\n\r\n")
+	assert.Empty(t, out.String())
+	run(t, AccessLog(config)(notFound), "GET /missing HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out.String(), " GET /missing 404 ")
+
+	// Test: SampleSuccess logs one in n successes but every error
+	out.Reset()
+	config = AccessLogConfig{Output: &out, SampleSuccess: 3}
+	logged := AccessLog(config)
+	success, failure := logged(ok), logged(notFound)
+	for range 6 {
+		run(t, success, "GET / HTTP/1.1\r\n\r\n")
+		run(t, failure, "GET /missing HTTP/1.1\r\n\r\n")
+	}
+	assert.Equal(t, 2, strings.Count(out.String(), " 200 "))
+	assert.Equal(t, 6, strings.Count(out.String(), " 404 "))
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"io"
+
+	"tcp.to.http/internal/digest"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// VerifyDigest refuses, with a 400, requests whose body does not match the
+// Digest or Content-MD5 they came with, in the headers or the trailers of a
+// chunked body. The body is hashed as it streams, so a spilled one is not
+// read into memory.
+func VerifyDigest() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			fields := req.Headers.Clone()
+			req.Trailers.ForEach(func(n, v string) {
+				fields.Replace(n, v)
+			})
+			v := digest.NewVerifier(fields)
+			if v == nil {
+				next(w, req)
+				return
+			}
+			if _, err := io.Copy(v, req.BodyReader()); err != nil {
+				server.Error(w, req, response.StatusInternalServeError, "")
+				return
+			}
+			if err := v.Verify(); err != nil {
+				server.Error(w, req, response.StatusBadRequest, err.Error())
+				return
+			}
+			next(w, req)
+		}
+	}
+}
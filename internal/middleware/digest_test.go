@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyDigest(t *testing.T) {
+	handler := VerifyDigest()(ok)
+	post := "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n"
+
+	// Test: Matching digests and requests without any reach the handler
+	out := run(t, handler, post+"Digest: SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	out = run(t, handler, post+"\r\nhello")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: A mismatch is a 400
+	out = run(t, handler, post+"Content-MD5: XUFAKrxLKna5cZ2REBfFkg==\r\n\r\nHELLO")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Digests in the trailers of a chunked body are checked too
+	chunked := "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n"
+	out = run(t, handler, chunked+"Content-MD5: XUFAKrxLKna5cZ2REBfFkg==\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	out = run(t, handler, chunked+"Content-MD5: bm9wZQ==\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+}
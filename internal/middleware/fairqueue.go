@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+type FairQueueConfig struct {
+	// Workers is how many requests are handled at once.
+	Workers int
+	// MaxQueuedPerClient bounds how many of a client's requests may wait
+	// for a worker; more are refused with 503. 0 means no bound.
+	MaxQueuedPerClient int
+	// Key identifies the client a request comes from, by default the IP
+	// of its remote address.
+	Key        func(req *request.Request) string
+	RetryAfter time.Duration
+}
+
+// FairQueue caps how many requests are handled at once and, while that cap
+// is reached, hands freed workers to waiting clients in turn rather than
+// in arrival order, so one client sending many requests cannot starve the
+// rest.
+type FairQueue struct {
+	config FairQueueConfig
+
+	mu   sync.Mutex
+	busy int
+	// waiting holds each client's queued requests; turns is the order in
+	// which clients with queued requests get the next worker.
+	waiting map[string][]chan struct{}
+	turns   []string
+}
+
+func NewFairQueue(config FairQueueConfig) *FairQueue {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.Key == nil {
+		config.Key = clientIP
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = time.Second
+	}
+	return &FairQueue{config: config, waiting: map[string][]chan struct{}{}}
+}
+
+func clientIP(req *request.Request) string {
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return ip
+	}
+	return req.RemoteAddr
+}
+
+// Queued is how many requests are waiting for a worker.
+func (q *FairQueue) Queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, waiters := range q.waiting {
+		n += len(waiters)
+	}
+	return n
+}
+
+func (q *FairQueue) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		ready, ok := q.enqueue(q.config.Key(req))
+		if !ok {
+			retryAfter := fmt.Sprintf("%d", int((q.config.RetryAfter+time.Second-1)/time.Second))
+			w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+				h.Replace("Retry-After", retryAfter)
+			})
+			server.Error(w, req, response.StatusServiceUnavailable, "")
+			return
+		}
+		if ready != nil {
+			select {
+			case <-ready:
+			case <-req.Context().Done():
+				// The client gave up waiting; if a worker was handed over
+				// meanwhile, pass it on.
+				if !q.cancel(q.config.Key(req), ready) {
+					q.release()
+				}
+				return
+			}
+		}
+		defer q.release()
+		next(w, req)
+	}
+}
+
+// enqueue takes a worker for key straight away, returning a nil channel, or
+// queues the request and returns a channel closed once a worker is handed
+// to it. It reports false when key's queue is full.
+func (q *FairQueue) enqueue(key string) (chan struct{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.busy < q.config.Workers && len(q.turns) == 0 {
+		q.busy++
+		return nil, true
+	}
+	waiters := q.waiting[key]
+	if q.config.MaxQueuedPerClient > 0 && len(waiters) >= q.config.MaxQueuedPerClient {
+		return nil, false
+	}
+	if len(waiters) == 0 {
+		q.turns = append(q.turns, key)
+	}
+	ready := make(chan struct{})
+	q.waiting[key] = append(waiters, ready)
+	return ready, true
+}
+
+// release hands the worker to the first request of the client whose turn
+// it is, or frees it when nobody is waiting.
+func (q *FairQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.turns) == 0 {
+		q.busy--
+		return
+	}
+	key := q.turns[0]
+	q.turns = q.turns[1:]
+	waiters := q.waiting[key]
+	close(waiters[0])
+	if len(waiters) == 1 {
+		delete(q.waiting, key)
+	} else {
+		q.waiting[key] = waiters[1:]
+		q.turns = append(q.turns, key)
+	}
+}
+
+// cancel takes ready out of key's queue, reporting false if it was no
+// longer there because a worker had been handed to it.
+func (q *FairQueue) cancel(key string, ready chan struct{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiters := q.waiting[key]
+	for i, w := range waiters {
+		if w != ready {
+			continue
+		}
+		waiters = append(waiters[:i:i], waiters[i+1:]...)
+		if len(waiters) > 0 {
+			q.waiting[key] = waiters
+			return true
+		}
+		delete(q.waiting, key)
+		for j, turn := range q.turns {
+			if turn == key {
+				q.turns = append(q.turns[:j:j], q.turns[j+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
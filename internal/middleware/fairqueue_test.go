@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func fromClient(t *testing.T, target, addr string) *request.Request {
+	req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = addr
+	return req
+}
+
+func TestFairQueue(t *testing.T) {
+	q := NewFairQueue(FairQueueConfig{Workers: 1, MaxQueuedPerClient: 3})
+	release := make(chan struct{})
+	mu := sync.Mutex{}
+	order := []string{}
+	handler := q.Middleware(func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/block" {
+			<-release
+		}
+		mu.Lock()
+		order = append(order, req.RequestLine.RequestTarget)
+		mu.Unlock()
+		ok(w, req)
+	})
+
+	wg := sync.WaitGroup{}
+	send := func(target, addr string, queued int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runRequest(handler, fromClient(t, target, addr))
+		}()
+		require.Eventually(t, func() bool { return q.Queued() == queued }, time.Second, time.Millisecond)
+	}
+	send("/block", "10.0.0.1:1000", 0)
+	send("/a1", "10.0.0.1:1001", 1)
+	send("/a2", "10.0.0.1:1002", 2)
+	send("/a3", "10.0.0.1:1003", 3)
+	send("/b1", "10.0.0.2:2000", 4)
+
+	// Test: A client over its queue bound is refused
+	out := runRequest(handler, fromClient(t, "/a4", "10.0.0.1:1004"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"), out)
+	assert.Contains(t, out, "retry-after: 1\r\n")
+
+	// Test: Freed workers go to clients in turn, not in arrival order
+	close(release)
+	wg.Wait()
+	assert.Equal(t, []string{"/block", "/a1", "/b1", "/a2", "/a3"}, order)
+	assert.Equal(t, 0, q.Queued())
+
+	// Test: A client that gives up leaves the queue and the worker is kept
+	release = make(chan struct{})
+	send("/block", "10.0.0.1:1000", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runRequest(handler, fromClient(t, "/gone", "10.0.0.3:3000").WithContext(ctx))
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return q.Queued() == 1 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+	assert.Equal(t, 0, q.Queued())
+	close(release)
+	wg.Wait()
+	out = runRequest(handler, fromClient(t, "/after", "10.0.0.3:3000"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.NotContains(t, order, "/gone")
+}
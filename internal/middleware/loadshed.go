@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+type LoadShedConfig struct {
+	// MaxInFlight sheds once this many requests are being handled.
+	MaxInFlight int64
+	// MaxLatency sheds while the moving average handler latency is above it
+	// and requests are still in flight.
+	MaxLatency time.Duration
+	// Overloaded is an optional extra signal, e.g. a queue depth check.
+	Overloaded func() bool
+	RetryAfter time.Duration
+}
+
+type LoadShedder struct {
+	config   LoadShedConfig
+	inFlight atomic.Int64
+
+	mu      sync.Mutex
+	latency time.Duration
+}
+
+func NewLoadShedder(config LoadShedConfig) *LoadShedder {
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = time.Second
+	}
+	return &LoadShedder{config: config}
+}
+
+func (l *LoadShedder) InFlight() int64 {
+	return l.inFlight.Load()
+}
+
+func (l *LoadShedder) Latency() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.latency
+}
+
+func (l *LoadShedder) Saturated() bool {
+	return l.saturated(l.inFlight.Load())
+}
+
+// saturated reports whether a request arriving while others are in flight
+// should be shed.
+func (l *LoadShedder) saturated(others int64) bool {
+	if l.config.MaxInFlight > 0 && others >= l.config.MaxInFlight {
+		return true
+	}
+	if l.config.MaxLatency > 0 && others > 0 && l.Latency() > l.config.MaxLatency {
+		return true
+	}
+	return l.config.Overloaded != nil && l.config.Overloaded()
+}
+
+func (l *LoadShedder) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		// Counted before the check, so concurrent arrivals each see the
+		// others and no more than MaxInFlight get through.
+		if l.saturated(l.inFlight.Add(1) - 1) {
+			l.inFlight.Add(-1)
+			retryAfter := fmt.Sprintf("%d", int((l.config.RetryAfter+time.Second-1)/time.Second))
+			w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+				h.Replace("Retry-After", retryAfter)
+			})
+			server.Error(w, req, response.StatusServiceUnavailable, "")
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			l.observe(time.Since(start))
+			l.inFlight.Add(-1)
+		}()
+		next(w, req)
+	}
+}
+
+func (l *LoadShedder) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.latency == 0 {
+		l.latency = d
+		return
+	}
+	l.latency += (d - l.latency) / 5
+}
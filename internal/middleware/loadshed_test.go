@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestLoadShedder(t *testing.T) {
+	// Test: Requests over MaxInFlight are shed with Retry-After
+	l := NewLoadShedder(LoadShedConfig{MaxInFlight: 1, RetryAfter: 1500 * time.Millisecond})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	blocking := l.Middleware(func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		ok(w, req)
+	})
+	done := make(chan string)
+	go func() {
+		done <- run(t, blocking, "GET / HTTP/1.1\r\n\r\n")
+	}()
+	<-started
+
+	out := run(t, l.Middleware(ok), "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Contains(t, out, "retry-after: 2\r\n")
+
+	close(release)
+	assert.True(t, strings.HasPrefix(<-done, "HTTP/1.1 200 OK\r\n"))
+	out = run(t, l.Middleware(ok), "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Custom overload signal
+	overloaded := true
+	l = NewLoadShedder(LoadShedConfig{Overloaded: func() bool { return overloaded }})
+	out = run(t, l.Middleware(ok), "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+	overloaded = false
+	out = run(t, l.Middleware(ok), "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestLoadShedderConcurrent(t *testing.T) {
+	// Test: Of many requests arriving at once, no more than MaxInFlight are
+	// handled together
+	l := NewLoadShedder(LoadShedConfig{MaxInFlight: 3})
+	var running, peak atomic.Int64
+	handler := l.Middleware(func(w *response.Writer, req *request.Request) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		ok(w, req)
+	})
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	var served atomic.Int64
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if strings.HasPrefix(run(t, handler, "GET / HTTP/1.1\r\n\r\n"), "HTTP/1.1 200 OK\r\n") {
+				served.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.LessOrEqual(t, peak.Load(), int64(3))
+	assert.Positive(t, served.Load())
+	assert.Equal(t, int64(0), l.InFlight())
+}
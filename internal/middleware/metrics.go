@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"tcp.to.http/internal/metrics"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/router"
+	"tcp.to.http/internal/server"
+)
+
+// unmatchedRoute labels requests no route handled, such as 404s, so stray
+// paths never become label values of their own.
+const unmatchedRoute = "unmatched"
+
+// metricsMethod is the method label for method: any token is a method, so
+// those outside RFC 9110's and PATCH share one label.
+func metricsMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+		return method
+	}
+	return "OTHER"
+}
+
+type MetricsConfig struct {
+	Registry *metrics.Registry
+	// Buckets bound the duration histograms, in seconds; nil means
+	// metrics.DefaultBuckets.
+	Buckets []float64
+}
+
+// Metrics counts requests and times them, labelled by method, status and
+// route pattern. The pattern comes from the router: pass ObserveRoute to
+// router.Observe.
+type Metrics struct {
+	config MetricsConfig
+}
+
+func NewMetrics(config MetricsConfig) *Metrics {
+	if config.Registry == nil {
+		config.Registry = metrics.NewRegistry()
+	}
+	return &Metrics{config: config}
+}
+
+type metricsKey struct{}
+
+// requestMetrics is shared by everything handling one request, so the
+// route found deep inside the router is known to the middleware around it.
+type requestMetrics struct {
+	metrics *Metrics
+	mu      sync.Mutex
+	route   string
+}
+
+func (rm *requestMetrics) pattern() string {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.route == "" {
+		return unmatchedRoute
+	}
+	return rm.route
+}
+
+func (m *Metrics) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		rm := &requestMetrics{metrics: m}
+		start := time.Now()
+		next(w, req.WithContext(context.WithValue(req.Context(), metricsKey{}, rm)))
+		d := time.Since(start)
+
+		method, route := metricsMethod(req.RequestLine.Method), rm.pattern()
+		m.config.Registry.Counter(metrics.Name("http_requests_total",
+			"method", method, "route", route, "status", fmt.Sprintf("%d", w.Status())),
+			"Requests handled, by method, route and status.").Inc()
+		m.config.Registry.Histogram(metrics.Name("http_request_duration_seconds",
+			"method", method, "route", route),
+			"Time spent handling requests, by method and route.", m.config.Buckets).Observe(d.Seconds())
+		head, body := req.WireBytes()
+		m.config.Registry.Counter(metrics.Name("http_request_bytes_total", "method", method, "route", route),
+			"Bytes read from clients, heads and bodies, by method and route.").Add(head + body)
+		head, body = w.WireBytes()
+		m.config.Registry.Counter(metrics.Name("http_response_bytes_total", "method", method, "route", route),
+			"Bytes written to clients, heads and bodies, by method and route.").Add(head + body)
+	}
+}
+
+// ObserveRoute notes the pattern that matched req; pass it to router.Observe.
+func (m *Metrics) ObserveRoute(req *request.Request, pattern string, _ response.StatusCode, _ time.Duration) {
+	if rm, ok := req.Context().Value(metricsKey{}).(*requestMetrics); ok {
+		rm.mu.Lock()
+		rm.route = pattern
+		rm.mu.Unlock()
+	}
+}
+
+// Recorder records a handler's own metrics, labelled with the method and
+// route of its request. Without the Metrics middleware it records nothing.
+type Recorder struct {
+	rm     *requestMetrics
+	labels []string
+}
+
+// RequestMetrics returns the Recorder for req.
+func RequestMetrics(req *request.Request) *Recorder {
+	rm, _ := req.Context().Value(metricsKey{}).(*requestMetrics)
+	route := router.Pattern(req)
+	if route == "" && rm != nil {
+		route = rm.pattern()
+	}
+	return &Recorder{rm: rm, labels: []string{"method", metricsMethod(req.RequestLine.Method), "route", route}}
+}
+
+// Count adds n to the counter name, with labels as extra key, value pairs.
+func (r *Recorder) Count(name, help string, n int64, labels ...string) {
+	if r.rm == nil {
+		return
+	}
+	r.rm.metrics.config.Registry.Counter(r.name(name, labels), help).Add(n)
+}
+
+// Observe adds v to the histogram name, with labels as extra key, value
+// pairs.
+func (r *Recorder) Observe(name, help string, v float64, labels ...string) {
+	if r.rm == nil {
+		return
+	}
+	r.rm.metrics.config.Registry.Histogram(r.name(name, labels), help, r.rm.metrics.config.Buckets).Observe(v)
+}
+
+func (r *Recorder) name(name string, labels []string) string {
+	return metrics.Name(name, append(append([]string{}, r.labels...), labels...)...)
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"tcp.to.http/internal/metrics"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/router"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(MetricsConfig{Registry: registry})
+	r := router.New()
+	r.Observe(m.ObserveRoute)
+	r.Handle("GET", "/users/{id}", func(w *response.Writer, req *request.Request) {
+		RequestMetrics(req).Count("user_lookups_total", "", 1, "source", "cache")
+		RequestMetrics(req).Observe("user_lookup_seconds", "", 0.02)
+		ok(w, req)
+	})
+	handler := m.Middleware(r.Serve)
+
+	// Test: Requests are labelled by route pattern, not path
+	run(t, handler, "GET /users/1 HTTP/1.1\r\n\r\n")
+	run(t, handler, "GET /users/2 HTTP/1.1\r\n\r\n")
+	v, _ := registry.Value(`http_requests_total{method="GET",route="/users/{id}",status="200"}`)
+	assert.Equal(t, int64(2), v)
+	v, _ = registry.Value(`http_request_duration_seconds{method="GET",route="/users/{id}"}`)
+	assert.Equal(t, int64(2), v)
+	v, _ = registry.Value(`http_request_bytes_total{method="GET",route="/users/{id}"}`)
+	assert.Equal(t, int64(2*len("GET /users/1 HTTP/1.1\r\n\r\n")), v)
+	v, _ = registry.Value(`http_response_bytes_total{method="GET",route="/users/{id}"}`)
+	assert.Positive(t, v)
+
+	// Test: Paths no route matched share one label
+	run(t, handler, "GET /nope/1 HTTP/1.1\r\n\r\n")
+	run(t, handler, "GET /nope/2 HTTP/1.1\r\n\r\n")
+	v, _ = registry.Value(`http_requests_total{method="GET",route="unmatched",status="404"}`)
+	assert.Equal(t, int64(2), v)
+
+	// Test: Unknown methods share one label
+	run(t, handler, "BREW /pot HTTP/1.1\r\n\r\n")
+	run(t, handler, "WHEN /pot HTTP/1.1\r\n\r\n")
+	v, _ = registry.Value(`http_requests_total{method="OTHER",route="unmatched",status="404"}`)
+	assert.Equal(t, int64(2), v)
+
+	// Test: Handlers record their own metrics with the route attached
+	v, _ = registry.Value(`user_lookups_total{method="GET",route="/users/{id}",source="cache"}`)
+	assert.Equal(t, int64(2), v)
+	v, _ = registry.Value(`user_lookup_seconds{method="GET",route="/users/{id}"}`)
+	assert.Equal(t, int64(2), v)
+
+	// Test: Without the middleware recording is a no-op
+	run(t, r.Serve, "GET /users/3 HTTP/1.1\r\n\r\n")
+	v, _ = registry.Value(`user_lookups_total{method="GET",route="/users/{id}",source="cache"}`)
+	assert.Equal(t, int64(2), v)
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+func run(t *testing.T, handler server.Handler, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return runRequest(handler, req)
+}
+
+func runRequest(handler server.Handler, req *request.Request) string {
+	out := bytes.Buffer{}
+	handler(response.NewWriter(&out), req)
+	return out.String()
+}
+
+func ok(w *response.Writer, req *request.Request) {
+	body := []byte("ok")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
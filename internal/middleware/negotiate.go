@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"html/template"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// Encoder serializes values as MediaType.
+type Encoder struct {
+	MediaType string
+	Write     func(w *response.Writer, status response.StatusCode, v any) error
+}
+
+func JSON() Encoder {
+	return Encoder{MediaType: "application/json", Write: (*response.Writer).WriteJSON}
+}
+
+func XML() Encoder {
+	return Encoder{MediaType: "application/xml", Write: (*response.Writer).WriteXML}
+}
+
+// HTML renders values through tmpl.
+func HTML(tmpl *template.Template) Encoder {
+	return Encoder{
+		MediaType: "text/html",
+		Write: func(w *response.Writer, status response.StatusCode, v any) error {
+			return w.Render(status, tmpl, v)
+		},
+	}
+}
+
+type encoderKey struct{}
+
+// Negotiate picks, from encoders, the one the request's Accept header
+// prefers, for Respond to serialize with. Requests that accept none of them
+// get a 406 and never reach next. Encoders are in order of preference, the
+// first being used when the client states none.
+func Negotiate(encoders ...Encoder) server.Middleware {
+	offers := make([]string, len(encoders))
+	for i, e := range encoders {
+		offers[i] = e.MediaType
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.Vary("Accept")
+			accept, _ := req.Headers.Get("accept")
+			best, ok := headers.Negotiate(accept, offers)
+			if !ok {
+				server.Error(w, req, response.StatusNotAcceptable, "")
+				return
+			}
+			for _, e := range encoders {
+				if e.MediaType == best {
+					next(w, req.WithContext(context.WithValue(req.Context(), encoderKey{}, e)))
+					return
+				}
+			}
+		}
+	}
+}
+
+// Respond sends v with status in the format Negotiate picked for req, or as
+// JSON when req did not pass through Negotiate.
+func Respond(w *response.Writer, req *request.Request, status response.StatusCode, v any) error {
+	e, ok := req.Context().Value(encoderKey{}).(Encoder)
+	if !ok {
+		e = JSON()
+	}
+	return e.Write(w, status, v)
+}
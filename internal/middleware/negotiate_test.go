@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestNegotiate(t *testing.T) {
+	type coffee struct {
+		Name string `json:"name" xml:"name"`
+	}
+	page := template.Must(template.New("coffee").Parse("<h1>{{.Name}}</h1>"))
+	handler := Negotiate(JSON(), XML(), HTML(page))(func(w *response.Writer, req *request.Request) {
+		Respond(w, req, response.StatusOK, coffee{Name: "latte"})
+	})
+
+	// Test: The value is serialized in the format the client prefers
+	out := run(t, handler, "GET / HTTP/1.1\r\nAccept: application/xml\r\n\r\n")
+	assert.Contains(t, out, "content-type: application/xml; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(out, "<coffee><name>latte</name></coffee>"))
+	out = run(t, handler, "GET / HTTP/1.1\r\nAccept: text/html, application/*;q=0.9\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "<h1>latte</h1>"))
+
+	// Test: Without Accept the first encoder is used
+	out = run(t, handler, "GET / HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "content-type: application/json\r\n")
+	assert.True(t, strings.HasSuffix(out, `{"name":"latte"}`))
+
+	// Test: Nothing acceptable is a 406 and the handler does not run
+	out = run(t, handler, "GET / HTTP/1.1\r\nAccept: image/png\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 406 Not Acceptable\r\n"))
+
+	// Test: Every answer varies on Accept
+	assert.Contains(t, out, "vary: Accept\r\n")
+}
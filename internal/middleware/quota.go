@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// QuotaStore keeps the bytes each client has used in fixed windows of time.
+type QuotaStore interface {
+	// Add adds n bytes to key's usage in its current window, starting a new
+	// window of length window once the last has ended, and returns the
+	// usage so far and when the window ends.
+	Add(key string, n int64, window time.Duration) (used int64, reset time.Time, err error)
+}
+
+type quotaWindow struct {
+	used  int64
+	reset time.Time
+}
+
+type MemoryQuotaStore struct {
+	mu      sync.Mutex
+	windows map[string]*quotaWindow
+}
+
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{windows: map[string]*quotaWindow{}}
+}
+
+func (s *MemoryQuotaStore) Add(key string, n int64, window time.Duration) (int64, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.reset) {
+		if !ok {
+			s.prune(now)
+		}
+		w = &quotaWindow{reset: now.Add(window)}
+		s.windows[key] = w
+	}
+	w.used += n
+	return w.used, w.reset, nil
+}
+
+// prune drops ended windows, so keys seen once do not pile up.
+func (s *MemoryQuotaStore) prune(now time.Time) {
+	for key, w := range s.windows {
+		if !now.Before(w.reset) {
+			delete(s.windows, key)
+		}
+	}
+}
+
+type QuotaConfig struct {
+	// Limit is how many bytes, read and written, heads included, a client
+	// may use per Window. A request that starts under the limit is served
+	// in full even if it ends over it; the request itself is counted
+	// before it is handled, its response only after. It must be positive.
+	Limit int64
+	// Window defaults to an hour.
+	Window time.Duration
+	// Key identifies the client, by default the IP of its remote address;
+	// see QuotaByHeader for API keys.
+	Key func(req *request.Request) string
+	// Store defaults to a MemoryQuotaStore.
+	Store QuotaStore
+}
+
+// QuotaByHeader keys quotas by the value of a header such as X-API-Key,
+// falling back to the client IP for requests without one.
+func QuotaByHeader(name string) func(req *request.Request) string {
+	return func(req *request.Request) string {
+		if value, ok := req.Headers.Get(name); ok && value != "" {
+			return name + ":" + value
+		}
+		return clientIP(req)
+	}
+}
+
+// Quota enforces a byte quota per client over a time window. Responses
+// carry X-RateLimit-Limit, X-RateLimit-Remaining (after the request, before
+// the response) and X-RateLimit-Reset (seconds until the window ends); a
+// client over its quota gets 429 with Retry-After until the window ends.
+func Quota(config QuotaConfig) server.Middleware {
+	if config.Limit <= 0 {
+		panic("middleware: quota Limit must be positive")
+	}
+	if config.Window <= 0 {
+		config.Window = time.Hour
+	}
+	if config.Key == nil {
+		config.Key = clientIP
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryQuotaStore()
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			key := config.Key(req)
+			// The request is counted up front, so concurrent requests see
+			// each other, and settled once the response is out.
+			reserved := quotaEstimate(req)
+			used, reset, err := config.Store.Add(key, reserved, config.Window)
+			if err != nil {
+				server.Error(w, req, response.StatusInternalServeError, "")
+				return
+			}
+			resetSeconds := fmt.Sprintf("%d", int64((time.Until(reset)+time.Second-1)/time.Second))
+			w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+				h.Replace("X-RateLimit-Limit", fmt.Sprintf("%d", config.Limit))
+				h.Replace("X-RateLimit-Remaining", fmt.Sprintf("%d", max(0, config.Limit-used)))
+				h.Replace("X-RateLimit-Reset", resetSeconds)
+			})
+			if used-reserved >= config.Limit {
+				config.Store.Add(key, -reserved, config.Window)
+				w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+					h.Replace("Retry-After", resetSeconds)
+				})
+				server.Error(w, req, response.StatusTooManyRequests, "")
+				return
+			}
+
+			next(w, req)
+			readHead, readBody := req.WireBytes()
+			writeHead, writeBody := w.WireBytes()
+			config.Store.Add(key, readHead+readBody+writeHead+writeBody-reserved, config.Window)
+		}
+	}
+}
+
+// quotaEstimate is what a request is known to use before it is handled:
+// its head and its body, as read so far or as declared by Content-Length.
+func quotaEstimate(req *request.Request) int64 {
+	head, body := req.WireBytes()
+	if value, ok := req.Headers.Get("content-length"); ok {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			body = max(body, n)
+		}
+	}
+	return head + body
+}
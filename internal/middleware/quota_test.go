@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestQuota(t *testing.T) {
+	// Any exchange uses up the one byte allowed.
+	handler := Quota(QuotaConfig{Limit: 1, Window: time.Hour, Key: QuotaByHeader("X-API-Key")})(ok)
+
+	// Test: Responses report the quota left after the request itself
+	out := runRequest(handler, fromClient(t, "/", "10.0.0.1:1000"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "x-ratelimit-limit: 1\r\n")
+	assert.Contains(t, out, "x-ratelimit-remaining: 0\r\n")
+	assert.Contains(t, out, "x-ratelimit-reset: 3600\r\n")
+
+	// Test: A client over its quota is refused until the window ends
+	out = runRequest(handler, fromClient(t, "/", "10.0.0.1:1002"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 429 Too Many Requests\r\n"), out)
+	assert.Contains(t, out, "x-ratelimit-remaining: 0\r\n")
+	assert.Contains(t, out, "retry-after: 3600\r\n")
+
+	// Test: Other clients and API keys have quotas of their own
+	out = runRequest(handler, fromClient(t, "/", "10.0.0.2:1000"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	out = run(t, handler, "GET / HTTP/1.1\r\nX-API-Key: k1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+}
+
+func TestMemoryQuotaStore(t *testing.T) {
+	// Test: Usage starts over in a new window
+	s := NewMemoryQuotaStore()
+	used, _, _ := s.Add("a", 10, 20*time.Millisecond)
+	assert.Equal(t, int64(10), used)
+	used, _, _ = s.Add("a", 5, 20*time.Millisecond)
+	assert.Equal(t, int64(15), used)
+	time.Sleep(30 * time.Millisecond)
+	used, reset, _ := s.Add("a", 1, 20*time.Millisecond)
+	assert.Equal(t, int64(1), used)
+	assert.True(t, reset.After(time.Now()))
+
+	// Test: Ended windows of other keys are dropped
+	time.Sleep(30 * time.Millisecond)
+	s.Add("b", 1, time.Minute)
+	assert.Len(t, s.windows, 1)
+}
+
+func TestQuotaCountsBytes(t *testing.T) {
+	// Test: Usage is the bytes read and written, heads included
+	handler := Quota(QuotaConfig{Limit: 10000, Window: time.Hour})(ok)
+	raw := "GET / HTTP/1.1\r\n\r\n"
+	first := run(t, handler, raw)
+	second := run(t, handler, raw)
+	assert.Contains(t, second, fmt.Sprintf("x-ratelimit-remaining: %d\r\n", 10000-2*len(raw)-len(first)))
+
+	// Test: A declared body counts before the handler runs
+	handler = Quota(QuotaConfig{Limit: 10000, Window: time.Hour})(ok)
+	raw = "POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"
+	out := run(t, handler, raw)
+	assert.Contains(t, out, fmt.Sprintf("x-ratelimit-remaining: %d\r\n", 10000-len(raw)))
+}
+
+func TestQuotaConcurrent(t *testing.T) {
+	// Test: Requests in flight together count against each other, so with
+	// room for one request only one is served
+	raw := "GET / HTTP/1.1\r\n\r\n"
+	release := make(chan struct{})
+	handler := Quota(QuotaConfig{Limit: int64(len(raw)), Window: time.Hour})(func(w *response.Writer, req *request.Request) {
+		<-release
+		ok(w, req)
+	})
+	outs := make(chan string, 5)
+	for range 5 {
+		go func() { outs <- run(t, handler, raw) }()
+	}
+	for range 4 {
+		out := <-outs
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 429 Too Many Requests\r\n"), out)
+	}
+	close(release)
+	assert.True(t, strings.HasPrefix(<-outs, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestQuotaConfig(t *testing.T) {
+	// Test: A quota of nothing is refused when the middleware is built
+	assert.Panics(t, func() { Quota(QuotaConfig{Window: time.Hour}) })
+
+	// Test: The window defaults to an hour
+	out := run(t, Quota(QuotaConfig{Limit: 1000})(ok), "GET / HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "x-ratelimit-reset: 3600\r\n")
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+type RequestIDConfig struct {
+	// Header carries the ID both ways, X-Request-ID when empty.
+	Header string
+	// Trusted reports whether an ID the client sent may be kept, such as
+	// for requests from a load balancer that set one. When nil, or false,
+	// a fresh ID replaces it.
+	Trusted func(req *request.Request) bool
+	// Generate makes new IDs; the default is 16 random bytes in hex.
+	Generate func() string
+}
+
+// RequestID gives each request an ID, kept on its context for request.ID,
+// logs and error pages, sent back in the response and forwarded upstream by
+// the proxy.
+func RequestID(config RequestIDConfig) server.Middleware {
+	if config.Header == "" {
+		config.Header = "X-Request-ID"
+	}
+	if config.Generate == nil {
+		config.Generate = func() string {
+			b := make([]byte, 16)
+			rand.Read(b)
+			return hex.EncodeToString(b)
+		}
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			id, ok := req.Headers.Get(config.Header)
+			if !ok || !validID(id) || config.Trusted == nil || !config.Trusted(req) {
+				id = config.Generate()
+				req.Headers.Replace(config.Header, id)
+			}
+			w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+				setDefault(h, config.Header, id)
+			})
+			next(w, req.WithContext(request.WithID(req.Context(), id)))
+		}
+	}
+}
+
+// validID keeps IDs short and printable, so a client cannot push arbitrary
+// bytes into logs.
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
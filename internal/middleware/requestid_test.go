@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+func TestRequestID(t *testing.T) {
+	seen := ""
+	n := 0
+	config := RequestIDConfig{Generate: func() string {
+		n++
+		return strings.Repeat("a", n)
+	}}
+	handler := RequestID(config)(func(w *response.Writer, req *request.Request) {
+		seen = req.ID()
+		ok(w, req)
+	})
+
+	// Test: A fresh ID goes on the context and the response
+	out := run(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "a", seen)
+	assert.Contains(t, out, "x-request-id: a\r\n")
+
+	// Test: Incoming IDs are replaced unless their source is trusted
+	out = run(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: client\r\n\r\n")
+	assert.Equal(t, "aa", seen)
+	config.Trusted = func(req *request.Request) bool { return true }
+	handler = RequestID(config)(func(w *response.Writer, req *request.Request) {
+		seen = req.ID()
+		ok(w, req)
+	})
+	out = run(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: client\r\n\r\n")
+	assert.Equal(t, "client", seen)
+	assert.Contains(t, out, "x-request-id: client\r\n")
+
+	// Test: Even trusted IDs must be short and printable
+	run(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: "+strings.Repeat("x", 200)+"\r\n\r\n")
+	assert.Equal(t, "aaa", seen)
+
+	// Test: Error pages carry the ID
+	handler = RequestID(config)(func(w *response.Writer, req *request.Request) {
+		server.Error(w, req, response.StatusNotFound, "")
+	})
+	out = run(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-ID: oops\r\n\r\n")
+	assert.Contains(t, out, "Not Found\nRequest ID: oops\n")
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	handler := SecurityHeaders(DefaultSecurityHeaders())(ok)
+
+	// Test: Defaults are applied, but no HSTS over plain HTTP
+	out := run(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, out, "x-content-type-options: nosniff\r\n")
+	assert.Contains(t, out, "x-frame-options: DENY\r\n")
+	assert.Contains(t, out, "content-security-policy: frame-ancestors 'none'\r\n")
+	assert.Contains(t, out, "referrer-policy: strict-origin-when-cross-origin\r\n")
+	assert.NotContains(t, out, "strict-transport-security")
+
+	// Test: HSTS is sent over TLS
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	req.TLS = &tls.ConnectionState{}
+	out = runRequest(handler, req)
+	assert.Contains(t, out, "strict-transport-security: max-age=31536000; includeSubDomains\r\n")
+
+	// Test: Handler-set headers win and CSP is extended
+	config := DefaultSecurityHeaders()
+	config.FrameOptions = ""
+	handler = SecurityHeaders(config)(func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Replace("Referrer-Policy", "no-referrer")
+		h.Replace("Content-Security-Policy", "default-src 'self';")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+	})
+	out = run(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, out, "referrer-policy: no-referrer\r\n")
+	assert.Contains(t, out, "content-security-policy: default-src 'self'; frame-ancestors 'none'\r\n")
+	assert.NotContains(t, out, "x-frame-options")
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// ERROR_HANDLER_TIMEOUT is what a handler's writes return once Timeout has
+// answered for it.
+var ERROR_HANDLER_TIMEOUT = fmt.Errorf("handler timed out")
+
+// Timeout gives next d to answer, through a request context that is done
+// when d passes. Its response is held back until it returns; if d passes
+// first the client gets a 503 instead and whatever next writes later is
+// dropped, so a stuck handler cannot hold the connection. A spilled request
+// body stays readable until next returns. Responses are buffered whole, so
+// wrap only handlers that do not stream.
+func Timeout(d time.Duration) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			ctx, cancel := context.WithTimeout(req.Context(), d)
+			defer cancel()
+			out := &timeoutBuffer{}
+			done := make(chan struct{})
+			var panicked *handlerPanic
+			// The server closes the request when this returns, which may be
+			// before next does; the body stays readable until then.
+			release := req.Hold()
+			go func() {
+				defer close(done)
+				defer release()
+				defer func() {
+					if p := recover(); p != nil {
+						panicked = &handlerPanic{value: p, stack: debug.Stack()}
+						if ctx.Err() != nil {
+							// Nobody is left to raise it again.
+							log.Printf("middleware: panic after timeout serving %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, panicked)
+						}
+					}
+				}()
+				next(response.NewWriter(out), req.WithContext(ctx))
+			}()
+
+			select {
+			case <-done:
+				if panicked != nil {
+					// Raised again where it would have been without Timeout,
+					// carrying the stack of the handler's goroutine.
+					panic(*panicked)
+				}
+				replay(w, out.Bytes())
+			case <-ctx.Done():
+				out.discard()
+				if req.Context().Err() != nil {
+					// The client is gone; nobody is waiting for an answer.
+					return
+				}
+				server.Error(w, req, response.StatusServiceUnavailable, "")
+			}
+		}
+	}
+}
+
+// handlerPanic is a panic in a handler behind Timeout, raised again outside
+// the handler's goroutine. Printed, it shows where the panic happened.
+type handlerPanic struct {
+	value any
+	stack []byte
+}
+
+func (p handlerPanic) String() string {
+	return fmt.Sprintf("%v\n\nhandler goroutine:\n%s", p.value, p.stack)
+}
+
+// timeoutBuffer collects a handler's response until Timeout gives up on it.
+type timeoutBuffer struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	timedOut bool
+}
+
+func (b *timeoutBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timedOut {
+		return 0, ERROR_HANDLER_TIMEOUT
+	}
+	return b.buf.Write(p)
+}
+
+func (b *timeoutBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+func (b *timeoutBuffer) discard() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timedOut = true
+	b.buf = bytes.Buffer{}
+}
+
+// replay writes a buffered response to w, the head through w so its
+// OnHeaders hooks run, and the body as it was framed.
+func replay(w *response.Writer, raw []byte) {
+	if len(raw) == 0 {
+		return
+	}
+	r := bytes.NewReader(raw)
+	// Parsed as an answer to HEAD, the parser stops after the headers.
+	parser := response.NewParser(r, response.ParseOptions{Method: "HEAD"})
+	res, err := parser.Next()
+	if err != nil {
+		w.WriteStatusLine(response.StatusInternalServeError)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+		return
+	}
+	w.WriteStatusLine(res.StatusLine.StatusCode)
+	w.WriteHeaders(*res.Headers)
+	w.Write(parser.TakeBuffered())
+	io.Copy(w, r)
+}
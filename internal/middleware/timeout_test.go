@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestTimeout(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: A handler within its deadline answers as usual, hooks included
+	handler := SecurityHeaders(DefaultSecurityHeaders())(Timeout(time.Second)(ok))
+	out := run(t, handler, raw)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "x-frame-options: DENY\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nok"))
+
+	// Test: Chunked bodies and trailers pass through as framed
+	out = run(t, Timeout(time.Second)(func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("content-length")
+		h.Replace("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteChunkedBody([]byte("hi"))
+		w.WriteBody([]byte("0\r\n"))
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Sum", "1")
+		w.WriteHeaders(*trailers)
+	}), raw)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n2\r\nhi\r\n0\r\nx-sum: 1\r\n\r\n"), out)
+
+	// Test: A handler past its deadline gets a 503 sent for it, and its
+	// late writes fail without reaching the client
+	late := make(chan error, 1)
+	out = run(t, Timeout(20*time.Millisecond)(func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		late <- w.WriteStatusLine(response.StatusOK)
+	}), raw)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.ErrorIs(t, <-late, ERROR_HANDLER_TIMEOUT)
+	assert.NotContains(t, out, "200 OK")
+}
+
+func TestTimeoutSpilledBody(t *testing.T) {
+	dir := t.TempDir()
+	body := strings.Repeat("x", 64)
+	raw := fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	req, err := request.RequestFromReaderWithOptions(strings.NewReader(raw), request.Options{SpillThreshold: 16, SpillDir: dir})
+	require.NoError(t, err)
+	files := func() int {
+		entries, _ := os.ReadDir(dir)
+		return len(entries)
+	}
+
+	// Test: A handler abandoned by the timeout still reads the whole body
+	// after the server closes the request
+	proceed := make(chan struct{})
+	read := make(chan string, 1)
+	out := runRequest(Timeout(20*time.Millisecond)(func(w *response.Writer, req *request.Request) {
+		<-proceed
+		got, _ := io.ReadAll(req.BodyReader())
+		read <- string(got)
+	}), req)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"))
+	require.NoError(t, req.Close())
+	assert.Equal(t, 1, files())
+	close(proceed)
+	assert.Equal(t, body, <-read)
+
+	// Test: The file is removed once the handler returns
+	assert.Eventually(t, func() bool { return files() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: A panic within the deadline is raised again with its value and
+	// the stack of the handler that raised it
+	var recovered any
+	func() {
+		defer func() { recovered = recover() }()
+		run(t, Timeout(time.Second)(panickingHandler), raw)
+	}()
+	require.NotNil(t, recovered)
+	printed := fmt.Sprint(recovered)
+	assert.Contains(t, printed, "boom")
+	assert.Contains(t, printed, "panickingHandler")
+}
+
+func panickingHandler(w *response.Writer, req *request.Request) {
+	panic("boom")
+}
@@ -0,0 +1,249 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+var ERROR_MALFORMED_RESPONSE = fmt.Errorf("malformed ocsp response")
+var ERROR_RESPONSE_STATUS = fmt.Errorf("ocsp responder returned an error status")
+var ERROR_WRONG_CERTIFICATE = fmt.Errorf("ocsp response is for a different certificate")
+var ERROR_BAD_SIGNATURE = fmt.Errorf("ocsp response signature does not verify")
+
+type Status int
+
+const (
+	Good Status = iota
+	Revoked
+	Unknown
+)
+
+var (
+	oidSHA1            = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidBasicResponse   = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	signatureAlgorithm = map[string]x509.SignatureAlgorithm{
+		"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+		"1.3.101.112":           x509.PureEd25519,
+	}
+)
+
+type certID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type request struct {
+	TBSRequest struct {
+		RequestList []struct {
+			Cert certID
+		}
+	}
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type response struct {
+	Status        asn1.Enumerated
+	ResponseBytes responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type basicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []singleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type singleResponse struct {
+	CertID     certID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    revokedInfo      `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// Response is the part of an OCSP response a stapler cares about. Raw is
+// what gets stapled.
+type Response struct {
+	Status     Status
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	Raw        []byte
+}
+
+func newCertID(cert, issuer *x509.Certificate) (certID, error) {
+	spki := struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return certID{}, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return certID{
+		HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		IssuerNameHash: nameHash[:],
+		IssuerKeyHash:  keyHash[:],
+		SerialNumber:   cert.SerialNumber,
+	}, nil
+}
+
+// CreateRequest encodes a DER OCSP request for cert.
+func CreateRequest(cert, issuer *x509.Certificate) ([]byte, error) {
+	id, err := newCertID(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	req := request{}
+	req.TBSRequest.RequestList = append(req.TBSRequest.RequestList, struct{ Cert certID }{id})
+	return asn1.Marshal(req)
+}
+
+// ParseResponse decodes der and checks it is a signed answer about cert,
+// signed by issuer or by a responder issuer delegated to.
+func ParseResponse(der []byte, cert, issuer *x509.Certificate) (*Response, error) {
+	resp := response{}
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil || len(rest) > 0 {
+		return nil, ERROR_MALFORMED_RESPONSE
+	}
+	if resp.Status != 0 {
+		return nil, fmt.Errorf("%w: %d", ERROR_RESPONSE_STATUS, resp.Status)
+	}
+	if !resp.ResponseBytes.ResponseType.Equal(oidBasicResponse) {
+		return nil, ERROR_MALFORMED_RESPONSE
+	}
+
+	basic := basicResponse{}
+	if _, err := asn1.Unmarshal(resp.ResponseBytes.Response, &basic); err != nil {
+		return nil, ERROR_MALFORMED_RESPONSE
+	}
+	data := responseData{}
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, ERROR_MALFORMED_RESPONSE
+	}
+	if err := verify(basic, issuer); err != nil {
+		return nil, err
+	}
+
+	want, err := newCertID(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	for _, single := range data.Responses {
+		id := single.CertID
+		if id.SerialNumber.Cmp(want.SerialNumber) != 0 ||
+			!bytes.Equal(id.IssuerNameHash, want.IssuerNameHash) ||
+			!bytes.Equal(id.IssuerKeyHash, want.IssuerKeyHash) {
+			continue
+		}
+
+		r := &Response{
+			Status:     Unknown,
+			ThisUpdate: single.ThisUpdate,
+			NextUpdate: single.NextUpdate,
+			Raw:        der,
+		}
+		switch {
+		case bool(single.Good):
+			r.Status = Good
+		case !single.Revoked.RevocationTime.IsZero():
+			r.Status = Revoked
+		}
+		return r, nil
+	}
+	return nil, ERROR_WRONG_CERTIFICATE
+}
+
+func verify(basic basicResponse, issuer *x509.Certificate) error {
+	alg, ok := signatureAlgorithm[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return ERROR_BAD_SIGNATURE
+	}
+
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		responder, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return ERROR_MALFORMED_RESPONSE
+		}
+		if !bytes.Equal(responder.Raw, issuer.Raw) {
+			if responder.CheckSignatureFrom(issuer) != nil || !hasEKU(responder, x509.ExtKeyUsageOCSPSigning) {
+				return ERROR_BAD_SIGNATURE
+			}
+			signer = responder
+		}
+	}
+	if signer.CheckSignature(alg, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()) != nil {
+		return ERROR_BAD_SIGNATURE
+	}
+	return nil
+}
+
+func hasEKU(cert *x509.Certificate, eku x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == eku {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch asks the certificate's OCSP responder about it.
+func Fetch(client *http.Client, cert, issuer *x509.Certificate) (*Response, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, fmt.Errorf("certificate %s has no ocsp responder", cert.Subject.CommonName)
+	}
+	body, err := CreateRequest(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("ocsp responder returned status %d", res.StatusCode)
+	}
+	der, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return ParseResponse(der, cert, issuer)
+}
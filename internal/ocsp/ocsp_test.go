@@ -0,0 +1,204 @@
+package ocsp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pki struct {
+	caKey   *ecdsa.PrivateKey
+	ca      *x509.Certificate
+	leafKey *ecdsa.PrivateKey
+	leaf    *x509.Certificate
+}
+
+func newPKI(t *testing.T, responder string) pki {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{responder},
+	}, ca, &leafKey.PublicKey, caKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return pki{caKey: caKey, ca: ca, leafKey: leafKey, leaf: leaf}
+}
+
+// respond builds a response signed by the CA, the way a responder would.
+func (p pki) respond(t *testing.T, status Status, thisUpdate, nextUpdate time.Time) []byte {
+	id, err := newCertID(p.leaf, p.ca)
+	require.NoError(t, err)
+
+	certStatus := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0}
+	if status == Revoked {
+		revokedAt, err := asn1.MarshalWithParams(thisUpdate, "generalized")
+		require.NoError(t, err)
+		certStatus = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: revokedAt}
+	}
+	keyHash, err := asn1.Marshal(id.IssuerKeyHash)
+	require.NoError(t, err)
+
+	tbs, err := asn1.Marshal(struct {
+		ResponderID asn1.RawValue
+		ProducedAt  time.Time `asn1:"generalized"`
+		Responses   []struct {
+			CertID     certID
+			Status     asn1.RawValue
+			ThisUpdate time.Time `asn1:"generalized"`
+			NextUpdate time.Time `asn1:"generalized,explicit,tag:0"`
+		}
+	}{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: keyHash},
+		ProducedAt:  thisUpdate,
+		Responses: []struct {
+			CertID     certID
+			Status     asn1.RawValue
+			ThisUpdate time.Time `asn1:"generalized"`
+			NextUpdate time.Time `asn1:"generalized,explicit,tag:0"`
+		}{{id, certStatus, thisUpdate, nextUpdate}},
+	})
+	require.NoError(t, err)
+
+	digest := sha256.Sum256(tbs)
+	sig, err := ecdsa.SignASN1(rand.Reader, p.caKey, digest[:])
+	require.NoError(t, err)
+	basic, err := asn1.Marshal(basicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+	})
+	require.NoError(t, err)
+	der, err := asn1.Marshal(response{ResponseBytes: responseBytes{ResponseType: oidBasicResponse, Response: basic}})
+	require.NoError(t, err)
+	return der
+}
+
+func TestParseResponse(t *testing.T) {
+	p := newPKI(t, "http://ocsp.invalid")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	// Test: A good response round-trips
+	r, err := ParseResponse(p.respond(t, Good, now, now.Add(time.Hour)), p.leaf, p.ca)
+	require.NoError(t, err)
+	assert.Equal(t, Good, r.Status)
+	assert.Equal(t, now.Add(time.Hour), r.NextUpdate.UTC())
+
+	// Test: Revocations are reported
+	r, err = ParseResponse(p.respond(t, Revoked, now, now.Add(time.Hour)), p.leaf, p.ca)
+	require.NoError(t, err)
+	assert.Equal(t, Revoked, r.Status)
+
+	// Test: Responses signed by someone else are rejected
+	other := newPKI(t, "http://ocsp.invalid")
+	_, err = ParseResponse(p.respond(t, Good, now, now.Add(time.Hour)), p.leaf, other.ca)
+	assert.Error(t, err)
+
+	// Test: Garbage is rejected
+	_, err = ParseResponse([]byte("nope"), p.leaf, p.ca)
+	assert.ErrorIs(t, err, ERROR_MALFORMED_RESPONSE)
+}
+
+func TestStapler(t *testing.T) {
+	var p pki
+	status := Good
+	requests := 0
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := io.ReadAll(r.Body)
+		req := request{}
+		_, err := asn1.Unmarshal(body, &req)
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), req.TBSRequest.RequestList[0].Cert.SerialNumber.Int64())
+
+		now := time.Now().UTC().Truncate(time.Second)
+		w.Write(p.respond(t, status, now, now.Add(4*time.Hour)))
+	}))
+	defer responder.Close()
+	p = newPKI(t, responder.URL)
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{p.leaf.Raw, p.ca.Raw},
+		PrivateKey:  p.leafKey,
+	}
+	s, err := NewStapler([]tls.Certificate{cert})
+	require.NoError(t, err)
+
+	// Test: The response is stapled and refreshed halfway to NextUpdate
+	s.Refresh()
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), s.certs[0].refreshAt, time.Minute)
+	assert.Equal(t, 1, requests)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: s.GetCertificate})
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(p.ca)
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	require.NoError(t, err)
+	stapledResponse := conn.ConnectionState().OCSPResponse
+	conn.Close()
+	r, err := ParseResponse(stapledResponse, p.leaf, p.ca)
+	require.NoError(t, err)
+	assert.Equal(t, Good, r.Status)
+
+	// Test: Nothing is fetched before the refresh time
+	s.Refresh()
+	assert.Equal(t, 1, requests)
+
+	// Test: A revoked status is never stapled
+	status = Revoked
+	s.certs[0].refreshAt = time.Time{}
+	s.Refresh()
+	got, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "localhost", Conn: &net.TCPConn{}})
+	require.NoError(t, err)
+	assert.Nil(t, got.OCSPStaple)
+}
@@ -0,0 +1,162 @@
+package ocsp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	retryInterval   = time.Minute
+	defaultLifetime = time.Hour
+)
+
+type stapled struct {
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+	// current is the certificate as handed to TLS, with the staple if one
+	// is valid.
+	current   *tls.Certificate
+	base      tls.Certificate
+	response  *Response
+	refreshAt time.Time
+}
+
+// Stapler keeps fresh OCSP responses for a set of certificates and staples
+// them in the handshake via GetCertificate. Certificates whose chain lacks
+// an issuer, or whose responder can't be reached, are served unstapled.
+type Stapler struct {
+	client *http.Client
+	now    func() time.Time
+
+	mu    sync.RWMutex
+	certs []*stapled
+}
+
+func NewStapler(certs []tls.Certificate) (*Stapler, error) {
+	s := &Stapler{
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+	for _, cert := range certs {
+		st := &stapled{base: cert}
+		st.current = &st.base
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		st.leaf = leaf
+		if len(cert.Certificate) > 1 {
+			if st.issuer, err = x509.ParseCertificate(cert.Certificate[1]); err != nil {
+				return nil, err
+			}
+		}
+		s.certs = append(s.certs, st)
+	}
+	return s, nil
+}
+
+// Refresh fetches responses that are due, and returns when the next one
+// will be.
+func (s *Stapler) Refresh() time.Time {
+	now := s.now()
+	next := now.Add(defaultLifetime)
+
+	for _, st := range s.certs {
+		if st.issuer == nil || len(st.leaf.OCSPServer) == 0 {
+			continue
+		}
+		if st.refreshAt.After(now) {
+			next = minTime(next, st.refreshAt)
+			continue
+		}
+
+		resp, err := Fetch(s.client, st.leaf, st.issuer)
+		s.mu.Lock()
+		switch {
+		case err != nil:
+			log.Printf("ocsp: %s: %v", st.leaf.Subject.CommonName, err)
+			st.refreshAt = now.Add(retryInterval)
+		case resp.Status != Good:
+			log.Printf("ocsp: %s: certificate status is not good, not stapling", st.leaf.Subject.CommonName)
+			st.response = nil
+			st.refreshAt = now.Add(retryInterval)
+		default:
+			st.response = resp
+			// Refresh halfway through the validity window, like most
+			// servers, so a flaky responder has time to recover.
+			st.refreshAt = now.Add(defaultLifetime)
+			if !resp.NextUpdate.IsZero() {
+				st.refreshAt = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+			}
+			st.refreshAt = maxTime(st.refreshAt, now.Add(retryInterval))
+		}
+		st.current = s.staple(st, now)
+		s.mu.Unlock()
+
+		next = minTime(next, st.refreshAt)
+	}
+	return next
+}
+
+// staple builds the certificate to serve, dropping responses that have
+// expired while the responder was unreachable.
+func (s *Stapler) staple(st *stapled, now time.Time) *tls.Certificate {
+	if st.response == nil || (!st.response.NextUpdate.IsZero() && now.After(st.response.NextUpdate)) {
+		return &st.base
+	}
+	cert := st.base
+	cert.OCSPStaple = st.response.Raw
+	return &cert
+}
+
+// Start refreshes in the background until stop is called.
+func (s *Stapler) Start() (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			timer := time.NewTimer(time.Until(s.Refresh()))
+			select {
+			case <-timer.C:
+			case <-done:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate.
+func (s *Stapler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.certs) == 0 {
+		return nil, nil
+	}
+	for _, st := range s.certs {
+		if hello.SupportsCertificate(st.current) == nil {
+			return st.current, nil
+		}
+	}
+	return s.certs[0].current, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
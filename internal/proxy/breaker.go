@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is the circuit for one upstream. It opens after MaxFailures
+// failures in a row, counting answers slower than SlowThreshold, and stays
+// open for FailTimeout; then a single probe request is let through, which
+// closes it again on success or reopens it on failure.
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	until    time.Time
+	probing  bool
+}
+
+// allow reports whether a request may go to the upstream now, taking the
+// probe slot when the circuit is half-open.
+func (b *breaker) allow(r *route, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < r.maxFailures() {
+		return true
+	}
+	if now.Before(b.until) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record notes how a request to the upstream went, reporting whether the
+// circuit opened or closed because of it.
+func (b *breaker) record(r *route, ok bool, took time.Duration) (changed, open bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok && r.SlowThreshold > 0 && took > r.SlowThreshold {
+		ok = false
+	}
+	wasOpen := b.failures >= r.maxFailures()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return wasOpen, false
+	}
+	b.failures++
+	if b.failures >= r.maxFailures() {
+		b.until = time.Now().Add(r.failTimeout())
+		return !wasOpen, true
+	}
+	return false, false
+}
+
+// release gives back a probe slot taken by a request whose outcome says
+// nothing about the upstream, such as one the client canceled.
+func (b *breaker) release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (r *route) maxFailures() int {
+	if r.MaxFailures <= 0 {
+		return 1
+	}
+	return r.MaxFailures
+}
+
+func (r *route) failTimeout() time.Duration {
+	if r.FailTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return r.FailTimeout
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// tunnel answers CONNECT host:port by dialing the target and splicing the
+// two connections together until either side is done.
+func tunnel(w *response.Writer, req *request.Request) {
+	addr := req.RequestLine.RequestTarget
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		server.Error(w, req, response.StatusBadRequest, "")
+		return
+	}
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	upstream, err := dialer.DialContext(req.Context(), "tcp", addr)
+	if err != nil {
+		server.Error(w, req, response.StatusBadGateway, "")
+		return
+	}
+	defer upstream.Close()
+
+	conn, err := server.Hijack(req)
+	if err != nil {
+		server.Error(w, req, response.StatusInternalServeError, "")
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+	splice(conn, upstream)
+}
+
+// splice copies both ways, passing on each side's end of stream as a
+// half-close so the other can finish sending.
+func splice(a, b net.Conn) {
+	wg := sync.WaitGroup{}
+	copyHalf := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Go(func() { copyHalf(a, b) })
+	wg.Go(func() { copyHalf(b, a) })
+	wg.Wait()
+}
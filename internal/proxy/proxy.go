@@ -0,0 +1,450 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"tcp.to.http/internal/chunked"
+	"tcp.to.http/internal/client"
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+	"tcp.to.http/internal/spill"
+)
+
+var ERROR_NO_UPSTREAMS = fmt.Errorf("route has no upstreams")
+
+// hopByHop headers describe one connection and are not forwarded.
+var hopByHop = []string{"connection", "keep-alive", "proxy-connection", "proxy-authenticate", "proxy-authorization", "te", "trailer", "transfer-encoding", "upgrade"}
+
+// Route sends requests for Prefix and the paths under it to its upstreams
+// in turn.
+type Route struct {
+	Prefix string
+	// Upstreams are base URLs such as http://10.0.0.1:8080; the request path
+	// is appended to theirs.
+	Upstreams []string
+
+	// StripPrefix is cut from the front of the path, then Rewrites apply in
+	// order, then AddPrefix is put in front.
+	StripPrefix string
+	Rewrites    []Rewrite
+	AddPrefix   string
+
+	SetRequestHeaders     map[string]string
+	RemoveRequestHeaders  []string
+	SetResponseHeaders    map[string]string
+	RemoveResponseHeaders []string
+
+	// Transforms rewrite response bodies in order as they stream through.
+	Transforms []Transform
+
+	// Retries is how many more upstreams an idempotent request may try when
+	// one cannot be reached or answers 502 or 503; running out of them is a
+	// 504.
+	Retries int
+	// An upstream's circuit opens after MaxFailures failures in a row, 1 by
+	// default, where answers slower than SlowThreshold count as failures
+	// when it is set. It is then passed over, while others are left, for
+	// FailTimeout, 10 seconds by default, after which one request probes
+	// whether it has recovered.
+	MaxFailures   int
+	SlowThreshold time.Duration
+	FailTimeout   time.Duration
+
+	// Buffer reads each upstream response whole before answering, which
+	// sends it with a Content-Length and lets a body that breaks off be
+	// retried like a failed request. Bodies past BufferMemory bytes, 1 MiB
+	// by default, are kept in a temporary file. Otherwise responses stream
+	// as they arrive.
+	Buffer       bool
+	BufferMemory int64
+}
+
+// Rewrite replaces matches of the regular expression Pattern in the path with
+// Replacement, which may refer to groups as $1.
+type Rewrite struct {
+	Pattern     string
+	Replacement string
+}
+
+type Config struct {
+	// Routes are matched by longest Prefix.
+	Routes []Route
+	// Client sends the upstream requests. The default passes redirects back
+	// to the client instead of following them.
+	Client *client.Client
+	// Connect makes the proxy open CONNECT tunnels to any host:port too.
+	Connect bool
+	// Trailers, when set, is given the trailers of each upstream response
+	// that had any, such as for logging.
+	Trailers func(req *request.Request, trailers *headers.Headers)
+	// Circuit, when set, is told each time an upstream's circuit opens or
+	// closes.
+	Circuit func(upstream string, open bool)
+}
+
+type route struct {
+	Route
+	upstreams []*url.URL
+	rewrites  []*regexp.Regexp
+	next      atomic.Uint64
+	breakers  []breaker
+}
+
+// upstream picks the next upstream in turn that is not in tried and whose
+// circuit lets the request through, or just the next one when there is none
+// such.
+func (r *route) upstream(tried map[int]bool) (int, *url.URL) {
+	start := r.next.Add(1) - 1
+	now := time.Now()
+	for k := range uint64(len(r.upstreams)) {
+		i := int((start + k) % uint64(len(r.upstreams)))
+		if !tried[i] && r.breakers[i].allow(r, now) {
+			return i, r.upstreams[i]
+		}
+	}
+	i := int(start % uint64(len(r.upstreams)))
+	return i, r.upstreams[i]
+}
+
+// record feeds how a request to upstream i went into its circuit.
+func (r *route) record(config Config, i int, ok bool, took time.Duration) {
+	changed, open := r.breakers[i].record(r, ok, took)
+	if changed && config.Circuit != nil {
+		config.Circuit(r.upstreams[i].String(), open)
+	}
+}
+
+// unavailable responses say the upstream could not serve the request, so
+// another may.
+func unavailable(res *client.Response) bool {
+	return res.StatusCode == response.StatusBadGateway || res.StatusCode == response.StatusServiceUnavailable
+}
+
+// idempotent methods can be sent again after a failure without doing twice
+// what the client asked once.
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// target is the request target sent upstream, with the route's path rules
+// applied and the query kept.
+func (r *route) target(req *request.Request) string {
+	path, query, hasQuery := strings.Cut(req.RequestLine.RequestTarget, "?")
+	path = strings.TrimPrefix(path, r.StripPrefix)
+	for i, re := range r.rewrites {
+		path = re.ReplaceAllString(path, r.Rewrites[i].Replacement)
+	}
+	path = r.AddPrefix + path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if hasQuery {
+		path += "?" + query
+	}
+	return path
+}
+
+// Handler is a reverse proxy for config.Routes, and a forward proxy for
+// CONNECT when config.Connect is set.
+func Handler(config Config) (server.Handler, error) {
+	if config.Client == nil {
+		config.Client = &client.Client{Redirects: client.RedirectPolicy{MaxHops: -1}}
+	}
+	routes := []*route{}
+	for _, r := range config.Routes {
+		if len(r.Upstreams) == 0 {
+			return nil, fmt.Errorf("%w: %s", ERROR_NO_UPSTREAMS, r.Prefix)
+		}
+		rt := &route{Route: r}
+		for _, raw := range r.Upstreams {
+			u, err := url.Parse(raw)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", r.Prefix, err)
+			}
+			if u.Scheme != "http" && u.Scheme != "https" {
+				return nil, fmt.Errorf("route %s: %w", r.Prefix, client.ERROR_UNSUPPORTED_SCHEME)
+			}
+			rt.upstreams = append(rt.upstreams, u)
+		}
+		for _, rw := range r.Rewrites {
+			re, err := regexp.Compile(rw.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", r.Prefix, err)
+			}
+			rt.rewrites = append(rt.rewrites, re)
+		}
+		rt.breakers = make([]breaker, len(rt.upstreams))
+		routes = append(routes, rt)
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "CONNECT" {
+			if !config.Connect {
+				server.Error(w, req, response.StatusMethodNotAllowed, "")
+				return
+			}
+			tunnel(w, req)
+			return
+		}
+
+		path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+		var best *route
+		for _, r := range routes {
+			if underPrefix(path, r.Prefix) && (best == nil || len(r.Prefix) > len(best.Prefix)) {
+				best = r
+			}
+		}
+		if best == nil {
+			server.Error(w, req, response.StatusNotFound, "")
+			return
+		}
+		if isWebSocket(req) {
+			websocket(w, req, config, best)
+			return
+		}
+		forward(w, req, config, best)
+	}, nil
+}
+
+// underPrefix reports whether path is prefix or below it, matching whole
+// segments: "/api" covers "/api/items" but not "/apix".
+func underPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func forward(w *response.Writer, req *request.Request, config Config, r *route) {
+	attempts := 1
+	if idempotent(req.RequestLine.Method) {
+		attempts += r.Retries
+	}
+	tried := map[int]bool{}
+	var res *client.Response
+	var buffered *spill.Buffer
+	var err error
+	for attempt := 1; ; attempt++ {
+		i, upstream := r.upstream(tried)
+		tried[i] = true
+		var out *client.Request
+		out, err = client.NewRequest(req.Context(), req.RequestLine.Method,
+			strings.TrimSuffix(upstream.String(), "/")+r.target(req), []byte(req.Body))
+		if err != nil {
+			server.Error(w, req, response.StatusBadRequest, "")
+			return
+		}
+		if req.Body == "" && req.BodyLen() > 0 {
+			// Spilled to disk: stream it rather than read it back into memory.
+			out.BodyReader, out.ContentLength = req.BodyReader(), req.BodyLen()
+		}
+		out.Headers = outgoing(req, r)
+
+		start := time.Now()
+		res, err = config.Client.Do(out)
+		if err != nil && req.Context().Err() != nil {
+			r.breakers[i].release()
+			break
+		}
+		ok := err == nil && !unavailable(res)
+		if ok && r.Buffer {
+			buffered, err = r.buffer(res.Body)
+			res.Body.Close()
+			ok = err == nil
+		}
+		r.record(config, i, ok, time.Since(start))
+		if ok {
+			break
+		}
+		if attempt == attempts {
+			break
+		}
+		if err == nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+	}
+	if err == nil && attempts > 1 && unavailable(res) {
+		res.Body.Close()
+		server.Error(w, req, response.StatusGatewayTimeout, "")
+		return
+	}
+	if err != nil {
+		status := response.StatusBadGateway
+		var ne net.Error
+		if attempts > 1 || errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+			status = response.StatusGatewayTimeout
+		}
+		server.Error(w, req, status, "")
+		return
+	}
+	defer res.Body.Close()
+
+	h := res.Headers.Clone()
+	removeHopByHop(h)
+	rewrite(h, r.SetResponseHeaders, r.RemoveResponseHeaders)
+	bodyless := req.RequestLine.Method == "HEAD" || res.StatusCode == response.StatusNoContent || res.StatusCode == response.StatusNotModified
+	var body io.Reader = res.Body
+	if buffered != nil {
+		defer buffered.Close()
+		body = buffered.Reader()
+		if trailers := upstreamTrailers(res); trailers != nil && config.Trailers != nil {
+			config.Trailers(req, trailers)
+		}
+	}
+	if !bodyless {
+		var taken []io.Reader
+		body, taken = transform(r.Transforms, req, h, body)
+		for _, t := range taken {
+			if c, ok := t.(io.Closer); ok {
+				defer c.Close()
+			}
+		}
+		if len(taken) > 0 {
+			h.Delete("content-length")
+		}
+		if buffered != nil {
+			if len(taken) > 0 {
+				// The transformed length is only known once it is all read.
+				out, err := r.buffer(body)
+				if err != nil {
+					server.Error(w, req, response.StatusBadGateway, "")
+					return
+				}
+				defer out.Close()
+				buffered, body = out, out.Reader()
+			}
+			h.Replace("Content-Length", strconv.FormatInt(buffered.Len(), 10))
+		}
+	}
+	_, sized := h.Get("content-length")
+	// Trailers only reach the client on a chunked body, and only unaltered
+	// ones: a transform would make checksums among them wrong.
+	forwardTrailers := !sized && !bodyless && body == io.Reader(res.Body) && w.SendsTrailers()
+	if !sized && !bodyless {
+		h.Replace("Transfer-Encoding", "chunked")
+		if declared, ok := res.Headers.Get("trailer"); ok && forwardTrailers {
+			h.Replace("Trailer", declared)
+		}
+	}
+	w.WriteStatusLine(res.StatusCode)
+	w.WriteHeaders(*h)
+	if bodyless {
+		return
+	}
+	if sized {
+		io.Copy(w, body)
+		return
+	}
+	cw := chunked.NewWriter(w)
+	if _, err := io.Copy(cw, body); err != nil {
+		// Ending the chunked body here would pass off a truncated response as
+		// complete; leaving it unterminated lets the client see the failure.
+		return
+	}
+	trailers := upstreamTrailers(res)
+	if trailers != nil && config.Trailers != nil {
+		config.Trailers(req, trailers)
+	}
+	if !forwardTrailers {
+		trailers = nil
+	}
+	cw.CloseWithTrailers(trailers)
+}
+
+// upstreamTrailers are the trailers of res that may be passed on, nil when
+// there are none.
+func upstreamTrailers(res *client.Response) *headers.Headers {
+	if res.Trailers == nil {
+		return nil
+	}
+	trailers := res.Trailers.Clone()
+	removeHopByHop(trailers)
+	for _, name := range []string{"content-length", "host", "content-type", "content-encoding"} {
+		trailers.Delete(name)
+	}
+	found := false
+	trailers.ForEach(func(n, v string) { found = true })
+	if !found {
+		return nil
+	}
+	return trailers
+}
+
+// buffer reads body whole for a route in buffering mode.
+func (r *route) buffer(body io.Reader) (*spill.Buffer, error) {
+	threshold := r.BufferMemory
+	if threshold <= 0 {
+		threshold = 1 << 20
+	}
+	b := spill.New(threshold)
+	if _, err := io.Copy(b, body); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// outgoing is the header set sent upstream for req: hop-by-hop fields
+// dropped, X-Forwarded-* added and the route's rules applied.
+func outgoing(req *request.Request, r *route) *headers.Headers {
+	h := req.Headers.Clone()
+	removeHopByHop(h)
+	// The client frames the body and names the upstream host itself.
+	h.Delete("content-length")
+	h.Delete("host")
+	if host, ok := req.Headers.Get("host"); ok {
+		h.Replace("X-Forwarded-Host", host)
+	}
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		h.Set("X-Forwarded-For", ip)
+	}
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	h.Replace("X-Forwarded-Proto", proto)
+	if id := req.ID(); id != "" {
+		if _, ok := h.Get("x-request-id"); !ok {
+			h.Replace("X-Request-ID", id)
+		}
+	}
+	rewrite(h, r.SetRequestHeaders, r.RemoveRequestHeaders)
+	return h
+}
+
+// removeHopByHop drops the hop-by-hop headers, including any the
+// Connection header names.
+func removeHopByHop(h *headers.Headers) {
+	if value, ok := h.Get("connection"); ok {
+		for _, name := range strings.Split(value, ",") {
+			h.Delete(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHop {
+		h.Delete(name)
+	}
+}
+
+func rewrite(h *headers.Headers, set map[string]string, remove []string) {
+	for _, name := range remove {
+		h.Delete(name)
+	}
+	for name, value := range set {
+		h.Replace(name, value)
+	}
+}
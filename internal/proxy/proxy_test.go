@@ -0,0 +1,523 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/chunked"
+	"tcp.to.http/internal/client"
+	"tcp.to.http/internal/headers"
+	"tcp.to.http/internal/middleware"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+func testServer(t *testing.T, handler server.Handler) string {
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return fmt.Sprintf("http://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+}
+
+func proxyServer(t *testing.T, config Config) string {
+	handler, err := Handler(config)
+	require.NoError(t, err)
+	return testServer(t, handler)
+}
+
+// echo answers with its name, the request line and the headers the proxy
+// is expected to set or drop.
+func echo(name string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		forwarded, _ := req.Headers.Get("x-forwarded-for")
+		extra, _ := req.Headers.Get("x-extra")
+		_, hop := req.Headers.Get("x-hop")
+		body := fmt.Sprintf("%s %s %s %s %s %v", name, req.RequestLine.Method, req.RequestLine.RequestTarget, forwarded, extra, hop)
+		h := response.GetDefaultHeaders(len(body))
+		h.Replace("X-Internal", "secret")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func fetch(t *testing.T, c *client.Client, method, url string) (*client.Response, string) {
+	req, err := client.NewRequest(context.Background(), method, url, nil)
+	require.NoError(t, err)
+	req.Headers.Set("Connection", "x-hop")
+	req.Headers.Set("X-Hop", "1")
+	res, err := c.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestReverseProxy(t *testing.T) {
+	a, b := testServer(t, echo("a")), testServer(t, echo("b"))
+	chunkedUpstream := testServer(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("content-length")
+		h.Replace("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteChunkedBody([]byte("streamed "))
+		w.WriteChunkedBody([]byte("upstream"))
+		w.WriteChunkedBodyDone()
+	})
+	base := proxyServer(t, Config{Routes: []Route{
+		{Prefix: "/", Upstreams: []string{a, b}, SetRequestHeaders: map[string]string{"X-Extra": "added"}, RemoveResponseHeaders: []string{"X-Internal"}},
+		{Prefix: "/stream", Upstreams: []string{chunkedUpstream}},
+		{Prefix: "/down", Upstreams: []string{"http://127.0.0.1:1"}},
+	}})
+	c := &client.Client{}
+
+	// Test: Requests go to the upstreams in turn, with the path and query kept
+	res, body := fetch(t, c, "GET", base+"/items?id=1")
+	assert.Equal(t, 200, int(res.StatusCode))
+	assert.Equal(t, "a GET /items?id=1 127.0.0.1 added false", body)
+	_, body = fetch(t, c, "DELETE", base+"/items")
+	assert.Equal(t, "b DELETE /items 127.0.0.1 added false", body)
+
+	// Test: Response header rules apply
+	_, ok := res.Headers.Get("x-internal")
+	assert.False(t, ok)
+
+	// Test: Bodies of unknown length are streamed on chunked
+	res, body = fetch(t, c, "GET", base+"/stream")
+	assert.Equal(t, "streamed upstream", body)
+	te, _ := res.Headers.Get("transfer-encoding")
+	assert.Equal(t, "chunked", te)
+
+	// Test: An unreachable upstream is a 502
+	res, _ = fetch(t, c, "GET", base+"/down")
+	assert.Equal(t, 502, int(res.StatusCode))
+
+	// Test: CONNECT is refused unless enabled
+	res, _ = fetch(t, c, "CONNECT", base+"/")
+	assert.Equal(t, 405, int(res.StatusCode))
+}
+
+func TestNoRoute(t *testing.T) {
+	// Test: Paths outside every route are a 404
+	base := proxyServer(t, Config{Routes: []Route{{Prefix: "/api", Upstreams: []string{"http://127.0.0.1:1"}}}})
+	res, _ := fetch(t, &client.Client{}, "GET", base+"/other")
+	assert.Equal(t, 404, int(res.StatusCode))
+
+	// Test: Prefixes match whole path segments
+	res, _ = fetch(t, &client.Client{}, "GET", base+"/apix")
+	assert.Equal(t, 404, int(res.StatusCode))
+	res, _ = fetch(t, &client.Client{}, "GET", base+"/api/items")
+	assert.Equal(t, 502, int(res.StatusCode))
+
+	// Test: Routes need at least one valid upstream
+	_, err := Handler(Config{Routes: []Route{{Prefix: "/"}}})
+	assert.ErrorIs(t, err, ERROR_NO_UPSTREAMS)
+	_, err = Handler(Config{Routes: []Route{{Prefix: "/", Upstreams: []string{"ftp://host"}}}})
+	assert.ErrorIs(t, err, client.ERROR_UNSUPPORTED_SCHEME)
+}
+
+func TestConnect(t *testing.T) {
+	target := testServer(t, echo("target"))
+	proxyURL, err := url.Parse(proxyServer(t, Config{Connect: true}))
+	require.NoError(t, err)
+
+	// Test: The client reaches the target through a CONNECT tunnel
+	c := &client.Client{Proxy: proxyURL}
+	res, body := fetch(t, c, "GET", target+"/through")
+	assert.Equal(t, 200, int(res.StatusCode))
+	assert.Equal(t, "target GET /through   true", body)
+}
+
+func TestTransforms(t *testing.T) {
+	page := testServer(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("content-length")
+		h.Replace("Transfer-Encoding", "chunked")
+		h.Replace("Content-Type", "text/html")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteChunkedBody([]byte(`<body><a href="http://inter`))
+		w.WriteChunkedBody([]byte(`nal/x">x</a></body>`))
+		w.WriteChunkedBodyDone()
+	})
+	base := proxyServer(t, Config{Routes: []Route{
+		{Prefix: "/", Upstreams: []string{page}, Transforms: []Transform{
+			Replace("text/html", "http://internal/", "/"),
+			Replace("text/html", "<body>", "<body><p>banner</p>"),
+			Gzip("text/html"),
+		}},
+		{Prefix: "/plain", Upstreams: []string{testServer(t, echo("plain"))}, Transforms: []Transform{Replace("text/html", "plain", "x")}},
+	}})
+	c := &client.Client{}
+
+	// Test: Bodies are rewritten even where a match spans two reads, and
+	// vary on Accept-Encoding though they were not compressed
+	res, body := fetch(t, c, "GET", base+"/")
+	assert.Equal(t, `<body><p>banner</p><a href="/x">x</a></body>`, body)
+	vary, _ := res.Headers.Get("vary")
+	assert.Equal(t, "Accept-Encoding", vary)
+
+	// Test: Clients that accept gzip get the rewritten body compressed
+	req, err := client.NewRequest(context.Background(), "GET", base+"/", nil)
+	require.NoError(t, err)
+	req.Headers.Set("Accept-Encoding", "gzip")
+	res, err = c.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	coding, _ := res.Headers.Get("content-encoding")
+	assert.Equal(t, "gzip", coding)
+	zr, err := gzip.NewReader(res.Body)
+	require.NoError(t, err)
+	raw, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, `<body><p>banner</p><a href="/x">x</a></body>`, string(raw))
+
+	// Test: Other media types pass through with their length
+	res, body = fetch(t, c, "GET", base+"/plain")
+	assert.Equal(t, "plain GET /plain 127.0.0.1  false", body)
+	_, sized := res.Headers.Get("content-length")
+	assert.True(t, sized)
+}
+
+func TestTrailers(t *testing.T) {
+	upstream := testServer(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("content-length")
+		h.Replace("Transfer-Encoding", "chunked")
+		h.Replace("Trailer", "X-Checksum")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		cw := chunked.NewWriter(w)
+		cw.Write([]byte("body"))
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Checksum", "abc")
+		trailers.Set("Connection", "close")
+		cw.CloseWithTrailers(trailers)
+	})
+	logged := make(chan string, 2)
+	base := proxyServer(t, Config{
+		Routes: []Route{
+			{Prefix: "/", Upstreams: []string{upstream}},
+			{Prefix: "/changed", Upstreams: []string{upstream}, Transforms: []Transform{Replace("", "body", "BODY")}},
+		},
+		Trailers: func(req *request.Request, trailers *headers.Headers) {
+			sum, _ := trailers.Get("x-checksum")
+			logged <- req.RequestLine.RequestTarget + " " + sum
+		},
+	})
+	c := &client.Client{}
+
+	// Test: Declared trailers reach the client, without hop-by-hop fields
+	res, body := fetch(t, c, "GET", base+"/")
+	assert.Equal(t, "body", body)
+	declared, _ := res.Headers.Get("trailer")
+	assert.Equal(t, "X-Checksum", declared)
+	sum, _ := res.Trailers.Get("x-checksum")
+	assert.Equal(t, "abc", sum)
+	_, ok := res.Trailers.Get("connection")
+	assert.False(t, ok)
+	assert.Equal(t, "/ abc", <-logged)
+
+	// Test: Transformed bodies drop the trailers but still report them
+	res, body = fetch(t, c, "GET", base+"/changed")
+	assert.Equal(t, "BODY", body)
+	_, ok = res.Headers.Get("trailer")
+	assert.False(t, ok)
+	_, ok = res.Trailers.Get("x-checksum")
+	assert.False(t, ok)
+	assert.Equal(t, "/changed abc", <-logged)
+}
+
+func TestWebSocket(t *testing.T) {
+	upstream := testServer(t, func(w *response.Writer, req *request.Request) {
+		if key, _ := req.Headers.Get("sec-websocket-key"); key != "abc" {
+			server.Error(w, req, response.StatusForbidden, "")
+			return
+		}
+		h := headers.NewHeaders()
+		h.Set("Connection", "Upgrade")
+		h.Set("Upgrade", "websocket")
+		h.Set("Sec-WebSocket-Accept", "xyz")
+		w.WriteStatusLine(response.StatusSwitchingProtocols)
+		w.WriteHeaders(*h)
+		conn, err := server.Hijack(req)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// A frame straight behind the handshake, then an echo.
+		conn.Write([]byte("hello "))
+		io.Copy(conn, conn)
+	})
+	base := proxyServer(t, Config{Routes: []Route{{Prefix: "/", Upstreams: []string{upstream}}}})
+	u, err := url.Parse(base)
+	require.NoError(t, err)
+	handshake := func(key string) (net.Conn, *response.Parser, *response.Response) {
+		conn, err := net.Dial("tcp", u.Host)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Key: %s\r\n\r\n", u.Host, key)
+		parser := response.NewParser(conn, response.ParseOptions{Method: "GET"})
+		res, err := parser.Next()
+		require.NoError(t, err)
+		return conn, parser, res
+	}
+
+	// Test: The handshake passes through and frames flow both ways
+	conn, parser, res := handshake("abc")
+	assert.Equal(t, response.StatusSwitchingProtocols, res.StatusLine.StatusCode)
+	accept, _ := res.Headers.Get("sec-websocket-accept")
+	assert.Equal(t, "xyz", accept)
+	upgrade, _ := res.Headers.Get("upgrade")
+	assert.Equal(t, "websocket", upgrade)
+	conn.Write([]byte("ping"))
+	got := parser.TakeBuffered()
+	buf := make([]byte, 64)
+	for len(got) < len("hello ping") {
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		got = append(got, buf[:n]...)
+	}
+	assert.Equal(t, "hello ping", string(got))
+
+	// Test: An upstream refusal reaches the client
+	_, _, res = handshake("wrong")
+	assert.Equal(t, response.StatusForbidden, res.StatusLine.StatusCode)
+}
+
+func TestPathRules(t *testing.T) {
+	a := testServer(t, echo("a"))
+	base := proxyServer(t, Config{Routes: []Route{
+		{Prefix: "/api/", Upstreams: []string{a}, StripPrefix: "/api", AddPrefix: "/v2"},
+		{Prefix: "/users/", Upstreams: []string{a}, Rewrites: []Rewrite{{Pattern: `^/users/(\d+)$`, Replacement: "/people?id=$1"}}},
+		{Prefix: "/bare", Upstreams: []string{a}, StripPrefix: "/bare"},
+	}})
+	c := &client.Client{}
+
+	// Test: Prefixes are stripped and added with the query kept
+	_, body := fetch(t, c, "GET", base+"/api/items?id=1")
+	assert.Equal(t, "a GET /v2/items?id=1 127.0.0.1  false", body)
+
+	// Test: Regular expression rewrites may use groups
+	_, body = fetch(t, c, "GET", base+"/users/42")
+	assert.Equal(t, "a GET /people?id=42 127.0.0.1  false", body)
+
+	// Test: A path stripped to nothing becomes /
+	_, body = fetch(t, c, "GET", base+"/bare")
+	assert.Equal(t, "a GET / 127.0.0.1  false", body)
+
+	// Test: Bad patterns are caught up front
+	_, err := Handler(Config{Routes: []Route{{Prefix: "/", Upstreams: []string{a}, Rewrites: []Rewrite{{Pattern: "("}}}}})
+	assert.Error(t, err)
+}
+
+func TestRetries(t *testing.T) {
+	busyCalls := atomic.Int32{}
+	busy := testServer(t, func(w *response.Writer, req *request.Request) {
+		busyCalls.Add(1)
+		server.Error(w, req, response.StatusServiceUnavailable, "")
+	})
+	good := testServer(t, echo("good"))
+	base := proxyServer(t, Config{Routes: []Route{
+		{Prefix: "/", Upstreams: []string{"http://127.0.0.1:1", busy, good}, Retries: 2},
+		{Prefix: "/once", Upstreams: []string{busy, good}},
+		{Prefix: "/none", Upstreams: []string{"http://127.0.0.1:1", busy}, Retries: 3},
+	}})
+	c := &client.Client{}
+
+	// Test: Idempotent requests go on past unreachable and unavailable upstreams
+	res, body := fetch(t, c, "GET", base+"/a")
+	assert.Equal(t, 200, int(res.StatusCode))
+	assert.Equal(t, "good GET /a 127.0.0.1  false", body)
+	assert.Equal(t, int32(1), busyCalls.Load())
+
+	// Test: Upstreams that failed are passed over for a while
+	_, body = fetch(t, c, "GET", base+"/b")
+	assert.Equal(t, "good GET /b 127.0.0.1  false", body)
+	assert.Equal(t, int32(1), busyCalls.Load())
+
+	// Test: Other methods are not sent twice
+	res, _ = fetch(t, c, "POST", base+"/once")
+	assert.Equal(t, 503, int(res.StatusCode))
+	assert.Equal(t, int32(2), busyCalls.Load())
+
+	// Test: Running out of upstreams is a 504
+	res, _ = fetch(t, c, "GET", base+"/none")
+	assert.Equal(t, 504, int(res.StatusCode))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	down, slow := atomic.Bool{}, atomic.Bool{}
+	calls := atomic.Int32{}
+	flaky := testServer(t, func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		if slow.Load() {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if down.Load() {
+			server.Error(w, req, response.StatusBadGateway, "")
+			return
+		}
+		echo("flaky")(w, req)
+	})
+	events := make(chan string, 10)
+	base := proxyServer(t, Config{
+		Routes: []Route{{Prefix: "/", Upstreams: []string{flaky}, MaxFailures: 2, SlowThreshold: 50 * time.Millisecond, FailTimeout: 200 * time.Millisecond}},
+		Circuit: func(upstream string, open bool) {
+			events <- fmt.Sprint(open)
+		},
+	})
+	c := &client.Client{}
+
+	// Test: The circuit opens only after MaxFailures failures in a row
+	down.Store(true)
+	fetch(t, c, "GET", base+"/")
+	assert.Empty(t, events)
+	fetch(t, c, "GET", base+"/")
+	assert.Equal(t, "true", <-events)
+
+	// Test: While open, a lone upstream is still tried rather than failing outright
+	down.Store(false)
+	_, body := fetch(t, c, "GET", base+"/")
+	assert.Equal(t, "flaky GET / 127.0.0.1  false", body)
+	assert.Equal(t, "false", <-events)
+
+	// Test: Slow answers count as failures
+	slow.Store(true)
+	fetch(t, c, "GET", base+"/")
+	fetch(t, c, "GET", base+"/")
+	assert.Equal(t, "true", <-events)
+
+	// Test: After FailTimeout a probe closes the circuit again
+	slow.Store(false)
+	time.Sleep(250 * time.Millisecond)
+	fetch(t, c, "GET", base+"/")
+	assert.Equal(t, "false", <-events)
+	assert.Equal(t, int32(6), calls.Load())
+}
+
+func TestCircuitSkipsUpstream(t *testing.T) {
+	badCalls := atomic.Int32{}
+	bad := testServer(t, func(w *response.Writer, req *request.Request) {
+		badCalls.Add(1)
+		server.Error(w, req, response.StatusServiceUnavailable, "")
+	})
+	good := testServer(t, echo("good"))
+	base := proxyServer(t, Config{Routes: []Route{{Prefix: "/", Upstreams: []string{bad, good}, FailTimeout: time.Minute}}})
+	c := &client.Client{}
+
+	// Test: Once its circuit is open an upstream gets no more requests
+	fetch(t, c, "GET", base+"/")
+	for range 4 {
+		_, body := fetch(t, c, "GET", base+"/")
+		assert.Equal(t, "good GET / 127.0.0.1  false", body)
+	}
+	assert.Equal(t, int32(1), badCalls.Load())
+}
+
+func TestBufferedRoute(t *testing.T) {
+	chunkedUpstream := testServer(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("content-length")
+		h.Replace("Transfer-Encoding", "chunked")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteChunkedBody([]byte("buffered "))
+		w.WriteChunkedBody([]byte("upstream"))
+		w.WriteChunkedBodyDone()
+	})
+	broken := testServer(t, func(w *response.Writer, req *request.Request) {
+		conn, err := server.Hijack(req)
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\ncut short"))
+		conn.Close()
+	})
+	base := proxyServer(t, Config{Routes: []Route{
+		{Prefix: "/", Upstreams: []string{chunkedUpstream}, Buffer: true, BufferMemory: 4},
+		{Prefix: "/changed", Upstreams: []string{chunkedUpstream}, Buffer: true, Transforms: []Transform{Replace("", "upstream", "and changed")}},
+		{Prefix: "/retry", Upstreams: []string{broken, chunkedUpstream}, Buffer: true, Retries: 1},
+	}})
+	c := &client.Client{}
+
+	// Test: Buffered responses go out with their length, even when spilled
+	res, body := fetch(t, c, "GET", base+"/")
+	assert.Equal(t, "buffered upstream", body)
+	length, _ := res.Headers.Get("content-length")
+	assert.Equal(t, "17", length)
+	_, ok := res.Headers.Get("transfer-encoding")
+	assert.False(t, ok)
+
+	// Test: The length is that of the transformed body
+	res, body = fetch(t, c, "GET", base+"/changed")
+	assert.Equal(t, "buffered and changed", body)
+	length, _ = res.Headers.Get("content-length")
+	assert.Equal(t, "20", length)
+
+	// Test: A body that breaks off is retried on the next upstream
+	res, body = fetch(t, c, "GET", base+"/retry")
+	assert.Equal(t, 200, int(res.StatusCode))
+	assert.Equal(t, "buffered upstream", body)
+}
+
+func TestArbitraryMethods(t *testing.T) {
+	upstream := testServer(t, func(w *response.Writer, req *request.Request) {
+		ct, _ := req.Headers.Get("content-type")
+		body := fmt.Sprintf("%s %s %q", req.RequestLine.Method, ct, req.Body)
+		h := response.GetDefaultHeaders(len(body))
+		h.Replace("Content-Type", "application/grpc-web+proto")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte(body))
+	})
+	base := proxyServer(t, Config{Routes: []Route{{Prefix: "/", Upstreams: []string{upstream}}}})
+	c := &client.Client{}
+
+	// Test: Any token method and content type pass through untouched
+	for _, method := range []string{"PATCH", "PROPFIND", "X-FROB"} {
+		req, err := client.NewRequest(context.Background(), method, base+"/svc.Echo/Call", []byte("\x00\x00\x00\x00\x02hi"))
+		require.NoError(t, err)
+		req.Headers.Set("Content-Type", "application/grpc-web+proto")
+		res, err := c.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, 200, int(res.StatusCode))
+		assert.Equal(t, method+` application/grpc-web+proto "\x00\x00\x00\x00\x02hi"`, string(body))
+		ct, _ := res.Headers.Get("content-type")
+		assert.Equal(t, "application/grpc-web+proto", ct)
+	}
+}
+
+func TestRequestIDForwarded(t *testing.T) {
+	upstream := testServer(t, func(w *response.Writer, req *request.Request) {
+		id, _ := req.Headers.Get("x-request-id")
+		h := response.GetDefaultHeaders(len(id))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte(id))
+	})
+	handler, err := Handler(Config{Routes: []Route{{Prefix: "/", Upstreams: []string{upstream}}}})
+	require.NoError(t, err)
+	base := testServer(t, middleware.RequestID(middleware.RequestIDConfig{Generate: func() string { return "req-1" }})(handler))
+
+	// Test: The upstream gets the same ID the client sees
+	res, body := fetch(t, &client.Client{}, "GET", base+"/")
+	assert.Equal(t, "req-1", body)
+	id, _ := res.Headers.Get("x-request-id")
+	assert.Equal(t, "req-1", id)
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+)
+
+// Transform rewrites an upstream response body as it streams through. It may
+// change the response headers h and returns the reader the client is sent
+// instead of body, or body itself to leave the response alone. The proxy
+// drops Content-Length once a transform takes over, and closes each returned
+// reader that is an io.Closer when the response is done.
+type Transform func(req *request.Request, h *headers.Headers, body io.Reader) io.Reader
+
+// transform runs body through ts, returning the readers to close; there are
+// none when no transform took the body.
+func transform(ts []Transform, req *request.Request, h *headers.Headers, body io.Reader) (io.Reader, []io.Reader) {
+	taken := []io.Reader{}
+	for _, t := range ts {
+		next := t(req, h, body)
+		if next != body {
+			taken = append(taken, next)
+		}
+		body = next
+	}
+	return body, taken
+}
+
+// identity reports whether the body is uncompressed and its Content-Type
+// starts with mediaType, such as "text/html"; an empty mediaType matches any.
+func identity(h *headers.Headers, mediaType string) bool {
+	if coding, ok := h.Get("content-encoding"); ok && !strings.EqualFold(strings.TrimSpace(coding), "identity") {
+		return false
+	}
+	ct, _ := h.Get("content-type")
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(ct)), mediaType)
+}
+
+// Replace substitutes new for every old in uncompressed bodies of mediaType,
+// such as rewriting upstream URLs in text/html or injecting a banner after
+// <body>. It holds back at most len(old)-1 bytes at a time, so matches split
+// across reads are still found.
+func Replace(mediaType, old, new string) Transform {
+	return func(req *request.Request, h *headers.Headers, body io.Reader) io.Reader {
+		if old == "" || !identity(h, mediaType) {
+			return body
+		}
+		return &replacer{src: body, old: []byte(old), new: []byte(new), buf: make([]byte, 4096)}
+	}
+}
+
+type replacer struct {
+	src      io.Reader
+	old, new []byte
+	buf      []byte
+	in, out  []byte
+	err      error
+}
+
+func (r *replacer) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		n, err := r.src.Read(r.buf)
+		r.in = append(r.in, r.buf[:n]...)
+		r.err = err
+		for {
+			i := bytes.Index(r.in, r.old)
+			if i < 0 {
+				break
+			}
+			r.out = append(append(r.out, r.in[:i]...), r.new...)
+			r.in = r.in[i+len(r.old):]
+		}
+		// A partial match may finish in the next read, unless there is none.
+		keep := len(r.old) - 1
+		if err != nil {
+			keep = 0
+		}
+		if cut := len(r.in) - keep; cut > 0 {
+			r.out = append(r.out, r.in[:cut]...)
+			r.in = append([]byte(nil), r.in[cut:]...)
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// Gzip compresses uncompressed bodies of mediaType for clients that accept
+// gzip.
+func Gzip(mediaType string) Transform {
+	return func(req *request.Request, h *headers.Headers, body io.Reader) io.Reader {
+		if !identity(h, mediaType) {
+			return body
+		}
+		// Whether or not it is compressed, the body now depends on the header.
+		h.AddVary("Accept-Encoding")
+		accept, _ := req.Headers.Get("accept-encoding")
+		if !strings.Contains(strings.ToLower(accept), "gzip") {
+			return body
+		}
+		h.Replace("Content-Encoding", "gzip")
+		pr, pw := io.Pipe()
+		go func() {
+			zw := gzip.NewWriter(pw)
+			_, err := io.Copy(zw, body)
+			if err == nil {
+				err = zw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		return pr
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"tcp.to.http/internal/client"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// isWebSocket reports whether req asks to upgrade to WebSocket.
+func isWebSocket(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get("upgrade")
+	connection, _ := req.Headers.Get("connection")
+	return hasToken(upgrade, "websocket") && hasToken(connection, "upgrade")
+}
+
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// websocket runs the upgrade handshake with an upstream of r and, once it
+// switches protocols, splices the client and upstream connections together
+// so frames pass through untouched.
+func websocket(w *response.Writer, req *request.Request, config Config, r *route) {
+	i, upstream := r.upstream(nil)
+	out, err := client.NewRequest(req.Context(), req.RequestLine.Method,
+		strings.TrimSuffix(upstream.String(), "/")+r.target(req), nil)
+	if err != nil {
+		server.Error(w, req, response.StatusBadRequest, "")
+		return
+	}
+	h := outgoing(req, r)
+	upgrade, _ := req.Headers.Get("upgrade")
+	h.Replace("Connection", "Upgrade")
+	h.Replace("Upgrade", upgrade)
+
+	start := time.Now()
+	conn, err := config.Client.Connect(out)
+	r.record(config, i, err == nil, time.Since(start))
+	if err != nil {
+		server.Error(w, req, response.StatusBadGateway, "")
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	head := fmt.Appendf(nil, "%s %s HTTP/1.1\r\nHost: %s\r\n", req.RequestLine.Method, out.URL.RequestURI(), out.URL.Host)
+	h.ForEach(func(n, v string) {
+		head = fmt.Appendf(head, "%s: %s\r\n", n, v)
+	})
+	if _, err := conn.Write(append(head, "\r\n"...)); err != nil {
+		server.Error(w, req, response.StatusBadGateway, "")
+		return
+	}
+	parser := response.NewParser(conn, response.ParseOptions{Method: req.RequestLine.Method})
+	res, err := parser.Next()
+	if err != nil {
+		server.Error(w, req, response.StatusBadGateway, "")
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	rh := res.Headers.Clone()
+	removeHopByHop(rh)
+	rewrite(rh, r.SetResponseHeaders, r.RemoveResponseHeaders)
+	if res.StatusLine.StatusCode != response.StatusSwitchingProtocols {
+		// A refusal, such as 403 or 426, goes back to the client as is.
+		rh.Replace("Content-Length", fmt.Sprintf("%d", len(res.Body)))
+		rh.Replace("Connection", "close")
+		w.WriteStatusLine(res.StatusLine.StatusCode)
+		w.WriteHeaders(*rh)
+		w.WriteBody([]byte(res.Body))
+		return
+	}
+	accepted, _ := res.Headers.Get("upgrade")
+	rh.Replace("Connection", "Upgrade")
+	rh.Replace("Upgrade", accepted)
+
+	w.WriteStatusLine(response.StatusSwitchingProtocols)
+	w.WriteHeaders(*rh)
+	down, err := server.Hijack(req)
+	if err != nil {
+		return
+	}
+	defer down.Close()
+	// Frames the upstream sent right behind its handshake go first.
+	if _, err := down.Write(parser.TakeBuffered()); err != nil {
+		return
+	}
+	splice(down, conn)
+}
@@ -0,0 +1,72 @@
+package request
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const benchRequest = "GET /api/v1/items?page=2 HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: bench/1.0\r\n" +
+	"Accept: application/json\r\n" +
+	"Accept-Encoding: gzip, deflate\r\n" +
+	"Connection: keep-alive\r\n\r\n"
+
+func BenchmarkParseRequestLine(b *testing.B) {
+	line := []byte("GET /api/v1/items?page=2 HTTP/1.1\r\n")
+	b.ReportAllocs()
+	b.SetBytes(int64(len(line)))
+	for b.Loop() {
+		if _, _, err := parseRequestLine(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchRequest)))
+	for b.Loop() {
+		if _, err := RequestFromReader(strings.NewReader(benchRequest)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkChunkedRequest(b *testing.B) {
+	body := strings.Repeat("400\r\n"+strings.Repeat("x", 0x400)+"\r\n", 16) + "0\r\n\r\n"
+	data := "POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" + body
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		if _, err := RequestFromReader(strings.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParserKeepAlive(b *testing.B) {
+	// Requests pipelined on one connection, as a keep-alive client sends them.
+	data := strings.Repeat(benchRequest, 64)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		p := NewParser(strings.NewReader(data), Options{})
+		for range 64 {
+			if _, err := p.Next(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkRequestFromReaderLazy(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchRequest)))
+	for b.Loop() {
+		if _, err := RequestFromReaderWithOptions(strings.NewReader(benchRequest), Options{LazyHeaders: true}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
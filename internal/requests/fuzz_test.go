@@ -0,0 +1,31 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func FuzzRequestParse(f *testing.F) {
+	f.Add("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 3)
+	f.Add("POST /submit HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello", 1)
+	f.Add("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;x=y\r\nhello\r\n0\r\nA: b\r\n\r\n", 2)
+	f.Add("POST / HTTP/1.1\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", 7)
+	f.Add("GET / HTTP/1.1\r\nX-Folded: a\r\n b\r\n\r\n", 5)
+	f.Add("GET  / http/1.1\r\n\r\n", 64)
+
+	f.Fuzz(func(t *testing.T, data string, perRead int) {
+		options := Options{MaxRequestLineLength: 256, MaxHeaderBytes: 1024}
+		whole, wholeErr := RequestFromReaderWithOptions(strings.NewReader(data), options)
+		pieces, piecesErr := RequestFromReaderWithOptions(&chunkReader{data: data, numBytesPerRead: max(1, perRead%64)}, options)
+
+		// However the input is split up, the parser reaches the same result.
+		assert.Equal(t, wholeErr == nil, piecesErr == nil, "%v / %v", wholeErr, piecesErr)
+		if wholeErr == nil && piecesErr == nil {
+			assert.Equal(t, whole.RequestLine, pieces.RequestLine)
+			assert.Equal(t, whole.Body, pieces.Body)
+			assert.Equal(t, StateDone, whole.state)
+		}
+	})
+}
@@ -0,0 +1,21 @@
+package request
+
+import "context"
+
+type idKey struct{}
+
+// WithID attaches a request ID, such as an X-Request-ID, to ctx.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// ContextID is the request ID attached to ctx, or "".
+func ContextID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// ID is the request ID attached to the request's context, or "".
+func (r *Request) ID() string {
+	return ContextID(r.Context())
+}
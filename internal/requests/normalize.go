@@ -0,0 +1,116 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+var ERROR_DUPLICATE_HOST = fmt.Errorf("More than one Host!🙈")
+var ERROR_INVALID_PATH_ENCODING = fmt.Errorf("Invalid percent-encoding in path!🙈")
+
+// Normalize puts r in a canonical form, so that routing and middleware
+// compare like with like:
+//
+//   - field values lose any leading and trailing whitespace
+//   - more than one Host is refused, and the host is lowercased, as is the
+//     scheme and host of an absolute-form target
+//   - percent-encoded unreserved characters in the path are decoded and
+//     other escapes uppercased, so "/%7Euser/a%2fb" becomes "/~user/a%2Fb";
+//     an encoded slash stays encoded
+//
+// The target as received is still available from RawTarget.
+func (r *Request) Normalize() error {
+	fields := map[string]string{}
+	r.Headers.ForEach(func(n, v string) {
+		if trimmed := strings.Trim(v, " \t"); trimmed != v {
+			fields[n] = trimmed
+		}
+	})
+	for n, v := range fields {
+		r.Headers.Replace(n, v)
+	}
+
+	if host, ok := r.Headers.Get("host"); ok {
+		if strings.Contains(host, ",") {
+			return ERROR_DUPLICATE_HOST
+		}
+		r.Headers.Replace("host", strings.ToLower(host))
+	}
+
+	target := r.RequestLine.RequestTarget
+	if target == "*" || !strings.Contains(target, "/") {
+		return nil
+	}
+	origin := ""
+	if scheme, rest, ok := strings.Cut(target, "://"); ok && !strings.Contains(scheme, "/") {
+		authority, path, _ := strings.Cut(rest, "/")
+		origin, target = strings.ToLower(scheme+"://"+authority), "/"+path
+	}
+	path, query, hasQuery := strings.Cut(target, "?")
+	path, err := normalizePath(path)
+	if err != nil {
+		return err
+	}
+	normalized := origin + path
+	if hasQuery {
+		normalized += "?" + query
+	}
+	if normalized != r.RequestLine.RequestTarget {
+		if r.rawTarget == "" {
+			r.rawTarget = r.RequestLine.RequestTarget
+		}
+		r.RequestLine.RequestTarget = normalized
+	}
+	return nil
+}
+
+// RawTarget is the request target exactly as the client sent it, before
+// Normalize.
+func (r *Request) RawTarget() string {
+	if r.rawTarget != "" {
+		return r.rawTarget
+	}
+	return r.RequestLine.RequestTarget
+}
+
+func normalizePath(path string) (string, error) {
+	if !strings.Contains(path, "%") {
+		return path, nil
+	}
+	b := strings.Builder{}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' {
+			b.WriteByte(path[i])
+			continue
+		}
+		if i+2 >= len(path) || unhex(path[i+1]) < 0 || unhex(path[i+2]) < 0 {
+			return "", ERROR_INVALID_PATH_ENCODING
+		}
+		c := byte(unhex(path[i+1])<<4 | unhex(path[i+2]))
+		if unreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(path[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func unhex(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
+
+// unreserved reports whether RFC 3986 says c never needs encoding.
+func unreserved(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,65 @@
+package request
+
+import (
+	"fmt"
+	"time"
+)
+
+// BodyProgress reports how much of a request body has arrived.
+type BodyProgress struct {
+	Received int64
+	// Expected is the Content-Length, or -1 for a chunked body.
+	Expected int64
+	// Elapsed is the time since the head was parsed.
+	Elapsed time.Duration
+	// Chunk holds the bytes that just arrived. It is only valid during the
+	// call.
+	Chunk []byte
+}
+
+// Rate is the average number of body bytes received per second.
+func (p BodyProgress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Received) / p.Elapsed.Seconds()
+}
+
+// startProgress begins tracking the body once the head is in.
+func (r *Request) startProgress() {
+	if r.options.OnBodyProgress == nil || r.state == StateDone {
+		return
+	}
+	r.started = time.Now()
+	r.progress.Expected = -1
+	if r.state == StateBody {
+		r.progress.Expected = int64(r.remaining)
+	}
+}
+
+// TakeBody, called from Options.OnBodyProgress, hands the body over to the
+// callback: the chunk being reported and every later one are no longer
+// kept, so the handler sees an empty body and BodyTaken reports true. This
+// lets the callback store a body as it arrives, which keeps what arrived
+// if the client goes away partway through.
+func (r *Request) TakeBody() {
+	r.bodyTaken = true
+}
+
+func (r *Request) BodyTaken() bool {
+	return r.bodyTaken
+}
+
+func (r *Request) reportProgress(chunk []byte) error {
+	if r.options.OnBodyProgress == nil {
+		return nil
+	}
+	r.progress.Received += int64(len(chunk))
+	r.progress.Elapsed = time.Since(r.started)
+	r.progress.Chunk = chunk
+	defer func() { r.progress.Chunk = nil }()
+	if err := r.options.OnBodyProgress(r, r.progress); err != nil {
+		return fmt.Errorf("%w: %w", ERROR_BODY_ABORTED, err)
+	}
+	return nil
+}
@@ -1,22 +1,35 @@
 package request
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"tcp.to.http/internal/chunked"
 	"tcp.to.http/internal/headers"
+	"tcp.to.http/internal/spill"
 )
 
 type parseState string
 
 const (
-	StateInit   parseState = "init"
-	StateHeader parseState = "headers"
-	StateBody   parseState = "body"
-	StateDone   parseState = "done"
-	StateError  parseState = "error"
+	StateInit      parseState = "init"
+	StateHeader    parseState = "headers"
+	StateBody      parseState = "body"
+	StateChunkSize parseState = "chunk size"
+	StateChunkData parseState = "chunk data"
+	StateChunkEnd  parseState = "chunk end"
+	StateTrailers  parseState = "trailers"
+	StateDone      parseState = "done"
+	StateError     parseState = "error"
 )
 
 type RequestLine struct {
@@ -30,33 +43,155 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     *headers.Headers
 	Body        string
+	// Trailers holds the fields after a chunked body.
+	Trailers   *headers.Headers
+	RemoteAddr string
+	// TLS is set for requests that arrived over TLS.
+	TLS         *tls.ConnectionState
 	state       parseState
+	ctx         context.Context
+	options     Options
+	lineBytes   int
+	headerBytes int
+	remaining   int
+	extensions  int
+	// trailerBytes and trailerFields count the trailers parsed so far.
+	trailerBytes  int
+	trailerFields int
+	raw           []byte
+	trace         *RequestTrace
+	spill         *spill.Buffer
+	// rawTarget is the target as received, once Normalize changed it.
+	rawTarget string
+	// wire counts every byte parsed, headBytes those up to the body.
+	wire      int64
+	headBytes int64
+	// progress tracks the body for Options.OnBodyProgress.
+	progress  BodyProgress
+	started   time.Time
+	bodyTaken bool
 }
 
-func getInt(headers *headers.Headers, name string, defaultValue int) int {
-	valueStr, exist := headers.Get(name)
-	if !exist {
-		return defaultValue
+const (
+	DefaultMaxRequestLineLength = 8 << 10
+	DefaultMaxHeaderBytes       = 64 << 10
+	DefaultMaxTrailerBytes      = chunked.DefaultMaxTrailerBytes
+	DefaultMaxTrailerFields     = chunked.DefaultMaxTrailerFields
+)
+
+// Options bounds how much the parser will buffer before giving up. Zero
+// values fall back to the defaults.
+type Options struct {
+	MaxRequestLineLength int
+	MaxHeaderBytes       int
+	// MaxTrailerBytes and MaxTrailerFields bound the trailers of a chunked
+	// body, which also count towards MaxHeaderBytes.
+	MaxTrailerBytes  int
+	MaxTrailerFields int
+	// RetainRaw keeps up to this many bytes of the request line and header
+	// block as received, for Raw. Zero disables it.
+	RetainRaw int
+	// LazyHeaders parses into headers.NewLazyHeaders, for handlers that look
+	// at a few fields of many requests.
+	LazyHeaders bool
+	// SpillThreshold moves bodies larger than this many bytes to a
+	// temporary file in SpillDir (os.TempDir() when empty), leaving Body
+	// empty; BodyReader reads them either way. Zero keeps every body in
+	// memory.
+	SpillThreshold int64
+	SpillDir       string
+	// OnBodyProgress is called as each run of body bytes arrives, before
+	// the handler sees the request, with its head already parsed. An error
+	// stops reading and fails the request with ERROR_BODY_ABORTED. It may
+	// call TakeBody to handle the bytes itself.
+	OnBodyProgress func(r *Request, p BodyProgress) error
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxRequestLineLength <= 0 {
+		o.MaxRequestLineLength = DefaultMaxRequestLineLength
+	}
+	if o.MaxHeaderBytes <= 0 {
+		o.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if o.MaxTrailerBytes <= 0 {
+		o.MaxTrailerBytes = DefaultMaxTrailerBytes
+	}
+	if o.MaxTrailerFields <= 0 {
+		o.MaxTrailerFields = DefaultMaxTrailerFields
+	}
+	return o
+}
+
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
 	}
+	return context.Background()
+}
 
-	value, err := strconv.Atoi(valueStr)
-	if err != nil {
-		return defaultValue
+// Raw returns the request line and header block exactly as the client sent
+// them, when enabled by Options.RetainRaw. For a request that failed to
+// parse it holds whatever had arrived, up to the cap.
+func (r *Request) Raw() []byte {
+	return r.raw
+}
+
+// WireBytes reports how many bytes of the request were read from the
+// connection: the request line and header fields, then the body with any
+// chunk framing and trailers.
+func (r *Request) WireBytes() (head, body int64) {
+	if r.headBytes == 0 {
+		return r.wire, 0
 	}
-	return value
+	return r.headBytes, r.wire - r.headBytes
 }
 
-func newRequest() *Request {
+// AcceptsTrailers reports whether the client asked for trailer fields with
+// TE: trailers.
+func (r *Request) AcceptsTrailers() bool {
+	value, _ := r.Headers.Get("te")
+	for _, coding := range strings.Split(value, ",") {
+		name, _, _ := strings.Cut(coding, ";")
+		if strings.EqualFold(strings.TrimSpace(name), "trailers") {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+func newRequest(options Options) *Request {
+	h := headers.NewHeaders()
+	if options.LazyHeaders {
+		h = headers.NewLazyHeaders()
+	}
 	return &Request{
-		state:   StateInit,
-		Headers: headers.NewHeaders(),
-		Body:    "",
+		state:    StateInit,
+		Headers:  h,
+		Trailers: headers.NewHeaders(),
+		Body:     "",
+		options:  options.withDefaults(),
 	}
 }
 
 var ERROR_MALFORMED_REQUEST_LINE = fmt.Errorf("You just encounter malformed Request line!🙈")
 var ERROR_UNSUPPORTED_HTTP_VERSION = fmt.Errorf("Unsupported HTTP version!🙈")
 var ERROR_REQUEST_IN_ERROR_STATE = fmt.Errorf("Request in error state!")
+var ERROR_REQUEST_LINE_TOO_LONG = fmt.Errorf("Request line too long!🙈")
+var ERROR_HEADERS_TOO_LARGE = fmt.Errorf("Request header fields too large!🙈")
+var ERROR_INVALID_CONTENT_LENGTH = fmt.Errorf("Invalid Content-Length!🙈")
+var ERROR_AMBIGUOUS_FRAMING = fmt.Errorf("Both Content-Length and Transfer-Encoding!🙈")
+var ERROR_UNSUPPORTED_TRANSFER_ENCODING = fmt.Errorf("Unsupported Transfer-Encoding!🙈")
+var ERROR_MALFORMED_CHUNK = fmt.Errorf("Malformed chunked body!🙈")
+var ERROR_TRAILERS_TOO_LARGE = fmt.Errorf("Trailer fields too large!🙈")
+var ERROR_TOO_MANY_TRAILERS = fmt.Errorf("Too many trailer fields!🙈")
+var ERROR_BODY_ABORTED = fmt.Errorf("Request body aborted!🙈")
 var SEPARATOR = []byte("\r\n")
 
 func parseRequestLine(b []byte) (*RequestLine, int, error) {
@@ -79,6 +214,10 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 	if len(HttpParts) != 2 || string(HttpParts[0]) != "HTTP" || string(HttpParts[1]) != "1.1" {
 		return nil, 0, ERROR_MALFORMED_REQUEST_LINE
 	}
+	// Any token is a method; handlers decide which they support.
+	if !headers.IsToken(string(parts[0])) {
+		return nil, 0, ERROR_MALFORMED_REQUEST_LINE
+	}
 
 	return &RequestLine{
 		Method:        string(parts[0]),
@@ -87,9 +226,57 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 	}, read, nil
 }
 
-func (r *Request) hasBody() bool {
-	length := getInt(r.Headers, "content-length", 0)
-	return length > 0
+// bodyState picks how the body is framed once the headers are in. A
+// request with both Content-Length and Transfer-Encoding, or with a length
+// that cannot be trusted, is refused rather than guessed at: a proxy in
+// front may have guessed differently.
+func (r *Request) bodyState() (parseState, error) {
+	te, chunked := r.Headers.Get("transfer-encoding")
+	cl, sized := r.Headers.Get("content-length")
+	switch {
+	case chunked && sized:
+		return StateError, ERROR_AMBIGUOUS_FRAMING
+	case chunked:
+		codings := strings.Split(te, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return StateError, ERROR_MALFORMED_CHUNK
+		}
+		if len(codings) > 1 {
+			return StateError, ERROR_UNSUPPORTED_TRANSFER_ENCODING
+		}
+		return StateChunkSize, nil
+	case sized:
+		n, err := contentLength(cl)
+		if err != nil {
+			return StateError, err
+		}
+		r.remaining = n
+		if n == 0 {
+			return StateDone, nil
+		}
+		return StateBody, nil
+	}
+	return StateDone, nil
+}
+
+// contentLength parses a Content-Length value. Repeated fields arrive
+// joined by commas and are fine as long as they agree.
+func contentLength(value string) (int, error) {
+	values := strings.Split(value, ",")
+	first := strings.TrimSpace(values[0])
+	for _, v := range values[1:] {
+		if strings.TrimSpace(v) != first {
+			return 0, ERROR_INVALID_CONTENT_LENGTH
+		}
+	}
+	if first == "" || strings.TrimLeft(first, "0123456789") != "" {
+		return 0, ERROR_INVALID_CONTENT_LENGTH
+	}
+	n, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, ERROR_INVALID_CONTENT_LENGTH
+	}
+	return n, nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -108,78 +295,357 @@ outer:
 			rl, n, err := parseRequestLine(currentRead)
 			if err != nil {
 				r.state = StateError
-				return 0, nil
+				return 0, err
 			}
 			if n == 0 {
+				if len(currentRead) > r.options.MaxRequestLineLength {
+					r.state = StateError
+					return 0, ERROR_REQUEST_LINE_TOO_LONG
+				}
 				break outer
 			}
+			if n-len(SEPARATOR) > r.options.MaxRequestLineLength {
+				r.state = StateError
+				return 0, ERROR_REQUEST_LINE_TOO_LONG
+			}
 			r.RequestLine = *rl
 			read += n
+			r.lineBytes = n
+			if r.trace != nil && r.trace.RequestLineParsed != nil {
+				r.trace.RequestLineParsed(r.RequestLine)
+			}
 
 			r.state = StateHeader
 
-		case StateHeader:
-			n, done, err := r.Headers.Parse(currentRead)
+		case StateHeader, StateTrailers:
+			var each func(name, value string)
+			if r.trace != nil {
+				each = r.trace.HeaderParsed
+			}
+			target := r.Headers
+			if r.state == StateTrailers {
+				target = r.Trailers
+				traced := each
+				each = func(name, value string) {
+					r.trailerFields++
+					if traced != nil {
+						traced(name, value)
+					}
+				}
+			}
+			n, done, err := target.ParseEach(currentRead, each)
 			if err != nil {
+				r.state = StateError
 				return 0, err
 			}
+			if r.state == StateTrailers {
+				if err := r.checkTrailers(n, done, len(currentRead)); err != nil {
+					r.state = StateError
+					return 0, err
+				}
+			}
+
+			pending := r.headerBytes + n
+			if !done {
+				pending = r.headerBytes + len(currentRead)
+			}
+			if pending > r.options.MaxHeaderBytes {
+				r.state = StateError
+				return 0, ERROR_HEADERS_TOO_LARGE
+			}
 
 			if n == 0 {
 				break outer
 			}
 			read += n
+			r.headerBytes += n
 
-			if done {
-				if r.hasBody() {
-					r.state = StateBody
-				} else {
+			if !done {
+				break
+			}
+			if r.state == StateTrailers {
+				r.state = StateDone
+				break
+			}
+			if r.state, err = r.bodyState(); err != nil {
+				return 0, err
+			}
+			r.headBytes = int64(r.lineBytes + r.headerBytes)
+			r.startProgress()
+
+		case StateBody, StateChunkData:
+			n := min(r.remaining, len(currentRead))
+			read += n
+			r.remaining -= n
+			if r.trace != nil && r.trace.BodyChunkRead != nil {
+				r.trace.BodyChunkRead(n)
+			}
+			if err := r.reportProgress(currentRead[:n]); err != nil {
+				r.state = StateError
+				return 0, err
+			}
+			if !r.bodyTaken {
+				if err := r.appendBody(currentRead[:n]); err != nil {
+					r.state = StateError
+					return 0, err
+				}
+			}
+			if r.remaining == 0 {
+				if r.state == StateBody {
 					r.state = StateDone
+				} else {
+					r.state = StateChunkEnd
 				}
 			}
-		case StateBody:
-			length := getInt(r.Headers, "content-length", 0)
-			if length == 0 {
-				panic("Chuncked not implemented")
+
+		case StateChunkSize:
+			idx := bytes.Index(currentRead, SEPARATOR)
+			if idx == -1 {
+				if len(currentRead) > chunked.MaxSizeLineLength {
+					r.state = StateError
+					return 0, ERROR_MALFORMED_CHUNK
+				}
+				break outer
+			}
+			n, ext, err := chunked.ParseSizeLine(currentRead[:idx])
+			if err == nil && n > math.MaxInt32 {
+				err = chunked.ERROR_MALFORMED_CHUNK
+			}
+			if r.extensions += ext; err == nil && r.extensions > chunked.MaxBodyExtensionBytes {
+				err = chunked.ERROR_EXTENSIONS_TOO_LARGE
+			}
+			if err != nil {
+				r.state = StateError
+				return 0, fmt.Errorf("%w: %w", ERROR_MALFORMED_CHUNK, err)
+			}
+			read += idx + len(SEPARATOR)
+			r.remaining = int(n)
+			if n == 0 {
+				r.state = StateTrailers
+			} else {
+				r.state = StateChunkData
 			}
-			remaining := min(length-len(r.Body), len(currentRead))
-			r.Body += string(currentRead[:remaining])
-			read += remaining
 
-			if len(r.Body) == length {
-				r.state = StateDone
+		case StateChunkEnd:
+			if len(currentRead) < len(SEPARATOR) {
+				break outer
 			}
+			if !bytes.HasPrefix(currentRead, SEPARATOR) {
+				r.state = StateError
+				return 0, ERROR_MALFORMED_CHUNK
+			}
+			read += len(SEPARATOR)
+			r.state = StateChunkSize
+
 		case StateDone:
 			break outer
+
 		default:
-			panic("something somethings")
+			r.state = StateError
+			return 0, ERROR_REQUEST_IN_ERROR_STATE
 		}
 	}
 	return read, nil
 }
 
+// checkTrailers applies the trailer limits once n more bytes of trailers
+// were parsed out of the available ones.
+func (r *Request) checkTrailers(n int, done bool, available int) error {
+	pending := r.trailerBytes + n
+	if !done {
+		pending = r.trailerBytes + available
+	}
+	if pending > r.options.MaxTrailerBytes {
+		return ERROR_TRAILERS_TOO_LARGE
+	}
+	if r.trailerFields > r.options.MaxTrailerFields {
+		return ERROR_TOO_MANY_TRAILERS
+	}
+	r.trailerBytes += n
+	return nil
+}
+
+func (r *Request) appendBody(p []byte) error {
+	if r.spill == nil && r.options.SpillThreshold > 0 && int64(len(r.Body)+len(p)) > r.options.SpillThreshold {
+		r.spill = spill.New(r.options.SpillThreshold)
+		r.spill.Dir = r.options.SpillDir
+		if _, err := r.spill.Write([]byte(r.Body)); err != nil {
+			return err
+		}
+		r.Body = ""
+	}
+	if r.spill != nil {
+		_, err := r.spill.Write(p)
+		return err
+	}
+	r.Body += string(p)
+	return nil
+}
+
+// BodyReader reads the body, whether it is held in Body or was spilled to
+// disk.
+func (r *Request) BodyReader() io.Reader {
+	if r.spill != nil {
+		return r.spill.Reader()
+	}
+	return strings.NewReader(r.Body)
+}
+
+func (r *Request) BodyLen() int64 {
+	if r.spill != nil {
+		return r.spill.Len()
+	}
+	return int64(len(r.Body))
+}
+
+// Close removes a spilled body's file. The server calls it once the
+// handler has returned.
+func (r *Request) Close() error {
+	if r.spill == nil {
+		return nil
+	}
+	return r.spill.Close()
+}
+
+// Hold keeps a spilled body's file in place past Close until release is
+// called, for work on the body that may outlive the handler, such as a
+// handler abandoned by a timeout.
+func (r *Request) Hold() (release func()) {
+	if r.spill == nil {
+		return func() {}
+	}
+	return r.spill.Hold()
+}
+
 func (r *Request) done() bool {
 	return r.state == StateDone || r.state == StateError
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	request := newRequest()
+	return RequestFromReaderWithOptions(reader, Options{})
+}
 
-	buf := make([]byte, 1024)
-	bufLen := 0
-	for !request.done() {
-		n, err := reader.Read(buf[bufLen:])
-		if err != nil {
-			return nil, err
+// RequestFromReaderWithOptions parses a single request from reader. On error
+// the partially parsed request is still returned so callers can inspect
+// whatever arrived (e.g. the Accept header when reporting a 431).
+func RequestFromReaderWithOptions(reader io.Reader, options Options) (*Request, error) {
+	return RequestFromReaderContext(context.Background(), reader, options)
+}
+
+// RequestFromReaderContext parses with ctx as the request's context, firing
+// the callbacks of any RequestTrace attached to it.
+func RequestFromReaderContext(ctx context.Context, reader io.Reader, options Options) (*Request, error) {
+	p := NewParser(reader, options)
+	defer p.Release()
+	return p.Next(ctx)
+}
+
+// Parser reads successive requests from one connection through a pooled
+// bufio.Reader. Bytes read past the end of a request, such as a pipelined
+// next request, stay buffered for the following call to Next.
+type Parser struct {
+	reader  *bufio.Reader
+	options Options
+}
+
+// readerPool recycles the parsers' read buffers across connections.
+var readerPool = sync.Pool{New: func() any { return bufio.NewReaderSize(nil, 4<<10) }}
+
+func NewParser(reader io.Reader, options Options) *Parser {
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(reader)
+	return &Parser{reader: br, options: options}
+}
+
+// Release hands the parser's buffer back for reuse. Buffered bytes are
+// lost, and the parser must not be used again.
+func (p *Parser) Release() {
+	if p.reader != nil {
+		p.reader.Reset(nil)
+		readerPool.Put(p.reader)
+		p.reader = nil
+	}
+}
+
+// Buffered reports how many bytes of the next request have already been
+// read.
+func (p *Parser) Buffered() int {
+	return p.reader.Buffered()
+}
+
+// TakeBuffered hands over the bytes read past the last request, for a
+// caller taking over the connection; the parser forgets them.
+func (p *Parser) TakeBuffered() []byte {
+	b, _ := p.reader.Peek(p.reader.Buffered())
+	b = append([]byte(nil), b...)
+	p.reader.Discard(len(b))
+	return b
+}
+
+func (p *Parser) Next(ctx context.Context) (*Request, error) {
+	request := newRequest(p.options)
+	request.ctx = ctx
+	request.trace = ContextTrace(ctx)
+
+	err := readRequest(request, p.reader)
+	if err != nil && request.trace != nil && request.trace.ParseError != nil {
+		request.trace.ParseError(err)
+	}
+	return request, err
+}
+
+// readRequest parses straight out of br's buffer. Only a line too long for
+// the buffer is gathered into carry until the parser can take it whole.
+func readRequest(request *Request, br *bufio.Reader) error {
+	var carry []byte
+	for {
+		window, _ := br.Peek(br.Buffered())
+		data := window
+		if len(carry) > 0 {
+			data = append(carry, window...)
 		}
 
-		bufLen += n
-		readN, err := request.parse(buf[:bufLen])
+		inHead := request.state == StateInit || request.state == StateHeader
+		n, err := request.parse(data)
+		request.wire += int64(n)
 		if err != nil {
-			return nil, err
+			request.retain(data)
+			return err
+		}
+		if inHead {
+			request.retain(data[:n])
+			if request.state != StateInit && request.state != StateHeader {
+				// The window may have run into the body; keep only the head.
+				request.raw = request.raw[:min(len(request.raw), request.lineBytes+request.headerBytes)]
+			}
 		}
 
-		copy(buf, buf[readN:bufLen])
-		bufLen -= readN
+		if n >= len(carry) {
+			br.Discard(n - len(carry))
+			carry = nil
+		} else {
+			carry = carry[n:]
+		}
+		if request.done() {
+			return nil
+		}
+		// The parser stopped short of what it has, so it needs more than a
+		// full buffer holds.
+		if br.Buffered() == br.Size() {
+			carry = append(carry, window...)
+			br.Discard(len(window))
+		}
+
+		// Wait for at least one more byte.
+		if _, err := br.Peek(br.Buffered() + 1); err != nil {
+			return err
+		}
+	}
+}
+
+// retain keeps data for Raw, up to the RetainRaw cap.
+func (r *Request) retain(data []byte) {
+	if keep := r.options.RetainRaw - len(r.raw); keep > 0 {
+		r.raw = append(r.raw, data[:min(len(data), keep)]...)
 	}
-	return request, nil
 }
@@ -1,7 +1,11 @@
 package request
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -106,3 +110,377 @@ func TestParseBody(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
 }
+
+func TestParseChunkedBody(t *testing.T) {
+	// Test: Chunks are joined and trailers kept apart
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6;name=value\r\nhello \r\n" +
+			"6\r\nworld!\r\n" +
+			"0\r\nX-Checksum: abc\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", r.Body)
+	sum, _ := r.Trailers.Get("x-checksum")
+	assert.Equal(t, "abc", sum)
+
+	// Test: Framing that could be read two ways is refused
+	for raw, want := range map[string]error{
+		"Content-Length: 5\r\nTransfer-Encoding: chunked\r\n": ERROR_AMBIGUOUS_FRAMING,
+		"Content-Length: 5\r\nContent-Length: 6\r\n":          ERROR_INVALID_CONTENT_LENGTH,
+		"Content-Length: +5\r\n":                              ERROR_INVALID_CONTENT_LENGTH,
+		"Content-Length: -1\r\n":                              ERROR_INVALID_CONTENT_LENGTH,
+		"Transfer-Encoding: chunked, gzip\r\n":                ERROR_MALFORMED_CHUNK,
+		"Transfer-Encoding: gzip, chunked\r\n":                ERROR_UNSUPPORTED_TRANSFER_ENCODING,
+	} {
+		_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\n" + raw + "\r\nhello\r\n0\r\n\r\n"))
+		assert.ErrorIs(t, err, want, raw)
+	}
+
+	// Test: Agreeing repeated Content-Lengths are fine
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", r.Body)
+
+	// Test: Bad chunk sizes and missing chunk terminators are errors
+	for _, body := range []string{"zz\r\nhello\r\n0\r\n\r\n", "5\r\nhelloX\r\n0\r\n\r\n", "+5\r\nhello\r\n0\r\n\r\n"} {
+		_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + body))
+		assert.ErrorIs(t, err, ERROR_MALFORMED_CHUNK, body)
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	// Test: Request line longer than the initial buffer is still parsed
+	target := "/" + strings.Repeat("a", 4000)
+	reader := &chunkReader{
+		data:            "GET " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 512,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, target, r.RequestLine.RequestTarget)
+
+	// Test: Lines longer than the read buffer are gathered until complete
+	long := "/" + strings.Repeat("a", 6000)
+	value := strings.Repeat("v", 20000)
+	reader = &chunkReader{
+		data:            "GET " + long + " HTTP/1.1\r\nX-Long: " + value + "\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 1500,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, long, r.RequestLine.RequestTarget)
+	got, _ := r.Headers.Get("x-long")
+	assert.Equal(t, value, got)
+
+	// Test: Request line over the limit
+	reader = &chunkReader{
+		data:            "GET " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 512,
+	}
+	_, err = RequestFromReaderWithOptions(reader, Options{MaxRequestLineLength: 1024})
+	require.ErrorIs(t, err, ERROR_REQUEST_LINE_TOO_LONG)
+
+	// Test: Request line over the limit without a line ending
+	reader = &chunkReader{
+		data:            "GET " + target,
+		numBytesPerRead: 512,
+	}
+	_, err = RequestFromReaderWithOptions(reader, Options{MaxRequestLineLength: 1024})
+	require.ErrorIs(t, err, ERROR_REQUEST_LINE_TOO_LONG)
+
+	// Test: Header block over the limit
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Big: " + strings.Repeat("b", 2048) + "\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithOptions(reader, Options{MaxHeaderBytes: 1024})
+	require.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+
+	// Test: Trailers have their own limits on size and field count
+	chunked := "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n"
+	reader = &chunkReader{
+		data:            chunked + "X-Big: " + strings.Repeat("b", 2048) + "\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	_, err = RequestFromReaderWithOptions(reader, Options{MaxTrailerBytes: 1024})
+	require.ErrorIs(t, err, ERROR_TRAILERS_TOO_LARGE)
+	reader = &chunkReader{
+		data:            chunked + strings.Repeat("X-A: 1\r\n", 5) + "\r\n",
+		numBytesPerRead: 64,
+	}
+	_, err = RequestFromReaderWithOptions(reader, Options{MaxTrailerFields: 4})
+	require.ErrorIs(t, err, ERROR_TOO_MANY_TRAILERS)
+	reader = &chunkReader{
+		data:            chunked + strings.Repeat("X-A: 1\r\n", 4) + "\r\n",
+		numBytesPerRead: 64,
+	}
+	r, err = RequestFromReaderWithOptions(reader, Options{MaxTrailerFields: 4})
+	require.NoError(t, err)
+	a, _ := r.Trailers.Get("x-a")
+	assert.Equal(t, "1,1,1,1", a)
+}
+
+func TestRequestRaw(t *testing.T) {
+	head := "POST /submit HTTP/1.1\r\nHost:   localhost:42069\r\nContent-Length: 5\r\n\r\n"
+
+	// Test: Raw is empty unless enabled
+	r, err := RequestFromReader(&chunkReader{data: head + "hello", numBytesPerRead: 7})
+	require.NoError(t, err)
+	assert.Nil(t, r.Raw())
+
+	// Test: Raw keeps the head exactly as sent, without the body
+	r, err = RequestFromReaderWithOptions(&chunkReader{data: head + "hello", numBytesPerRead: 7}, Options{RetainRaw: 1024})
+	require.NoError(t, err)
+	assert.Equal(t, head, string(r.Raw()))
+	assert.Equal(t, "hello", r.Body)
+
+	// Test: Raw is capped
+	r, err = RequestFromReaderWithOptions(&chunkReader{data: head + "hello", numBytesPerRead: 7}, Options{RetainRaw: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "POST /subm", string(r.Raw()))
+
+	// Test: Malformed requests keep what arrived
+	r, err = RequestFromReaderWithOptions(&chunkReader{data: "GET / HTTP/1.1\r\nBad Header: x\r\n\r\n", numBytesPerRead: 64}, Options{RetainRaw: 1024})
+	require.Error(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\nBad Header: x\r\n\r\n", string(r.Raw()))
+}
+
+func TestRequestTrace(t *testing.T) {
+	events := []string{}
+	ctx := WithTrace(context.Background(), &RequestTrace{
+		RequestLineParsed: func(line RequestLine) { events = append(events, "line "+line.Method) },
+		HeaderParsed:      func(name, value string) { events = append(events, "header "+name+"="+value) },
+		BodyChunkRead:     func(n int) { events = append(events, fmt.Sprintf("body %d", n)) },
+		ParseError:        func(err error) { events = append(events, "error "+err.Error()) },
+	})
+
+	// Test: Callbacks fire in parse order
+	reader := &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6\r\n\r\nabcdef",
+		numBytesPerRead: 57,
+	}
+	r, err := RequestFromReaderContext(ctx, reader, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, ctx, r.Context())
+	assert.Equal(t, []string{"line POST", "header Host=localhost", "header Content-Length=6", "body 2", "body 4"}, events)
+
+	// Test: Failures are reported
+	events = nil
+	reader = &chunkReader{data: "GET / HTTP/1.1\r\nHost: local", numBytesPerRead: 3}
+	_, err = RequestFromReaderContext(ctx, reader, Options{})
+	require.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []string{"line GET", "error EOF"}, events)
+}
+
+func TestBodyProgress(t *testing.T) {
+	// Test: Progress is reported as the body arrives, with the head parsed
+	seen := []BodyProgress{}
+	options := Options{OnBodyProgress: func(r *Request, p BodyProgress) error {
+		assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+		seen = append(seen, p)
+		return nil
+	}}
+	reader := &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabcdefghij",
+		numBytesPerRead: 4,
+	}
+	_, err := RequestFromReaderWithOptions(reader, options)
+	require.NoError(t, err)
+	require.NotEmpty(t, seen)
+	last := seen[len(seen)-1]
+	assert.Equal(t, int64(10), last.Received)
+	assert.Equal(t, int64(10), last.Expected)
+	assert.Positive(t, last.Elapsed)
+	assert.Positive(t, last.Rate())
+
+	// Test: Chunked bodies have no expected length
+	seen = nil
+	reader = &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n",
+		numBytesPerRead: 64,
+	}
+	_, err = RequestFromReaderWithOptions(reader, options)
+	require.NoError(t, err)
+	assert.Len(t, seen, 2)
+	assert.Equal(t, BodyProgress{Received: 5, Expected: -1, Elapsed: seen[1].Elapsed, Chunk: []byte("de")}, seen[1])
+
+	// Test: A hook that takes the body gets every chunk and leaves it empty
+	taken := ""
+	options.OnBodyProgress = func(r *Request, p BodyProgress) error {
+		if p.Received == int64(len(p.Chunk)) {
+			r.TakeBody()
+		}
+		if r.BodyTaken() {
+			taken += string(p.Chunk)
+		}
+		return nil
+	}
+	reader = &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabcdefghij",
+		numBytesPerRead: 4,
+	}
+	r, err := RequestFromReaderWithOptions(reader, options)
+	require.NoError(t, err)
+	assert.True(t, r.BodyTaken())
+	assert.Empty(t, r.Body)
+	assert.Equal(t, "abcdefghij", taken)
+
+	// Test: An error from the hook aborts the body mid-stream
+	tooBig := fmt.Errorf("upload too large")
+	options.OnBodyProgress = func(r *Request, p BodyProgress) error {
+		if p.Received > 4 {
+			return tooBig
+		}
+		return nil
+	}
+	reader = &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabcdefghij",
+		numBytesPerRead: 4,
+	}
+	_, err = RequestFromReaderWithOptions(reader, options)
+	assert.ErrorIs(t, err, ERROR_BODY_ABORTED)
+	assert.ErrorIs(t, err, tooBig)
+}
+
+func TestWireBytes(t *testing.T) {
+	// Test: Head and body are counted apart, chunk framing and trailers
+	// included in the body
+	head := "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"
+	body := "3\r\nabc\r\n0\r\nX-Sum: 1\r\n\r\n"
+	reader := &chunkReader{data: head + body + "GET / HTTP/1.1\r\n\r\n", numBytesPerRead: 5}
+	p := NewParser(reader, Options{})
+	defer p.Release()
+	r, err := p.Next(context.Background())
+	require.NoError(t, err)
+	h, b := r.WireBytes()
+	assert.Equal(t, int64(len(head)), h)
+	assert.Equal(t, int64(len(body)), b)
+
+	// Test: A request without a body is all head
+	r, err = p.Next(context.Background())
+	require.NoError(t, err)
+	h, b = r.WireBytes()
+	assert.Equal(t, int64(len("GET / HTTP/1.1\r\n\r\n")), h)
+	assert.Zero(t, b)
+}
+
+func TestNormalize(t *testing.T) {
+	parse := func(raw string) *Request {
+		r, err := RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		return r
+	}
+
+	// Test: Host is lowercased and the path made canonical, the query left alone
+	r := parse("GET /%7euser/a%2fb/%41?q=%7e HTTP/1.1\r\nHost: Example.COM\r\n\r\n")
+	require.NoError(t, r.Normalize())
+	assert.Equal(t, "/~user/a%2Fb/A?q=%7e", r.RequestLine.RequestTarget)
+	assert.Equal(t, "/%7euser/a%2fb/%41?q=%7e", r.RawTarget())
+	host, _ := r.Headers.Get("host")
+	assert.Equal(t, "example.com", host)
+
+	// Test: Normalizing twice changes nothing and keeps the raw target
+	require.NoError(t, r.Normalize())
+	assert.Equal(t, "/~user/a%2Fb/A?q=%7e", r.RequestLine.RequestTarget)
+	assert.Equal(t, "/%7euser/a%2fb/%41?q=%7e", r.RawTarget())
+
+	// Test: The scheme and host of an absolute-form target are lowercased
+	r = parse("GET HTTP://Example.com/A%7e HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, r.Normalize())
+	assert.Equal(t, "http://example.com/A~", r.RequestLine.RequestTarget)
+
+	// Test: An unchanged target is its own raw form
+	r = parse("GET /plain HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, r.Normalize())
+	assert.Equal(t, "/plain", r.RawTarget())
+
+	// Test: Values set by code are trimmed too
+	r.Headers.Set("X-Padded", "\t value ")
+	require.NoError(t, r.Normalize())
+	padded, _ := r.Headers.Get("x-padded")
+	assert.Equal(t, "value", padded)
+
+	// Test: Two Hosts and broken escapes are refused
+	r = parse("GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n")
+	assert.ErrorIs(t, r.Normalize(), ERROR_DUPLICATE_HOST)
+	r = parse("GET /a%2 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.ErrorIs(t, r.Normalize(), ERROR_INVALID_PATH_ENCODING)
+	r = parse("GET /a%zz HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.ErrorIs(t, r.Normalize(), ERROR_INVALID_PATH_ENCODING)
+}
+
+func TestParserPipelining(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /a HTTP/1.1\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /c HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 1024,
+	}
+	p := NewParser(reader, Options{})
+
+	// Test: Requests sent back to back are split at their boundaries
+	r, err := p.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "/a", r.RequestLine.RequestTarget)
+	assert.Equal(t, "abc", r.Body)
+	assert.Positive(t, p.Buffered())
+
+	r, err = p.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+
+	r, err = p.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "/c", r.RequestLine.RequestTarget)
+	assert.Zero(t, p.Buffered())
+
+	// Test: A clean close between requests is io.EOF
+	_, err = p.Next(context.Background())
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Malformed request lines are errors
+	_, err = RequestFromReader(strings.NewReader("GET /\r\n\r\n"))
+	assert.ErrorIs(t, err, ERROR_MALFORMED_REQUEST_LINE)
+}
+
+func TestRequestSpill(t *testing.T) {
+	dir := t.TempDir()
+	options := Options{SpillThreshold: 16, SpillDir: dir}
+	files := func() int {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	// Test: A small body stays in memory
+	r, err := RequestFromReaderWithOptions(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"), options)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", r.Body)
+	assert.Equal(t, 0, files())
+
+	// Test: Larger bodies, chunked ones too, go to a file and read back whole
+	body := strings.Repeat("0123456789", 10)
+	for _, raw := range []string{
+		fmt.Sprintf("POST / HTTP/1.1\r\nContent-Length: %d\r\n\r\n%s", len(body), body),
+		fmt.Sprintf("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n%s\r\n%x\r\n%s\r\n0\r\n\r\n", body[:5], len(body)-5, body[5:]),
+	} {
+		r, err = RequestFromReaderWithOptions(&chunkReader{data: raw, numBytesPerRead: 7}, options)
+		require.NoError(t, err)
+		assert.Empty(t, r.Body)
+		assert.Equal(t, int64(len(body)), r.BodyLen())
+		got, err := io.ReadAll(r.BodyReader())
+		require.NoError(t, err)
+		assert.Equal(t, body, string(got))
+		assert.Equal(t, 1, files())
+
+		// Test: Close removes the file
+		require.NoError(t, r.Close())
+		assert.Equal(t, 0, files())
+	}
+}
@@ -0,0 +1,27 @@
+package request
+
+import "context"
+
+// RequestTrace hooks into the parser as it works through a request, e.g. to
+// measure time to first header or spot clients that trickle bytes. Any
+// callback may be nil.
+type RequestTrace struct {
+	RequestLineParsed func(line RequestLine)
+	HeaderParsed      func(name, value string)
+	// BodyChunkRead reports each run of body bytes consumed.
+	BodyChunkRead func(n int)
+	// ParseError reports why the request could not be read, including I/O
+	// errors such as timeouts.
+	ParseError func(err error)
+}
+
+type traceKey struct{}
+
+func WithTrace(ctx context.Context, trace *RequestTrace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+func ContextTrace(ctx context.Context) *RequestTrace {
+	trace, _ := ctx.Value(traceKey{}).(*RequestTrace)
+	return trace
+}
@@ -0,0 +1,155 @@
+package response
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"tcp.to.http/internal/headers"
+)
+
+var ERROR_RANGE_NOT_SATISFIABLE = fmt.Errorf("no satisfiable range")
+
+// maxRanges bounds how many ranges one request may ask for; more than that
+// is treated as no Range header at all rather than as that many parts.
+const maxRanges = 32
+
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+func (r ByteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange reads a Range header for a representation of size bytes. A
+// header that is not a valid bytes range set, or asks for too many ranges,
+// gives no ranges and should be ignored; ERROR_RANGE_NOT_SATISFIABLE means
+// none of its ranges overlap the content.
+func ParseRange(value string, size int64) ([]ByteRange, error) {
+	set, ok := strings.CutPrefix(strings.TrimSpace(value), "bytes=")
+	if !ok {
+		return nil, nil
+	}
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, nil
+	}
+
+	ranges := []ByteRange{}
+	for _, spec := range specs {
+		first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+		if !ok {
+			return nil, nil
+		}
+		if first == "" {
+			// A suffix range, the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, ByteRange{Start: size - n, Length: n})
+			continue
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, nil
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, ByteRange{Start: start, Length: end - start + 1})
+	}
+	if len(ranges) == 0 {
+		return nil, ERROR_RANGE_NOT_SATISFIABLE
+	}
+	return ranges, nil
+}
+
+// WriteRangeNotSatisfiable answers a Range none of whose ranges fit in size
+// bytes.
+func (w *Writer) WriteRangeNotSatisfiable(size int64) error {
+	h := GetDefaultHeaders(0)
+	h.Replace("Content-Range", fmt.Sprintf("bytes */%d", size))
+	if err := w.WriteStatusLine(StatusRangeNotSatisfied); err != nil {
+		return err
+	}
+	return w.WriteHeaders(*h)
+}
+
+// ServeRanges sends ranges of content, size bytes in all, as a 206. h holds
+// the headers of the whole representation, such as its Content-Type and
+// Last-Modified. A single range is sent as is with a Content-Range; several
+// go as multipart/byteranges, each part with its own Content-Type and
+// Content-Range.
+func (w *Writer) ServeRanges(h *headers.Headers, content io.ReaderAt, size int64, ranges []ByteRange) error {
+	h = h.Clone()
+	contentType, _ := h.Get("content-type")
+	if len(ranges) == 1 {
+		h.Replace("Content-Length", fmt.Sprintf("%d", ranges[0].Length))
+		h.Replace("Content-Range", ranges[0].contentRange(size))
+		if err := w.WriteStatusLine(StatusPartialContent); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(*h); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, io.NewSectionReader(content, ranges[0].Start, ranges[0].Length))
+		return err
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	boundary := hex.EncodeToString(b)
+	// Part heads are built first so the length is known up front.
+	heads := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		heads[i] = fmt.Sprintf("--%s\r\n", boundary)
+		if i > 0 {
+			heads[i] = "\r\n" + heads[i]
+		}
+		if contentType != "" {
+			heads[i] += fmt.Sprintf("Content-Type: %s\r\n", contentType)
+		}
+		heads[i] += fmt.Sprintf("Content-Range: %s\r\n\r\n", r.contentRange(size))
+		length += int64(len(heads[i])) + r.Length
+	}
+	tail := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	length += int64(len(tail))
+
+	h.Replace("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Replace("Content-Length", fmt.Sprintf("%d", length))
+	if err := w.WriteStatusLine(StatusPartialContent); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+	for i, r := range ranges {
+		if _, err := w.WriteBody([]byte(heads[i])); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(content, r.Start, r.Length)); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteBody([]byte(tail))
+	return err
+}
@@ -0,0 +1,43 @@
+package response
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LogTimeFormat is the Common Log Format timestamp layout.
+const LogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+type clockReading struct {
+	unix int64
+	date string
+	log  string
+}
+
+// clock holds the current second, formatted once for every response and
+// log line written within it.
+var clock atomic.Pointer[clockReading]
+
+func readClock() *clockReading {
+	now := time.Now()
+	if r := clock.Load(); r != nil && r.unix == now.Unix() {
+		return r
+	}
+	r := &clockReading{
+		unix: now.Unix(),
+		date: now.UTC().Format(TimeFormat),
+		log:  now.Format(LogTimeFormat),
+	}
+	clock.Store(r)
+	return r
+}
+
+// Date is the current time as the Date header wants it.
+func Date() string {
+	return readClock().date
+}
+
+// LogTime is the current time as access logs write it.
+func LogTime() string {
+	return readClock().log
+}
@@ -0,0 +1,37 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/headers"
+)
+
+func TestDate(t *testing.T) {
+	// Test: Date is the current second in IMF-fixdate
+	d, err := time.Parse(TimeFormat, Date())
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), d, 2*time.Second)
+
+	// Test: Within a second the formatted value is reused
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() { Date() }))
+
+	// Test: The response head gets a Date unless it has one; trailers don't
+	out := &bytes.Buffer{}
+	w := NewWriter(out)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(*GetDefaultHeaders(0))
+	w.WriteHeaders(*headers.NewHeaders())
+	assert.Equal(t, 1, bytes.Count(out.Bytes(), []byte("date: ")))
+
+	out.Reset()
+	h := GetDefaultHeaders(0)
+	h.Replace("Date", "Thu, 01 Jan 1970 00:00:00 GMT")
+	w = NewWriter(out)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(*h)
+	assert.Equal(t, 1, bytes.Count(out.Bytes(), []byte("date: ")))
+}
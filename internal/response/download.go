@@ -0,0 +1,95 @@
+package response
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+)
+
+// ServeDownload sends r as an attachment named name. A negative size streams
+// the content chunked.
+func (w *Writer) ServeDownload(name string, r io.Reader, size int64) error {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	h := GetDefaultHeaders(int(size))
+	h.Replace("Content-Type", contentType)
+	h.Replace("Content-Disposition", ContentDisposition("attachment", name))
+	if size < 0 {
+		h.Delete("Content-Length")
+		h.Replace("Transfer-Encoding", "chunked")
+	}
+	if err := w.WriteStatusLine(StatusOK); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+
+	if size >= 0 {
+		_, err := io.CopyN(w, r, size)
+		return err
+	}
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := w.WriteChunkedBody(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			_, err := w.WriteChunkedBodyDone()
+			return err
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ContentDisposition builds the header value for name. Names that are not
+// plain ASCII get an RFC 5987 filename* parameter alongside an ASCII
+// fallback for older clients.
+func ContentDisposition(disposition, name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+
+	fallback := strings.Builder{}
+	ascii := true
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			ascii = false
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	value := fmt.Sprintf(`%s; filename="%s"`, disposition, fallback.String())
+	if !ascii {
+		value += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return value
+}
+
+func encodeRFC5987(s string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
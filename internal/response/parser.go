@@ -0,0 +1,465 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"tcp.to.http/internal/chunked"
+	"tcp.to.http/internal/headers"
+)
+
+type parseState string
+
+const (
+	stateStatusLine parseState = "status line"
+	stateHeaders    parseState = "headers"
+	stateBody       parseState = "body"
+	stateUntilClose parseState = "body until close"
+	stateChunkSize  parseState = "chunk size"
+	stateChunkData  parseState = "chunk data"
+	stateChunkEnd   parseState = "chunk end"
+	stateTrailers   parseState = "trailers"
+	stateDone       parseState = "done"
+	stateError      parseState = "error"
+)
+
+var ERROR_MALFORMED_STATUS_LINE = fmt.Errorf("malformed response status line")
+var ERROR_MALFORMED_CHUNK = fmt.Errorf("malformed chunked encoding")
+var ERROR_INVALID_CONTENT_LENGTH = fmt.Errorf("invalid content-length")
+var ERROR_RESPONSE_HEADERS_TOO_LARGE = fmt.Errorf("response header fields too large")
+var ERROR_RESPONSE_IN_ERROR_STATE = fmt.Errorf("response in error state")
+
+// The trailer errors are chunked's, so they are the same whether the body
+// was read whole or streamed.
+var ERROR_RESPONSE_TRAILERS_TOO_LARGE = chunked.ERROR_TRAILERS_TOO_LARGE
+var ERROR_TOO_MANY_RESPONSE_TRAILERS = chunked.ERROR_TOO_MANY_TRAILERS
+var SEPARATOR = []byte("\r\n")
+
+const DefaultMaxResponseHeaderBytes = 1 << 20
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// ParseOptions tells the parser what it cannot learn from the response
+// itself.
+type ParseOptions struct {
+	// Method is the request method; responses to HEAD carry no body.
+	Method         string
+	MaxHeaderBytes int
+	// MaxTrailerBytes and MaxTrailerFields bound the trailers of a chunked
+	// body; zero means chunked's defaults.
+	MaxTrailerBytes  int
+	MaxTrailerFields int
+}
+
+func (r *Response) hasBody() bool {
+	code := r.StatusLine.StatusCode
+	if r.options.Method == "CONNECT" && code >= 200 && code < 300 {
+		// What follows is the tunnel, not a body.
+		return false
+	}
+	return r.options.Method != "HEAD" && code >= 200 && code != StatusNoContent && code != StatusNotModified
+}
+
+// bodyState picks how the body is delimited once the headers are in.
+func (r *Response) bodyState() (parseState, error) {
+	if !r.hasBody() {
+		return stateDone, nil
+	}
+	if te, ok := r.Headers.Get("transfer-encoding"); ok {
+		codings := strings.Split(te, ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return stateChunkSize, nil
+		}
+		r.untilClose = true
+		return stateUntilClose, nil
+	}
+	if cl, ok := r.Headers.Get("content-length"); ok {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 {
+			return stateError, ERROR_INVALID_CONTENT_LENGTH
+		}
+		r.remaining = n
+		if n == 0 {
+			return stateDone, nil
+		}
+		return stateBody, nil
+	}
+	r.untilClose = true
+	return stateUntilClose, nil
+}
+
+// Reusable reports whether the connection the response came in on can carry
+// another request: HTTP/1.1, no Connection: close, and a body that did not
+// run until the server closed.
+func (r *Response) Reusable() bool {
+	if r.StatusLine.HttpVersion != "1.1" || r.untilClose {
+		return false
+	}
+	value, _ := r.Headers.Get("connection")
+	for _, token := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "close") {
+			return false
+		}
+	}
+	return true
+}
+
+func parseStatusLine(b []byte) (*StatusLine, int, error) {
+	idx := bytes.Index(b, SEPARATOR)
+	if idx == -1 {
+		return nil, 0, nil
+	}
+
+	version, rest, ok := strings.Cut(string(b[:idx]), " ")
+	if !ok || (version != "HTTP/1.1" && version != "HTTP/1.0") {
+		return nil, 0, ERROR_MALFORMED_STATUS_LINE
+	}
+	code, reason, _ := strings.Cut(rest, " ")
+	n, err := strconv.Atoi(code)
+	if err != nil || len(code) != 3 {
+		return nil, 0, ERROR_MALFORMED_STATUS_LINE
+	}
+
+	return &StatusLine{
+		HttpVersion:  strings.TrimPrefix(version, "HTTP/"),
+		StatusCode:   StatusCode(n),
+		ReasonPhrase: reason,
+	}, idx + len(SEPARATOR), nil
+}
+
+func (r *Response) inBody() bool {
+	return r.state != stateStatusLine && r.state != stateHeaders && r.state != stateDone
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	read := 0
+	for r.state != stateDone && !(r.headOnly && r.inBody()) {
+		current := data[read:]
+		if len(current) == 0 {
+			break
+		}
+
+		switch r.state {
+		case stateError:
+			return 0, ERROR_RESPONSE_IN_ERROR_STATE
+
+		case stateStatusLine:
+			sl, n, err := parseStatusLine(current)
+			if err != nil {
+				r.state = stateError
+				return 0, err
+			}
+			if n == 0 {
+				if len(current) > r.options.MaxHeaderBytes {
+					r.state = stateError
+					return 0, ERROR_RESPONSE_HEADERS_TOO_LARGE
+				}
+				return read, nil
+			}
+			r.StatusLine = *sl
+			read += n
+			r.headerBytes += n
+			r.state = stateHeaders
+
+		case stateHeaders, stateTrailers:
+			target := r.Headers
+			var each func(name, value string)
+			if r.state == stateTrailers {
+				target = r.Trailers
+				each = func(name, value string) {
+					r.trailerFields++
+				}
+			}
+			n, done, err := target.ParseEach(current, each)
+			if err != nil {
+				r.state = stateError
+				return 0, err
+			}
+			if r.state == stateTrailers {
+				pending := r.trailerBytes + n
+				if !done {
+					pending = r.trailerBytes + len(current)
+				}
+				switch {
+				case pending > r.options.MaxTrailerBytes:
+					err = ERROR_RESPONSE_TRAILERS_TOO_LARGE
+				case r.trailerFields > r.options.MaxTrailerFields:
+					err = ERROR_TOO_MANY_RESPONSE_TRAILERS
+				}
+				if err != nil {
+					r.state = stateError
+					return 0, err
+				}
+				r.trailerBytes += n
+			}
+			if r.headerBytes+n > r.options.MaxHeaderBytes || (!done && r.headerBytes+len(current) > r.options.MaxHeaderBytes) {
+				r.state = stateError
+				return 0, ERROR_RESPONSE_HEADERS_TOO_LARGE
+			}
+			read += n
+			r.headerBytes += n
+			if !done {
+				return read, nil
+			}
+			if r.state == stateTrailers {
+				r.state = stateDone
+				break
+			}
+			if r.state, err = r.bodyState(); err != nil {
+				return 0, err
+			}
+
+		case stateBody, stateChunkData:
+			n := min(r.remaining, len(current))
+			r.Body += string(current[:n])
+			read += n
+			r.remaining -= n
+			if r.remaining == 0 {
+				if r.state == stateBody {
+					r.state = stateDone
+				} else {
+					r.state = stateChunkEnd
+				}
+			}
+
+		case stateUntilClose:
+			r.Body += string(current)
+			read += len(current)
+
+		case stateChunkSize:
+			idx := bytes.Index(current, SEPARATOR)
+			if idx == -1 {
+				if len(current) > chunked.MaxSizeLineLength {
+					r.state = stateError
+					return 0, ERROR_MALFORMED_CHUNK
+				}
+				return read, nil
+			}
+			n, ext, err := chunked.ParseSizeLine(current[:idx])
+			if err == nil && n > math.MaxInt32 {
+				err = chunked.ERROR_MALFORMED_CHUNK
+			}
+			if r.extensions += ext; err == nil && r.extensions > chunked.MaxBodyExtensionBytes {
+				err = chunked.ERROR_EXTENSIONS_TOO_LARGE
+			}
+			if err != nil {
+				r.state = stateError
+				return 0, fmt.Errorf("%w: %w", ERROR_MALFORMED_CHUNK, err)
+			}
+			read += idx + len(SEPARATOR)
+			r.remaining = int(n)
+			if n == 0 {
+				r.state = stateTrailers
+			} else {
+				r.state = stateChunkData
+			}
+
+		case stateChunkEnd:
+			if len(current) < len(SEPARATOR) {
+				return read, nil
+			}
+			if !bytes.HasPrefix(current, SEPARATOR) {
+				r.state = stateError
+				return 0, ERROR_MALFORMED_CHUNK
+			}
+			read += len(SEPARATOR)
+			r.state = stateChunkSize
+		}
+	}
+	return read, nil
+}
+
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	return ResponseFromReaderWithOptions(reader, ParseOptions{})
+}
+
+func ResponseFromReaderWithOptions(reader io.Reader, options ParseOptions) (*Response, error) {
+	return NewParser(reader, options).Next()
+}
+
+// Parser reads successive responses from one connection, keeping bytes read
+// past the end of a response for the next call to Next.
+type Parser struct {
+	reader  io.Reader
+	options ParseOptions
+	pending []byte
+}
+
+func NewParser(reader io.Reader, options ParseOptions) *Parser {
+	if options.MaxHeaderBytes <= 0 {
+		options.MaxHeaderBytes = DefaultMaxResponseHeaderBytes
+	}
+	if options.MaxTrailerBytes <= 0 {
+		options.MaxTrailerBytes = chunked.DefaultMaxTrailerBytes
+	}
+	if options.MaxTrailerFields <= 0 {
+		options.MaxTrailerFields = chunked.DefaultMaxTrailerFields
+	}
+	return &Parser{reader: reader, options: options}
+}
+
+// Buffered reports how many bytes past the last response have been read.
+func (p *Parser) Buffered() int {
+	return len(p.pending)
+}
+
+// TakeBuffered hands over the bytes read past the last response, for a
+// caller taking over the connection after 101 Switching Protocols; the
+// parser forgets them.
+func (p *Parser) TakeBuffered() []byte {
+	b := p.pending
+	p.pending = nil
+	return b
+}
+
+// SetMethod changes the request method the next responses answer.
+func (p *Parser) SetMethod(method string) {
+	p.options.Method = method
+}
+
+func (p *Parser) newResponse() *Response {
+	return &Response{
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		state:    stateStatusLine,
+		options:  p.options,
+	}
+}
+
+// Next reads a whole response, body included.
+func (p *Parser) Next() (*Response, error) {
+	r := p.newResponse()
+	return r, p.read(r)
+}
+
+// NextStream reads the status line and headers and returns a reader for the
+// body. Trailers are filled in once the body has been read to EOF, and the
+// body must be read to EOF before the parser is used again.
+func (p *Parser) NextStream() (*Response, io.Reader, error) {
+	r := p.newResponse()
+	r.headOnly = true
+	if err := p.read(r); err != nil {
+		return r, nil, err
+	}
+
+	switch r.state {
+	case stateBody:
+		return r, &sizedBody{source{p}, int64(r.remaining)}, nil
+	case stateChunkSize:
+		br := bufio.NewReader(source{p})
+		cr := chunked.NewReader(br, r.Trailers)
+		cr.MaxTrailerBytes, cr.MaxTrailerFields = p.options.MaxTrailerBytes, p.options.MaxTrailerFields
+		return r, &restoreOnEOF{cr, br, p}, nil
+	case stateUntilClose:
+		return r, source{p}, nil
+	}
+	return r, bytes.NewReader(nil), nil
+}
+
+// sizedBody reads a Content-Length body, which the connection closing
+// before its end cuts short.
+type sizedBody struct {
+	r io.Reader
+	n int64
+}
+
+func (b *sizedBody) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	if err == io.EOF && b.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// source reads the bytes the parser already holds before the connection.
+type source struct {
+	p *Parser
+}
+
+func (s source) Read(b []byte) (int, error) {
+	if len(s.p.pending) > 0 {
+		n := copy(b, s.p.pending)
+		s.p.pending = s.p.pending[n:]
+		return n, nil
+	}
+	return s.p.reader.Read(b)
+}
+
+// restoreOnEOF hands what br buffered past the end of a chunked body back
+// to the parser for the next response.
+type restoreOnEOF struct {
+	io.Reader
+	br *bufio.Reader
+	p  *Parser
+}
+
+func (r *restoreOnEOF) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if err == io.EOF && r.br != nil {
+		rest, _ := r.br.Peek(r.br.Buffered())
+		r.p.pending = append(append([]byte(nil), rest...), r.p.pending...)
+		r.br = nil
+	}
+	return n, err
+}
+
+func (p *Parser) read(r *Response) error {
+	buf := make([]byte, max(1024, len(p.pending)))
+	bufLen := copy(buf, p.pending)
+	p.pending = nil
+	fresh := bufLen > 0
+	for {
+		if fresh {
+			n, err := r.parse(buf[:bufLen])
+			if err != nil {
+				return err
+			}
+			copy(buf, buf[n:bufLen])
+			bufLen -= n
+		}
+		if r.state == stateDone || (r.headOnly && r.inBody()) {
+			if bufLen > 0 {
+				p.pending = append([]byte(nil), buf[:bufLen]...)
+			}
+			return nil
+		}
+
+		if bufLen == len(buf) {
+			grown := make([]byte, len(buf)*2)
+			copy(grown, buf[:bufLen])
+			buf = grown
+		}
+		n, err := p.reader.Read(buf[bufLen:])
+		bufLen += n
+		fresh = n > 0
+		if err == io.EOF && n == 0 {
+			if r.state == stateUntilClose {
+				r.state = stateDone
+				return nil
+			}
+			if r.state == stateStatusLine && bufLen == 0 {
+				return io.EOF
+			}
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+}
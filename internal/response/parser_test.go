@@ -0,0 +1,142 @@
+package response
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// oneByteReader hands out a byte per Read, the worst case for the parser.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	return o.r.Read(p[:1])
+}
+
+func TestResponseParse(t *testing.T) {
+	// Test: Status line, headers and a Content-Length body
+	r, err := ResponseFromReader(oneByteReader{strings.NewReader("HTTP/1.1 404 Not Found\r\nContent-Length: 5\r\nX-A: b\r\n\r\nnope!")})
+	require.NoError(t, err)
+	assert.Equal(t, StatusNotFound, r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Found", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	v, _ := r.Headers.Get("x-a")
+	assert.Equal(t, "b", v)
+	assert.Equal(t, "nope!", r.Body)
+
+	// Test: Chunked body with extensions and trailers
+	raw := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5;name=x\r\nhello\r\n7\r\n, world\r\n0\r\nX-Sum: abc\r\n\r\n"
+	r, err = ResponseFromReader(oneByteReader{strings.NewReader(raw)})
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", r.Body)
+	v, _ = r.Trailers.Get("x-sum")
+	assert.Equal(t, "abc", v)
+
+	// Test: Without a length the body runs to EOF
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.0 200 OK\r\n\r\nall of it"))
+	require.NoError(t, err)
+	assert.Equal(t, "all of it", r.Body)
+
+	// Test: HEAD and 304 responses have no body
+	r, err = ResponseFromReaderWithOptions(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n"), ParseOptions{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+	r, err = ResponseFromReader(strings.NewReader("HTTP/1.1 304 Not Modified\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+
+	// Test: Malformed input
+	_, err = ResponseFromReader(strings.NewReader("HTTP/2 200 OK\r\n\r\n"))
+	assert.ErrorIs(t, err, ERROR_MALFORMED_STATUS_LINE)
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+	assert.ErrorIs(t, err, ERROR_MALFORMED_CHUNK)
+	_, err = ResponseFromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = ResponseFromReaderWithOptions(strings.NewReader("HTTP/1.1 200 OK\r\nX: "+strings.Repeat("a", 200)+"\r\n\r\n"), ParseOptions{MaxHeaderBytes: 100})
+	assert.ErrorIs(t, err, ERROR_RESPONSE_HEADERS_TOO_LARGE)
+}
+
+func TestResponseParserKeepAlive(t *testing.T) {
+	raw := "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\none" +
+		"HTTP/1.1 204 No Content\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\ntwo"
+	p := NewParser(strings.NewReader(raw), ParseOptions{})
+
+	// Test: Back to back responses are split at their boundaries
+	for _, want := range []string{"one", "", "two"} {
+		r, err := p.Next()
+		require.NoError(t, err)
+		assert.Equal(t, want, r.Body)
+	}
+	_, err := p.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestResponseParserStream(t *testing.T) {
+	raw := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\none\r\n0\r\nX-Sum: 1\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\ntwo" +
+		"HTTP/1.1 200 OK\r\n\r\nthree"
+	p := NewParser(oneByteReader{strings.NewReader(raw)}, ParseOptions{})
+
+	// Test: Bodies stream, and trailers arrive once the body is read
+	r, body, err := p.NextStream()
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+	b, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(b))
+	sum, _ := r.Trailers.Get("x-sum")
+	assert.Equal(t, "1", sum)
+	assert.True(t, r.Reusable())
+
+	// Test: The next response starts where the previous body ended
+	r, body, err = p.NextStream()
+	require.NoError(t, err)
+	b, _ = io.ReadAll(body)
+	assert.Equal(t, "two", string(b))
+
+	// Test: A body without a length runs to EOF and ends reuse
+	r, body, err = p.NextStream()
+	require.NoError(t, err)
+	b, _ = io.ReadAll(body)
+	assert.Equal(t, "three", string(b))
+	assert.False(t, r.Reusable())
+}
+
+func TestResponseParserStreamCutShort(t *testing.T) {
+	// Test: A connection closing inside a sized body is an error, not EOF
+	p := NewParser(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort"), ParseOptions{})
+	_, body, err := p.NextStream()
+	require.NoError(t, err)
+	b, err := io.ReadAll(body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "short", string(b))
+}
+
+func TestResponseParserTrailerLimits(t *testing.T) {
+	raw := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n" + strings.Repeat("X-A: 1\r\n", 3) + "\r\n"
+	options := ParseOptions{MaxTrailerFields: 2}
+
+	// Test: Whole and streamed bodies refuse the same trailers the same way
+	_, err := NewParser(oneByteReader{strings.NewReader(raw)}, options).Next()
+	assert.ErrorIs(t, err, ERROR_TOO_MANY_RESPONSE_TRAILERS)
+	_, body, err := NewParser(strings.NewReader(raw), options).NextStream()
+	require.NoError(t, err)
+	_, err = io.ReadAll(body)
+	assert.ErrorIs(t, err, ERROR_TOO_MANY_RESPONSE_TRAILERS)
+
+	// Test: Trailers over the size limit
+	options = ParseOptions{MaxTrailerBytes: 16}
+	_, err = NewParser(strings.NewReader(raw), options).Next()
+	assert.ErrorIs(t, err, ERROR_RESPONSE_TRAILERS_TOO_LARGE)
+	_, body, err = NewParser(strings.NewReader(raw), options).NextStream()
+	require.NoError(t, err)
+	_, err = io.ReadAll(body)
+	assert.ErrorIs(t, err, ERROR_RESPONSE_TRAILERS_TOO_LARGE)
+}
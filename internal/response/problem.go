@@ -0,0 +1,61 @@
+package response
+
+import (
+	"encoding/json"
+)
+
+// Problem is an RFC 7807 problem details object. It satisfies error so
+// handlers can return it directly.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     StatusCode
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+func NewProblem(status StatusCode, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+	return p.Title
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := map[string]any{}
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	m["type"] = p.Type
+	if p.Type == "" {
+		m["type"] = "about:blank"
+	}
+	m["title"] = p.Title
+	m["status"] = p.Status
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+func (w *Writer) WriteProblem(p *Problem) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	return w.writeWhole(p.Status, "application/problem+json", body)
+}
@@ -0,0 +1,74 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"sync"
+)
+
+// Render executes tmpl with data and sends the result as an HTML page with
+// status. The page is rendered in full before anything is written, so a
+// template error becomes a plain 500 rather than half a page; the error is
+// returned either way.
+func (w *Writer) Render(status StatusCode, tmpl *template.Template, data any) error {
+	body := bytes.Buffer{}
+	if err := tmpl.Execute(&body, data); err != nil {
+		w.renderFailed()
+		return err
+	}
+
+	return w.writeWhole(status, "text/html; charset=utf-8", body.Bytes())
+}
+
+// Templates caches the templates parsed from files in fsys matching
+// patterns. With Reload set they are parsed again on every lookup, so edits
+// show up without a restart while developing.
+type Templates struct {
+	Reload bool
+	Funcs  template.FuncMap
+
+	fsys     fs.FS
+	patterns []string
+
+	mu     sync.Mutex
+	parsed *template.Template
+}
+
+func NewTemplates(fsys fs.FS, patterns ...string) *Templates {
+	return &Templates{fsys: fsys, patterns: patterns}
+}
+
+// Lookup returns the template called name, parsing the files first if they
+// have not been parsed yet or Reload is set.
+func (t *Templates) Lookup(name string) (*template.Template, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.parsed == nil || t.Reload {
+		parsed, err := template.New("").Funcs(t.Funcs).ParseFS(t.fsys, t.patterns...)
+		if err != nil {
+			return nil, err
+		}
+		t.parsed = parsed
+	}
+	tmpl := t.parsed.Lookup(name)
+	if tmpl == nil {
+		return nil, fmt.Errorf("template %q not found", name)
+	}
+	return tmpl, nil
+}
+
+// Render sends the template called name, as Writer.Render does.
+func (t *Templates) Render(w *Writer, status StatusCode, name string, data any) error {
+	tmpl, err := t.Lookup(name)
+	if err != nil {
+		w.renderFailed()
+		return err
+	}
+	return w.Render(status, tmpl, data)
+}
+
+func (w *Writer) renderFailed() {
+	w.writeWhole(StatusInternalServeError, "text/plain", []byte(StatusText(StatusInternalServeError)))
+}
@@ -0,0 +1,52 @@
+package response
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	// Test: A page is sent as escaped HTML with its length
+	out := bytes.Buffer{}
+	tmpl := template.Must(template.New("page").Parse("<p>{{.}}</p>"))
+	require.NoError(t, NewWriter(&out).Render(StatusOK, tmpl, "<b>"))
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out.String(), "content-type: text/html; charset=utf-8\r\n")
+	assert.Contains(t, out.String(), "content-length: 16\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "<p>&lt;b&gt;</p>"))
+
+	// Test: A template error becomes a clean 500 with none of the page
+	out.Reset()
+	tmpl = template.Must(template.New("page").Parse("<p>before</p>{{.Missing}}"))
+	assert.Error(t, NewWriter(&out).Render(StatusOK, tmpl, 42))
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.NotContains(t, out.String(), "before")
+
+	// Test: Cached templates are parsed once unless Reload is set
+	fsys := fstest.MapFS{"views/hello.html": {Data: []byte(`{{define "hello"}}hello {{.}}{{end}}`)}}
+	templates := NewTemplates(fsys, "views/*.html")
+	out.Reset()
+	require.NoError(t, templates.Render(NewWriter(&out), StatusOK, "hello", "world"))
+	assert.True(t, strings.HasSuffix(out.String(), "hello world"))
+
+	fsys["views/hello.html"] = &fstest.MapFile{Data: []byte(`{{define "hello"}}bye {{.}}{{end}}`)}
+	out.Reset()
+	templates.Render(NewWriter(&out), StatusOK, "hello", "world")
+	assert.True(t, strings.HasSuffix(out.String(), "hello world"))
+
+	templates.Reload = true
+	out.Reset()
+	templates.Render(NewWriter(&out), StatusOK, "hello", "world")
+	assert.True(t, strings.HasSuffix(out.String(), "bye world"))
+
+	// Test: An unknown template name is a 500
+	out.Reset()
+	assert.Error(t, templates.Render(NewWriter(&out), StatusOK, "missing", nil))
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+}
@@ -1,24 +1,120 @@
 package response
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
+	"net"
 
 	// "golang.org/x/text/message"
 	"tcp.to.http/internal/headers"
 )
 
+// Response is a parsed HTTP response, the counterpart of request.Request.
 type Response struct {
+	StatusLine StatusLine
+	Headers    *headers.Headers
+	Body       string
+	// Trailers holds the fields sent after a chunked body.
+	Trailers *headers.Headers
+
+	state       parseState
+	options     ParseOptions
+	remaining   int
+	extensions  int
+	headerBytes int
+	// trailerBytes and trailerFields count the trailers parsed so far.
+	trailerBytes  int
+	trailerFields int
+	untilClose    bool
+	headOnly      bool
 }
 
 type StatusCode int
 
+// TimeFormat is the IMF-fixdate layout used by Date, Expires and
+// Last-Modified.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
 const (
+	StatusSwitchingProtocols StatusCode = 101
+	StatusEarlyHints         StatusCode = 103
 	StatusOK                 StatusCode = 200
+	StatusCreated            StatusCode = 201
+	StatusAccepted           StatusCode = 202
+	StatusNoContent          StatusCode = 204
+	StatusPartialContent     StatusCode = 206
+	StatusMovedPermanently   StatusCode = 301
+	StatusFound              StatusCode = 302
+	StatusSeeOther           StatusCode = 303
+	StatusNotModified        StatusCode = 304
+	StatusTemporaryRedirect  StatusCode = 307
+	StatusPermanentRedirect  StatusCode = 308
 	StatusBadRequest         StatusCode = 400
+	StatusUnauthorized       StatusCode = 401
+	StatusForbidden          StatusCode = 403
+	StatusNotFound           StatusCode = 404
+	StatusMethodNotAllowed   StatusCode = 405
+	StatusNotAcceptable      StatusCode = 406
+	StatusConflict           StatusCode = 409
+	StatusGone               StatusCode = 410
+	StatusPreconditionFailed StatusCode = 412
+	StatusContentTooLarge    StatusCode = 413
+	StatusURITooLong         StatusCode = 414
+	StatusUnsupportedMedia   StatusCode = 415
+	StatusRangeNotSatisfied  StatusCode = 416
+	StatusUpgradeRequired    StatusCode = 426
+	StatusTooManyRequests    StatusCode = 429
+	StatusHeaderTooLarge     StatusCode = 431
 	StatusInternalServeError StatusCode = 500
+	StatusNotImplemented     StatusCode = 501
+	StatusBadGateway         StatusCode = 502
+	StatusServiceUnavailable StatusCode = 503
+	StatusGatewayTimeout     StatusCode = 504
 )
 
+var statusText = map[StatusCode]string{
+	StatusSwitchingProtocols: "Switching Protocols",
+	StatusEarlyHints:         "Early Hints",
+	StatusOK:                 "OK",
+	StatusCreated:            "Created",
+	StatusAccepted:           "Accepted",
+	StatusNoContent:          "No Content",
+	StatusPartialContent:     "Partial Content",
+	StatusMovedPermanently:   "Moved Permanently",
+	StatusFound:              "Found",
+	StatusSeeOther:           "See Other",
+	StatusNotModified:        "Not Modified",
+	StatusTemporaryRedirect:  "Temporary Redirect",
+	StatusPermanentRedirect:  "Permanent Redirect",
+	StatusBadRequest:         "Bad Request",
+	StatusUnauthorized:       "Unauthorized",
+	StatusForbidden:          "Forbidden",
+	StatusNotFound:           "Not Found",
+	StatusMethodNotAllowed:   "Method Not Allowed",
+	StatusNotAcceptable:      "Not Acceptable",
+	StatusConflict:           "Conflict",
+	StatusGone:               "Gone",
+	StatusPreconditionFailed: "Precondition Failed",
+	StatusContentTooLarge:    "Content Too Large",
+	StatusURITooLong:         "URI Too Long",
+	StatusUnsupportedMedia:   "Unsupported Media Type",
+	StatusRangeNotSatisfied:  "Range Not Satisfiable",
+	StatusUpgradeRequired:    "Upgrade Required",
+	StatusTooManyRequests:    "Too Many Requests",
+	StatusHeaderTooLarge:     "Request Header Fields Too Large",
+	StatusInternalServeError: "Internal Server Error",
+	StatusNotImplemented:     "Not Implemented",
+	StatusBadGateway:         "Bad Gateway",
+	StatusServiceUnavailable: "Service Unavailable",
+	StatusGatewayTimeout:     "Gateway Timeout",
+}
+
+func StatusText(code StatusCode) string {
+	return statusText[code]
+}
+
 func GetDefaultHeaders(contentLen int) *headers.Headers {
 	h := headers.NewHeaders()
 	h.Set("Content-Length", fmt.Sprintf("%d", contentLen))
@@ -29,41 +125,322 @@ func GetDefaultHeaders(contentLen int) *headers.Headers {
 }
 
 type Writer struct {
-	writer io.Writer
+	writer      io.Writer
+	status      StatusCode
+	headersSent bool
+	onHeaders   []func(status StatusCode, h *headers.Headers)
+
+	// head holds the status line and headers back while bufferHead is set.
+	bufferHead bool
+	head       []byte
+	// misuse is the first write refused for being out of order.
+	misuse error
+	// dropTrailers leaves trailer fields out; see DropTrailers.
+	dropTrailers bool
+	// dropBody sends the head alone; see DropBody.
+	dropBody bool
+	// headBytes and bodyBytes count what has been written; see WireBytes.
+	headBytes int64
+	bodyBytes int64
+}
+
+var ERROR_STATUS_WRITTEN = fmt.Errorf("status line already written")
+var ERROR_NO_STATUS = fmt.Errorf("headers written before the status line")
+
+// buffersWriter is implemented by the server's connections to write
+// several buffers in one syscall.
+type buffersWriter interface {
+	WriteBuffers(bufs net.Buffers) (int64, error)
 }
 
 func NewWriter(writer io.Writer) *Writer {
 	return &Writer{writer: writer}
 }
 
+// BufferHead holds the status line and headers back until the first body
+// write or Flush, so a small response goes out in a single write.
+func (w *Writer) BufferHead() {
+	w.bufferHead = true
+}
+
+// Flush writes out a head held back by BufferHead.
+func (w *Writer) Flush() error {
+	if len(w.head) == 0 {
+		return nil
+	}
+	head := w.head
+	w.head = nil
+	_, err := w.writer.Write(head)
+	return err
+}
+
+// write sends p, behind the held back head if there is one, counting it as
+// body.
+func (w *Writer) write(p []byte) (int, error) {
+	if w.dropBody {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	n, err := w.writeBehindHead(p)
+	w.bodyBytes += int64(n)
+	return n, err
+}
+
+func (w *Writer) writeBehindHead(p []byte) (int, error) {
+	if len(w.head) == 0 {
+		return w.writer.Write(p)
+	}
+	head := w.head
+	w.head = nil
+	var n int64
+	var err error
+	if bw, ok := w.writer.(buffersWriter); ok {
+		n, err = bw.WriteBuffers(net.Buffers{head, p})
+	} else {
+		var m int
+		m, err = w.writer.Write(append(head, p...))
+		n = int64(m)
+	}
+	return max(0, int(n)-len(head)), err
+}
+
+// OnHeaders registers fn to run right before the response headers are
+// written, giving middleware a last chance to add or change them.
+func (w *Writer) OnHeaders(fn func(status StatusCode, h *headers.Headers)) {
+	w.onHeaders = append(w.onHeaders, fn)
+}
+
+// Vary records that the response depends on the request headers names, so
+// they are added to its Vary header for caches whatever status is sent.
+func (w *Writer) Vary(names ...string) {
+	w.OnHeaders(func(_ StatusCode, h *headers.Headers) {
+		h.AddVary(names...)
+	})
+}
+
+// DropTrailers makes w leave out trailer fields, and the Trailer header
+// announcing them, for a client that did not say it wants them with TE:
+// trailers. The chunked body still ends properly.
+func (w *Writer) DropTrailers() {
+	w.dropTrailers = true
+}
+
+// DropBody makes w send the status line and headers but nothing after
+// them, for answering HEAD with a handler written for GET. Body writes
+// still succeed.
+func (w *Writer) DropBody() {
+	w.dropBody = true
+}
+
+// SendsTrailers reports whether trailers written to w reach the client.
+func (w *Writer) SendsTrailers() bool {
+	return !w.dropTrailers
+}
+
+// WireBytes reports how many bytes of the response have been written: the
+// status line and header fields, then the body with any chunk framing and
+// trailers. A head held back by BufferHead counts once written to w.
+func (w *Writer) WireBytes() (head, body int64) {
+	return w.headBytes, w.bodyBytes
+}
+
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// Misuse is the first out of order write w refused, such as a second status
+// line, which would otherwise have corrupted the response on the wire.
+func (w *Writer) Misuse() error {
+	return w.misuse
+}
+
+func (w *Writer) refuse(err error) error {
+	if w.misuse == nil {
+		w.misuse = err
+	}
+	return err
+}
+
 func (w *Writer) WriteHeaders(h headers.Headers) error {
+	if w.status == 0 {
+		return w.refuse(ERROR_NO_STATUS)
+	}
+	// Later calls write trailers, which take no Date.
+	head := !w.headersSent
+	switch {
+	case head:
+		w.headersSent = true
+		if len(w.onHeaders) > 0 || w.dropTrailers {
+			h = *h.Clone()
+			for _, fn := range w.onHeaders {
+				fn(w.status, &h)
+			}
+		}
+		if w.dropTrailers {
+			h.Delete("Trailer")
+		}
+	case w.dropTrailers:
+		// An empty trailer section still ends the chunked body.
+		h = *headers.NewHeaders()
+	}
+
 	b := []byte{}
 	h.ForEach(func(n, v string) {
 		b = fmt.Appendf(b, "%s: %s\r\n", n, v)
 	})
+	if _, ok := h.Get("date"); head && !ok {
+		b = fmt.Appendf(b, "date: %s\r\n", Date())
+	}
 	b = fmt.Append(b, "\r\n")
+	if !head {
+		_, err := w.write(b)
+		return err
+	}
+	w.headBytes += int64(len(b))
+	if w.bufferHead {
+		w.head = append(w.head, b...)
+		return nil
+	}
 	_, err := w.writer.Write(b)
 	return err
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	statusLine := []byte{}
-	switch statusCode {
-	case StatusOK:
-		statusLine = []byte("HTTP/1.1 200 OK\r\n")
-	case StatusBadRequest:
-		statusLine = []byte("HTTP/1.1 400 Bad Request\r\n")
-	case StatusInternalServeError:
-		statusLine = []byte("HTTP/1.1 500 Internal Server Error\r\n")
-	default:
+	// Codes without a known reason phrase (e.g. relayed from a backend) are
+	// still valid on the wire; the phrase is simply left empty.
+	if statusCode < 100 || statusCode > 999 {
 		return fmt.Errorf("unrecognized error code")
 	}
+	if w.status != 0 {
+		return w.refuse(fmt.Errorf("%w: %d, then %d", ERROR_STATUS_WRITTEN, w.status, statusCode))
+	}
+	text := statusText[statusCode]
+	w.status = statusCode
+	statusLine := fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", statusCode, text)
+	w.headBytes += int64(len(statusLine))
+	if w.bufferHead {
+		w.head = append(w.head, statusLine...)
+		return nil
+	}
 	_, err := w.writer.Write(statusLine)
 	return err
 }
 
+// WriteInformational sends an interim 1xx response, such as 103 Early Hints,
+// ahead of the final one. It goes out at once, even with BufferHead, and
+// leaves w ready for the final status line.
+func (w *Writer) WriteInformational(statusCode StatusCode, h headers.Headers) error {
+	if statusCode < 100 || statusCode > 199 || statusCode == StatusSwitchingProtocols {
+		return fmt.Errorf("not an informational status: %d", statusCode)
+	}
+	if w.status != 0 {
+		return w.refuse(fmt.Errorf("%w: %d, then %d", ERROR_STATUS_WRITTEN, w.status, statusCode))
+	}
+	b := fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", statusCode, statusText[statusCode])
+	h.ForEach(func(n, v string) {
+		b = fmt.Appendf(b, "%s: %s\r\n", n, v)
+	})
+	b = fmt.Append(b, "\r\n")
+	w.headBytes += int64(len(b))
+	_, err := w.writer.Write(b)
+	return err
+}
+
 func (w *Writer) WriteBody(p []byte) (int, error) {
-	n, err := w.writer.Write(p)
+	n, err := w.write(p)
+
+	return n, err
+}
 
+// ReadFrom copies r into the body, letting the server's connection use
+// sendfile or splice for files and sockets.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if w.dropBody {
+		return io.Copy(io.Discard, r)
+	}
+	var n int64
+	var err error
+	if rf, ok := w.writer.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(w.writer, r)
+	}
+	w.bodyBytes += n
 	return n, err
 }
+
+// Tee copies everything written from now on, status line and headers
+// included, to dst as well.
+func (w *Writer) Tee(dst io.Writer) {
+	w.writer = io.MultiWriter(w.writer, dst)
+}
+
+// Write makes the Writer usable as an io.Writer for the body.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	chunk := fmt.Appendf(nil, "%x\r\n", len(p))
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+	if _, err := w.write(chunk); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	return w.write([]byte("0\r\n\r\n"))
+}
+
+// WriteTrailers ends a chunked body with the last chunk and the trailer
+// fields h.
+func (w *Writer) WriteTrailers(h headers.Headers) error {
+	if _, err := w.write([]byte("0\r\n")); err != nil {
+		return err
+	}
+	return w.WriteHeaders(h)
+}
+
+func (w *Writer) WriteJSON(statusCode StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.writeWhole(statusCode, "application/json", body)
+}
+
+// WriteXML sends v encoded as an XML document, declaration included.
+func (w *Writer) WriteXML(statusCode StatusCode, v any) error {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.writeWhole(statusCode, "application/xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+func (w *Writer) WriteText(statusCode StatusCode, text string) error {
+	return w.writeWhole(statusCode, "text/plain; charset=utf-8", []byte(text))
+}
+
+func (w *Writer) writeWhole(statusCode StatusCode, contentType string, body []byte) error {
+	h := GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", contentType)
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(*h); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(body)
+	return err
+}
@@ -0,0 +1,134 @@
+package response
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/headers"
+)
+
+func TestWriterOrder(t *testing.T) {
+	out := bytes.Buffer{}
+	w := NewWriter(&out)
+
+	// Test: Headers before a status line are refused
+	assert.ErrorIs(t, w.WriteHeaders(*GetDefaultHeaders(0)), ERROR_NO_STATUS)
+	assert.Empty(t, out.String())
+
+	// Test: A second status line is refused and the first one stands
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(*GetDefaultHeaders(2))
+	w.WriteBody([]byte("ok"))
+	assert.ErrorIs(t, w.WriteStatusLine(StatusInternalServeError), ERROR_STATUS_WRITTEN)
+	assert.Equal(t, StatusOK, w.Status())
+	assert.NotContains(t, out.String(), "500")
+
+	// Test: The first refusal is remembered
+	assert.ErrorIs(t, w.Misuse(), ERROR_NO_STATUS)
+}
+
+func TestWriteInformational(t *testing.T) {
+	out := bytes.Buffer{}
+	w := NewWriter(&out)
+	w.BufferHead()
+
+	// Test: A 1xx goes out at once and the final response follows
+	h := headers.NewHeaders()
+	h.Set("Link", "</app.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, *h))
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nlink: </app.css>; rel=preload; as=style\r\n\r\n", out.String())
+	assert.Zero(t, w.Status())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	w.WriteHeaders(*GetDefaultHeaders(0))
+	w.Flush()
+	assert.Contains(t, out.String(), "\r\n\r\nHTTP/1.1 200 OK\r\n")
+
+	// Test: Final and upgrade statuses are refused, as is a 1xx after the final status
+	assert.Error(t, w.WriteInformational(StatusOK, *h))
+	assert.Error(t, w.WriteInformational(StatusSwitchingProtocols, *h))
+	assert.ErrorIs(t, w.WriteInformational(StatusEarlyHints, *h), ERROR_STATUS_WRITTEN)
+}
+
+func TestWireBytes(t *testing.T) {
+	// Test: Head and body bytes are counted apart, framing included
+	out := bytes.Buffer{}
+	w := NewWriter(&out)
+	w.BufferHead()
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Replace("Transfer-Encoding", "chunked")
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(*h)
+	head, body := w.WireBytes()
+	assert.Positive(t, head)
+	assert.Zero(t, body)
+
+	w.WriteChunkedBody([]byte("hello"))
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	w.WriteTrailers(*trailers)
+	head, body = w.WireBytes()
+	headEnd := strings.Index(out.String(), "\r\n\r\n") + 4
+	assert.Equal(t, int64(headEnd), head)
+	assert.Equal(t, int64(out.Len()-headEnd), body)
+	assert.Equal(t, int64(len("5\r\nhello\r\n0\r\nx-checksum: abc\r\n\r\n")), body)
+
+	// Test: Bodies copied with ReadFrom are counted
+	out.Reset()
+	w = NewWriter(&out)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(*GetDefaultHeaders(4))
+	w.ReadFrom(strings.NewReader("body"))
+	head, body = w.WireBytes()
+	assert.Equal(t, int64(out.Len()), head+body)
+	assert.Equal(t, int64(4), body)
+}
+
+func TestWriteFormats(t *testing.T) {
+	type item struct {
+		Name string `xml:"name"`
+	}
+
+	// Test: XML carries its declaration, charset and exact length
+	out := bytes.Buffer{}
+	assert.NoError(t, NewWriter(&out).WriteXML(StatusOK, item{Name: "tea"}))
+	body := xml.Header + "<item><name>tea</name></item>"
+	assert.Contains(t, out.String(), "content-type: application/xml; charset=utf-8\r\n")
+	assert.Contains(t, out.String(), fmt.Sprintf("content-length: %d\r\n", len(body)))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"+body))
+
+	// Test: Text length counts bytes, not runes
+	out.Reset()
+	assert.NoError(t, NewWriter(&out).WriteText(StatusCreated, "héllo"))
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 201 Created\r\n"))
+	assert.Contains(t, out.String(), "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, out.String(), "content-length: 6\r\n")
+
+	// Test: A value that cannot be encoded writes nothing
+	out.Reset()
+	assert.Error(t, NewWriter(&out).WriteXML(StatusOK, make(chan int)))
+	assert.Empty(t, out.String())
+}
+
+func TestParseRange(t *testing.T) {
+	// Test: Open, closed and suffix ranges are clipped to the content
+	ranges, err := ParseRange("bytes=0-0, 5-, -3, 8-100", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []ByteRange{{0, 1}, {5, 5}, {7, 3}, {8, 2}}, ranges)
+
+	// Test: Invalid headers are ignored rather than refused
+	for _, value := range []string{"items=0-1", "bytes=a-b", "bytes=5-1", "bytes=1", "bytes=" + strings.Repeat("0-1,", 40) + "0-1"} {
+		ranges, err := ParseRange(value, 10)
+		assert.NoError(t, err, value)
+		assert.Nil(t, ranges, value)
+	}
+
+	// Test: Only unsatisfiable ranges
+	_, err = ParseRange("bytes=10-, -0", 10)
+	assert.ErrorIs(t, err, ERROR_RANGE_NOT_SATISFIABLE)
+}
@@ -0,0 +1,318 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+// SensitiveHeaders are never echoed back by TRACE.
+var SensitiveHeaders = []string{
+	"authorization",
+	"proxy-authorization",
+	"cookie",
+	"set-cookie",
+	"x-api-key",
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]server.Handler
+}
+
+type Router struct {
+	routes           []*route
+	hints            map[string][]string
+	trace            bool
+	observer         Observer
+	notFound         server.Handler
+	methodNotAllowed server.Handler
+}
+
+// Observer is told about every request that reached a route handler, keyed
+// by the route pattern rather than the (high-cardinality) path.
+type Observer func(req *request.Request, pattern string, status response.StatusCode, d time.Duration)
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers h for method on pattern. Patterns are slash separated;
+// a "{name}" segment matches any single segment and a trailing "*" matches
+// the remainder of the path.
+func (r *Router) Handle(method, pattern string, h server.Handler) {
+	for _, rt := range r.routes {
+		if rt.pattern == pattern {
+			rt.handlers[method] = h
+			return
+		}
+	}
+	r.routes = append(r.routes, &route{
+		pattern:  pattern,
+		segments: split(pattern),
+		handlers: map[string]server.Handler{method: h},
+	})
+}
+
+// EnableTrace turns on the TRACE echo for every route. It is off by default
+// since it reflects request headers back to the caller.
+func (r *Router) EnableTrace() {
+	r.trace = true
+}
+
+// EarlyHints has requests matching pattern answered with a 103 Early Hints
+// carrying links, Link header values such as those from Preload and
+// Preconnect, before the route's handler runs; the client can start fetching
+// them while the handler works.
+func (r *Router) EarlyHints(pattern string, links ...string) {
+	if r.hints == nil {
+		r.hints = map[string][]string{}
+	}
+	r.hints[pattern] = append(r.hints[pattern], links...)
+}
+
+// Preload is the Link value asking the client to fetch url early, as the
+// kind of resource named by as, such as "style", "script" or "font".
+func Preload(url, as string) string {
+	link := fmt.Sprintf("<%s>; rel=preload; as=%s", url, as)
+	if as == "font" {
+		// Fonts are always fetched in CORS mode, and a preload without it
+		// is wasted.
+		link += "; crossorigin"
+	}
+	return link
+}
+
+// Preconnect is the Link value asking the client to open a connection to
+// origin, such as "https://cdn.example.com", ahead of time.
+func Preconnect(origin string) string {
+	return fmt.Sprintf("<%s>; rel=preconnect", origin)
+}
+
+func (r *Router) Observe(fn Observer) {
+	r.observer = fn
+}
+
+// NotFound sets the handler for paths no route matches, in place of a plain
+// 404.
+func (r *Router) NotFound(h server.Handler) {
+	r.notFound = h
+}
+
+// MethodNotAllowed sets the handler for methods a matched route does not
+// handle, in place of a plain 405. The Allow header is added to whatever it
+// sends.
+func (r *Router) MethodNotAllowed(h server.Handler) {
+	r.methodNotAllowed = h
+}
+
+type paramsKey struct{}
+type patternKey struct{}
+
+func Param(req *request.Request, name string) string {
+	params, _ := req.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+// Pattern returns the pattern of the route that matched req.
+func Pattern(req *request.Request) string {
+	pattern, _ := req.Context().Value(patternKey{}).(string)
+	return pattern
+}
+
+func Path(req *request.Request) string {
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	return path
+}
+
+func (r *Router) Serve(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+
+	if req.RequestLine.RequestTarget == "*" {
+		if method != "OPTIONS" {
+			server.Error(w, req, response.StatusBadRequest, "")
+			return
+		}
+		writeEmpty(w, response.StatusOK, strings.Join(r.methods(r.routes...), ", "))
+		return
+	}
+
+	matched := r.match(Path(req))
+	if len(matched) == 0 {
+		if r.notFound != nil {
+			r.notFound(w, req)
+			return
+		}
+		server.Error(w, req, response.StatusNotFound, "")
+		return
+	}
+
+	// The first route that matches and handles the method wins; the Allow
+	// header otherwise covers every route the path matched, since patterns
+	// such as "/users/{id}" and "/users/me" can overlap. HEAD falls back
+	// to GET, with the body left out.
+	var rt *route
+	var params map[string]string
+	var h server.Handler
+	headAsGet := false
+	routes := []*route{}
+	for _, m := range matched {
+		if handler, ok := m.route.handlers[method]; ok {
+			rt, params, h = m.route, m.params, handler
+			break
+		}
+		if handler, ok := m.route.handlers["GET"]; ok && method == "HEAD" {
+			rt, params, h, headAsGet = m.route, m.params, handler, true
+			break
+		}
+		routes = append(routes, m.route)
+	}
+	if h == nil {
+		allow := strings.Join(r.methods(routes...), ", ")
+		switch {
+		case method == "OPTIONS":
+			writeEmpty(w, response.StatusOK, allow)
+		case method == "TRACE" && r.trace:
+			echo(w, req)
+		default:
+			w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+				h.Replace("Allow", allow)
+			})
+			if r.methodNotAllowed != nil {
+				r.methodNotAllowed(w, req)
+				return
+			}
+			server.Error(w, req, response.StatusMethodNotAllowed, "")
+		}
+		return
+	}
+
+	ctx := context.WithValue(req.Context(), patternKey{}, rt.pattern)
+	if len(params) > 0 {
+		ctx = context.WithValue(ctx, paramsKey{}, params)
+	}
+	req = req.WithContext(ctx)
+	if headAsGet {
+		w.DropBody()
+	}
+	if links := r.hints[rt.pattern]; len(links) > 0 {
+		h := headers.NewHeaders()
+		h.Set("Link", strings.Join(links, ", "))
+		w.WriteInformational(response.StatusEarlyHints, *h)
+	}
+
+	if r.observer == nil {
+		h(w, req)
+		return
+	}
+	start := time.Now()
+	h(w, req)
+	r.observer(req, rt.pattern, w.Status(), time.Since(start))
+}
+
+type match struct {
+	route  *route
+	params map[string]string
+}
+
+// match returns every route whose pattern matches path, in the order they
+// were registered.
+func (r *Router) match(path string) []match {
+	segments := split(path)
+	matched := []match{}
+outer:
+	for _, rt := range r.routes {
+		params := map[string]string{}
+		for i, s := range rt.segments {
+			if s == "*" && i == len(rt.segments)-1 {
+				params["*"] = strings.Join(segments[i:], "/")
+				matched = append(matched, match{rt, params})
+				continue outer
+			}
+			if i >= len(segments) {
+				continue outer
+			}
+			if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+				if segments[i] == "" {
+					continue outer
+				}
+				params[s[1:len(s)-1]] = segments[i]
+			} else if s != segments[i] {
+				continue outer
+			}
+		}
+		if len(rt.segments) == len(segments) {
+			matched = append(matched, match{rt, params})
+		}
+	}
+	return matched
+}
+
+func (r *Router) methods(routes ...*route) []string {
+	set := map[string]bool{"OPTIONS": true}
+	if r.trace {
+		set["TRACE"] = true
+	}
+	for _, rt := range routes {
+		for m := range rt.handlers {
+			set[m] = true
+		}
+	}
+	if set["GET"] {
+		set["HEAD"] = true
+	}
+
+	methods := []string{}
+	for m := range set {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+func split(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+func writeEmpty(w *response.Writer, status response.StatusCode, allow string) {
+	h := response.GetDefaultHeaders(0)
+	if allow != "" {
+		h.Replace("Allow", allow)
+	}
+	w.WriteStatusLine(status)
+	w.WriteHeaders(*h)
+}
+
+func echo(w *response.Writer, req *request.Request) {
+	names := []string{}
+	req.Headers.ForEach(func(n, v string) {
+		for _, s := range SensitiveHeaders {
+			if n == s {
+				return
+			}
+		}
+		names = append(names, n)
+	})
+	sort.Strings(names)
+
+	body := fmt.Appendf(nil, "%s %s HTTP/%s\r\n", req.RequestLine.Method, req.RequestLine.RequestTarget, req.RequestLine.HttpVersion)
+	for _, n := range names {
+		v, _ := req.Headers.Get(n)
+		body = fmt.Appendf(body, "%s: %s\r\n", n, v)
+	}
+	body = fmt.Append(body, "\r\n")
+
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", "message/http")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	w.WriteBody(body)
+}
@@ -0,0 +1,182 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func run(t *testing.T, r *Router, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	out := bytes.Buffer{}
+	r.Serve(response.NewWriter(&out), req)
+	return out.String()
+}
+
+func text(body string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		b := []byte(body + Param(req, "id") + Param(req, "*"))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(b)))
+		w.WriteBody(b)
+	}
+}
+
+func TestRouterMatch(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/", text("root"))
+	r.Handle("GET", "/users/{id}", text("user "))
+	r.Handle("GET", "/static/*", text("static "))
+
+	// Test: Static, param and wildcard routes
+	assert.True(t, strings.HasSuffix(run(t, r, "GET / HTTP/1.1\r\n\r\n"), "root"))
+	assert.True(t, strings.HasSuffix(run(t, r, "GET /users/42?x=1 HTTP/1.1\r\n\r\n"), "user 42"))
+	assert.True(t, strings.HasSuffix(run(t, r, "GET /static/css/site.css HTTP/1.1\r\n\r\n"), "static css/site.css"))
+
+	// Test: Unknown path
+	assert.True(t, strings.HasPrefix(run(t, r, "GET /users HTTP/1.1\r\n\r\n"), "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasPrefix(run(t, r, "GET /users/1/2 HTTP/1.1\r\n\r\n"), "HTTP/1.1 404 Not Found\r\n"))
+}
+
+func TestRouterHead(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/users/{id}", text("user "))
+	r.Handle("HEAD", "/own", func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Replace("X-Own", "yes")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+	})
+	r.Handle("GET", "/own", text("own"))
+
+	// Test: HEAD is answered by the GET handler, without the body
+	out := run(t, r, "HEAD /users/42 HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "content-length: 7\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"), out)
+
+	// Test: A HEAD handler of the route's own comes first
+	assert.Contains(t, run(t, r, "HEAD /own HTTP/1.1\r\n\r\n"), "x-own: yes\r\n")
+}
+
+func TestRouterOptionsAndTrace(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/coffee", text("coffee"))
+	r.Handle("POST", "/coffee", text("brewed"))
+	r.Handle("DELETE", "/tea", text("gone"))
+
+	// Test: OPTIONS on a route lists its methods
+	out := run(t, r, "OPTIONS /coffee HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: OPTIONS * lists every method the router knows
+	out = run(t, r, "OPTIONS * HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "allow: DELETE, GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: TRACE is disabled by default
+	out = run(t, r, "TRACE /coffee HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD, OPTIONS, POST\r\n")
+
+	// Test: TRACE echo strips sensitive headers
+	r.EnableTrace()
+	out = run(t, r, "TRACE /coffee HTTP/1.1\r\nHost: localhost\r\nCookie: secret=1\r\nAuthorization: Basic eA==\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: message/http\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nTRACE /coffee HTTP/1.1\r\nhost: localhost\r\n\r\n"))
+}
+
+func TestRouterObserve(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/users/{id}", text("user "))
+
+	observed := []string{}
+	r.Observe(func(req *request.Request, pattern string, status response.StatusCode, d time.Duration) {
+		observed = append(observed, fmt.Sprintf("%s %s %d %s", req.RequestLine.Method, pattern, status, Pattern(req)))
+	})
+
+	// Test: Observers see the route pattern, not the path
+	run(t, r, "GET /users/42 HTTP/1.1\r\n\r\n")
+	run(t, r, "GET /users/7 HTTP/1.1\r\n\r\n")
+	assert.Equal(t, []string{"GET /users/{id} 200 /users/{id}", "GET /users/{id} 200 /users/{id}"}, observed)
+
+	// Test: Unmatched requests are not observed
+	run(t, r, "GET /missing HTTP/1.1\r\n\r\n")
+	assert.Len(t, observed, 2)
+}
+
+func TestRouterFallbacks(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/coffee", text("coffee"))
+	page := func(status response.StatusCode, body string) func(w *response.Writer, req *request.Request) {
+		return func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(status)
+			w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+			w.WriteBody([]byte(body))
+		}
+	}
+
+	// Test: Without hooks the fallbacks are plain 404 and 405 responses
+	assert.True(t, strings.HasPrefix(run(t, r, "GET /tea HTTP/1.1\r\n\r\n"), "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasPrefix(run(t, r, "PUT /coffee HTTP/1.1\r\n\r\n"), "HTTP/1.1 405 Method Not Allowed\r\n"))
+
+	// Test: NotFound replaces the 404
+	r.NotFound(page(response.StatusNotFound, "no such page"))
+	out := run(t, r, "GET /tea HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasSuffix(out, "no such page"))
+
+	// Test: MethodNotAllowed replaces the 405 and still gets Allow
+	r.MethodNotAllowed(page(response.StatusMethodNotAllowed, "try GET"))
+	out = run(t, r, "PUT /coffee HTTP/1.1\r\n\r\n")
+	assert.Contains(t, out, "allow: GET, HEAD, OPTIONS\r\n")
+	assert.True(t, strings.HasSuffix(out, "try GET"))
+}
+
+func TestRouterOverlappingRoutes(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/users/{id}", text("user "))
+	r.Handle("DELETE", "/users/me", text("deleted"))
+	r.Handle("POST", "/users/*", text("posted "))
+
+	// Test: A later route that handles the method is used when an earlier
+	// match does not
+	assert.True(t, strings.HasSuffix(run(t, r, "DELETE /users/me HTTP/1.1\r\n\r\n"), "deleted"))
+	assert.True(t, strings.HasSuffix(run(t, r, "GET /users/me HTTP/1.1\r\n\r\n"), "user me"))
+	assert.True(t, strings.HasSuffix(run(t, r, "POST /users/me HTTP/1.1\r\n\r\n"), "posted me"))
+
+	// Test: Allow covers every route the path matched
+	out := run(t, r, "PUT /users/me HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "allow: DELETE, GET, HEAD, OPTIONS, POST\r\n")
+	assert.Contains(t, run(t, r, "OPTIONS /users/42 HTTP/1.1\r\n\r\n"), "allow: GET, HEAD, OPTIONS, POST\r\n")
+}
+
+func TestRouterEarlyHints(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/", text("home"))
+	r.Handle("GET", "/about", text("about"))
+	r.EarlyHints("/", Preload("/app.css", "style"), Preconnect("https://cdn.example.com"))
+	r.EarlyHints("/", Preload("/font.woff2", "font"))
+
+	// Test: A 103 with the route's links comes before the response
+	out := run(t, r, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 103 Early Hints\r\n"+
+		"link: </app.css>; rel=preload; as=style, <https://cdn.example.com>; rel=preconnect, </font.woff2>; rel=preload; as=font; crossorigin\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "home"))
+
+	// Test: Routes without hints and unmatched paths get none
+	assert.True(t, strings.HasPrefix(run(t, r, "GET /about HTTP/1.1\r\n\r\n"), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasPrefix(run(t, r, "GET /missing HTTP/1.1\r\n\r\n"), "HTTP/1.1 404 Not Found\r\n"))
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ERROR_CLIENT_GONE is the cause of a request context canceled because the
+// client closed the connection before the handler finished.
+var ERROR_CLIENT_GONE = fmt.Errorf("client closed the connection")
+
+// watchClient cancels the request context as soon as the client hangs up,
+// by keeping a read pending while the handler runs. A byte that does arrive
+// (a pipelined request) is kept for the next parse. When the client asked
+// for Connection: close, EOF is only its half-close and not taken as
+// hanging up. The returned stop must be called before the connection is
+// read again.
+func (c *conn) watchClient(cancel context.CancelCauseFunc, closing bool) (stop func()) {
+	c.cancel = cancel
+	nc, ok := c.ReadWriteCloser.(net.Conn)
+	if !ok || len(c.pending) > 0 {
+		return func() { c.cancel = nil }
+	}
+
+	nc.SetReadDeadline(time.Time{})
+	done := make(chan struct{})
+	aborted := atomic.Bool{}
+	go func() {
+		defer close(done)
+		b := make([]byte, 1)
+		n, err := nc.Read(b)
+		c.read.Add(int64(n))
+		if n > 0 {
+			c.pending = b[:n]
+			return
+		}
+		if err != nil && !aborted.Load() {
+			if err == io.EOF && closing {
+				return
+			}
+			if err != io.EOF {
+				c.fail(err)
+			}
+			cancel(ERROR_CLIENT_GONE)
+		}
+	}()
+
+	return func() {
+		aborted.Store(true)
+		nc.SetReadDeadline(time.Unix(1, 0))
+		<-done
+		nc.SetReadDeadline(time.Time{})
+		c.cancel = nil
+	}
+}
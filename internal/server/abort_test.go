@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestClientAbort(t *testing.T) {
+	cause := make(chan error, 1)
+	s := newTestServer()
+	s.handler = func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			cause <- context.Cause(req.Context())
+		case <-time.After(time.Second):
+			cause <- nil
+		}
+	}
+
+	// Test: Hanging up mid-handler cancels the request context
+	client, srv := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		runConnection(s, srv)
+		close(done)
+	}()
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	client.Close()
+	assert.ErrorIs(t, <-cause, ERROR_CLIENT_GONE)
+	<-done
+}
+
+func TestClientWatchKeepsPipelinedRequest(t *testing.T) {
+	s := newTestServer(WithIdleTimeout(time.Second))
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := s.handler
+	s.handler = func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		require.NoError(t, req.Context().Err())
+		handler(w, req)
+	}
+
+	// Test: A request sent while the previous one is in flight is not lost
+	client, srv := net.Pipe()
+	go runConnection(s, srv)
+	defer client.Close()
+	go func() {
+		client.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		<-started
+		go client.Write([]byte("GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	r := bufio.NewReader(client)
+	assert.Contains(t, readResponse(t, r), "200 OK")
+	assert.Contains(t, readResponse(t, r), "200 OK")
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+var benchRequest = []byte("GET / HTTP/1.1\r\nHost: localhost\r\nUser-Agent: bench/1.0\r\nAccept: */*\r\n\r\n")
+
+func benchServer(b *testing.B) *Server {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(2))
+		w.WriteBody([]byte("ok"))
+	}, WithHost("127.0.0.1"), WithIdleTimeout(time.Minute))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { s.Close() })
+	return s
+}
+
+// skipResponse reads one response from the "ok" handler above.
+func skipResponse(b *testing.B, r *bufio.Reader) {
+	for {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(line) == 2 {
+			break
+		}
+	}
+	if _, err := r.Discard(2); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkServeKeepAlive(b *testing.B) {
+	s := benchServer(b)
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := conn.Write(benchRequest); err != nil {
+			b.Fatal(err)
+		}
+		skipResponse(b, r)
+	}
+}
+
+func BenchmarkServeNewConnection(b *testing.B) {
+	s := benchServer(b)
+	request := append([]byte(nil), benchRequest[:len(benchRequest)-2]...)
+	request = append(request, "Connection: close\r\n\r\n"...)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := conn.Write(request); err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}
+}
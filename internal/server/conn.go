@@ -0,0 +1,283 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"tcp.to.http/internal/metrics"
+)
+
+// conn counts the bytes moving over a connection and remembers the first
+// I/O error, so the close can be attributed to a reset or a timeout.
+type conn struct {
+	io.ReadWriteCloser
+	read    atomic.Int64
+	written atomic.Int64
+
+	// readLimit and writeLimit are nil unless WithRateLimit is set.
+	readLimit  *bucket
+	writeLimit *bucket
+
+	// idleSince is set while waiting for the next request, guarded by the
+	// server's connsMu. closeAfter asks for Connection: close on the next
+	// response and reaped records that the server closed c while idle.
+	idleSince  time.Time
+	closeAfter atomic.Bool
+	reaped     atomic.Bool
+	// hijacked connections belong to a handler and are left open.
+	hijacked atomic.Bool
+	// linger is set when c closes after a response, rather than on an error
+	// or timeout.
+	linger bool
+
+	// pending holds a byte read while watching for the client to hang up,
+	// and cancel is the current request's, canceled on a failed write.
+	pending []byte
+	cancel  context.CancelCauseFunc
+
+	deadlines deadlines
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.readLimit != nil {
+		p = p[:min(len(p), c.readLimit.burst)]
+	}
+	n, err := c.ReadWriteCloser.Read(p)
+	c.read.Add(int64(n))
+	if n > 0 {
+		c.deadlines.readProgress()
+	}
+	if c.readLimit != nil {
+		c.readLimit.wait(n)
+	}
+	if err != nil && err != io.EOF {
+		c.fail(err)
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if c.writeLimit == nil {
+		return c.write(p)
+	}
+
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.writeLimit.burst)]
+		c.writeLimit.wait(len(chunk))
+		n, err := c.write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *conn) write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.wrote(int64(n), err)
+	return n, err
+}
+
+// WriteBuffers sends bufs in one writev on a plain TCP connection, and as
+// one joined write otherwise, so a response head and a small body share a
+// syscall (and a TLS record).
+func (c *conn) WriteBuffers(bufs net.Buffers) (int64, error) {
+	if _, ok := c.ReadWriteCloser.(*net.TCPConn); !ok || c.writeLimit != nil {
+		n, err := c.Write(bytes.Join(bufs, nil))
+		return int64(n), err
+	}
+	n, err := bufs.WriteTo(c.ReadWriteCloser.(*net.TCPConn))
+	c.wrote(n, err)
+	return n, err
+}
+
+// ReadFrom lets a TCP connection copy files with sendfile and sockets with
+// splice. Rate limits and streaming deadlines need to see every write, so
+// with those the copy stays in userspace.
+func (c *conn) ReadFrom(r io.Reader) (int64, error) {
+	tc, ok := c.ReadWriteCloser.(*net.TCPConn)
+	if !ok || c.writeLimit != nil || c.deadlines.streaming {
+		return io.Copy(writerOnly{c}, r)
+	}
+	n, err := tc.ReadFrom(r)
+	c.wrote(n, err)
+	return n, err
+}
+
+// writerOnly hides a ReadFrom method from io.Copy.
+type writerOnly struct {
+	io.Writer
+}
+
+func (c *conn) wrote(n int64, err error) {
+	c.written.Add(n)
+	if n > 0 {
+		c.deadlines.writeProgress()
+	}
+	if err != nil {
+		c.fail(err)
+		if c.cancel != nil {
+			c.cancel(ERROR_CLIENT_GONE)
+		}
+	}
+}
+
+func (c *conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+const (
+	lingerTimeout  = 500 * time.Millisecond
+	maxLingerBytes = 256 << 10
+)
+
+// close ends c. After a response it first sends FIN and reads off what the
+// client may still be sending for a moment: closing with unread input makes
+// the kernel reset the connection, which can discard the response before
+// the client has read it.
+func (c *conn) close() error {
+	nc, ok := c.ReadWriteCloser.(net.Conn)
+	cw, canHalfClose := c.ReadWriteCloser.(interface{ CloseWrite() error })
+	if !c.linger || !ok || !canHalfClose || c.Err() != nil {
+		return c.Close()
+	}
+	if cw.CloseWrite() == nil {
+		nc.SetReadDeadline(time.Now().Add(lingerTimeout))
+		io.CopyN(io.Discard, nc, maxLingerBytes)
+	}
+	return c.Close()
+}
+
+func (c *conn) remoteAddr() string {
+	if nc, ok := c.ReadWriteCloser.(net.Conn); ok {
+		return nc.RemoteAddr().String()
+	}
+	return ""
+}
+
+type connMetrics struct {
+	accepted *metrics.Counter
+	closed   *metrics.Counter
+	reset    *metrics.Counter
+	timedOut *metrics.Counter
+	idle     *metrics.Counter
+	active   *metrics.Gauge
+	read     *metrics.Counter
+	written  *metrics.Counter
+	inFlight *metrics.Gauge
+}
+
+func newConnMetrics(r *metrics.Registry) connMetrics {
+	return connMetrics{
+		accepted: r.Counter("connections_accepted_total", "Connections accepted."),
+		closed:   r.Counter("connections_closed_total", "Connections closed, for any reason."),
+		reset:    r.Counter("connections_reset_total", "Connections reset or broken by the peer."),
+		timedOut: r.Counter("connections_timed_out_total", "Connections closed after a read or write timeout."),
+		idle:     r.Counter("connections_idle_closed_total", "Idle keep-alive connections closed by the server."),
+		active:   r.Gauge("connections_active", "Connections currently open."),
+		read:     r.Counter("connection_bytes_read_total", "Bytes read from clients."),
+		written:  r.Counter("connection_bytes_written_total", "Bytes written to clients."),
+		inFlight: r.Gauge("requests_in_flight", "Requests being handled."),
+	}
+}
+
+// WithMetrics records connection counters in r instead of the server's own
+// registry.
+func WithMetrics(r *metrics.Registry) Option {
+	return func(s *Server) {
+		s.metrics = r
+	}
+}
+
+// WithConnLog logs every connection's accept and close, with its byte
+// counts and why it ended. Off by default.
+func WithConnLog(l *log.Logger) Option {
+	return func(s *Server) {
+		s.connLog = l
+	}
+}
+
+func (s *Server) Metrics() *metrics.Registry {
+	return s.metrics
+}
+
+func closeReason(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "closed"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.ErrClosedPipe):
+		return "reset"
+	}
+	return "error"
+}
+
+func (s *Server) trackConn(c *conn) func() {
+	start := time.Now()
+	s.connMetrics.accepted.Inc()
+	s.connMetrics.active.Add(1)
+	if s.connLog != nil {
+		s.connLog.Printf("conn %s accepted", c.remoteAddr())
+	}
+
+	return func() {
+		read, written := c.read.Load(), c.written.Load()
+		reason := closeReason(c.Err())
+		if c.reaped.Load() {
+			reason = "idle"
+		}
+
+		s.connMetrics.active.Add(-1)
+		s.connMetrics.closed.Inc()
+		s.connMetrics.read.Add(read)
+		s.connMetrics.written.Add(written)
+		switch reason {
+		case "reset":
+			s.connMetrics.reset.Inc()
+		case "timeout":
+			s.connMetrics.timedOut.Inc()
+		case "idle":
+			s.connMetrics.idle.Inc()
+		}
+
+		if s.connLog != nil {
+			msg := ""
+			if err := c.Err(); err != nil {
+				msg = ": " + err.Error()
+			}
+			s.connLog.Printf("conn %s %s after %s, read %d bytes, wrote %d bytes%s",
+				c.remoteAddr(), reason, time.Since(start).Round(time.Microsecond), read, written, msg)
+		}
+	}
+}
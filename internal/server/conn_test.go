@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/metrics"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func newTestServer(options ...Option) *Server {
+	s := &Server{handler: func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(2))
+		w.WriteBody([]byte("ok"))
+	}}
+	for _, option := range options {
+		option(s)
+	}
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
+	}
+	s.connMetrics = newConnMetrics(s.metrics)
+	return s
+}
+
+func value(t *testing.T, s *Server, name string) int64 {
+	v, ok := s.Metrics().Value(name)
+	require.True(t, ok, name)
+	return v
+}
+
+func TestConnectionLifecycle(t *testing.T) {
+	logs := bytes.Buffer{}
+	s := newTestServer(WithConnLog(log.New(&logs, "", 0)))
+
+	// Test: A normal exchange is counted and logged with its byte counts
+	client, srv := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		runConnection(s, srv)
+		close(done)
+	}()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	_, err := client.Write([]byte(raw))
+	require.NoError(t, err)
+	out, err := io.ReadAll(client)
+	require.NoError(t, err)
+	<-done
+
+	assert.Equal(t, int64(1), value(t, s, "connections_accepted_total"))
+	assert.Equal(t, int64(1), value(t, s, "connections_closed_total"))
+	assert.Equal(t, int64(0), value(t, s, "connections_active"))
+	assert.Equal(t, int64(len(raw)), value(t, s, "connection_bytes_read_total"))
+	assert.Equal(t, int64(len(out)), value(t, s, "connection_bytes_written_total"))
+	assert.Contains(t, logs.String(), "conn pipe accepted\n")
+	assert.Contains(t, logs.String(), "conn pipe closed after ")
+	assert.Contains(t, logs.String(), "read 35 bytes, wrote ")
+
+	// Test: A read timeout is attributed to the timeout counter
+	client, srv = net.Pipe()
+	srv.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	runConnection(s, srv)
+	client.Close()
+	assert.Equal(t, int64(1), value(t, s, "connections_timed_out_total"))
+	assert.Contains(t, logs.String(), "conn pipe timeout after ")
+
+	// Test: A peer that goes away mid-response counts as a reset
+	client, srv = net.Pipe()
+	go func() {
+		client.Write([]byte(raw))
+		client.Close()
+	}()
+	runConnection(s, srv)
+	assert.Equal(t, int64(1), value(t, s, "connections_reset_total"))
+	assert.Equal(t, int64(3), value(t, s, "connections_closed_total"))
+}
+
+func TestReadTimeout(t *testing.T) {
+	s := newTestServer(WithReadTimeout(time.Hour))
+	s.SetTimeouts(10*time.Millisecond, 0)
+
+	// Test: Timeouts changed at runtime apply to new connections
+	client, srv := net.Pipe()
+	defer client.Close()
+	runConnection(s, srv)
+	assert.Equal(t, int64(1), value(t, s, "connections_timed_out_total"))
+}
+
+func TestResponseInOneWrite(t *testing.T) {
+	s := newTestServer()
+	client, srv := net.Pipe()
+	defer client.Close()
+	go runConnection(s, srv)
+	_, err := client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	// Test: Status line, headers and a small body arrive in a single write;
+	// a pipe read returns at most one write
+	buf := make([]byte, 4096)
+	n, err := client.Read(buf)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(buf[:n]), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(buf[:n]), "\r\n\r\nok"), string(buf[:n]))
+}
+
+func TestFileBody(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+	handler := func(w *response.Writer, req *request.Request) {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(data)))
+		io.Copy(w, f)
+	}
+
+	for _, options := range [][]Option{nil, {WithStreamingDeadlines(), WithWriteTimeout(time.Second)}} {
+		s, err := Serve(0, handler, append(options, WithHost("127.0.0.1"))...)
+		require.NoError(t, err)
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		out, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		conn.Close()
+		s.Close()
+
+		// Test: A file copied through the connection (sendfile, or userspace
+		// under streaming deadlines) arrives whole and is counted
+		assert.True(t, bytes.HasSuffix(out, data))
+		assert.Eventually(t, func() bool {
+			v, _ := s.Metrics().Value("connection_bytes_written_total")
+			return v == int64(len(out))
+		}, time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestRequestsInFlight(t *testing.T) {
+	// Test: The gauge counts requests while their handler runs
+	s := newTestServer()
+	during := int64(-1)
+	s.handler = func(w *response.Writer, req *request.Request) {
+		during = value(t, s, "requests_in_flight")
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}
+	client, done := serve(s)
+	_, err := client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	io.ReadAll(client)
+	<-done
+	assert.Equal(t, int64(1), during)
+	assert.Equal(t, int64(0), value(t, s, "requests_in_flight"))
+}
+
+func TestBodyProgress(t *testing.T) {
+	// Test: An upload the progress hook aborts is answered with 413
+	received := int64(0)
+	s := newTestServer(WithBodyProgress(func(r *request.Request, p request.BodyProgress) error {
+		received = p.Received
+		if p.Received > 4 {
+			return fmt.Errorf("too large")
+		}
+		return nil
+	}))
+	called := false
+	s.handler = func(w *response.Writer, req *request.Request) {
+		called = true
+	}
+	client, done := serve(s)
+	go client.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabcdefghij"))
+	out, _ := io.ReadAll(client)
+	<-done
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 413 Content Too Large\r\n"), string(out))
+	assert.Greater(t, received, int64(4))
+	assert.False(t, called)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// WithStreamingDeadlines makes the read and write timeouts limits on
+// inactivity: every read or write that moves data pushes the deadline out
+// again, so a slow but steady body is not cut off halfway.
+func WithStreamingDeadlines() Option {
+	return func(s *Server) {
+		s.streamingDeadlines = true
+	}
+}
+
+// deadlines refreshes a connection's read and write deadlines at every
+// request boundary instead of once for the connection's lifetime.
+type deadlines struct {
+	nc        net.Conn
+	read      time.Duration
+	write     time.Duration
+	streaming bool
+	// idle is set while the idle timeout is in force; the first byte of the
+	// next request swaps it for the read timeout.
+	idle bool
+}
+
+// startRead arms the deadline for reading a request. While idle the idle
+// timeout applies instead of the read timeout.
+func (d *deadlines) startRead(idle bool, idleTimeout time.Duration) {
+	d.idle = idle
+	timeout := d.read
+	if idle {
+		timeout = idleTimeout
+	}
+	if d.nc != nil && timeout > 0 {
+		d.nc.SetReadDeadline(time.Now().Add(timeout))
+	}
+}
+
+func (d *deadlines) startWrite() {
+	if d.nc != nil && d.write > 0 {
+		d.nc.SetWriteDeadline(time.Now().Add(d.write))
+	}
+}
+
+func (d *deadlines) readProgress() {
+	switch {
+	case d.nc == nil:
+	case d.read > 0 && (d.idle || d.streaming):
+		d.nc.SetReadDeadline(time.Now().Add(d.read))
+	case d.idle:
+		d.nc.SetReadDeadline(time.Time{})
+	}
+	d.idle = false
+}
+
+func (d *deadlines) writeProgress() {
+	if d.streaming {
+		d.startWrite()
+	}
+}
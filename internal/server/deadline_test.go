@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// trickle writes raw a piece at a time, pausing between pieces.
+func trickle(client net.Conn, pause time.Duration, pieces ...string) {
+	for _, piece := range pieces {
+		time.Sleep(pause)
+		client.Write([]byte(piece))
+	}
+}
+
+func TestDeadlineRefresh(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: The idle timeout only covers the wait for the next request
+	s := newTestServer(WithIdleTimeout(50*time.Millisecond), WithReadTimeout(time.Second))
+	client, done := serve(s)
+	r := bufio.NewReader(client)
+	client.Write([]byte(raw))
+	readResponse(t, r)
+	go trickle(client, 30*time.Millisecond, "GET / HTTP/1.1\r\n", "Host: localhost\r\n", "Connection: close\r\n\r\n")
+	assert.Contains(t, readResponse(t, r), "200 OK")
+	<-done
+	client.Close()
+
+	// Test: Without streaming deadlines a slow body hits the read timeout
+	post := []string{"POST / HTTP/1.1\r\nContent-Length: 4\r\n\r\n", "a", "b", "c", "d"}
+	s = newTestServer(WithReadTimeout(60 * time.Millisecond))
+	client, done = serve(s)
+	go trickle(client, 25*time.Millisecond, post...)
+	out, _ := io.ReadAll(client)
+	<-done
+	assert.Empty(t, out)
+
+	// Test: Streaming deadlines only time out on inactivity
+	s = newTestServer(WithReadTimeout(60*time.Millisecond), WithStreamingDeadlines())
+	client, done = serve(s)
+	go trickle(client, 25*time.Millisecond, post...)
+	out, _ = io.ReadAll(client)
+	<-done
+	assert.Contains(t, string(out), "200 OK")
+}
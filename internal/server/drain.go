@@ -0,0 +1,84 @@
+package server
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+// WithHealthCheck has the server answer GET and HEAD requests for path
+// itself: 200 while it serves, 503 from the moment Shutdown starts draining,
+// so load balancers polling it stop sending traffic.
+func WithHealthCheck(path string) Option {
+	return func(s *Server) {
+		s.healthPath = path
+	}
+}
+
+// WithDrainDelay makes Shutdown fail health checks and stop keeping
+// connections alive for d before it stops accepting, giving load balancers
+// time to notice and move traffic elsewhere.
+func WithDrainDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.drainDelay = d
+	}
+}
+
+// Draining reports whether Shutdown has begun.
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// handlerFor is the handler that serves r: the health check, the
+// maintenance page or the server's own.
+func (s *Server) handlerFor(r *request.Request) Handler {
+	method := r.RequestLine.Method
+	path, _, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	if s.healthPath != "" && path == s.healthPath && (method == "GET" || method == "HEAD") {
+		return s.health
+	}
+	if s.InMaintenance() && !s.maintenanceAllows(requestPath(r)) {
+		return s.maintenancePage
+	}
+	return s.handler
+}
+
+// requestPath is r's target as an origin-form path with dot segments, even
+// encoded ones, resolved, so "/status/../admin" is not taken for a path
+// under "/status". Only for deciding how to serve r; the handler still sees
+// the target as sent.
+func requestPath(r *request.Request) string {
+	target := r.RequestLine.RequestTarget
+	if scheme, rest, ok := strings.Cut(target, "://"); ok && !strings.Contains(scheme, "/") {
+		_, p, _ := strings.Cut(rest, "/")
+		target = "/" + p
+	}
+	p, _, _ := strings.Cut(target, "?")
+	if !strings.HasPrefix(p, "/") {
+		return p
+	}
+	return path.Clean(dotEscapes.Replace(p))
+}
+
+var dotEscapes = strings.NewReplacer("%2e", ".", "%2E", ".")
+
+func (s *Server) health(w *response.Writer, r *request.Request) {
+	status, body := response.StatusOK, []byte("ok\n")
+	switch {
+	case s.Draining():
+		status, body = response.StatusServiceUnavailable, []byte("draining\n")
+	case s.InMaintenance() && s.maintenanceConfig.FailHealth:
+		status, body = response.StatusServiceUnavailable, []byte("maintenance\n")
+	}
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", "text/plain; charset=utf-8")
+	h.Replace("Cache-Control", "no-store")
+	w.WriteStatusLine(status)
+	w.WriteHeaders(*h)
+	if r.RequestLine.Method != "HEAD" {
+		w.WriteBody(body)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func fetch(t *testing.T, addr net.Addr, method, target string) string {
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestDrain(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}, WithHealthCheck("/healthz"), WithDrainDelay(200*time.Millisecond), WithIdleTimeout(time.Minute))
+	require.NoError(t, err)
+
+	// Test: The health check passes while serving, and HEAD has no body
+	out := fetch(t, s.Addr(), "GET", "/healthz")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nok\n"), out)
+	out = fetch(t, s.Addr(), "HEAD", "/healthz?probe=1")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"), out)
+
+	// Test: Other methods on the health path reach the handler
+	out = fetch(t, s.Addr(), "POST", "/healthz")
+	assert.NotContains(t, out, "ok\n")
+
+	// Test: Shutdown fails the health check while still accepting
+	done := make(chan error)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	require.Eventually(t, s.Draining, time.Second, time.Millisecond)
+	out = fetch(t, s.Addr(), "GET", "/healthz")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"), out)
+	assert.Contains(t, out, "cache-control: no-store\r\n")
+
+	// Test: Requests during the delay are served but not kept alive
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, string(res), "connection: close\r\n")
+
+	// Test: After the delay the listener closes
+	require.NoError(t, <-done)
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+}
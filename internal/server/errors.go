@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"maps"
+	"strings"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+type HandlerError struct {
+	StatusCode response.StatusCode
+	Message    string
+}
+
+func (e *HandlerError) Error() string {
+	return e.Message
+}
+
+type problemDetailsKey struct{}
+
+// WithProblemDetails makes server generated errors use
+// application/problem+json for clients that accept JSON.
+func WithProblemDetails() Option {
+	return func(s *Server) {
+		s.problemDetails = true
+	}
+}
+
+func acceptsJSON(req *request.Request) bool {
+	accept, _ := req.Headers.Get("accept")
+	return strings.Contains(accept, "application/json") || strings.Contains(accept, "+json")
+}
+
+// Error writes an error response for status. It is what the server, router
+// and middleware use so all generated errors look the same.
+func Error(w *response.Writer, req *request.Request, status response.StatusCode, detail string) {
+	WriteProblem(w, req, response.NewProblem(status, detail))
+}
+
+func WriteProblem(w *response.Writer, req *request.Request, p *response.Problem) {
+	enabled, _ := req.Context().Value(problemDetailsKey{}).(bool)
+	if enabled {
+		w.Vary("Accept")
+	}
+	if enabled && acceptsJSON(req) {
+		if p.Instance == "" {
+			p.Instance = req.RequestLine.RequestTarget
+		}
+		if id := req.ID(); id != "" {
+			withID := *p
+			withID.Extensions = maps.Clone(p.Extensions)
+			if withID.Extensions == nil {
+				withID.Extensions = map[string]any{}
+			}
+			withID.Extensions["request_id"] = id
+			p = &withID
+		}
+		w.WriteProblem(p)
+		return
+	}
+
+	body := []byte(p.Title + "\n")
+	if p.Detail != "" {
+		body = []byte(p.Detail + "\n")
+	}
+	if id := req.ID(); id != "" {
+		// Something to quote when reporting the error.
+		body = append(body, "Request ID: "+id+"\n"...)
+	}
+	w.WriteStatusLine(p.Status)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// HandleErrors adapts a handler that returns an error. A *response.Problem
+// or *HandlerError picks the status; anything else becomes a 500.
+func HandleErrors(fn func(w *response.Writer, req *request.Request) error) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		err := fn(w, req)
+		if err == nil {
+			return
+		}
+
+		var problem *response.Problem
+		var handlerErr *HandlerError
+		switch {
+		case errors.As(err, &problem):
+			WriteProblem(w, req, problem)
+		case errors.As(err, &handlerErr):
+			Error(w, req, handlerErr.StatusCode, handlerErr.Message)
+		default:
+			Error(w, req, response.StatusInternalServeError, "")
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestHandleErrors(t *testing.T) {
+	run := func(problemDetails bool, accept string, err error) string {
+		req, e := request.RequestFromReader(strings.NewReader("GET /coffee HTTP/1.1\r\nAccept: " + accept + "\r\n\r\n"))
+		require.NoError(t, e)
+		if problemDetails {
+			req = req.WithContext(context.WithValue(req.Context(), problemDetailsKey{}, true))
+		}
+		out := bytes.Buffer{}
+		HandleErrors(func(w *response.Writer, req *request.Request) error {
+			return err
+		})(response.NewWriter(&out), req)
+		return out.String()
+	}
+
+	// Test: Plain text by default
+	out := run(false, "application/json", &HandlerError{StatusCode: response.StatusNotFound, Message: "no coffee"})
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nno coffee\n"))
+
+	// Test: problem+json for clients accepting JSON
+	p := response.NewProblem(response.StatusForbidden, "teapots only")
+	p.Extensions = map[string]any{"balance": 30}
+	out = run(true, "application/json", fmt.Errorf("wrapped: %w", p))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"))
+	assert.Contains(t, out, "content-type: application/problem+json\r\n")
+	assert.True(t, strings.HasSuffix(out, `{"balance":30,"detail":"teapots only","instance":"/coffee","status":403,"title":"Forbidden","type":"about:blank"}`))
+
+	// Test: Plain text when the client doesn't accept JSON
+	out = run(true, "text/html", fmt.Errorf("boom"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nInternal Server Error\n"))
+
+	// Test: Errors vary on Accept only when their format depends on it
+	assert.Contains(t, out, "vary: Accept\r\n")
+	out = run(false, "application/json", fmt.Errorf("boom"))
+	assert.NotContains(t, out, "vary")
+}
+
+func TestNormalization(t *testing.T) {
+	var target, raw string
+	s := newTestServer(WithNormalization())
+	next := s.handler
+	s.handler = func(w *response.Writer, req *request.Request) {
+		target, raw = req.RequestLine.RequestTarget, req.RawTarget()
+		next(w, req)
+	}
+
+	// Test: The handler sees the canonical request, and the raw target
+	client, done := serve(s)
+	client.Write([]byte("GET /%7eme HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	out, _ := io.ReadAll(client)
+	<-done
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, "/~me", target)
+	assert.Equal(t, "/%7eme", raw)
+
+	// Test: Two Hosts get a 400 without reaching the handler
+	target = ""
+	client, done = serve(s)
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n"))
+	out, _ = io.ReadAll(client)
+	<-done
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Empty(t, target)
+}
+
+func TestHandlerPanicAndMisuse(t *testing.T) {
+	logged := bytes.Buffer{}
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	raw := "GET /x HTTP/1.1\r\nHost: localhost\r\n\r\nGET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: A panic before anything was written becomes a 500 and closes
+	// the connection
+	s := newTestServer(WithIdleTimeout(time.Second))
+	s.handler = func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}
+	client, done := serve(s)
+	client.Write([]byte(raw))
+	out, _ := io.ReadAll(client)
+	<-done
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Contains(t, string(out), "connection: close\r\n")
+	assert.Equal(t, 1, strings.Count(string(out), "HTTP/1.1"))
+	assert.Contains(t, logged.String(), "panic serving GET /x: boom")
+
+	// Test: A panic after the status only ends the connection, and a
+	// second status line never reaches the wire
+	for _, handler := range []Handler{
+		func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(10))
+			w.WriteBody([]byte("part"))
+			panic("boom")
+		},
+		func(w *response.Writer, req *request.Request) {
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(10))
+			w.WriteBody([]byte("part"))
+			Error(w, req, response.StatusInternalServeError, "")
+		},
+	} {
+		logged.Reset()
+		s.handler = handler
+		client, done = serve(s)
+		client.Write([]byte(raw))
+		out, _ = io.ReadAll(client)
+		<-done
+		assert.Equal(t, 1, strings.Count(string(out), "HTTP/1.1"), string(out))
+		assert.NotContains(t, string(out), "500")
+		assert.NotEmpty(t, logged.String())
+	}
+	assert.Contains(t, logged.String(), "status line already written: 200, then 500")
+}
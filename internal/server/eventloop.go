@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+)
+
+// WithEventLoop parks idle keep-alive connections, and ones still sending
+// their request head, in an epoll set instead of a goroutine each. A
+// goroutine is started once a whole head has arrived and goes away again
+// after the response. It only pays off with very many mostly idle
+// connections, and is Linux only: elsewhere, and for TLS, it has no effect.
+func WithEventLoop() Option {
+	return func(s *Server) {
+		s.eventLoop = true
+	}
+}
+
+// loopConn is a connection while it belongs to the event loop.
+type loopConn struct {
+	c      *conn
+	ctx    context.Context
+	raw    syscall.RawConn
+	fd     int
+	finish func()
+	// head gathers the request head; since is when the connection last
+	// came back to the loop, or its first byte arrived.
+	head   []byte
+	since  time.Time
+	served bool
+	busy   bool
+	closed bool
+}
+
+// headLimit is how much of a head the loop gathers before handing it to
+// the parser anyway, which then rejects it.
+func (s *Server) headLimit() int {
+	line, header := s.requestOptions.MaxRequestLineLength, s.requestOptions.MaxHeaderBytes
+	if line <= 0 {
+		line = request.DefaultMaxRequestLineLength
+	}
+	if header <= 0 {
+		header = request.DefaultMaxHeaderBytes
+	}
+	return line + header + 4
+}
+
+var headEnd = []byte("\r\n\r\n")
+
+// gathered appends p to the head and reports whether it is complete.
+func (lc *loopConn) gathered(p []byte, limit int) bool {
+	from := max(0, len(lc.head)-len(headEnd)+1)
+	if len(lc.head) == 0 {
+		lc.since = time.Now()
+	}
+	lc.head = append(lc.head, p...)
+	return bytes.Contains(lc.head[from:], headEnd) || len(lc.head) >= limit
+}
+
+// expired reports whether lc has waited longer than its timeout, and
+// whether that was between requests rather than within one.
+func (lc *loopConn) expired(s *Server, now time.Time) (expired, idle bool) {
+	limit := time.Duration(s.readTimeout.Load())
+	idle = lc.served && len(lc.head) == 0
+	if idle {
+		limit = s.idleTimeout
+	}
+	return limit > 0 && now.Sub(lc.since) > limit, idle
+}
+
+// serve answers the requests whose head the loop gathered, and any the
+// client pipelined behind them, then reports whether the connection can go
+// back to the loop.
+func (l *eventLoop) serve(lc *loopConn) bool {
+	s, c := l.s, lc.c
+	c.pending, lc.head = lc.head, nil
+	parser := request.NewParser(c, s.requestOptions)
+	defer parser.Release()
+	for {
+		if !serveRequest(s, c, parser, lc.ctx, true) {
+			return false
+		}
+		if parser.Buffered() == 0 && len(c.pending) == 0 {
+			break
+		}
+	}
+	// The loop reads without waiting, which fails once a deadline passed.
+	if nc, ok := c.ReadWriteCloser.(net.Conn); ok {
+		nc.SetDeadline(time.Time{})
+	}
+	lc.served = true
+	lc.since = time.Now()
+	return s.setIdle(c, true)
+}
+
+// handOver runs the requests on lc in a goroutine of their own.
+func (l *eventLoop) handOver(lc *loopConn) {
+	l.s.setIdle(lc.c, false)
+	go func() {
+		if l.serve(lc) {
+			l.rearm(lc)
+		} else {
+			l.remove(lc)
+		}
+	}()
+}
+
+// loopConns is the loop's set of connections, by file descriptor.
+type loopConns struct {
+	mu    sync.Mutex
+	conns map[int]*loopConn
+}
+
+// remove closes lc and lets the server forget it.
+func (l *eventLoop) remove(lc *loopConn) {
+	l.mu.Lock()
+	if lc.closed {
+		l.mu.Unlock()
+		return
+	}
+	lc.closed = true
+	if l.conns[lc.fd] == lc {
+		delete(l.conns, lc.fd)
+	}
+	l.mu.Unlock()
+
+	l.unwatch(lc.fd)
+	if !lc.c.hijacked.Load() {
+		lc.c.close()
+	}
+	l.s.removeConn(lc.c)
+	lc.finish()
+	l.s.conns.Done()
+}
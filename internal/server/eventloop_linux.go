@@ -0,0 +1,171 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+type eventLoop struct {
+	loopConns
+	s    *Server
+	epfd int
+	buf  []byte
+}
+
+func newEventLoop(s *Server) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	l := &eventLoop{s: s, epfd: epfd, buf: make([]byte, 16<<10)}
+	l.conns = map[int]*loopConn{}
+	go l.run()
+	return l, nil
+}
+
+// add takes over a newly accepted connection, reporting false for one the
+// loop cannot watch.
+func (l *eventLoop) add(nc net.Conn) bool {
+	tc, ok := nc.(*net.TCPConn)
+	if !ok {
+		return false
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	fd := -1
+	raw.Control(func(f uintptr) { fd = int(f) })
+
+	c := l.s.newConn(tc)
+	lc := &loopConn{c: c, raw: raw, fd: fd, since: time.Now(), finish: l.s.trackConn(c)}
+	lc.ctx = l.s.admit(c)
+	l.mu.Lock()
+	if old := l.conns[fd]; old != nil {
+		// The descriptor was closed behind the loop's back and reused.
+		l.mu.Unlock()
+		l.remove(old)
+		l.mu.Lock()
+	}
+	l.conns[fd] = lc
+	l.mu.Unlock()
+
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: int32(fd)}
+	if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+		log.Printf("server: event loop: %v", err)
+		l.remove(lc)
+	}
+	return true
+}
+
+// rearm gives lc back to the loop after its requests were answered.
+func (l *eventLoop) rearm(lc *loopConn) {
+	l.mu.Lock()
+	lc.busy = false
+	l.mu.Unlock()
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: int32(lc.fd)}
+	if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, lc.fd, &event); err != nil {
+		l.remove(lc)
+	}
+}
+
+func (l *eventLoop) unwatch(fd int) {
+	syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+func (l *eventLoop) run() {
+	defer syscall.Close(l.epfd)
+	events := make([]syscall.EpollEvent, 128)
+	for {
+		// The timeout bounds how late timeouts and closed connections are
+		// noticed.
+		n, err := syscall.EpollWait(l.epfd, events, 100)
+		if err != nil && !errors.Is(err, syscall.EINTR) {
+			log.Printf("server: event loop: %v", err)
+			return
+		}
+		for _, event := range events[:max(n, 0)] {
+			l.mu.Lock()
+			lc := l.conns[int(event.Fd)]
+			l.mu.Unlock()
+			if lc != nil {
+				l.ready(lc)
+			}
+		}
+		if l.sweep() {
+			return
+		}
+	}
+}
+
+// ready reads what lc has to offer without blocking, and hands it over
+// once a request head is complete.
+func (l *eventLoop) ready(lc *loopConn) {
+	n := 0
+	var readErr error
+	err := lc.raw.Read(func(fd uintptr) bool {
+		n, readErr = syscall.Read(int(fd), l.buf)
+		return true
+	})
+	switch {
+	case err != nil:
+		l.remove(lc)
+	case errors.Is(readErr, syscall.EAGAIN):
+		l.rearm(lc)
+	case readErr != nil || n <= 0:
+		if readErr != nil {
+			lc.c.fail(readErr)
+		}
+		l.remove(lc)
+	default:
+		lc.c.read.Add(int64(n))
+		if !lc.gathered(l.buf[:n], l.s.headLimit()) {
+			l.s.setIdle(lc.c, false)
+			l.rearm(lc)
+			return
+		}
+		l.mu.Lock()
+		lc.busy = true
+		l.mu.Unlock()
+		l.handOver(lc)
+	}
+}
+
+// sweep closes connections that timed out or that the server closed while
+// they were idle. It reports whether the loop is done: the server is
+// closed and no connection is left.
+func (l *eventLoop) sweep() bool {
+	now := time.Now()
+	expired := []*loopConn{}
+	l.mu.Lock()
+	for _, lc := range l.conns {
+		if lc.busy {
+			continue
+		}
+		if lc.c.reaped.Load() || (len(lc.head) == 0 && l.s.closed.Load()) {
+			// Closed by the server while idle, or idle at shutdown: nothing of
+			// a request is under way, not even a first one.
+			lc.c.reaped.Store(true)
+			expired = append(expired, lc)
+		} else if timeout, idle := lc.expired(l.s, now); timeout {
+			if idle {
+				lc.c.reaped.Store(true)
+			} else {
+				// Counted as a timeout when the connection closes.
+				lc.c.fail(os.ErrDeadlineExceeded)
+			}
+			expired = append(expired, lc)
+		}
+	}
+	done := l.s.closed.Load() && len(l.conns) == len(expired)
+	l.mu.Unlock()
+
+	for _, lc := range expired {
+		l.remove(lc)
+	}
+	return done
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func eventLoopServer(t *testing.T, options ...Option) *Server {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		body := req.RequestLine.RequestTarget + " " + req.Body
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}, append([]Option{WithHost("127.0.0.1"), WithEventLoop(), WithIdleTimeout(time.Minute)}, options...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func readBody(t *testing.T, p *response.Parser) string {
+	res, err := p.Next()
+	require.NoError(t, err)
+	return res.Body
+}
+
+func TestEventLoop(t *testing.T) {
+	s := eventLoopServer(t)
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	r := response.NewParser(conn, response.ParseOptions{})
+
+	// Test: A head that arrives in pieces is answered once complete
+	conn.Write([]byte("GET /one HTTP/1.1\r\nHo"))
+	time.Sleep(20 * time.Millisecond)
+	conn.Write([]byte("st: localhost\r\n\r\n"))
+	assert.Equal(t, "/one ", readBody(t, r))
+
+	// Test: The connection returns to the loop and carries more requests,
+	// bodies and pipelined ones included
+	conn.Write([]byte("POST /two HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /three HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Equal(t, "/two hello", readBody(t, r))
+	assert.Equal(t, "/three ", readBody(t, r))
+}
+
+func TestEventLoopIdleConnections(t *testing.T) {
+	s := eventLoopServer(t)
+	before := runtime.NumGoroutine()
+
+	// Test: Idle connections cost no goroutine
+	conns := []net.Conn{}
+	for range 200 {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	assert.Eventually(t, func() bool { return s.openCount() == 200 }, time.Second, 10*time.Millisecond)
+	assert.Less(t, runtime.NumGoroutine(), before+20)
+
+	// Test: Shutdown closes the idle ones and waits for the rest
+	for _, conn := range conns[:10] {
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		readBody(t, response.NewParser(conn, response.ParseOptions{}))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	conns[0].SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conns[0].Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestEventLoopTimeouts(t *testing.T) {
+	s := eventLoopServer(t, WithIdleTimeout(50*time.Millisecond), WithReadTimeout(50*time.Millisecond))
+
+	// Test: An idle keep-alive connection is closed after the idle timeout
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	readBody(t, response.NewParser(conn, response.ParseOptions{}))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: So is one that never finishes its head
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: local"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	out, _ := io.ReadAll(conn)
+	assert.Empty(t, out)
+	assert.Eventually(t, func() bool {
+		v, _ := s.Metrics().Value("connections_timed_out_total")
+		return v == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package server
+
+import "net"
+
+type eventLoop struct {
+	loopConns
+	s *Server
+}
+
+// newEventLoop leaves WithEventLoop without effect outside Linux.
+func newEventLoop(s *Server) (*eventLoop, error) {
+	return nil, nil
+}
+
+func (l *eventLoop) add(nc net.Conn) bool { return false }
+func (l *eventLoop) rearm(lc *loopConn)   {}
+func (l *eventLoop) unwatch(fd int)       {}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	request "tcp.to.http/internal/requests"
+)
+
+var ERROR_NOT_HIJACKABLE = fmt.Errorf("connection cannot be hijacked")
+
+type hijackKey struct{}
+
+// hijacker is attached to each request's context so Hijack can reach the
+// connection it came in on.
+type hijacker struct {
+	c         *conn
+	parser    *request.Parser
+	stopWatch func()
+	flush     func() error
+	once      sync.Once
+	hijacked  bool
+}
+
+// Hijack takes the connection req arrived on away from the server, for
+// protocols such as CONNECT tunnels and WebSocket that stop speaking HTTP.
+// The caller writes whatever response it needs directly and must close the
+// connection; bytes the client already sent are read from it first. The
+// handler should return without using its response.Writer again.
+func Hijack(req *request.Request) (net.Conn, error) {
+	h, ok := req.Context().Value(hijackKey{}).(*hijacker)
+	if !ok {
+		return nil, ERROR_NOT_HIJACKABLE
+	}
+	nc, ok := h.c.ReadWriteCloser.(net.Conn)
+	if !ok || h.hijacked {
+		return nil, ERROR_NOT_HIJACKABLE
+	}
+	// A response head written before hijacking, such as 101 Switching
+	// Protocols, may still be held back.
+	if err := h.flush(); err != nil {
+		return nil, err
+	}
+	h.once.Do(h.stopWatch)
+	h.hijacked = true
+	h.c.hijacked.Store(true)
+	nc.SetDeadline(time.Time{})
+
+	ahead := append(h.parser.TakeBuffered(), h.c.pending...)
+	h.c.pending = nil
+	return &hijackedConn{Conn: nc, ahead: bytes.NewReader(ahead)}, nil
+}
+
+type hijackedConn struct {
+	net.Conn
+	ahead *bytes.Reader
+}
+
+func (hc *hijackedConn) Read(p []byte) (int, error) {
+	if hc.ahead.Len() > 0 {
+		return hc.ahead.Read(p)
+	}
+	return hc.Conn.Read(p)
+}
+
+// WriteTo and ReadFrom hand io.Copy the TCP connection itself, so a tunnel
+// between two sockets is spliced in the kernel.
+func (hc *hijackedConn) WriteTo(w io.Writer) (int64, error) {
+	n, err := hc.ahead.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+	var m int64
+	if rf, ok := w.(io.ReaderFrom); ok {
+		m, err = rf.ReadFrom(hc.Conn)
+	} else {
+		m, err = io.Copy(w, hc.Conn)
+	}
+	return n + m, err
+}
+
+func (hc *hijackedConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := hc.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(hc.Conn, r)
+}
+
+// CloseWrite half-closes TCP and TLS connections, so a tunnel can pass on
+// the end of one direction.
+func (hc *hijackedConn) CloseWrite() error {
+	if cw, ok := hc.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return hc.Conn.Close()
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestHijack(t *testing.T) {
+	s := newTestServer()
+	s.handler = func(w *response.Writer, req *request.Request) {
+		nc, err := Hijack(req)
+		require.NoError(t, err)
+		_, err = Hijack(req)
+		assert.ErrorIs(t, err, ERROR_NOT_HIJACKABLE)
+		nc.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+		go func() {
+			defer nc.Close()
+			io.Copy(nc, nc)
+		}()
+	}
+	client, done := serve(s)
+	defer client.Close()
+
+	// Test: Bytes sent along with the request reach the new owner first
+	go client.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\nearly"))
+	r := bufio.NewReader(client)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", line)
+	r.ReadString('\n')
+	b := make([]byte, 5)
+	_, err = io.ReadFull(r, b)
+	require.NoError(t, err)
+	assert.Equal(t, "early", string(b))
+
+	// Test: The server lets go of the connection without closing it
+	<-done
+	go client.Write([]byte("later"))
+	_, err = io.ReadFull(r, b)
+	require.NoError(t, err)
+	assert.Equal(t, "later", string(b))
+}
+
+func TestHijackWithoutServer(t *testing.T) {
+	// Test: Requests that did not come through a server cannot be hijacked
+	req := &request.Request{}
+	_, err := Hijack(req)
+	assert.ErrorIs(t, err, ERROR_NOT_HIJACKABLE)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+// WithIdleTimeout keeps connections open between requests for up to d.
+// Without it every response is sent with Connection: close.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// WithMaxConnections caps the number of open connections. Once over the cap
+// the oldest idle connections are closed, and busy ones are told to close
+// after their current response.
+func WithMaxConnections(n int) Option {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// setIdle moves c in or out of the idle set. It reports false when the
+// server is already shutting down and c should not wait for another request.
+func (s *Server) setIdle(c *conn, idle bool) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if idle {
+		c.idleSince = time.Now()
+	} else {
+		c.idleSince = time.Time{}
+	}
+	return !idle || !s.closed.Load()
+}
+
+func (s *Server) addConn(c *conn) int {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.open == nil {
+		s.open = map[*conn]struct{}{}
+	}
+	s.open[c] = struct{}{}
+	return len(s.open)
+}
+
+func (s *Server) removeConn(c *conn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	delete(s.open, c)
+}
+
+func (s *Server) openCount() int {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	return len(s.open)
+}
+
+// shed frees up to n connections: idle ones are closed oldest first and, if
+// that is not enough, busy ones close once their response is done.
+func (s *Server) shed(n int) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	idle, busy := []*conn{}, []*conn{}
+	for c := range s.open {
+		if c.idleSince.IsZero() {
+			busy = append(busy, c)
+		} else {
+			idle = append(idle, c)
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool { return idle[i].idleSince.Before(idle[j].idleSince) })
+
+	for _, c := range append(idle, busy...) {
+		if n <= 0 {
+			return
+		}
+		if c.idleSince.IsZero() {
+			if c.closeAfter.Swap(true) {
+				continue
+			}
+		} else {
+			c.reaped.Store(true)
+			c.ReadWriteCloser.Close()
+		}
+		n--
+	}
+}
+
+// closeIdle closes every idle connection and marks the rest to close after
+// their current response.
+func (s *Server) closeIdle() {
+	s.shed(math.MaxInt)
+}
+
+// keepAlive decides, when the headers go out, whether the connection can
+// carry another request, and says so in the Connection header.
+func (s *Server) keepAlive(w *response.Writer, r *request.Request, c *conn) func() bool {
+	decided, keep := false, false
+	w.OnHeaders(func(status response.StatusCode, h *headers.Headers) {
+		decided = true
+		if status == response.StatusSwitchingProtocols {
+			// The handler's Connection: Upgrade stands; the connection is
+			// about to be hijacked.
+			return
+		}
+		keep = s.idleTimeout > 0 && clientKeepAlive(r) && !c.closeAfter.Load() &&
+			!s.closed.Load() && !s.draining.Load() && framed(status, h)
+		if keep {
+			h.Replace("Connection", "keep-alive")
+		} else {
+			h.Replace("Connection", "close")
+		}
+	})
+	return func() bool {
+		return decided && keep && c.Err() == nil
+	}
+}
+
+func clientKeepAlive(r *request.Request) bool {
+	value, _ := r.Headers.Get("connection")
+	for _, token := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "close") {
+			return false
+		}
+	}
+	return r.RequestLine.HttpVersion == "1.1"
+}
+
+// framed reports whether the client can find the end of the body without
+// the connection closing.
+func framed(status response.StatusCode, h *headers.Headers) bool {
+	if status < 200 || status == response.StatusNoContent || status == response.StatusNotModified {
+		return true
+	}
+	if _, ok := h.Get("content-length"); ok {
+		return true
+	}
+	te, _ := h.Get("transfer-encoding")
+	return strings.EqualFold(te, "chunked")
+}
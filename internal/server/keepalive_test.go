@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+// readResponse reads one "ok" response written by newTestServer's handler.
+func readResponse(t *testing.T, r *bufio.Reader) string {
+	head := ""
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		head += line
+		if line == "\r\n" {
+			break
+		}
+	}
+	body := make([]byte, 2)
+	_, err := io.ReadFull(r, body)
+	require.NoError(t, err)
+	return head
+}
+
+func idleCount(s *Server) int {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	n := 0
+	for c := range s.open {
+		if !c.idleSince.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+func serve(s *Server) (net.Conn, <-chan struct{}) {
+	client, srv := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		runConnection(s, srv)
+		close(done)
+	}()
+	return client, done
+}
+
+func TestKeepAlive(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: Without an idle timeout every response closes the connection
+	client, done := serve(newTestServer())
+	client.Write([]byte(raw))
+	out, _ := io.ReadAll(client)
+	<-done
+	assert.Contains(t, string(out), "connection: close\r\n")
+
+	// Test: Several requests are served over one connection
+	s := newTestServer(WithIdleTimeout(time.Second))
+	client, done = serve(s)
+	r := bufio.NewReader(client)
+	for range 2 {
+		client.Write([]byte(raw))
+		assert.Contains(t, readResponse(t, r), "connection: keep-alive\r\n")
+	}
+
+	// Test: The client asking to close gets Connection: close
+	client.Write([]byte("GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	assert.Contains(t, readResponse(t, r), "connection: close\r\n")
+	<-done
+	client.Close()
+	assert.Equal(t, int64(1), value(t, s, "connections_accepted_total"))
+
+	// Test: An idle connection is closed once the idle timeout expires
+	s = newTestServer(WithIdleTimeout(20 * time.Millisecond))
+	client, done = serve(s)
+	client.Write([]byte(raw))
+	readResponse(t, bufio.NewReader(client))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+	client.Close()
+	assert.Equal(t, int64(1), value(t, s, "connections_idle_closed_total"))
+	assert.Equal(t, int64(0), value(t, s, "connections_timed_out_total"))
+}
+
+func TestShedIdleConnections(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	s := newTestServer(WithIdleTimeout(time.Minute), WithMaxConnections(1))
+
+	// Test: Going over the cap closes the oldest idle connection
+	first, firstDone := serve(s)
+	first.Write([]byte(raw))
+	readResponse(t, bufio.NewReader(first))
+	require.Eventually(t, func() bool { return idleCount(s) == 1 }, time.Second, time.Millisecond)
+
+	second, _ := serve(s)
+	select {
+	case <-firstDone:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not shed")
+	}
+	first.Close()
+	assert.Equal(t, int64(1), value(t, s, "connections_idle_closed_total"))
+
+	// Test: A busy connection is told to close after its response
+	s.shed(1)
+	second.Write([]byte(raw))
+	head := readResponse(t, bufio.NewReader(second))
+	assert.True(t, strings.Contains(head, "connection: close\r\n"), head)
+	second.Close()
+}
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	s := newTestServer(WithIdleTimeout(time.Minute))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s.listener = listener
+	go runServer(s, listener)
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	readResponse(t, bufio.NewReader(client))
+
+	// Test: Shutdown does not wait for idle keep-alive connections
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+}
+
+func TestClientConnectionClose(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		time.Sleep(20 * time.Millisecond)
+		body := []byte("ok")
+		if req.Context().Err() != nil {
+			body = []byte("gone")
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, WithIdleTimeout(time.Second))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: A client that half-closes after asking to close still gets its
+	// response, and then a clean close
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	conn.(*net.TCPConn).CloseWrite()
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "connection: close\r\n")
+	assert.True(t, strings.HasSuffix(string(out), "\r\n\r\nok"), string(out))
+
+	// Test: Requests pipelined behind it are left unanswered without a reset
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\nGET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	out, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(out), "HTTP/1.1 200"))
+}
+
+func TestUnreadBodyKeepsFraming(t *testing.T) {
+	// The parser reads each body whole before the handler runs, so a handler
+	// that ignores it leaves nothing behind to be taken for the next request.
+	s := newTestServer(WithIdleTimeout(time.Second))
+	client, done := serve(s)
+	r := bufio.NewReader(client)
+
+	// Test: Sized and chunked bodies the handler never looked at are not
+	// misread as the requests behind them
+	client.Write([]byte("POST / HTTP/1.1\r\nContent-Length: 15\r\n\r\nGET /evil HTTP/" +
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nGET /\r\n0\r\nX-Sum: 1\r\n\r\n" +
+		"GET / HTTP/1.1\r\nConnection: close\r\n\r\n"))
+	for range 2 {
+		assert.Contains(t, readResponse(t, r), "connection: keep-alive\r\n")
+	}
+	assert.Contains(t, readResponse(t, r), "connection: close\r\n")
+	<-done
+	client.Close()
+}
+
+func TestStrictTrailers(t *testing.T) {
+	s := newTestServer(WithStrictTrailers())
+	s.handler = func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Replace("Transfer-Encoding", "chunked")
+		h.Replace("Trailer", "X-Sum")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteChunkedBody([]byte("ok"))
+		w.WriteBody([]byte("0\r\n"))
+		trailers := response.GetDefaultHeaders(0)
+		trailers.Delete("Content-Length")
+		trailers.Delete("Connection")
+		trailers.Delete("Content-Type")
+		trailers.Replace("X-Sum", "abc")
+		w.WriteHeaders(*trailers)
+	}
+	fetch := func(raw string) *response.Response {
+		client, done := serve(s)
+		client.Write([]byte(raw))
+		res, err := response.ResponseFromReader(client)
+		require.NoError(t, err)
+		client.Close()
+		<-done
+		return res
+	}
+
+	// Test: Clients that did not ask for trailers get the body without them
+	res := fetch("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, "ok", res.Body)
+	_, announced := res.Headers.Get("trailer")
+	assert.False(t, announced)
+	_, sent := res.Trailers.Get("x-sum")
+	assert.False(t, sent)
+
+	// Test: TE: trailers gets them
+	res = fetch("GET / HTTP/1.1\r\nHost: localhost\r\nTE: gzip;q=0.5, trailers\r\nConnection: TE\r\n\r\n")
+	assert.Equal(t, "ok", res.Body)
+	sum, _ := res.Trailers.Get("x-sum")
+	assert.Equal(t, "abc", sum)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+type MaintenanceConfig struct {
+	// Allow lists paths still served during maintenance, with everything
+	// under them, such as "/status" or "/static/". They match whole
+	// segments: "/status" allows "/status/db" but not "/statuses".
+	Allow []string
+	// Page is the body of the 503, of type ContentType, which defaults to
+	// HTML when Page is set. Without a Page a plain text one is sent.
+	Page        []byte
+	ContentType string
+	RetryAfter  time.Duration
+	// FailHealth makes the health check fail during maintenance too, so
+	// load balancers stop sending traffic; otherwise it stays green.
+	FailHealth bool
+}
+
+// WithMaintenance configures what SetMaintenance(true) serves.
+func WithMaintenance(config MaintenanceConfig) Option {
+	return func(s *Server) {
+		s.maintenanceConfig = config
+	}
+}
+
+// SetMaintenance turns maintenance mode on or off: while on, requests for
+// paths not allowed by the MaintenanceConfig get a 503 maintenance page.
+func (s *Server) SetMaintenance(on bool) {
+	s.maintenance.Store(on)
+}
+
+func (s *Server) InMaintenance() bool {
+	return s.maintenance.Load()
+}
+
+// maintenanceAllows reports whether path is served during maintenance.
+func (s *Server) maintenanceAllows(path string) bool {
+	for _, prefix := range s.maintenanceConfig.Allow {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) maintenancePage(w *response.Writer, r *request.Request) {
+	config := s.maintenanceConfig
+	if config.RetryAfter > 0 {
+		retryAfter := fmt.Sprintf("%d", int((config.RetryAfter+time.Second-1)/time.Second))
+		w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+			h.Replace("Retry-After", retryAfter)
+		})
+	}
+	if config.Page == nil {
+		Error(w, r, response.StatusServiceUnavailable, "down for maintenance")
+		return
+	}
+	contentType := config.ContentType
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	h := response.GetDefaultHeaders(len(config.Page))
+	h.Replace("Content-Type", contentType)
+	h.Replace("Cache-Control", "no-store")
+	w.WriteStatusLine(response.StatusServiceUnavailable)
+	w.WriteHeaders(*h)
+	if r.RequestLine.Method != "HEAD" {
+		w.WriteBody(config.Page)
+	}
+}
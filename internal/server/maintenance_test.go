@@ -0,0 +1,71 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestMaintenance(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, "app\n")
+	}, WithHealthCheck("/healthz"), WithMaintenance(MaintenanceConfig{
+		Allow:      []string{"/status"},
+		Page:       []byte("<h1>Back soon</h1>"),
+		RetryAfter: 90 * time.Second,
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Everything is served normally until maintenance is switched on
+	assert.True(t, strings.HasSuffix(fetch(t, s.Addr(), "GET", "/"), "\r\n\r\napp\n"))
+	s.SetMaintenance(true)
+	assert.True(t, s.InMaintenance())
+
+	// Test: Other paths get the maintenance page
+	out := fetch(t, s.Addr(), "GET", "/orders?id=1")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"), out)
+	assert.Contains(t, out, "content-type: text/html; charset=utf-8\r\n")
+	assert.Contains(t, out, "retry-after: 90\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n<h1>Back soon</h1>"), out)
+
+	// Test: Allowed paths and the health check still work
+	assert.True(t, strings.HasSuffix(fetch(t, s.Addr(), "GET", "/status/db"), "\r\n\r\napp\n"))
+	assert.True(t, strings.HasPrefix(fetch(t, s.Addr(), "GET", "/healthz"), "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Allowed paths match whole segments of the resolved path, in
+	// any form of target
+	for _, target := range []string{"/statusanything", "/status/../admin", "/status/%2e%2e/admin"} {
+		out = fetch(t, s.Addr(), "GET", target)
+		assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"), target)
+	}
+	assert.True(t, strings.HasSuffix(fetch(t, s.Addr(), "GET", "/status"), "\r\n\r\napp\n"))
+	assert.True(t, strings.HasSuffix(fetch(t, s.Addr(), "GET", "http://localhost/status/db"), "\r\n\r\napp\n"))
+
+	// Test: Switching it off restores service
+	s.SetMaintenance(false)
+	assert.True(t, strings.HasSuffix(fetch(t, s.Addr(), "GET", "/orders"), "\r\n\r\napp\n"))
+}
+
+func TestMaintenanceFailHealth(t *testing.T) {
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, "app\n")
+	}, WithHealthCheck("/healthz"), WithMaintenance(MaintenanceConfig{FailHealth: true}))
+	require.NoError(t, err)
+	defer s.Close()
+	s.SetMaintenance(true)
+
+	// Test: The health check fails with FailHealth, and the default page
+	// is plain text
+	out := fetch(t, s.Addr(), "GET", "/healthz")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"), out)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nmaintenance\n"), out)
+	out = fetch(t, s.Addr(), "GET", "/")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 503 Service Unavailable\r\n"), out)
+	assert.Contains(t, out, "down for maintenance")
+}
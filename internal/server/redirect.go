@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+type RedirectConfig struct {
+	// Port is where plain HTTP is accepted; defaults to 80.
+	Port uint16
+	// HTTPSPort is added to the redirect target unless it is 443, the
+	// default.
+	HTTPSPort uint16
+	// ACMEChallenge, when set, answers ACME HTTP-01 challenges: it returns
+	// the key authorization for a token, if one is pending.
+	ACMEChallenge func(token string) (string, bool)
+}
+
+// RedirectHTTPS binds the plain HTTP port and sends every request to the
+// same host and path over HTTPS.
+func RedirectHTTPS(config RedirectConfig, options ...Option) (*Server, error) {
+	if config.Port == 0 {
+		config.Port = 80
+	}
+	return Serve(config.Port, RedirectHandler(config), options...)
+}
+
+func RedirectHandler(config RedirectConfig) Handler {
+	if config.HTTPSPort == 0 {
+		config.HTTPSPort = 443
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		target := req.RequestLine.RequestTarget
+		if config.ACMEChallenge != nil {
+			if token, ok := strings.CutPrefix(target, acmeChallengePrefix); ok {
+				keyAuth, ok := config.ACMEChallenge(token)
+				if !ok {
+					Error(w, req, response.StatusNotFound, "")
+					return
+				}
+				h := response.GetDefaultHeaders(len(keyAuth))
+				w.WriteStatusLine(response.StatusOK)
+				w.WriteHeaders(*h)
+				w.WriteBody([]byte(keyAuth))
+				return
+			}
+		}
+
+		host, ok := req.Headers.Get("host")
+		if !ok || host == "" || !strings.HasPrefix(target, "/") {
+			Error(w, req, response.StatusBadRequest, "")
+			return
+		}
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if config.HTTPSPort != 443 {
+			host = fmt.Sprintf("%s:%d", host, config.HTTPSPort)
+		}
+
+		// 308 keeps the method and body for anything but GET and HEAD.
+		status := response.StatusMovedPermanently
+		if m := req.RequestLine.Method; m != "GET" && m != "HEAD" {
+			status = response.StatusPermanentRedirect
+		}
+		h := response.GetDefaultHeaders(0)
+		h.Replace("Location", "https://"+host+target)
+		w.WriteStatusLine(status)
+		w.WriteHeaders(*h)
+	}
+}
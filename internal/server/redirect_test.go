@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestRedirectHandler(t *testing.T) {
+	run := func(h Handler, raw string) string {
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		out := bytes.Buffer{}
+		h(response.NewWriter(&out), req)
+		return out.String()
+	}
+	h := RedirectHandler(RedirectConfig{
+		ACMEChallenge: func(token string) (string, bool) {
+			return token + ".thumbprint", token == "abc"
+		},
+	})
+
+	// Test: Path and query are preserved, the port is dropped
+	out := run(h, "GET /a/b?c=d HTTP/1.1\r\nHost: example.com:80\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, out, "location: https://example.com/a/b?c=d\r\n")
+
+	// Test: Other methods get a 308
+	out = run(h, "POST /form HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 308 Permanent Redirect\r\n"))
+
+	// Test: Requests without a Host are rejected
+	out = run(h, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: ACME challenges are answered over plain HTTP
+	out = run(h, "GET /.well-known/acme-challenge/abc HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nabc.thumbprint"))
+	out = run(h, "GET /.well-known/acme-challenge/zzz HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: A non-standard HTTPS port is kept in the target
+	h = RedirectHandler(RedirectConfig{HTTPSPort: 8443})
+	out = run(h, "GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n")
+	assert.Contains(t, out, "location: https://[::1]:8443/\r\n")
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// listenFDEnv tells a restarted process which inherited file descriptor is
+// the listening socket.
+const listenFDEnv = "TCP_TO_HTTP_LISTEN_FD"
+
+var ERROR_NOT_TCP_LISTENER = fmt.Errorf("listener cannot be passed to a child process")
+
+// listen reuses the socket handed down by Restart when there is one, so the
+// new process starts accepting on the same port without ever closing it.
+func listen(host string, port uint16, lc net.ListenConfig) (net.Listener, error) {
+	fd := os.Getenv(listenFDEnv)
+	if fd == "" {
+		return lc.Listen(context.Background(), "tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	}
+	os.Unsetenv(listenFDEnv)
+
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", listenFDEnv, err)
+	}
+	f := os.NewFile(uintptr(n), "listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// Restart starts a new copy of the running binary, with the same arguments,
+// that inherits the listening socket. The caller should then Shutdown this
+// server so it drains while the child takes new connections.
+func (s *Server) Restart() (*os.Process, error) {
+	tl, ok := s.listener.(*net.TCPListener)
+	if !ok {
+		return nil, ERROR_NOT_TCP_LISTENER
+	}
+	f, err := tl.File()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// ExtraFiles[0] becomes fd 3 in the child.
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(), listenFDEnv+"=3")
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// RestartOnSignal restarts on SIGUSR2 and drains this process for up to
+// drainTimeout. The returned channel is closed once draining is over, at
+// which point the caller should exit.
+func (s *Server) RestartOnSignal(drainTimeout time.Duration) <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	done := make(chan struct{})
+
+	go func() {
+		for range signals {
+			p, err := s.Restart()
+			if err != nil {
+				log.Printf("server: restart failed, still serving: %v", err)
+				continue
+			}
+			log.Printf("server: started pid %d, draining", p.Pid)
+			signal.Stop(signals)
+
+			ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			if err := s.Shutdown(ctx); err != nil {
+				log.Printf("server: drain incomplete: %v", err)
+			}
+			cancel()
+			close(done)
+			return
+		}
+	}()
+	return done
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func get(t *testing.T, addr net.Addr) string {
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestInheritedListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	// listen takes ownership of the descriptor, as a child process would.
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+	f.Close()
+
+	// Test: Serve picks up the socket handed down by a restart
+	t.Setenv(listenFDEnv, fmt.Sprint(fd))
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, l.Addr().String(), s.Addr().String())
+	assert.Empty(t, os.Getenv(listenFDEnv))
+	assert.True(t, strings.HasPrefix(get(t, s.Addr()), "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		<-release
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	})
+	require.NoError(t, err)
+
+	result := make(chan string)
+	go func() { result <- get(t, s.Addr()) }()
+	require.Eventually(t, func() bool {
+		active, _ := s.Metrics().Value("connections_active")
+		return active == 1
+	}, time.Second, time.Millisecond)
+
+	// Test: Shutdown gives up when the context ends first
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	// Test: New connections are refused while in-flight ones finish
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+	close(release)
+	assert.True(t, strings.HasPrefix(<-result, "HTTP/1.1 200 OK\r\n"))
+	require.NoError(t, s.Shutdown(context.Background()))
+}
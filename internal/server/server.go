@@ -1,67 +1,484 @@
 package server
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
 	"io"
+	"log"
 	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"tcp.to.http/internal/metrics"
 	request "tcp.to.http/internal/requests"
 	"tcp.to.http/internal/response"
 )
 
-type HandlerError struct {
-	StatusCode response.StatusCode
-	Message    string
-}
-
 type Handler func(w *response.Writer, req *request.Request)
 
+type Middleware func(next Handler) Handler
+
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
 type Server struct {
-	closed  bool
-	handler Handler
+	closed            atomic.Bool
+	listener          net.Listener
+	conns             sync.WaitGroup
+	handler           Handler
+	requestOptions    request.Options
+	problemDetails    bool
+	strictTrailers    bool
+	normalize         bool
+	upgrades          string
+	healthPath        string
+	drainDelay        time.Duration
+	draining          atomic.Bool
+	maintenance       atomic.Bool
+	maintenanceConfig MaintenanceConfig
+	metrics           *metrics.Registry
+	connMetrics       connMetrics
+	connLog           *log.Logger
+	connContext       func(ctx context.Context, remoteAddr string) context.Context
+	readTimeout       atomic.Int64
+	writeTimeout      atomic.Int64
+
+	tlsConfig    *tls.Config
+	tlsTuning    []func(c *tls.Config)
+	ocspStapling bool
+	stopStapling func()
+
+	readRate  int64
+	writeRate int64
+
+	idleTimeout        time.Duration
+	streamingDeadlines bool
+	tcp                tcpOptions
+	host               string
+	maxConns           int
+	eventLoop          bool
+	loop               *eventLoop
+	connsMu            sync.Mutex
+	open               map[*conn]struct{}
+}
+
+type Option func(s *Server)
+
+func WithMaxRequestLineLength(n int) Option {
+	return func(s *Server) {
+		s.requestOptions.MaxRequestLineLength = n
+	}
+}
+
+func WithMaxHeaderBytes(n int) Option {
+	return func(s *Server) {
+		s.requestOptions.MaxHeaderBytes = n
+	}
+}
+
+// WithMaxTrailers bounds the trailers of chunked request bodies by size and
+// number of fields.
+func WithMaxTrailers(bytes, fields int) Option {
+	return func(s *Server) {
+		s.requestOptions.MaxTrailerBytes = bytes
+		s.requestOptions.MaxTrailerFields = fields
+	}
+}
+
+// WithStrictTrailers sends trailer fields only to clients that asked for
+// them with TE: trailers, as RFC 7230 section 4.3 has it; others get the
+// body alone.
+func WithStrictTrailers() Option {
+	return func(s *Server) {
+		s.strictTrailers = true
+	}
+}
+
+// WithLazyHeaders parses request headers without a string per field; see
+// headers.NewLazyHeaders.
+func WithLazyHeaders() Option {
+	return func(s *Server) {
+		s.requestOptions.LazyHeaders = true
+	}
+}
+
+// WithSpillThreshold keeps request bodies over n bytes in a temporary
+// file for the length of the request. Handlers taking such bodies read
+// them with BodyReader.
+func WithSpillThreshold(n int64) Option {
+	return func(s *Server) {
+		s.requestOptions.SpillThreshold = n
+	}
+}
+
+// WithHost listens on one address, such as "127.0.0.1", instead of all of
+// them.
+func WithHost(host string) Option {
+	return func(s *Server) {
+		s.host = host
+	}
+}
+
+// WithListener serves connections accepted from l; the port and host are
+// then ignored.
+func WithListener(l net.Listener) Option {
+	return func(s *Server) {
+		s.listener = l
+	}
+}
+
+// WithReadTimeout bounds how long a client may take to send its request.
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.readTimeout.Store(int64(d))
+	}
 }
 
-func runConnection(s *Server, conn io.ReadWriteCloser) {
-	defer conn.Close()
-	responseWriter := response.NewWriter(conn)
-	r, err := request.RequestFromReader(conn)
+// WithWriteTimeout bounds how long writing the response may take.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.writeTimeout.Store(int64(d))
+	}
+}
+
+// SetTimeouts changes the read and write timeouts of a running server.
+// Connections already being served keep the deadlines they started with.
+func (s *Server) SetTimeouts(read, write time.Duration) {
+	s.readTimeout.Store(int64(read))
+	s.writeTimeout.Store(int64(write))
+}
+
+// WithRetainRaw keeps up to n bytes of each request's head for
+// request.Raw, to see exactly what a misbehaving client sent.
+func WithRetainRaw(n int) Option {
+	return func(s *Server) {
+		s.requestOptions.RetainRaw = n
+	}
+}
+
+// WithBodyProgress calls fn as each request's body arrives, before the
+// handler runs, e.g. to publish upload progress or cut off an upload that is
+// too large or too slow: an error from fn answers 413 and closes the
+// connection.
+func WithBodyProgress(fn func(r *request.Request, p request.BodyProgress) error) Option {
+	return func(s *Server) {
+		s.requestOptions.OnBodyProgress = fn
+	}
+}
+
+// WithConnContext derives the context each connection's request is parsed
+// with, e.g. to attach a request.RequestTrace.
+func WithConnContext(fn func(ctx context.Context, remoteAddr string) context.Context) Option {
+	return func(s *Server) {
+		s.connContext = fn
+	}
+}
+
+func errorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ERROR_REQUEST_LINE_TOO_LONG):
+		return response.StatusURITooLong
+	case errors.Is(err, request.ERROR_HEADERS_TOO_LARGE),
+		errors.Is(err, request.ERROR_TRAILERS_TOO_LARGE),
+		errors.Is(err, request.ERROR_TOO_MANY_TRAILERS):
+		return response.StatusHeaderTooLarge
+	case errors.Is(err, request.ERROR_UNSUPPORTED_TRANSFER_ENCODING):
+		return response.StatusNotImplemented
+	case errors.Is(err, request.ERROR_BODY_ABORTED):
+		return response.StatusContentTooLarge
+	}
+	return response.StatusBadRequest
+}
+
+func (s *Server) newConn(rwc io.ReadWriteCloser) *conn {
+	c := &conn{
+		ReadWriteCloser: rwc,
+		readLimit:       newBucket(s.readRate),
+		writeLimit:      newBucket(s.writeRate),
+	}
+	c.deadlines.nc, _ = rwc.(net.Conn)
+	c.deadlines.streaming = s.streamingDeadlines
+	return c
+}
+
+// admit registers c as open, shedding others when over the connection cap,
+// and returns its base context.
+func (s *Server) admit(c *conn) context.Context {
+	if n := s.addConn(c); s.maxConns > 0 && n > s.maxConns {
+		s.shed(n - s.maxConns)
+	}
+	ctx := context.Background()
+	if s.connContext != nil {
+		ctx = s.connContext(ctx, c.remoteAddr())
+	}
+	return ctx
+}
+
+func runConnection(s *Server, rwc io.ReadWriteCloser) {
+	c := s.newConn(rwc)
+	defer s.trackConn(c)()
+	defer func() {
+		if !c.hijacked.Load() {
+			c.close()
+		}
+	}()
+	ctx := s.admit(c)
+	defer s.removeConn(c)
+
+	parser := request.NewParser(c, s.requestOptions)
+	defer parser.Release()
+	for first := true; ; first = false {
+		if !serveRequest(s, c, parser, ctx, first) {
+			return
+		}
+	}
+}
+
+// WithNormalization puts every request in canonical form with
+// request.Normalize before it reaches the handler, so routing and middleware
+// all see the same host and path however the client spelled them. A request
+// that can't be normalized, such as one with two Hosts, gets a 400.
+func WithNormalization() Option {
+	return func(s *Server) {
+		s.normalize = true
+	}
+}
+
+// serveRequest reads and answers one request, reporting whether the
+// connection should be kept open for another.
+func serveRequest(s *Server, c *conn, parser *request.Parser, ctx context.Context, first bool) bool {
+	// Timeouts are read per request so SetTimeouts reaches connections
+	// that are already open.
+	c.deadlines.read = time.Duration(s.readTimeout.Load())
+	c.deadlines.write = time.Duration(s.writeTimeout.Load())
+	idle := !first && parser.Buffered() == 0 && len(c.pending) == 0
+	if idle && !s.setIdle(c, true) {
+		return false
+	}
+	c.deadlines.startRead(idle, s.idleTimeout)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	read := c.read.Load()
+	r, err := parser.Next(ctx)
+	defer r.Close()
+	if idle {
+		s.setIdle(c, false)
+		if err != nil && c.read.Load() == read {
+			// Nothing of a new request arrived: the idle timeout expired, the
+			// client went away or the server reaped the connection.
+			if closeReason(c.Err()) == "timeout" {
+				c.reaped.Store(true)
+			}
+			return false
+		}
+	}
+
+	responseWriter := response.NewWriter(c)
+	responseWriter.BufferHead()
+	r.RemoteAddr = c.remoteAddr()
+	if tc, ok := c.ReadWriteCloser.(*tls.Conn); ok && err == nil {
+		state := tc.ConnectionState()
+		r.TLS = &state
+	}
+	if s.problemDetails {
+		r = r.WithContext(context.WithValue(r.Context(), problemDetailsKey{}, true))
+	}
+	if s.strictTrailers && !r.AcceptsTrailers() {
+		responseWriter.DropTrailers()
+	}
+	c.deadlines.startWrite()
 	if err != nil {
-		responseWriter.WriteStatusLine(response.StatusBadRequest)
-		responseWriter.WriteHeaders(*response.GetDefaultHeaders(0))
-		return
+		// Nobody is listening for an error response on a reset or timed
+		// out connection, or one the client closed mid-request.
+		if c.Err() == nil && err != io.EOF {
+			Error(responseWriter, r, errorStatus(err), "")
+			responseWriter.Flush()
+		}
+		return false
+	}
+	if s.normalize {
+		if err := r.Normalize(); err != nil {
+			Error(responseWriter, r, response.StatusBadRequest, "")
+			responseWriter.Flush()
+			return false
+		}
 	}
 
-	s.handler(responseWriter, r)
+	keepAlive := s.keepAlive(responseWriter, r, c)
+	s.advertiseUpgrades(responseWriter)
+	h := &hijacker{c: c, parser: parser, stopWatch: c.watchClient(cancel, !clientKeepAlive(r)), flush: responseWriter.Flush}
+	whole := s.runHandler(c, responseWriter, r.WithContext(context.WithValue(r.Context(), hijackKey{}, h)))
+	h.once.Do(h.stopWatch)
+	if !h.hijacked && responseWriter.Flush() != nil {
+		return false
+	}
+	if h.hijacked || !whole {
+		return false
+	}
+	if !keepAlive() {
+		c.linger = true
+		return false
+	}
+	return true
+}
+
+// runHandler calls the handler, reporting whether the response it wrote can
+// be trusted to be whole. A panic is logged rather than taking the server
+// down, and answered with a 500 when nothing was written yet; a write the
+// response.Writer refused as out of order is logged too. Either way the
+// connection is closed after the response.
+func (s *Server) runHandler(c *conn, w *response.Writer, r *request.Request) (whole bool) {
+	s.connMetrics.inFlight.Add(1)
+	defer s.connMetrics.inFlight.Add(-1)
+	defer func() {
+		p := recover()
+		if p == nil {
+			return
+		}
+		whole = false
+		log.Printf("server: panic serving %s %s: %v\n%s", r.RequestLine.Method, r.RequestLine.RequestTarget, p, debug.Stack())
+		if w.Status() == 0 && !c.hijacked.Load() {
+			c.closeAfter.Store(true)
+			Error(w, r, response.StatusInternalServeError, "")
+		}
+	}()
+	s.handlerFor(r)(w, r)
+	if err := w.Misuse(); err != nil {
+		log.Printf("server: %s %s: %v", r.RequestLine.Method, r.RequestLine.RequestTarget, err)
+		return false
+	}
+	return true
 }
 
 func runServer(s *Server, listener net.Listener) {
+	backoff := 5 * time.Millisecond
 	for {
 		conn, err := listener.Accept()
-		if s.closed {
-
+		if s.closed.Load() {
+			if conn != nil {
+				conn.Close()
+			}
+			return
 		}
 		if err != nil {
+			if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+				// Out of file descriptors: free some up and retry.
+				log.Printf("server: accept: %v; shedding idle connections", err)
+				s.shed(max(1, s.openCount()/10))
+				time.Sleep(backoff)
+				backoff = min(2*backoff, time.Second)
+				continue
+			}
+			log.Printf("server: accept: %v", err)
 			return
 		}
-		go runConnection(s, conn)
+		backoff = 5 * time.Millisecond
+		s.conns.Add(1)
+		if s.loop != nil && s.loop.add(conn) {
+			continue
+		}
+		go func() {
+			defer s.conns.Done()
+			runConnection(s, conn)
+		}()
 	}
 }
 
-func Serve(port uint16, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		return nil, err
+func Serve(port uint16, handler Handler, options ...Option) (*Server, error) {
+	server := &Server{handler: handler}
+	for _, option := range options {
+		option(server)
+	}
+	listener := server.listener
+	if listener == nil {
+		var err error
+		listener, err = listen(server.host, port, server.tcp.listenConfig())
+		if err != nil {
+			return nil, err
+		}
+		server.listener = listener
+	}
+	if server.metrics == nil {
+		server.metrics = metrics.NewRegistry()
+	}
+	server.connMetrics = newConnMetrics(server.metrics)
+
+	// The raw listener is kept for Close and Restart; TLS wraps only what
+	// is accepted.
+	accept := server.tcp.wrap(listener)
+	if server.tlsConfig != nil {
+		for _, fn := range server.tlsTuning {
+			fn(server.tlsConfig)
+		}
+		if server.ocspStapling && len(server.tlsConfig.Certificates) > 0 {
+			if err := server.startStapling(); err != nil {
+				listener.Close()
+				return nil, err
+			}
+		}
+		accept = tls.NewListener(accept, server.tlsConfig)
 	}
-	server := &Server{
-		closed:  false,
-		handler: handler,
+	if server.eventLoop {
+		loop, err := newEventLoop(server)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		server.loop = loop
 	}
-	go runServer(server, listener)
+	go runServer(server, accept)
 
 	return server, nil
 }
 
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections. Connections already accepted are
+// left to finish; use Shutdown to wait for them.
 func (s *Server) Close() error {
-	s.closed = true
-	return nil
+	if s.closed.Swap(true) {
+		return nil
+	}
+	if s.stopStapling != nil {
+		s.stopStapling()
+	}
+	return s.listener.Close()
+}
+
+// Shutdown closes the listener and idle keep-alive connections, then waits
+// until every busy connection has finished its response or ctx is done.
+// With WithDrainDelay it first fails health checks for the delay, while
+// still accepting.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.draining.Swap(true) && s.drainDelay > 0 {
+		select {
+		case <-time.After(s.drainDelay):
+		case <-ctx.Done():
+		}
+	}
+	s.Close()
+	s.closeIdle()
+
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
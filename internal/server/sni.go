@@ -0,0 +1,198 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp.to.http/internal/singleflight"
+)
+
+var ERROR_NO_CERTIFICATE = fmt.Errorf("no certificate for server name")
+
+// WithGetCertificate picks the certificate for each handshake with fn, such
+// as a Certificates' GetCertificate, in place of the TLS config's own
+// Certificates. WithOCSPStapling, which staples for those Certificates,
+// replaces it.
+func WithGetCertificate(fn func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)) Option {
+	return tuneTLS(func(c *tls.Config) {
+		c.GetCertificate = fn
+	})
+}
+
+// Certificates serves a certificate per SNI name, for several hosts on one
+// listener. It can be changed while the server runs, and Load, when set,
+// fetches certificates for names it does not know yet.
+type Certificates struct {
+	// Load is asked for a certificate for a name with none, e.g. to read
+	// it from disk or a secret store. A nil certificate with no error
+	// falls back to the default. Results are cached until Remove, misses
+	// for MissTTL; concurrent handshakes for one name share a Load.
+	Load func(name string) (*tls.Certificate, error)
+	// MissTTL is how long a name Load had no certificate for is served the
+	// default before Load is asked again, one minute by default.
+	MissTTL time.Duration
+	// MaxLoaded bounds how many names Load results are cached for, 1000 by
+	// default; clients pick the names, so past it the oldest give way.
+	MaxLoaded int
+
+	mu       sync.RWMutex
+	byName   map[string]*tls.Certificate
+	loaded   map[string]loadedCertificate
+	fallback *tls.Certificate
+	flight   singleflight.Group[loadResult]
+}
+
+// loadedCertificate is a cached Load result; cert is nil for a miss.
+type loadedCertificate struct {
+	cert *tls.Certificate
+	at   time.Time
+}
+
+type loadResult struct {
+	cert *tls.Certificate
+	err  error
+}
+
+func NewCertificates() *Certificates {
+	return &Certificates{
+		byName: map[string]*tls.Certificate{},
+		loaded: map[string]loadedCertificate{},
+	}
+}
+
+// Add serves cert for names, which may be wildcards such as
+// "*.example.com". Without names it is served for the DNS names of its
+// leaf certificate.
+func (c *Certificates) Add(cert *tls.Certificate, names ...string) error {
+	if len(names) == 0 {
+		leaf, err := leafOf(cert)
+		if err != nil {
+			return err
+		}
+		names = leaf.DNSNames
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		c.byName[strings.ToLower(name)] = cert
+	}
+	return nil
+}
+
+func leafOf(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("certificate has no chain")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+// Remove stops serving the certificates for names, so Load is asked again.
+func (c *Certificates) Remove(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		delete(c.byName, strings.ToLower(name))
+		delete(c.loaded, strings.ToLower(name))
+	}
+}
+
+// SetDefault serves cert to clients that send no SNI name, or one with no
+// certificate.
+func (c *Certificates) SetDefault(cert *tls.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fallback = cert
+}
+
+// GetCertificate picks the certificate for hello's server name: an exact
+// match, then a wildcard for its parent domain, then Load, then the
+// default.
+func (c *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name != "" {
+		if cert := c.lookup(name); cert != nil {
+			return cert, nil
+		}
+		if c.Load != nil {
+			cert, err := c.load(name)
+			if err != nil {
+				return nil, err
+			}
+			if cert != nil {
+				return cert, nil
+			}
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.fallback == nil {
+		return nil, fmt.Errorf("%w: %q", ERROR_NO_CERTIFICATE, name)
+	}
+	return c.fallback, nil
+}
+
+func (c *Certificates) lookup(name string) *tls.Certificate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if cert, ok := c.byName[name]; ok {
+		return cert
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		return c.byName["*."+parent]
+	}
+	return nil
+}
+
+// load answers from the cache of Load results, or asks Load once for all
+// the handshakes waiting on name.
+func (c *Certificates) load(name string) (*tls.Certificate, error) {
+	c.mu.RLock()
+	entry, ok := c.loaded[name]
+	c.mu.RUnlock()
+	if ok && (entry.cert != nil || time.Since(entry.at) < c.missTTL()) {
+		return entry.cert, nil
+	}
+
+	res, _ := c.flight.Do(name, func() loadResult {
+		cert, err := c.Load(name)
+		if err == nil {
+			c.remember(name, cert)
+		}
+		return loadResult{cert, err}
+	})
+	return res.cert, res.err
+}
+
+func (c *Certificates) remember(name string, cert *tls.Certificate) {
+	limit := c.MaxLoaded
+	if limit <= 0 {
+		limit = 1000
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.loaded[name]; !ok && len(c.loaded) >= limit {
+		oldest := ""
+		for key, entry := range c.loaded {
+			if oldest == "" || entry.at.Before(c.loaded[oldest].at) {
+				oldest = key
+			}
+		}
+		delete(c.loaded, oldest)
+	}
+	c.loaded[name] = loadedCertificate{cert: cert, at: time.Now()}
+}
+
+func (c *Certificates) missTTL() time.Duration {
+	if c.MissTTL > 0 {
+		return c.MissTTL
+	}
+	return time.Minute
+}
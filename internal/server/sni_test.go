@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/tls"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestCertificatesBySNI(t *testing.T) {
+	a, _ := selfSigned(t, "a.example")
+	wildcard, _ := selfSigned(t, "*.b.example")
+	fallback, _ := selfSigned(t, "localhost")
+	loaded, _ := selfSigned(t, "c.example")
+
+	certs := NewCertificates()
+	require.NoError(t, certs.Add(&a))
+	require.NoError(t, certs.Add(&wildcard))
+	loads := 0
+	certs.Load = func(name string) (*tls.Certificate, error) {
+		loads++
+		if name == "c.example" {
+			return &loaded, nil
+		}
+		return nil, nil
+	}
+
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteText(response.StatusOK, "ok")
+	}, WithTLS(&tls.Config{}), WithGetCertificate(certs.GetCertificate))
+	require.NoError(t, err)
+	defer s.Close()
+
+	served := func(name string) (string, error) {
+		conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{ServerName: name, InsecureSkipVerify: true})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	// Test: Each name gets its own certificate, wildcards included
+	name, err := served("a.example")
+	require.NoError(t, err)
+	assert.Equal(t, "a.example", name)
+	name, err = served("API.b.example")
+	require.NoError(t, err)
+	assert.Equal(t, "*.b.example", name)
+
+	// Test: Unknown names are loaded once, then cached
+	name, err = served("c.example")
+	require.NoError(t, err)
+	assert.Equal(t, "c.example", name)
+	served("c.example")
+	assert.Equal(t, 1, loads)
+
+	// Test: Names with no certificate fail without a default
+	_, err = served("d.example")
+	assert.Error(t, err)
+	certs.SetDefault(&fallback)
+	name, err = served("d.example")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", name)
+
+	// Test: Certificates can be swapped while serving
+	replacement, _ := selfSigned(t, "www.a.example", "a.example")
+	certs.Remove("a.example")
+	require.NoError(t, certs.Add(&replacement))
+	name, err = served("a.example")
+	require.NoError(t, err)
+	assert.Equal(t, "www.a.example", name)
+}
+
+func TestCertificatesLoadCache(t *testing.T) {
+	fallback, _ := selfSigned(t, "localhost")
+	loaded, _ := selfSigned(t, "c.example")
+	certs := NewCertificates()
+	certs.SetDefault(&fallback)
+	certs.MissTTL = 20 * time.Millisecond
+	certs.MaxLoaded = 2
+	var loads atomic.Int32
+	release := make(chan struct{})
+	certs.Load = func(name string) (*tls.Certificate, error) {
+		loads.Add(1)
+		<-release
+		if strings.HasPrefix(name, "c") {
+			return &loaded, nil
+		}
+		return nil, nil
+	}
+	get := func(name string) *tls.Certificate {
+		cert, err := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		require.NoError(t, err)
+		return cert
+	}
+
+	// Test: Concurrent handshakes for one name share a Load
+	wg := sync.WaitGroup{}
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Same(t, &loaded, get("c.example"))
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), loads.Load())
+
+	// Test: Misses are cached until MissTTL passes
+	assert.Same(t, &fallback, get("d.example"))
+	assert.Same(t, &fallback, get("d.example"))
+	assert.Equal(t, int32(2), loads.Load())
+	time.Sleep(30 * time.Millisecond)
+	get("d.example")
+	assert.Equal(t, int32(3), loads.Load())
+
+	// Test: Past MaxLoaded the oldest cached names give way
+	get("e.example")
+	assert.Len(t, certs.loaded, 2)
+	assert.NotContains(t, certs.loaded, "c.example")
+	get("c.example")
+	assert.Equal(t, int32(5), loads.Load())
+}
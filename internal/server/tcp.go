@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+type tcpOptions struct {
+	noDelay   *bool
+	keepAlive *net.KeepAliveConfig
+	reusePort bool
+}
+
+// WithNoDelay sets TCP_NODELAY on accepted connections. Go turns it on by
+// default; pass false to let Nagle's algorithm coalesce small writes.
+func WithNoDelay(enabled bool) Option {
+	return func(s *Server) {
+		s.tcp.noDelay = &enabled
+	}
+}
+
+// WithTCPKeepAlive turns on SO_KEEPALIVE probes for accepted connections:
+// the first after idle, then every interval, giving up after count
+// unanswered probes. Zero values keep the system defaults.
+func WithTCPKeepAlive(idle, interval time.Duration, count int) Option {
+	return func(s *Server) {
+		s.tcp.keepAlive = &net.KeepAliveConfig{Enable: true, Idle: idle, Interval: interval, Count: count}
+	}
+}
+
+// WithReusePort sets SO_REUSEPORT on the listening socket so several
+// processes can listen on the same port, with the kernel spreading new
+// connections between them.
+func WithReusePort() Option {
+	return func(s *Server) {
+		s.tcp.reusePort = true
+	}
+}
+
+func (o tcpOptions) listenConfig() net.ListenConfig {
+	if !o.reusePort {
+		return net.ListenConfig{}
+	}
+	return net.ListenConfig{
+		Control: func(_, _ string, rc syscall.RawConn) error {
+			var err error
+			rc.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+			})
+			return err
+		},
+	}
+}
+
+// tunedListener applies the socket options to every connection it accepts.
+type tunedListener struct {
+	net.Listener
+	options tcpOptions
+}
+
+func (o tcpOptions) wrap(l net.Listener) net.Listener {
+	if o.noDelay == nil && o.keepAlive == nil {
+		return l
+	}
+	return tunedListener{l, o}
+}
+
+func (l tunedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if tc, ok := c.(*net.TCPConn); ok {
+		if l.options.noDelay != nil {
+			tc.SetNoDelay(*l.options.noDelay)
+		}
+		if l.options.keepAlive != nil {
+			tc.SetKeepAliveConfig(*l.options.keepAlive)
+		}
+	}
+	return c, err
+}
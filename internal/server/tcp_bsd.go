@@ -0,0 +1,5 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package server
+
+const soReusePort = 0x200
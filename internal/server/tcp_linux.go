@@ -0,0 +1,4 @@
+package server
+
+// soReusePort is SO_REUSEPORT, which the syscall package leaves out on Linux.
+const soReusePort = 0xf
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sockopt(t *testing.T, c net.Conn, level, opt int) int {
+	rc, err := c.(*net.TCPConn).SyscallConn()
+	require.NoError(t, err)
+	value := 0
+	rc.Control(func(fd uintptr) {
+		value, err = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	require.NoError(t, err)
+	return value
+}
+
+func TestTCPOptions(t *testing.T) {
+	// Test: With SO_REUSEPORT two servers can share a port
+	first, err := Serve(0, nil, WithReusePort())
+	require.NoError(t, err)
+	defer first.Close()
+	port := uint16(first.Addr().(*net.TCPAddr).Port)
+	second, err := Serve(port, nil, WithReusePort())
+	require.NoError(t, err)
+	second.Close()
+
+	// Test: Without it the port is taken
+	_, err = Serve(port, nil)
+	assert.Error(t, err)
+
+	// Test: Accepted connections get the socket options
+	s := newTestServer(WithNoDelay(false), WithTCPKeepAlive(time.Minute, 10*time.Second, 3))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	l := s.tcp.wrap(listener)
+	go net.Dial("tcp", listener.Addr().String())
+	c, err := l.Accept()
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, 0, sockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+	assert.Equal(t, 1, sockopt(t, c, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+	assert.Equal(t, 60, sockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE))
+	assert.Equal(t, 10, sockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL))
+}
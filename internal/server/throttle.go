@@ -0,0 +1,53 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket holding up to a second's worth of bytes.
+type bucket struct {
+	rate  float64
+	burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(bytesPerSecond int64) *bucket {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &bucket{
+		rate:   float64(bytesPerSecond),
+		burst:  int(max(bytesPerSecond, 1024)),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n tokens, sleeping until the bucket has refilled enough.
+func (b *bucket) wait(n int) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, float64(b.burst))
+	b.last = now
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / b.rate * float64(time.Second)))
+	}
+}
+
+// WithRateLimit caps each connection's read and write throughput in bytes
+// per second, so one client streaming a large response can't take the
+// whole uplink. Zero leaves a direction unlimited.
+func WithRateLimit(readBytesPerSecond, writeBytesPerSecond int64) Option {
+	return func(s *Server) {
+		s.readRate = readBytesPerSecond
+		s.writeRate = writeBytesPerSecond
+	}
+}
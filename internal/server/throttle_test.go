@@ -0,0 +1,36 @@
+package server
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestRateLimit(t *testing.T) {
+	const rate = 1 << 20
+	body := make([]byte, rate*3/2)
+	s := newTestServer(WithRateLimit(0, rate))
+	s.handler = func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+
+	client, srv := net.Pipe()
+	go runConnection(s, srv)
+	start := time.Now()
+	_, err := client.Write([]byte("GET /video HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	out, err := io.ReadAll(client)
+	require.NoError(t, err)
+
+	// Test: Writes beyond the initial burst are paced to the rate
+	assert.Greater(t, len(out), len(body))
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
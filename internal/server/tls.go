@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/tls"
+	"io"
+
+	"tcp.to.http/internal/ocsp"
+)
+
+// WithTLS serves TLS using a copy of config. The tuning options below apply
+// on top of it, in any order; without WithTLS they have no effect.
+func WithTLS(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config.Clone()
+	}
+}
+
+func tuneTLS(fn func(c *tls.Config)) Option {
+	return func(s *Server) {
+		s.tlsTuning = append(s.tlsTuning, fn)
+	}
+}
+
+// WithTLSMinVersion sets the oldest protocol version accepted, e.g.
+// tls.VersionTLS13.
+func WithTLSMinVersion(version uint16) Option {
+	return tuneTLS(func(c *tls.Config) {
+		c.MinVersion = version
+	})
+}
+
+// WithCipherSuites restricts the TLS 1.0-1.2 cipher suites; TLS 1.3 suites
+// are not configurable.
+func WithCipherSuites(suites ...uint16) Option {
+	return tuneTLS(func(c *tls.Config) {
+		c.CipherSuites = suites
+	})
+}
+
+func WithCurvePreferences(curves ...tls.CurveID) Option {
+	return tuneTLS(func(c *tls.Config) {
+		c.CurvePreferences = curves
+	})
+}
+
+// WithSessionTickets turns ticket-based session resumption on or off. It
+// is on by default. Instances behind one load balancer (or a restarted
+// process) can resume each other's sessions if given the same keys.
+func WithSessionTickets(enabled bool, keys ...[32]byte) Option {
+	return tuneTLS(func(c *tls.Config) {
+		c.SessionTicketsDisabled = !enabled
+		if len(keys) > 0 {
+			c.SetSessionTicketKeys(keys)
+		}
+	})
+}
+
+// WithKeyLogWriter writes TLS secrets in NSS key log format so captures
+// can be decrypted in Wireshark. Never enable it in production.
+func WithKeyLogWriter(w io.Writer) Option {
+	return tuneTLS(func(c *tls.Config) {
+		c.KeyLogWriter = w
+	})
+}
+
+// WithOCSPStapling staples OCSP responses for the TLS config's
+// Certificates, refreshing them in the background while the server runs.
+func WithOCSPStapling() Option {
+	return func(s *Server) {
+		s.ocspStapling = true
+	}
+}
+
+func (s *Server) startStapling() error {
+	stapler, err := ocsp.NewStapler(s.tlsConfig.Certificates)
+	if err != nil {
+		return err
+	}
+	// GetCertificate is only consulted for every handshake when
+	// Certificates is empty.
+	s.tlsConfig.Certificates = nil
+	s.tlsConfig.GetCertificate = stapler.GetCertificate
+	s.stopStapling = stapler.Start()
+	return nil
+}
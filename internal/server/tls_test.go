@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+// selfSigned makes a certificate for names, localhost when there are none.
+func selfSigned(t *testing.T, names ...string) (tls.Certificate, *x509.CertPool) {
+	if len(names) == 0 {
+		names = []string{"localhost"}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	keyLog := &bytes.Buffer{}
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		body := []byte("plain")
+		if req.TLS != nil {
+			body = []byte(tls.VersionName(req.TLS.Version))
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	},
+		WithTLSMinVersion(tls.VersionTLS13),
+		WithKeyLogWriter(keyLog),
+		WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+
+	sessions := tls.NewLRUClientSessionCache(1)
+	get := func(maxVersion uint16) (string, tls.ConnectionState, error) {
+		conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+			RootCAs:            pool,
+			ServerName:         "localhost",
+			MaxVersion:         maxVersion,
+			ClientSessionCache: sessions,
+		})
+		if err != nil {
+			return "", tls.ConnectionState{}, err
+		}
+		defer conn.Close()
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		out, err := io.ReadAll(conn)
+		return string(out), conn.ConnectionState(), err
+	}
+
+	// Test: Requests carry the TLS state
+	out, state, err := get(0)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nTLS 1.3"))
+	assert.False(t, state.DidResume)
+
+	// Test: Secrets go to the key log writer
+	assert.Contains(t, keyLog.String(), "CLIENT_HANDSHAKE_TRAFFIC_SECRET ")
+
+	// Test: Sessions resume from tickets
+	_, state, err = get(0)
+	require.NoError(t, err)
+	assert.True(t, state.DidResume)
+
+	// Test: The minimum version is enforced
+	_, _, err = get(tls.VersionTLS12)
+	assert.Error(t, err)
+}
+
+func TestOCSPStaplingWithoutResponder(t *testing.T) {
+	cert, pool := selfSigned(t)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}, WithTLS(&tls.Config{Certificates: []tls.Certificate{cert}}), WithOCSPStapling())
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Certificates without an OCSP responder are served unstapled
+	conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Empty(t, conn.ConnectionState().OCSPResponse)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"strings"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+// WithUpgrades advertises protocols, such as "websocket" or "h2c", in an
+// Upgrade header on every response that does not carry one already, so
+// clients learn they may switch.
+func WithUpgrades(protocols ...string) Option {
+	return func(s *Server) {
+		s.upgrades = strings.Join(protocols, ", ")
+	}
+}
+
+// UpgradeRequired refuses req with 426 Upgrade Required, naming in the
+// Upgrade header the protocols, most preferred first, it must switch to.
+func UpgradeRequired(w *response.Writer, req *request.Request, protocols ...string) {
+	upgrades := strings.Join(protocols, ", ")
+	w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+		addUpgrade(h, upgrades)
+	})
+	Error(w, req, response.StatusUpgradeRequired, "")
+}
+
+// advertiseUpgrades adds the server's Upgrade header to w's response. It
+// must be registered after keepAlive, which sets Connection.
+func (s *Server) advertiseUpgrades(w *response.Writer) {
+	if s.upgrades == "" {
+		return
+	}
+	w.OnHeaders(func(status response.StatusCode, h *headers.Headers) {
+		if _, ok := h.Get("upgrade"); !ok && status != response.StatusSwitchingProtocols {
+			addUpgrade(h, s.upgrades)
+		}
+	})
+}
+
+// addUpgrade sets Upgrade and lists it in Connection, as a header only
+// meant for the next hop must be.
+func addUpgrade(h *headers.Headers, upgrades string) {
+	h.Replace("Upgrade", upgrades)
+	connection, _ := h.Get("connection")
+	for _, token := range strings.Split(connection, ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+			return
+		}
+	}
+	if connection != "" {
+		connection += ", "
+	}
+	h.Replace("Connection", connection+"Upgrade")
+}
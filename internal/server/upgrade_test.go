@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestUpgrades(t *testing.T) {
+	fetch := func(s *Server) *response.Response {
+		client, done := serve(s)
+		client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		res, err := response.ResponseFromReader(client)
+		require.NoError(t, err)
+		client.Close()
+		<-done
+		return res
+	}
+
+	// Test: 426 names the protocols to switch to, as a connection option
+	s := newTestServer(WithIdleTimeout(time.Second))
+	s.handler = func(w *response.Writer, req *request.Request) {
+		UpgradeRequired(w, req, "websocket")
+	}
+	res := fetch(s)
+	assert.Equal(t, response.StatusUpgradeRequired, res.StatusLine.StatusCode)
+	upgrade, _ := res.Headers.Get("upgrade")
+	assert.Equal(t, "websocket", upgrade)
+	connection, _ := res.Headers.Get("connection")
+	assert.Equal(t, "keep-alive, Upgrade", connection)
+
+	// Test: Advertised upgrades go on every response
+	res = fetch(newTestServer(WithUpgrades("h2c", "websocket")))
+	assert.Equal(t, response.StatusOK, res.StatusLine.StatusCode)
+	upgrade, _ = res.Headers.Get("upgrade")
+	assert.Equal(t, "h2c, websocket", upgrade)
+	connection, _ = res.Headers.Get("connection")
+	assert.Equal(t, "close, Upgrade", connection)
+
+	// Test: 101 keeps the handler's Connection: Upgrade
+	s = newTestServer(WithIdleTimeout(time.Second), WithUpgrades("h2c"))
+	s.handler = func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		h.Replace("Connection", "Upgrade")
+		h.Replace("Upgrade", "websocket")
+		w.WriteStatusLine(response.StatusSwitchingProtocols)
+		w.WriteHeaders(*h)
+	}
+	res = fetch(s)
+	upgrade, _ = res.Headers.Get("upgrade")
+	assert.Equal(t, "websocket", upgrade)
+	connection, _ = res.Headers.Get("connection")
+	assert.Equal(t, "Upgrade", connection)
+}
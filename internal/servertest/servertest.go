@@ -0,0 +1,99 @@
+package servertest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"tcp.to.http/internal/client"
+	"tcp.to.http/internal/server"
+)
+
+// Server is a server started for a handler test, the way net/http's
+// httptest does it, so tests need not agree on a port.
+type Server struct {
+	*server.Server
+	// URL is the base URL, without a trailing slash.
+	URL string
+	// Client is set up to reach the server, pipe or not.
+	Client *client.Client
+}
+
+// New serves handler on a random loopback port until the test ends.
+func New(t testing.TB, handler server.Handler, options ...server.Option) *Server {
+	t.Helper()
+	options = append([]server.Option{server.WithHost("127.0.0.1")}, options...)
+	s, err := server.Serve(0, handler, options...)
+	if err != nil {
+		t.Fatalf("servertest: %v", err)
+	}
+	ts := &Server{Server: s, URL: "http://" + s.Addr().String(), Client: &client.Client{}}
+	t.Cleanup(ts.close)
+	return ts
+}
+
+// NewPipe serves handler over in-memory connections; no socket is opened.
+// Only ts.Client can reach it.
+func NewPipe(t testing.TB, handler server.Handler, options ...server.Option) *Server {
+	t.Helper()
+	l := &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+	s, err := server.Serve(0, handler, append(options, server.WithListener(l))...)
+	if err != nil {
+		t.Fatalf("servertest: %v", err)
+	}
+	ts := &Server{Server: s, URL: "http://" + l.Addr().String(), Client: &client.Client{Dial: l.dial}}
+	t.Cleanup(ts.close)
+	return ts
+}
+
+func (ts *Server) close() {
+	ts.Client.CloseIdleConnections()
+	ts.Server.Close()
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "servertest.pipe:80" }
+
+// pipeListener hands the server one end of a net.Pipe per dial.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (l *pipeListener) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if addr != l.Addr().String() {
+		return nil, fmt.Errorf("servertest: %s is not %s", addr, l.Addr())
+	}
+	c, s := net.Pipe()
+	select {
+	case l.conns <- s:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
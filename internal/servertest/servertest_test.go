@@ -0,0 +1,73 @@
+package servertest
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/client"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+func echo(w *response.Writer, req *request.Request) {
+	body := req.RequestLine.Method + " " + req.RequestLine.RequestTarget
+	h := response.GetDefaultHeaders(len(body))
+	h.Delete("Connection")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	w.WriteBody([]byte(body))
+}
+
+func get(t *testing.T, ts *Server, path string) string {
+	req, err := client.NewRequest(context.Background(), "GET", ts.URL+path, nil)
+	require.NoError(t, err)
+	res, err := ts.Client.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestNew(t *testing.T) {
+	ts := New(t, echo)
+
+	// Test: The server listens on a random loopback port
+	assert.Regexp(t, `^http://127\.0\.0\.1:\d+$`, ts.URL)
+	assert.Equal(t, "GET /hello", get(t, ts, "/hello"))
+}
+
+func TestNewPipe(t *testing.T) {
+	ts := NewPipe(t, echo, server.WithIdleTimeout(time.Second))
+
+	// Test: Requests travel over in-memory connections, kept alive between
+	// requests
+	assert.Equal(t, "GET /one", get(t, ts, "/one"))
+	assert.Equal(t, "GET /two", get(t, ts, "/two"))
+	accepted, _ := ts.Metrics().Value("connections_accepted_total")
+	assert.Equal(t, int64(1), accepted)
+
+	// Test: Other hosts are not reachable through the pipe client
+	req, err := client.NewRequest(context.Background(), "GET", "http://127.0.0.1:1/", nil)
+	require.NoError(t, err)
+	_, err = ts.Client.Do(req)
+	assert.Error(t, err)
+}
+
+func TestCleanup(t *testing.T) {
+	var ts *Server
+	t.Run("inner", func(t *testing.T) {
+		ts = NewPipe(t, echo)
+	})
+
+	// Test: The server is closed once its test ends
+	req, err := client.NewRequest(context.Background(), "GET", ts.URL+"/", nil)
+	require.NoError(t, err)
+	_, err = ts.Client.Do(req)
+	assert.Error(t, err)
+}
@@ -0,0 +1,277 @@
+package session
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+var ERROR_INVALID_COOKIE = fmt.Errorf("invalid session cookie")
+var ERROR_MISSING_HASH_KEY = fmt.Errorf("session hash key is required")
+
+type Session struct {
+	id        string
+	oldID     string
+	values    map[string]string
+	dirty     bool
+	destroyed bool
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *Session) Set(key, value string) {
+	s.values[key] = value
+	s.dirty = true
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.values, key)
+	s.dirty = true
+}
+
+// Rotate issues a new session id while keeping the values. Call it whenever
+// the privilege level changes (login, logout, sudo) to prevent fixation.
+func (s *Session) Rotate() {
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.dirty = true
+}
+
+func (s *Session) Destroy() {
+	s.values = map[string]string{}
+	s.destroyed = true
+}
+
+type Config struct {
+	CookieName string
+	// HashKey signs the cookie; BlockKey (16, 24 or 32 bytes) additionally
+	// encrypts it when set.
+	HashKey  []byte
+	BlockKey []byte
+	MaxAge   time.Duration
+	Path     string
+	Domain   string
+	Secure   bool
+}
+
+type Manager struct {
+	store  Store
+	config Config
+	aead   cipher.AEAD
+}
+
+func NewManager(store Store, config Config) (*Manager, error) {
+	if len(config.HashKey) == 0 {
+		return nil, ERROR_MISSING_HASH_KEY
+	}
+	if config.CookieName == "" {
+		config.CookieName = "session"
+	}
+	if config.MaxAge == 0 {
+		config.MaxAge = 24 * time.Hour
+	}
+	if config.Path == "" {
+		config.Path = "/"
+	}
+
+	m := &Manager{store: store, config: config}
+	if len(config.BlockKey) > 0 {
+		block, err := aes.NewCipher(config.BlockKey)
+		if err != nil {
+			return nil, err
+		}
+		m.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+type contextKey struct{}
+
+func FromRequest(req *request.Request) *Session {
+	s, _ := req.Context().Value(contextKey{}).(*Session)
+	return s
+}
+
+func (m *Manager) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		s := m.Load(req)
+		w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+			if err := m.Save(s, h); err != nil {
+				log.Printf("session: save failed: %v", err)
+			}
+		})
+		next(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, s)))
+	}
+}
+
+// Load returns the session referenced by the request cookie, or a fresh
+// empty session when there is none or it cannot be verified.
+func (m *Manager) Load(req *request.Request) *Session {
+	if value, ok := cookie(req.Headers, m.config.CookieName); ok {
+		if id, err := m.decode(value); err == nil {
+			if values, err := m.store.Load(id); err == nil {
+				return &Session{id: id, values: values}
+			}
+		}
+	}
+	return &Session{id: newID(), values: map[string]string{}}
+}
+
+// Save persists s and adds the matching Set-Cookie header to h. Unmodified
+// sessions are left alone.
+func (m *Manager) Save(s *Session, h *headers.Headers) error {
+	if s.oldID != "" {
+		if err := m.store.Delete(s.oldID); err != nil {
+			return err
+		}
+		s.oldID = ""
+	}
+
+	if s.destroyed {
+		if err := m.store.Delete(s.id); err != nil {
+			return err
+		}
+		h.Set("Set-Cookie", m.cookie("", -1))
+		return nil
+	}
+
+	if !s.dirty {
+		return nil
+	}
+	if err := m.store.Save(s.id, s.values, m.config.MaxAge); err != nil {
+		return err
+	}
+	value, err := m.encode(s.id)
+	if err != nil {
+		return err
+	}
+	h.Set("Set-Cookie", m.cookie(value, int(m.config.MaxAge.Seconds())))
+	s.dirty = false
+	return nil
+}
+
+func (m *Manager) cookie(value string, maxAge int) string {
+	c := fmt.Sprintf("%s=%s; Path=%s; Max-Age=%d; HttpOnly; SameSite=Lax", m.config.CookieName, value, m.config.Path, maxAge)
+	if m.config.Domain != "" {
+		c += "; Domain=" + m.config.Domain
+	}
+	if m.config.Secure {
+		c += "; Secure"
+	}
+	return c
+}
+
+func (m *Manager) encode(id string) (string, error) {
+	payload := []byte(fmt.Sprintf("%s|%d", id, time.Now().Unix()))
+	if m.aead != nil {
+		nonce := make([]byte, m.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = m.aead.Seal(nonce, nonce, payload, []byte(m.config.CookieName))
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + m.sign(encoded), nil
+}
+
+func (m *Manager) decode(value string) (string, error) {
+	encoded, sig, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(m.sign(encoded))) {
+		return "", ERROR_INVALID_COOKIE
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ERROR_INVALID_COOKIE
+	}
+	if m.aead != nil {
+		size := m.aead.NonceSize()
+		if len(payload) < size {
+			return "", ERROR_INVALID_COOKIE
+		}
+		payload, err = m.aead.Open(nil, payload[:size], payload[size:], []byte(m.config.CookieName))
+		if err != nil {
+			return "", ERROR_INVALID_COOKIE
+		}
+	}
+
+	id, ts, ok := strings.Cut(string(payload), "|")
+	if !ok || !validID(id) {
+		return "", ERROR_INVALID_COOKIE
+	}
+	issued, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > m.config.MaxAge {
+		return "", ERROR_INVALID_COOKIE
+	}
+	return id, nil
+}
+
+func (m *Manager) sign(value string) string {
+	mac := hmac.New(sha256.New, m.config.HashKey)
+	mac.Write([]byte(m.config.CookieName + "|" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func cookie(h *headers.Headers, name string) (string, bool) {
+	v, ok := h.Get("cookie")
+	if !ok {
+		return "", false
+	}
+	for _, part := range strings.FieldsFunc(v, func(r rune) bool { return r == ';' || r == ',' }) {
+		n, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && n == name {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func validID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9':
+		case c == '-' || c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func serve(t *testing.T, m *Manager, handler func(w *response.Writer, req *request.Request), cookieHeader string) string {
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if cookieHeader != "" {
+		raw += "Cookie: " + cookieHeader + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	out := bytes.Buffer{}
+	m.Middleware(handler)(response.NewWriter(&out), req)
+
+	for _, line := range strings.Split(out.String(), "\r\n") {
+		if v, ok := strings.CutPrefix(line, "set-cookie: "); ok {
+			pair, _, _ := strings.Cut(v, ";")
+			return pair
+		}
+	}
+	return ""
+}
+
+func respond(w *response.Writer) {
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*response.GetDefaultHeaders(0))
+}
+
+func TestSessionMiddleware(t *testing.T) {
+	for _, blockKey := range [][]byte{nil, []byte("0123456789abcdef")} {
+		store := NewMemoryStore()
+		m, err := NewManager(store, Config{HashKey: []byte("secret"), BlockKey: blockKey})
+		require.NoError(t, err)
+
+		// Test: Untouched sessions don't set a cookie
+		c := serve(t, m, func(w *response.Writer, req *request.Request) {
+			require.NotNil(t, FromRequest(req))
+			respond(w)
+		}, "")
+		assert.Equal(t, "", c)
+
+		// Test: Values survive across requests
+		c = serve(t, m, func(w *response.Writer, req *request.Request) {
+			FromRequest(req).Set("user", "prime")
+			respond(w)
+		}, "")
+		require.NotEqual(t, "", c)
+
+		var id string
+		serve(t, m, func(w *response.Writer, req *request.Request) {
+			s := FromRequest(req)
+			id = s.ID()
+			user, _ := s.Get("user")
+			assert.Equal(t, "prime", user)
+			respond(w)
+		}, c)
+
+		// Test: Tampered cookies start a new session
+		serve(t, m, func(w *response.Writer, req *request.Request) {
+			_, ok := FromRequest(req).Get("user")
+			assert.False(t, ok)
+			respond(w)
+		}, c+"x")
+
+		// Test: Rotation issues a new id and drops the old one
+		rotated := serve(t, m, func(w *response.Writer, req *request.Request) {
+			FromRequest(req).Rotate()
+			respond(w)
+		}, c)
+		assert.NotEqual(t, c, rotated)
+		_, err = store.Load(id)
+		assert.ErrorIs(t, err, ERROR_SESSION_NOT_FOUND)
+		serve(t, m, func(w *response.Writer, req *request.Request) {
+			user, _ := FromRequest(req).Get("user")
+			assert.Equal(t, "prime", user)
+			respond(w)
+		}, rotated)
+
+		// Test: The session cookie goes on its own line next to the handler's
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		out := bytes.Buffer{}
+		m.Middleware(func(w *response.Writer, req *request.Request) {
+			FromRequest(req).Set("user", "prime")
+			h := response.GetDefaultHeaders(0)
+			h.Set("Set-Cookie", "theme=dark; Path=/")
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*h)
+		})(response.NewWriter(&out), req)
+		assert.Contains(t, out.String(), "\r\nset-cookie: theme=dark; Path=/\r\n")
+		assert.Equal(t, 2, strings.Count(out.String(), "\r\nset-cookie: "))
+		assert.Regexp(t, "\r\nset-cookie: session=[^,]+; Path=/; Max-Age=", out.String())
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	// Test: Round trip
+	require.NoError(t, store.Save("abc", map[string]string{"k": "v"}, time.Minute))
+	values, err := store.Load("abc")
+	require.NoError(t, err)
+	assert.Equal(t, "v", values["k"])
+
+	// Test: Delete and path traversal
+	require.NoError(t, store.Delete("abc"))
+	_, err = store.Load("abc")
+	assert.ErrorIs(t, err, ERROR_SESSION_NOT_FOUND)
+	_, err = store.Load("../etc/passwd")
+	assert.ErrorIs(t, err, ERROR_INVALID_SESSION_ID)
+
+	// Test: Concurrent saves of one session leave one whole record and no
+	// temporary files
+	dir := t.TempDir()
+	store, err = NewFileStore(dir)
+	require.NoError(t, err)
+	wg := sync.WaitGroup{}
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, store.Save("abc", map[string]string{"k": strings.Repeat(string(rune('a'+i)), 1000)}, time.Minute))
+		}()
+	}
+	wg.Wait()
+	values, err = store.Load("abc")
+	require.NoError(t, err)
+	require.NotEmpty(t, values["k"])
+	assert.Equal(t, strings.Repeat(values["k"][:1], 1000), values["k"])
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,171 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ERROR_SESSION_NOT_FOUND = fmt.Errorf("session not found")
+var ERROR_INVALID_SESSION_ID = fmt.Errorf("invalid session id")
+
+type Store interface {
+	Load(id string) (map[string]string, error)
+	Save(id string, values map[string]string, maxAge time.Duration) error
+	Delete(id string) error
+}
+
+type memoryRecord struct {
+	values  map[string]string
+	expires time.Time
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]memoryRecord
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: map[string]memoryRecord{},
+	}
+}
+
+func (s *MemoryStore) Load(id string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return nil, ERROR_SESSION_NOT_FOUND
+	}
+	if time.Now().After(record.expires) {
+		delete(s.records, id)
+		return nil, ERROR_SESSION_NOT_FOUND
+	}
+	return copyValues(record.values), nil
+}
+
+func (s *MemoryStore) Save(id string, values map[string]string, maxAge time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[id] = memoryRecord{
+		values:  copyValues(values),
+		expires: time.Now().Add(maxAge),
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, id)
+	return nil
+}
+
+type fileRecord struct {
+	Values  map[string]string `json:"values"`
+	Expires time.Time         `json:"expires"`
+}
+
+// FileStore keeps one JSON file per session inside dir.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", ERROR_INVALID_SESSION_ID
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *FileStore) Load(id string) (map[string]string, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ERROR_SESSION_NOT_FOUND
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := fileRecord{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	if time.Now().After(record.Expires) {
+		os.Remove(path)
+		return nil, ERROR_SESSION_NOT_FOUND
+	}
+	return record.Values, nil
+}
+
+func (s *FileStore) Save(id string, values map[string]string, maxAge time.Duration) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(fileRecord{
+		Values:  values,
+		Expires: time.Now().Add(maxAge),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Each save writes a file of its own, so concurrent saves of one
+	// session never mix their writes; the last rename wins.
+	tmp, err := os.CreateTemp(s.dir, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func copyValues(values map[string]string) map[string]string {
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		out[k] = v
+	}
+	return out
+}
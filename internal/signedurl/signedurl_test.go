@@ -0,0 +1,54 @@
+package signedurl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	now := time.Now()
+
+	// Test: Valid signed url
+	target, err := s.Sign("GET", "/video?quality=hd", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.NoError(t, s.Verify("GET", target))
+
+	// Test: Tampering with path, query, method or expiry
+	assert.ErrorIs(t, s.Verify("GET", strings.Replace(target, "/video", "/admin", 1)), ERROR_INVALID_SIGNATURE)
+	assert.ErrorIs(t, s.Verify("GET", strings.Replace(target, "quality=hd", "quality=4k", 1)), ERROR_INVALID_SIGNATURE)
+	assert.ErrorIs(t, s.Verify("DELETE", target), ERROR_INVALID_SIGNATURE)
+	assert.ErrorIs(t, s.Verify("GET", target+"&extra=1"), ERROR_INVALID_SIGNATURE)
+	assert.ErrorIs(t, s.Verify("GET", "/video?quality=hd"), ERROR_MISSING_SIGNATURE)
+
+	// Test: Expired url
+	s.now = func() time.Time { return now.Add(time.Hour) }
+	assert.ErrorIs(t, s.Verify("GET", target), ERROR_URL_EXPIRED)
+}
+
+func TestMiddleware(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	handler := s.Middleware(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	})
+	run := func(target string) string {
+		req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\n\r\n"))
+		require.NoError(t, err)
+		out := bytes.Buffer{}
+		handler(response.NewWriter(&out), req)
+		return out.String()
+	}
+
+	target, err := s.Sign("GET", "/video", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(run(target), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasPrefix(run("/video"), "HTTP/1.1 403 Forbidden\r\n"))
+}
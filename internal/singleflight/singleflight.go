@@ -0,0 +1,45 @@
+package singleflight
+
+import "sync"
+
+type call[T any] struct {
+	wg  sync.WaitGroup
+	val T
+	dup int
+}
+
+// Group coalesces concurrent calls with the same key into a single
+// execution whose result is handed to every caller.
+type Group[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+func (g *Group[T]) Do(key string, fn func() T) (v T, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call[T]{}
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dup++
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, true
+	}
+
+	c := &call[T]{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		shared = c.dup > 0
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+
+	c.val = fn()
+	return c.val, false
+}
@@ -0,0 +1,61 @@
+package singleflight
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupDo(t *testing.T) {
+	// Test: Concurrent callers share one execution
+	g := Group[int]{}
+	var calls atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	wg := sync.WaitGroup{}
+	results := make([]int, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = g.Do("key", func() int {
+			close(started)
+			<-release
+			return int(calls.Add(1))
+		})
+	}()
+	<-started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = g.Do("key", func() int {
+				return int(calls.Add(1))
+			})
+		}()
+	}
+	for {
+		g.mu.Lock()
+		dup := g.calls["key"].dup
+		g.mu.Unlock()
+		if dup == len(results)-1 {
+			break
+		}
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	for _, r := range results {
+		assert.Equal(t, 1, r)
+	}
+
+	// Test: Sequential calls each execute
+	v, shared := g.Do("key", func() int { return 42 })
+	assert.Equal(t, 42, v)
+	assert.False(t, shared)
+}
@@ -0,0 +1,125 @@
+package spill
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// Buffer holds what is written to it in memory until it grows past
+// Threshold bytes, then moves it to a temporary file. Close removes the
+// file; a Buffer that never spilled needs no Close, but may have one.
+type Buffer struct {
+	// Threshold is the most kept in memory; zero or less never spills.
+	Threshold int64
+	// Dir is where the file goes, os.TempDir() when empty.
+	Dir string
+
+	mem  bytes.Buffer
+	file *os.File
+	size int64
+
+	// holds counts Hold calls not yet released; closing records a Close
+	// put off until they are.
+	mu      sync.Mutex
+	holds   int
+	closing bool
+}
+
+func New(threshold int64) *Buffer {
+	return &Buffer{Threshold: threshold}
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	if b.file == nil && b.Threshold > 0 && b.size+int64(len(p)) > b.Threshold {
+		f, err := os.CreateTemp(b.Dir, "spill-*")
+		if err != nil {
+			return 0, err
+		}
+		b.file = f
+		if _, err := f.Write(b.mem.Bytes()); err != nil {
+			return 0, err
+		}
+		b.mem = bytes.Buffer{}
+	}
+
+	var n int
+	var err error
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.mem.Write(p)
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+func (b *Buffer) Len() int64 {
+	return b.size
+}
+
+func (b *Buffer) Spilled() bool {
+	return b.file != nil
+}
+
+// Bytes returns the content while it is still in memory, nil once spilled.
+func (b *Buffer) Bytes() []byte {
+	if b.file != nil {
+		return nil
+	}
+	return b.mem.Bytes()
+}
+
+// Reader reads the content from the start, without disturbing writes or
+// other readers.
+func (b *Buffer) Reader() io.Reader {
+	if b.file != nil {
+		return io.NewSectionReader(b.file, 0, b.size)
+	}
+	return bytes.NewReader(b.mem.Bytes())
+}
+
+// Hold puts off Close until release is called, for a reader that may
+// outlive whoever closes b. Calling release more than once is harmless.
+func (b *Buffer) Hold() (release func()) {
+	b.mu.Lock()
+	b.holds++
+	b.mu.Unlock()
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			b.holds--
+			closeNow := b.holds == 0 && b.closing
+			b.mu.Unlock()
+			if closeNow {
+				b.close()
+			}
+		})
+	}
+}
+
+// Close releases the memory or removes the file, once every Hold has been
+// released.
+func (b *Buffer) Close() error {
+	b.mu.Lock()
+	if b.holds > 0 {
+		b.closing = true
+		b.mu.Unlock()
+		return nil
+	}
+	b.mu.Unlock()
+	return b.close()
+}
+
+func (b *Buffer) close() error {
+	b.mem = bytes.Buffer{}
+	if b.file == nil {
+		return nil
+	}
+	f := b.file
+	b.file = nil
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,69 @@
+package spill
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer(t *testing.T) {
+	dir := t.TempDir()
+	b := New(10)
+	b.Dir = dir
+
+	// Test: Up to the threshold the content stays in memory
+	b.Write([]byte("hello"))
+	assert.False(t, b.Spilled())
+	assert.Equal(t, "hello", string(b.Bytes()))
+
+	// Test: Past it everything moves to a file, and reads back whole
+	b.Write([]byte(" world, and more"))
+	assert.True(t, b.Spilled())
+	assert.Nil(t, b.Bytes())
+	assert.Equal(t, int64(21), b.Len())
+	content, err := io.ReadAll(b.Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world, and more", string(content))
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+
+	// Test: Close removes the file
+	require.NoError(t, b.Close())
+	entries, _ = os.ReadDir(dir)
+	assert.Empty(t, entries)
+
+	// Test: Without a threshold nothing spills
+	b = New(0)
+	b.Write([]byte(strings.Repeat("x", 1<<20)))
+	assert.False(t, b.Spilled())
+}
+
+func TestBufferHold(t *testing.T) {
+	dir := t.TempDir()
+	b := New(4)
+	b.Dir = dir
+	b.Write([]byte("spilled content"))
+	files := func() int {
+		entries, _ := os.ReadDir(dir)
+		return len(entries)
+	}
+
+	// Test: Close while held leaves the file readable
+	release := b.Hold()
+	other := b.Hold()
+	require.NoError(t, b.Close())
+	content, err := io.ReadAll(b.Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "spilled content", string(content))
+
+	// Test: The file goes with the last release, and only then
+	release()
+	release()
+	assert.Equal(t, 1, files())
+	other()
+	assert.Equal(t, 0, files())
+}
@@ -0,0 +1,252 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ERROR_UPLOAD_NOT_FOUND = fmt.Errorf("upload not found")
+var ERROR_INVALID_UPLOAD_ID = fmt.Errorf("invalid upload id")
+var ERROR_OFFSET_MISMATCH = fmt.Errorf("upload offset mismatch")
+var ERROR_UPLOAD_TOO_LARGE = fmt.Errorf("upload exceeds its declared length")
+
+type Info struct {
+	ID     string
+	Length int64
+	Offset int64
+}
+
+func (i Info) Complete() bool {
+	return i.Offset == i.Length
+}
+
+// Store persists partial uploads. Append must reject writes that don't start
+// at the current offset or would run past the declared length.
+type Store interface {
+	Create(id string, length int64) error
+	Info(id string) (Info, error)
+	Append(id string, offset int64, r io.Reader) (Info, error)
+	Delete(id string) error
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	uploads map[string]*memoryUpload
+}
+
+type memoryUpload struct {
+	length int64
+	data   bytes.Buffer
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		uploads: map[string]*memoryUpload{},
+	}
+}
+
+func (s *MemoryStore) Create(id string, length int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uploads[id] = &memoryUpload{length: length}
+	return nil
+}
+
+func (s *MemoryStore) Info(id string) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[id]
+	if !ok {
+		return Info{}, ERROR_UPLOAD_NOT_FOUND
+	}
+	return Info{ID: id, Length: u.length, Offset: int64(u.data.Len())}, nil
+}
+
+func (s *MemoryStore) Append(id string, offset int64, r io.Reader) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[id]
+	if !ok {
+		return Info{}, ERROR_UPLOAD_NOT_FOUND
+	}
+	if offset != int64(u.data.Len()) {
+		return Info{}, ERROR_OFFSET_MISMATCH
+	}
+
+	err := appendLimited(&u.data, r, u.length-offset)
+	return Info{ID: id, Length: u.length, Offset: int64(u.data.Len())}, err
+}
+
+// Bytes returns the data received so far for id.
+func (s *MemoryStore) Bytes(id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[id]
+	if !ok {
+		return nil, ERROR_UPLOAD_NOT_FOUND
+	}
+	return bytes.Clone(u.data.Bytes()), nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.uploads, id)
+	return nil
+}
+
+// FileStore keeps each upload as <id>.bin inside dir, with its declared
+// length in <id>.json. The offset is the size of the data file, so a crash
+// mid-write resumes from whatever actually reached the disk.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+type fileInfo struct {
+	Length int64 `json:"length"`
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Path returns the data file for id, for handing the finished upload on.
+func (s *FileStore) Path(id string) (string, error) {
+	if !validID(id) {
+		return "", ERROR_INVALID_UPLOAD_ID
+	}
+	return filepath.Join(s.dir, id+".bin"), nil
+}
+
+func (s *FileStore) Create(id string, length int64) error {
+	path, err := s.Path(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(fileInfo{Length: length})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, id+".json"), data, 0o600)
+}
+
+func (s *FileStore) Info(id string) (Info, error) {
+	path, err := s.Path(id)
+	if err != nil {
+		return Info{}, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, ERROR_UPLOAD_NOT_FOUND
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	info := fileInfo{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return Info{}, err
+	}
+
+	stat, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, ERROR_UPLOAD_NOT_FOUND
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{ID: id, Length: info.Length, Offset: stat.Size()}, nil
+}
+
+func (s *FileStore) Append(id string, offset int64, r io.Reader) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := s.Info(id)
+	if err != nil {
+		return Info{}, err
+	}
+	if offset != info.Offset {
+		return Info{}, ERROR_OFFSET_MISMATCH
+	}
+
+	path, _ := s.Path(id)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return Info{}, err
+	}
+	err = appendLimited(f, r, info.Length-offset)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	stat, statErr := os.Stat(path)
+	if statErr != nil {
+		return Info{}, statErr
+	}
+	info.Offset = stat.Size()
+	return info, err
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.Path(id)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{path, filepath.Join(s.dir, id+".json")} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendLimited copies r into w, failing once more than remaining bytes
+// arrive. Whatever fits is still written so the client can resume.
+func appendLimited(w io.Writer, r io.Reader, remaining int64) error {
+	n, err := io.Copy(w, io.LimitReader(r, remaining))
+	if err != nil {
+		return err
+	}
+	if n == remaining {
+		if extra, _ := r.Read(make([]byte, 1)); extra > 0 {
+			return ERROR_UPLOAD_TOO_LARGE
+		}
+	}
+	return nil
+}
+
+func validID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,280 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"tcp.to.http/internal/headers"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+const tusVersion = "1.0.0"
+
+type Config struct {
+	// Prefix is the collection path; uploads live at Prefix + "/" + id.
+	Prefix  string
+	Store   Store
+	MaxSize int64
+	// OnComplete runs once the last byte of an upload has been stored.
+	OnComplete func(info Info)
+}
+
+// Handler serves a tus-style resumable upload endpoint:
+//
+//	POST   Prefix       with Upload-Length creates an upload
+//	HEAD   Prefix/id    reports Upload-Offset and Upload-Length
+//	PATCH  Prefix/id    appends at Upload-Offset (or a Content-Range start)
+//	DELETE Prefix/id    abandons the upload
+//
+// A client that loses its connection asks HEAD for the offset and resumes
+// from there; with BodyProgress installed too, that offset includes the
+// part of the lost PATCH that arrived.
+func Handler(config Config) server.Handler {
+	prefix := strings.TrimSuffix(config.Prefix, "/")
+
+	return func(w *response.Writer, req *request.Request) {
+		w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+			h.Replace("Tus-Resumable", tusVersion)
+		})
+
+		target, id, ok := uploadID(req, prefix)
+		if !ok {
+			server.Error(w, req, response.StatusNotFound, "")
+			return
+		}
+
+		switch {
+		case id == "" && req.RequestLine.Method == "POST":
+			create(w, req, config, target)
+		case id == "" || strings.Contains(id, "/"):
+			notAllowed(w, req, "POST")
+		case req.RequestLine.Method == "HEAD":
+			head(w, req, config, id)
+		case req.RequestLine.Method == "PATCH":
+			patch(w, req, config, id)
+		case req.RequestLine.Method == "DELETE":
+			if err := config.Store.Delete(id); err != nil {
+				storeError(w, req, err)
+				return
+			}
+			writeEmpty(w, response.StatusNoContent, nil)
+		default:
+			notAllowed(w, req, "HEAD, PATCH, DELETE")
+		}
+	}
+}
+
+// BodyProgress stores PATCH bodies for config's uploads as they arrive;
+// pass it to server.WithBodyProgress next to Handler(config). A client that
+// loses its connection mid-PATCH then keeps every byte the server received
+// instead of none. Requests it can't stream, such as one with a stale
+// offset, are left for Handler to answer.
+func BodyProgress(config Config) func(r *request.Request, p request.BodyProgress) error {
+	prefix := strings.TrimSuffix(config.Prefix, "/")
+
+	return func(r *request.Request, p request.BodyProgress) error {
+		_, id, ok := uploadID(r, prefix)
+		if !ok || id == "" || r.RequestLine.Method != "PATCH" {
+			return nil
+		}
+		offset, ok := requestOffset(r)
+		if !ok {
+			return nil
+		}
+		start := p.Received - int64(len(p.Chunk))
+		if !r.BodyTaken() {
+			// Whether to stream is decided on the first chunk, so the body
+			// is either all stored here or all left to Handler.
+			if start > 0 || !patchable(r) {
+				return nil
+			}
+			if info, err := config.Store.Info(id); err != nil || info.Offset != offset {
+				return nil
+			}
+			r.TakeBody()
+		}
+
+		info, err := config.Store.Append(id, offset+start, bytes.NewReader(p.Chunk))
+		if err != nil {
+			return err
+		}
+		finish(config, info, true)
+		return nil
+	}
+}
+
+// uploadID splits the request path into the target and the upload ID
+// under prefix, which is empty for the collection itself. The prefix must
+// end at a segment: "/filesABC" is not an upload under "/files".
+func uploadID(req *request.Request, prefix string) (target, id string, ok bool) {
+	target, _, _ = strings.Cut(req.RequestLine.RequestTarget, "?")
+	rest, ok := strings.CutPrefix(target, prefix)
+	if !ok || rest != "" && !strings.HasPrefix(rest, "/") {
+		return target, "", false
+	}
+	return target, strings.TrimPrefix(rest, "/"), true
+}
+
+func create(w *response.Writer, req *request.Request, config Config, target string) {
+	value, _ := req.Headers.Get("upload-length")
+	length, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || length < 0 {
+		server.Error(w, req, response.StatusBadRequest, "missing or invalid Upload-Length")
+		return
+	}
+	if config.MaxSize > 0 && length > config.MaxSize {
+		server.Error(w, req, response.StatusContentTooLarge, "")
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	if err := config.Store.Create(id, length); err != nil {
+		storeError(w, req, err)
+		return
+	}
+
+	info := Info{ID: id, Length: length}
+	if req.BodyLen() > 0 {
+		// creation-with-upload: the first chunk may ride along on the POST.
+		if info, err = config.Store.Append(id, 0, req.BodyReader()); err != nil {
+			storeError(w, req, err)
+			return
+		}
+	}
+	finish(config, info, true)
+
+	writeEmpty(w, response.StatusCreated, map[string]string{
+		"Location":      strings.TrimSuffix(target, "/") + "/" + id,
+		"Upload-Offset": strconv.FormatInt(info.Offset, 10),
+	})
+}
+
+func head(w *response.Writer, req *request.Request, config Config, id string) {
+	info, err := config.Store.Info(id)
+	if err != nil {
+		storeError(w, req, err)
+		return
+	}
+	writeEmpty(w, response.StatusOK, map[string]string{
+		"Upload-Offset": strconv.FormatInt(info.Offset, 10),
+		"Upload-Length": strconv.FormatInt(info.Length, 10),
+		"Cache-Control": "no-store",
+	})
+}
+
+func patch(w *response.Writer, req *request.Request, config Config, id string) {
+	offset, ok := requestOffset(req)
+	if !ok {
+		server.Error(w, req, response.StatusBadRequest, "missing or invalid Upload-Offset")
+		return
+	}
+	if !patchable(req) {
+		server.Error(w, req, response.StatusUnsupportedMedia, "")
+		return
+	}
+
+	var info Info
+	var err error
+	if req.BodyTaken() {
+		// BodyProgress stored the body as it arrived.
+		info, err = config.Store.Info(id)
+	} else {
+		info, err = config.Store.Append(id, offset, req.BodyReader())
+		if err == nil {
+			finish(config, info, info.Offset > offset)
+		}
+	}
+	if err != nil {
+		storeError(w, req, err)
+		return
+	}
+
+	writeEmpty(w, response.StatusNoContent, map[string]string{
+		"Upload-Offset": strconv.FormatInt(info.Offset, 10),
+	})
+}
+
+// patchable reports whether a PATCH body is in a form Handler accepts:
+// tus's offset stream, or a Content-Range for non-tus clients.
+func patchable(req *request.Request) bool {
+	if _, ranged := req.Headers.Get("content-range"); ranged {
+		return true
+	}
+	contentType, _ := req.Headers.Get("content-type")
+	return contentType == "application/offset+octet-stream"
+}
+
+// requestOffset reads Upload-Offset, falling back to the start of a
+// "Content-Range: bytes start-end/total" header for non-tus clients.
+func requestOffset(req *request.Request) (int64, bool) {
+	if value, ok := req.Headers.Get("upload-offset"); ok {
+		offset, err := strconv.ParseInt(value, 10, 64)
+		return offset, err == nil && offset >= 0
+	}
+
+	value, ok := req.Headers.Get("content-range")
+	if !ok {
+		return 0, false
+	}
+	spec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	offset, err := strconv.ParseInt(start, 10, 64)
+	return offset, err == nil && offset >= 0
+}
+
+// finish runs OnComplete for an upload that changed and is now complete,
+// so that repeating the last PATCH, or an empty one, doesn't run it again.
+func finish(config Config, info Info, changed bool) {
+	if changed && info.Complete() && config.OnComplete != nil {
+		config.OnComplete(info)
+	}
+}
+
+func storeError(w *response.Writer, req *request.Request, err error) {
+	switch {
+	case errors.Is(err, ERROR_UPLOAD_NOT_FOUND), errors.Is(err, ERROR_INVALID_UPLOAD_ID):
+		server.Error(w, req, response.StatusNotFound, "")
+	case errors.Is(err, ERROR_OFFSET_MISMATCH):
+		server.Error(w, req, response.StatusConflict, err.Error())
+	case errors.Is(err, ERROR_UPLOAD_TOO_LARGE):
+		server.Error(w, req, response.StatusContentTooLarge, err.Error())
+	default:
+		log.Printf("upload: %v", err)
+		server.Error(w, req, response.StatusInternalServeError, "")
+	}
+}
+
+func notAllowed(w *response.Writer, req *request.Request, allow string) {
+	w.OnHeaders(func(_ response.StatusCode, h *headers.Headers) {
+		h.Replace("Allow", allow)
+	})
+	server.Error(w, req, response.StatusMethodNotAllowed, "")
+}
+
+func writeEmpty(w *response.Writer, status response.StatusCode, extra map[string]string) {
+	h := response.GetDefaultHeaders(0)
+	h.Delete("Content-Type")
+	if status == response.StatusNoContent {
+		h.Delete("Content-Length")
+	}
+	for name, value := range extra {
+		h.Replace(name, value)
+	}
+	w.WriteStatusLine(status)
+	w.WriteHeaders(*h)
+}
@@ -0,0 +1,144 @@
+package upload
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/server"
+)
+
+func run(t *testing.T, handler server.Handler, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	out := bytes.Buffer{}
+	handler(response.NewWriter(&out), req)
+	return out.String()
+}
+
+func patchRequest(id, offset, body string) string {
+	return "PATCH /files/" + id + " HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Type: application/offset+octet-stream\r\n" +
+		"Upload-Offset: " + offset + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func TestResumableUpload(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "file": fileStore} {
+		t.Run(name, func(t *testing.T) {
+			completed := []Info{}
+			handler := Handler(Config{
+				Prefix:     "/files",
+				Store:      store,
+				OnComplete: func(info Info) { completed = append(completed, info) },
+			})
+
+			// Test: POST creates an upload and returns its location
+			out := run(t, handler, "POST /files HTTP/1.1\r\nHost: localhost\r\nUpload-Length: 9\r\n\r\n")
+			require.True(t, strings.HasPrefix(out, "HTTP/1.1 201 Created\r\n"), out)
+			assert.Contains(t, out, "tus-resumable: 1.0.0\r\n")
+			id := regexp.MustCompile(`location: /files/([0-9a-f]+)\r\n`).FindStringSubmatch(out)[1]
+
+			// Test: Chunks append at the current offset
+			out = run(t, handler, patchRequest(id, "0", "abcd"))
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"), out)
+			assert.Contains(t, out, "upload-offset: 4\r\n")
+
+			// Test: A stale offset is rejected
+			out = run(t, handler, patchRequest(id, "0", "abcd"))
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 409 Conflict\r\n"), out)
+
+			// Test: HEAD reports where to resume
+			out = run(t, handler, "HEAD /files/"+id+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			assert.Contains(t, out, "upload-offset: 4\r\n")
+			assert.Contains(t, out, "upload-length: 9\r\n")
+
+			// Test: Content-Range works in place of Upload-Offset
+			out = run(t, handler, "PATCH /files/"+id+" HTTP/1.1\r\nHost: localhost\r\n"+
+				"Content-Range: bytes 4-8/9\r\nContent-Length: 5\r\n\r\nefghi")
+			assert.Contains(t, out, "upload-offset: 9\r\n")
+			require.Len(t, completed, 1)
+			assert.Equal(t, Info{ID: id, Length: 9, Offset: 9}, completed[0])
+
+			// Test: Writing past the declared length fails
+			out = run(t, handler, patchRequest(id, "9", "j"))
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 413 "), out)
+
+			// Test: Paths that only start with the prefix are not uploads
+			out = run(t, handler, "HEAD /files"+id+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), out)
+
+			// Test: DELETE abandons the upload
+			out = run(t, handler, "DELETE /files/"+id+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"), out)
+			out = run(t, handler, "HEAD /files/"+id+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), out)
+		})
+	}
+}
+
+func TestFileStoreRejectsBadIDs(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	// Test: IDs cannot escape the directory
+	_, err = store.Info("../secret")
+	assert.ErrorIs(t, err, ERROR_INVALID_UPLOAD_ID)
+}
+
+func TestStreamedPatch(t *testing.T) {
+	store := NewMemoryStore()
+	completed := 0
+	config := Config{Prefix: "/files", Store: store, OnComplete: func(Info) { completed++ }}
+	handler := Handler(config)
+	options := request.Options{OnBodyProgress: BodyProgress(config)}
+	stream := func(raw string) (string, error) {
+		req, err := request.RequestFromReaderWithOptions(strings.NewReader(raw), options)
+		if err != nil {
+			return "", err
+		}
+		out := bytes.Buffer{}
+		handler(response.NewWriter(&out), req)
+		return out.String(), nil
+	}
+
+	out := run(t, handler, "POST /files HTTP/1.1\r\nHost: localhost\r\nUpload-Length: 9\r\n\r\n")
+	id := regexp.MustCompile(`location: /files/([0-9a-f]+)\r\n`).FindStringSubmatch(out)[1]
+
+	// Test: A PATCH cut off mid-body keeps the bytes that arrived
+	raw := patchRequest(id, "0", "abcdef")
+	_, err := stream(raw[:len(raw)-2])
+	require.Error(t, err)
+	out = run(t, handler, "HEAD /files/"+id+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, out, "upload-offset: 4\r\n")
+
+	// Test: A stale offset is left to the handler to refuse
+	out, err = stream(patchRequest(id, "0", "abcd"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 409 Conflict\r\n"), out)
+
+	// Test: Resuming completes the upload, reported once
+	out, err = stream(patchRequest(id, "4", "efghi"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"), out)
+	assert.Contains(t, out, "upload-offset: 9\r\n")
+	data, _ := store.Bytes(id)
+	assert.Equal(t, "abcdefghi", string(data))
+	assert.Equal(t, 1, completed)
+
+	// Test: An empty PATCH on a complete upload doesn't complete it again
+	out, err = stream(patchRequest(id, "9", ""))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"), out)
+	assert.Equal(t, 1, completed)
+}
@@ -0,0 +1,225 @@
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"tcp.to.http/internal/client"
+	"tcp.to.http/internal/headers"
+	"tcp.to.http/internal/response"
+)
+
+var ERROR_NO_INTERACTION = fmt.Errorf("no recorded interaction matches the request")
+
+// Redacted replaces the value of every redacted header in a cassette.
+const Redacted = "REDACTED"
+
+// DefaultRedact are the headers redacted when Recorder.Redact is nil.
+var DefaultRedact = []string{"authorization", "proxy-authorization", "cookie", "set-cookie"}
+
+type Mode int
+
+const (
+	// ModeReplay answers from the cassette and never touches the network.
+	ModeReplay Mode = iota
+	// ModeRecord sends every request upstream and appends it to the cassette.
+	ModeRecord
+)
+
+type Request struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+type Response struct {
+	Status   int               `json:"status"`
+	Headers  map[string]string `json:"headers"`
+	Body     string            `json:"body"`
+	Trailers map[string]string `json:"trailers,omitempty"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Recorder records a client's upstream interactions to a cassette file, or
+// replays them from it. Plug Transport into a client.Client, the one given
+// to a reverse proxy included.
+type Recorder struct {
+	Path string
+	Mode Mode
+	// Upstream sends the requests being recorded. It should not follow
+	// redirects itself; the client using Transport does.
+	Upstream *client.Client
+	// MatchHeaders are request headers that must agree too, on top of the
+	// method, URL and body.
+	MatchHeaders []string
+	// Redact are headers, of requests and responses, whose values never
+	// reach the cassette. Nil means DefaultRedact.
+	Redact []string
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// New returns a recorder for the cassette at path, loading it to replay.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		Path:     path,
+		Mode:     mode,
+		Upstream: &client.Client{Redirects: client.RedirectPolicy{MaxHops: -1}},
+	}
+	if mode == ModeReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &r.cassette); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+	return r, nil
+}
+
+// Save writes what was recorded to Path.
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.Path, append(data, '\n'), 0o644)
+}
+
+func (r *Recorder) Transport(req *client.Request) (*client.Response, error) {
+	body := req.Body
+	if req.BodyReader != nil {
+		b, err := io.ReadAll(req.BodyReader)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+	recorded := Request{
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Headers: r.redact(req.Headers),
+		Body:    string(body),
+	}
+
+	if r.Mode == ModeReplay {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		// Identical requests are answered in the order they were recorded.
+		for i, in := range r.cassette.Interactions {
+			if !r.used[i] && r.matches(in.Request, recorded) {
+				r.used[i] = true
+				return toResponse(req, in.Response), nil
+			}
+		}
+		return nil, fmt.Errorf("%s %s: %w", req.Method, recorded.URL, ERROR_NO_INTERACTION)
+	}
+
+	out := *req
+	out.Body, out.BodyReader = body, nil
+	res, err := r.Upstream.Do(&out)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	in := Interaction{
+		Request: recorded,
+		Response: Response{
+			Status:  int(res.StatusCode),
+			Headers: fields(res.Headers),
+			Body:    string(resBody),
+		},
+	}
+	if res.Trailers != nil {
+		in.Response.Trailers = fields(res.Trailers)
+	}
+	// The caller gets what was sent, only the cassette is redacted.
+	replay := toResponse(req, in.Response)
+	in.Response.Headers = r.redact(res.Headers)
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, in)
+	r.mu.Unlock()
+	return replay, nil
+}
+
+func (r *Recorder) matches(recorded, req Request) bool {
+	if recorded.Method != req.Method || recorded.URL != req.URL || recorded.Body != req.Body {
+		return false
+	}
+	for _, name := range r.MatchHeaders {
+		name = strings.ToLower(name)
+		if recorded.Headers[name] != req.Headers[name] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Recorder) redact(h *headers.Headers) map[string]string {
+	redact := r.Redact
+	if redact == nil {
+		redact = DefaultRedact
+	}
+	m := fields(h)
+	for _, name := range redact {
+		name = strings.ToLower(name)
+		if _, ok := m[name]; ok {
+			m[name] = Redacted
+		}
+	}
+	return m
+}
+
+func fields(h *headers.Headers) map[string]string {
+	m := map[string]string{}
+	h.ForEach(func(n, v string) {
+		m[n] = v
+	})
+	return m
+}
+
+func toHeaders(m map[string]string) *headers.Headers {
+	h := headers.NewHeaders()
+	for n, v := range m {
+		h.Replace(n, v)
+	}
+	return h
+}
+
+func toResponse(req *client.Request, recorded Response) *client.Response {
+	res := &client.Response{
+		StatusCode: response.StatusCode(recorded.Status),
+		Request:    req,
+		Headers:    toHeaders(recorded.Headers),
+		Body:       io.NopCloser(bytes.NewReader([]byte(recorded.Body))),
+	}
+	if recorded.Trailers != nil {
+		res.Trailers = toHeaders(recorded.Trailers)
+	}
+	return res
+}
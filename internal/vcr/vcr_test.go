@@ -0,0 +1,107 @@
+package vcr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tcp.to.http/internal/client"
+	request "tcp.to.http/internal/requests"
+	"tcp.to.http/internal/response"
+	"tcp.to.http/internal/servertest"
+)
+
+func do(t *testing.T, c *client.Client, url string, h map[string]string) (*client.Response, string, error) {
+	req, err := client.NewRequest(context.Background(), "GET", url, nil)
+	require.NoError(t, err)
+	for n, v := range h {
+		req.Headers.Replace(n, v)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body), nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	var hits atomic.Int32
+	ts := servertest.New(t, func(w *response.Writer, req *request.Request) {
+		body := fmt.Sprintf("hit %d", hits.Add(1))
+		h := response.GetDefaultHeaders(len(body))
+		h.Replace("Set-Cookie", "session=s3cret")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte(body))
+	})
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	secret := map[string]string{"Authorization": "Bearer s3cret", "X-Tenant": "a"}
+
+	rec, err := New(path, ModeRecord)
+	require.NoError(t, err)
+	c := &client.Client{Transport: rec.Transport}
+	res, body, err := do(t, c, ts.URL+"/thing", secret)
+	require.NoError(t, err)
+	// Test: While recording, the caller gets the real response
+	assert.Equal(t, "hit 1", body)
+	cookie, _ := res.Headers.Get("set-cookie")
+	assert.Equal(t, "session=s3cret", cookie)
+	_, _, err = do(t, c, ts.URL+"/thing", secret)
+	require.NoError(t, err)
+	require.NoError(t, rec.Save())
+
+	// Test: Secrets never reach the cassette
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "s3cret")
+	assert.Contains(t, string(data), Redacted)
+
+	ts.Close()
+	replay, err := New(path, ModeReplay)
+	require.NoError(t, err)
+	replay.MatchHeaders = []string{"X-Tenant"}
+	c = &client.Client{Transport: replay.Transport}
+
+	// Test: A header that must match and differs finds nothing
+	_, _, err = do(t, c, ts.URL+"/thing", map[string]string{"X-Tenant": "b"})
+	assert.ErrorIs(t, err, ERROR_NO_INTERACTION)
+
+	// Test: Identical requests replay in recorded order, without the network
+	_, body, err = do(t, c, ts.URL+"/thing", secret)
+	require.NoError(t, err)
+	assert.Equal(t, "hit 1", body)
+	res, body, err = do(t, c, ts.URL+"/thing", secret)
+	require.NoError(t, err)
+	assert.Equal(t, "hit 2", body)
+	assert.Equal(t, response.StatusOK, res.StatusCode)
+
+	// Test: Once used up, an interaction is not replayed again
+	_, _, err = do(t, c, ts.URL+"/thing", secret)
+	assert.ErrorIs(t, err, ERROR_NO_INTERACTION)
+}
+
+func TestReplayRedirects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"interactions": [
+		{"request": {"method": "GET", "url": "http://example.test/old"},
+		 "response": {"status": 301, "headers": {"location": "/new"}}},
+		{"request": {"method": "GET", "url": "http://example.test/new"},
+		 "response": {"status": 200, "body": "moved"}}
+	]}`), 0o644))
+	replay, err := New(path, ModeReplay)
+	require.NoError(t, err)
+
+	// Test: The client follows recorded redirects as it would real ones
+	_, body, err := do(t, &client.Client{Transport: replay.Transport}, "http://example.test/old", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "moved", body)
+}